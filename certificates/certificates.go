@@ -0,0 +1,599 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package certificates issues the tls certificates of the services and the
+// ports with implicit tls. Certificates are issued for the requested server
+// name from a local ca, which is generated and persisted when not
+// configured, unless a configured certificate matches the server name. The
+// certificates of the names of a subject are persisted, so services keep
+// their certificate across restarts.
+package certificates
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+	"golang.org/x/time/rate"
+)
+
+var log = logging.MustGetLogger("honeytrap:certificates")
+
+// maxCached limits the number of issued certificates kept, the server names
+// are chosen by the clients.
+const maxCached = 1024
+
+// renewBefore is the remaining validity at which persisted certificates are
+// issued again.
+const renewBefore = 30 * 24 * time.Hour
+
+// DefaultCASubject is the subject of a generated ca, the default subject of
+// openssl, which is common on self-signed servers.
+var DefaultCASubject = Subject{
+	CommonName:   "Internet Widgits Pty Ltd CA",
+	Organization: []string{"Internet Widgits Pty Ltd"},
+	Country:      []string{"AU"},
+	Province:     []string{"Some-State"},
+}
+
+// Subject contains the subject of issued certificates.
+type Subject struct {
+	CommonName         string   `toml:"common-name"`
+	Organization       []string `toml:"organization"`
+	OrganizationalUnit []string `toml:"organizational-unit"`
+	Country            []string `toml:"country"`
+	Province           []string `toml:"province"`
+	Locality           []string `toml:"locality"`
+
+	// Names are added as subject alternative names
+	Names []string `toml:"names"`
+}
+
+func (s Subject) name() pkix.Name {
+	return pkix.Name{
+		CommonName:         s.CommonName,
+		Organization:       s.Organization,
+		OrganizationalUnit: s.OrganizationalUnit,
+		Country:            s.Country,
+		Province:           s.Province,
+		Locality:           s.Locality,
+	}
+}
+
+// has returns whether the server name is the common name or one of the
+// names of the subject.
+func (s Subject) has(serverName string) bool {
+	if serverName == s.CommonName {
+		return true
+	}
+
+	for _, name := range s.Names {
+		if name == serverName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KeyPair is a configured certificate and key file.
+type KeyPair struct {
+	Cert string `toml:"cert"`
+	Key  string `toml:"key"`
+}
+
+// Manager issues the certificates.
+//
+//	[certificates]
+//	ca-cert = "ca.pem"
+//	ca-key = "ca-key.pem"
+//	key-type = "ecdsa"
+//	issue-rate = 60
+//
+//	[certificates.ca-subject]
+//	common-name = "Example Root CA"
+//	organization = ["Example"]
+//
+//	[[certificates.certificate]]
+//	cert = "www.example.com.pem"
+//	key = "www.example.com-key.pem"
+type Manager struct {
+	// CACert and CAKey are the files of the ca, a ca is generated when
+	// not set
+	CACert string `toml:"ca-cert"`
+	CAKey  string `toml:"ca-key"`
+
+	// CASubject is the subject of a generated ca, DefaultCASubject when
+	// not set
+	CASubject Subject `toml:"ca-subject"`
+
+	// IssueRate is the number of certificates issued per minute for
+	// server names which are not names of the subject, zero is unlimited.
+	// The certificate of the subject is returned over the rate.
+	IssueRate int `toml:"issue-rate"`
+
+	// KeyType is the type of the keys of issued certificates, ecdsa or
+	// rsa
+	KeyType string `toml:"key-type"`
+
+	// KeyPairs are used for the server names they are valid for
+	KeyPairs []KeyPair `toml:"certificate"`
+
+	m sync.Mutex
+
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	caDER  []byte
+	loaded []tls.Certificate
+
+	limiter *rate.Limiter
+
+	// cache and lru contain the most recently used certificates, the
+	// elements of lru are cached values
+	cache     map[string]*list.Element
+	lru       *list.List
+	cacheSize int
+}
+
+type cached struct {
+	key  string
+	cert *tls.Certificate
+}
+
+// New returns a manager.
+func New(options ...func(*Manager) error) (*Manager, error) {
+	m := &Manager{
+		KeyType:   "ecdsa",
+		IssueRate: 60,
+		cache:     map[string]*list.Element{},
+		lru:       list.New(),
+		cacheSize: maxCached,
+	}
+
+	for _, fn := range options {
+		if err := fn(m); err != nil {
+			return nil, err
+		}
+	}
+
+	switch m.KeyType {
+	case "ecdsa", "rsa":
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", m.KeyType)
+	}
+
+	if (m.CACert == "") != (m.CAKey == "") {
+		return nil, errors.New("both ca-cert and ca-key should be set")
+	}
+
+	if m.CASubject.CommonName == "" && len(m.CASubject.Organization) == 0 {
+		m.CASubject = DefaultCASubject
+	}
+
+	if m.IssueRate < 0 {
+		return nil, errors.New("issue-rate can't be negative")
+	} else if m.IssueRate == 0 {
+		m.limiter = rate.NewLimiter(rate.Inf, 0)
+	} else {
+		m.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(m.IssueRate)), m.IssueRate)
+	}
+
+	for _, kp := range m.KeyPairs {
+		cert, err := tls.LoadX509KeyPair(kp.Cert, kp.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate %s: %s", kp.Cert, err.Error())
+		}
+
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+
+		m.loaded = append(m.loaded, cert)
+	}
+
+	return m, nil
+}
+
+var (
+	defaultManager *Manager
+	defaultM       sync.Mutex
+)
+
+// SetDefault sets the manager used by the services.
+func SetDefault(m *Manager) {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	defaultManager = m
+}
+
+// Default returns the manager used by the services.
+func Default() *Manager {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	if defaultManager == nil {
+		defaultManager, _ = New()
+	}
+
+	return defaultManager
+}
+
+func (m *Manager) generateKey() (crypto.Signer, error) {
+	if m.KeyType == "rsa" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// loadCA loads the ca, should be called with the lock held.
+func (m *Manager) loadCA() error {
+	if m.ca != nil {
+		return nil
+	}
+
+	var certPEM, keyPEM []byte
+
+	if m.CACert != "" {
+		var err error
+
+		if certPEM, err = ioutil.ReadFile(m.CACert); err != nil {
+			return err
+		}
+
+		if keyPEM, err = ioutil.ReadFile(m.CAKey); err != nil {
+			return err
+		}
+	} else if st, err := storage.Namespace("certificates"); err != nil {
+		return err
+	} else if certPEM, err = st.Get("ca.cert"); err == nil {
+		if keyPEM, err = st.Get("ca.key"); err != nil {
+			return err
+		}
+	} else if err != storage.ErrNotFound {
+		return err
+	} else {
+		if certPEM, keyPEM, err = generateCA(m.CASubject); err != nil {
+			return err
+		}
+
+		if err := st.Set("ca.cert", certPEM); err != nil {
+			log.Errorf("Could not persist ca: %s", err.Error())
+		}
+
+		if err := st.Set("ca.key", keyPEM); err != nil {
+			log.Errorf("Could not persist ca: %s", err.Error())
+		}
+
+		log.Infof("Generated local certificate authority")
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("unsupported ca key")
+	}
+
+	m.ca, m.caKey, m.caDER = ca, signer, pair.Certificate[0]
+	return nil
+}
+
+func generateCA(subject Subject) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sn, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               subject.name(),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// Certificate returns a certificate for the server name, the server name
+// may be empty. A configured certificate valid for the server name is
+// returned, otherwise a certificate with the subject is issued. Keys are
+// generated without holding the lock, so a slow key doesn't block other
+// handshakes.
+func (m *Manager) Certificate(subject Subject, serverName string) (*tls.Certificate, error) {
+	if serverName != "" {
+		for i := range m.loaded {
+			if m.loaded[i].Leaf.VerifyHostname(serverName) == nil {
+				return &m.loaded[i], nil
+			}
+		}
+	}
+
+	data, err := json.Marshal(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	key := string(data) + serverName
+
+	if cert := m.cached(key); cert != nil {
+		return cert, nil
+	}
+
+	m.m.Lock()
+	err = m.loadCA()
+	ca, caKey, caDER := m.ca, m.caKey, m.caDER
+	m.m.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificates of the subject are persisted, the certificates of
+	// other server names are issued at a limited rate
+	persist := serverName == "" || subject.has(serverName)
+
+	if persist {
+		if cert := m.stored(key, ca); cert != nil {
+			return m.add(key, cert), nil
+		}
+	} else if !m.limiter.Allow() {
+		return m.Certificate(subject, "")
+	}
+
+	cert, err := m.issue(subject, serverName, ca, caKey, caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	if persist {
+		m.store(key, cert)
+	}
+
+	return m.add(key, cert), nil
+}
+
+// cached returns the cached certificate of key, nil when not cached.
+func (m *Manager) cached(key string) *tls.Certificate {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	elem, ok := m.cache[key]
+	if !ok {
+		return nil
+	}
+
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*cached).cert
+}
+
+// add caches the certificate of key, evicting the least recently used
+// certificate. When a certificate has been cached meanwhile, that one is
+// returned.
+func (m *Manager) add(key string, cert *tls.Certificate) *tls.Certificate {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if elem, ok := m.cache[key]; ok {
+		m.lru.MoveToFront(elem)
+		return elem.Value.(*cached).cert
+	}
+
+	for m.lru.Len() >= m.cacheSize {
+		elem := m.lru.Back()
+		m.lru.Remove(elem)
+		delete(m.cache, elem.Value.(*cached).key)
+	}
+
+	m.cache[key] = m.lru.PushFront(&cached{key, cert})
+	return cert
+}
+
+func storageKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "certificate." + hex.EncodeToString(sum[:])
+}
+
+// stored returns the persisted certificate of key, nil when not persisted or
+// when it should be issued again.
+func (m *Manager) stored(key string, ca *x509.Certificate) *tls.Certificate {
+	st, err := storage.Namespace("certificates")
+	if err != nil {
+		return nil
+	}
+
+	data, err := st.Get(storageKey(key))
+	if err != nil {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		log.Errorf("Error loading persisted certificate: %s", err.Error())
+		return nil
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil
+	}
+
+	// issue again when expiring, when the ca has changed or when the key
+	// type has changed
+	if time.Now().Add(renewBefore).After(cert.Leaf.NotAfter) {
+		return nil
+	} else if cert.Leaf.CheckSignatureFrom(ca) != nil {
+		return nil
+	}
+
+	switch cert.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		if m.KeyType != "rsa" {
+			return nil
+		}
+	case *ecdsa.PrivateKey:
+		if m.KeyType != "ecdsa" {
+			return nil
+		}
+	}
+
+	return &cert
+}
+
+// store persists the certificate of key.
+func (m *Manager) store(key string, cert *tls.Certificate) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		log.Errorf("Could not persist certificate: %s", err.Error())
+		return
+	}
+
+	var data []byte
+	for _, der := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+
+	st, err := storage.Namespace("certificates")
+	if err != nil {
+		log.Errorf("Could not persist certificate: %s", err.Error())
+	} else if err := st.Set(storageKey(key), data); err != nil {
+		log.Errorf("Could not persist certificate: %s", err.Error())
+	}
+}
+
+// issue issues a certificate signed by the ca.
+func (m *Manager) issue(subject Subject, serverName string, ca *x509.Certificate, caKey crypto.Signer, caDER []byte) (*tls.Certificate, error) {
+	key, err := m.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sn, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	if serverName != "" {
+		subject.CommonName = serverName
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               subject.name(),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, name := range append(append([]string{}, subject.Names...), subject.CommonName) {
+		if name == "" {
+			continue
+		} else if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// GetCertificate returns a function for tls.Config.GetCertificate, which
+// issues certificates with the subject for the requested server name.
+func (m *Manager) GetCertificate(subject Subject) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return m.Certificate(subject, hello.ServerName)
+	}
+}
+
+// CA returns the certificate of the ca.
+func (m *Manager) CA() (*x509.Certificate, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if err := m.loadCA(); err != nil {
+		return nil, err
+	}
+
+	return m.ca, nil
+}
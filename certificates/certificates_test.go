@@ -0,0 +1,378 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/storage"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "honeytrap-certificates")
+	if err != nil {
+		panic(err)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func verify(t *testing.T, m *Manager, cert *tls.Certificate, name string) {
+	ca, err := m.CA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{
+		DNSName: name,
+		Roots:   roots,
+	}); err != nil {
+		t.Errorf("Expected certificate to verify for %s: %s", name, err.Error())
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestIssue(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := Subject{
+		CommonName:   "mail.example.com",
+		Organization: []string{"Example"},
+		Names:        []string{"smtp.example.com", "192.0.2.1"},
+	}
+
+	cert, err := m.Certificate(subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify(t, m, cert, "mail.example.com")
+	verify(t, m, cert, "smtp.example.com")
+
+	if len(cert.Leaf.IPAddresses) != 1 {
+		t.Errorf("Expected ip address, got %v", cert.Leaf.IPAddresses)
+	}
+
+	if v := cert.Leaf.Subject.Organization; len(v) != 1 || v[0] != "Example" {
+		t.Errorf("Unexpected organization %v", v)
+	}
+
+	// the server name is the common name
+	sni, err := m.Certificate(subject, "www.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify(t, m, sni, "www.example.org")
+
+	if again, _ := m.Certificate(subject, "www.example.org"); again != sni {
+		t.Errorf("Expected cached certificate")
+	}
+
+	// a new manager uses the persisted ca
+	m2, err := New(func(m *Manager) error {
+		m.KeyType = "rsa"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert2, err := m2.Certificate(Subject{}, "www.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify(t, m, cert2, "www.example.org")
+
+	if _, ok := cert2.PrivateKey.(*ecdsa.PrivateKey); ok {
+		t.Errorf("Expected rsa key")
+	}
+}
+
+func writeKeyPair(t *testing.T, dir string, name string) KeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp := KeyPair{
+		Cert: filepath.Join(dir, name+".pem"),
+		Key:  filepath.Join(dir, name+"-key.pem"),
+	}
+
+	if err := ioutil.WriteFile(kp.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(kp.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return kp
+}
+
+func TestKeyPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	kp := writeKeyPair(t, dir, "www.example.com")
+
+	m, err := New(func(m *Manager) error {
+		m.KeyPairs = []KeyPair{kp}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the configured certificate is selected by server name
+	cert, err := m.Certificate(Subject{}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Leaf.Issuer.CommonName != "www.example.com" {
+		t.Errorf("Expected configured certificate, got issued by %s", cert.Leaf.Issuer.CommonName)
+	}
+
+	cert, err = m.Certificate(Subject{}, "mail.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify(t, m, cert, "mail.example.com")
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New(func(m *Manager) error {
+		m.KeyType = "dsa"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for unsupported key type")
+	}
+
+	if _, err := New(func(m *Manager) error {
+		m.CACert = "ca.pem"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for missing ca key")
+	}
+
+	if _, err := New(func(m *Manager) error {
+		m.IssueRate = -1
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for negative issue rate")
+	}
+}
+
+func TestCASubject(t *testing.T) {
+	certPEM, _, err := generateCA(Subject{
+		CommonName:   "Example Root CA",
+		Organization: []string{"Example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ca.Subject.CommonName != "Example Root CA" || len(ca.Subject.Organization) != 1 || ca.Subject.Organization[0] != "Example" {
+		t.Errorf("Unexpected ca subject %s", ca.Subject)
+	}
+
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.CASubject.CommonName != DefaultCASubject.CommonName {
+		t.Errorf("Expected default ca subject, got %v", m.CASubject)
+	}
+}
+
+func TestPersistCertificate(t *testing.T) {
+	subject := Subject{CommonName: "ftp.example.com"}
+
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := m.Certificate(subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new manager returns the persisted certificate of the subject
+	m2, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert2, err := m2.Certificate(subject, "ftp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := m2.Certificate(subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Errorf("Expected persisted certificate")
+	}
+
+	// the server name is stored separately from the empty server name
+	if cert2.Leaf.Subject.CommonName != "ftp.example.com" {
+		t.Errorf("Unexpected common name %s", cert2.Leaf.Subject.CommonName)
+	}
+
+	// certificates of other server names aren't persisted
+	sni, err := m.Certificate(subject, "www.example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sni2, err := m2.Certificate(subject, "www.example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sni.Leaf.SerialNumber.Cmp(sni2.Leaf.SerialNumber) == 0 {
+		t.Errorf("Expected new certificate")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.cacheSize = 2
+
+	first, err := m.Certificate(Subject{}, "a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Certificate(Subject{}, "b.example.com")
+
+	// a.example.com is used last, b.example.com is evicted
+	if again, _ := m.Certificate(Subject{}, "a.example.com"); again != first {
+		t.Errorf("Expected cached certificate")
+	}
+
+	m.Certificate(Subject{}, "c.example.com")
+
+	if m.lru.Len() != 2 {
+		t.Fatalf("Expected 2 cached certificates, got %d", m.lru.Len())
+	}
+
+	if _, ok := m.cache[string(mustJSON(t, Subject{}))+"b.example.com"]; ok {
+		t.Errorf("Expected b.example.com to be evicted")
+	}
+
+	if again, _ := m.Certificate(Subject{}, "a.example.com"); again != first {
+		t.Errorf("Expected a.example.com to be cached")
+	}
+}
+
+func TestIssueRate(t *testing.T) {
+	m, err := New(func(m *Manager) error {
+		m.IssueRate = 1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := Subject{CommonName: "mail.example.com"}
+
+	if _, err := m.Certificate(subject, "scan1.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// over the rate the certificate of the subject is returned
+	cert, err := m.Certificate(subject, "scan2.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Leaf.Subject.CommonName != "mail.example.com" {
+		t.Errorf("Expected certificate of the subject, got %s", cert.Leaf.Subject.CommonName)
+	}
+}
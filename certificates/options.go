@@ -0,0 +1,29 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certificates
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the certificates section of the configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Manager) error {
+	return func(m *Manager) error {
+		return decoder.PrimitiveDecode(c, m)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/cmd"
@@ -28,6 +29,7 @@ import (
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/server"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/asciicast"
 	cli "gopkg.in/urfave/cli.v1"
 
 	logging "github.com/op/go-logging"
@@ -65,6 +67,11 @@ var globalFlags = []cli.Flag{
 		Value: "~/.honeytrap",
 		Usage: "Store data in `DIR`",
 	},
+	cli.DurationFlag{
+		Name:  "shutdown-timeout",
+		Value: server.DefaultShutdownTimeout,
+		Usage: "Time connections and channels are given to finish on shutdown",
+	},
 	cli.BoolFlag{Name: "cpu-profile", Usage: "Enable cpu profiler"},
 	cli.BoolFlag{Name: "mem-profile", Usage: "Enable memory profiler"},
 
@@ -141,6 +148,8 @@ func serve(c *cli.Context) error {
 
 	options = append(options, server.WithToken())
 
+	options = append(options, server.WithShutdownTimeout(c.GlobalDuration("shutdown-timeout")))
+
 	if c.GlobalBool("cpu-profile") {
 		options = append(options, server.WithCPUProfiler())
 	}
@@ -195,9 +204,21 @@ func serve(c *cli.Context) error {
 		signal.Notify(s, os.Interrupt)
 		signal.Notify(s, syscall.SIGTERM)
 
-		select {
-		case <-s:
-			cancel()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for {
+			select {
+			case <-hup:
+				log.Info("Received SIGHUP, reloading configuration")
+
+				if _, err := srvr.Reload(); err != nil {
+					log.Errorf("Error reloading configuration: %s", err.Error())
+				}
+			case <-s:
+				cancel()
+				return
+			}
 		}
 	}()
 
@@ -206,6 +227,45 @@ func serve(c *cli.Context) error {
 	return nil
 }
 
+func replay(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return cli.NewExitError("Session id is required, eg. honeytrap replay <sessionid>", 1)
+	}
+
+	dataDir, err := server.ExpandDataDir(c.GlobalString("data"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	f, err := asciicast.Open(dataDir, id)
+	if os.IsNotExist(err) {
+		return cli.NewExitError(fmt.Sprintf("No recording found for session %s", id), 1)
+	} else if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		s := make(chan os.Signal, 1)
+		signal.Notify(s, os.Interrupt)
+
+		<-s
+		cancel()
+	}()
+
+	if err := asciicast.Play(ctx, os.Stdout, f, c.Float64("speed"), c.Duration("idle-time-limit")); err == context.Canceled {
+	} else if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return nil
+}
+
 func New() *cli.App {
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Fprintf(c.App.Writer,
@@ -222,7 +282,18 @@ Commit-ID: %s
 	app.Flags = globalFlags
 	app.Description = `honeytrap: The honeypot server.`
 	app.CustomAppHelpTemplate = helpTemplate
-	app.Commands = []cli.Command{}
+	app.Commands = []cli.Command{
+		{
+			Name:      "replay",
+			Usage:     "Replay a recorded ssh or telnet session",
+			ArgsUsage: "<sessionid>",
+			Action:    replay,
+			Flags: []cli.Flag{
+				cli.Float64Flag{Name: "speed, s", Value: 1, Usage: "Playback speed"},
+				cli.DurationFlag{Name: "idle-time-limit, i", Value: 2 * time.Second, Usage: "Limit pauses to `DURATION`, 0 to disable"},
+			},
+		},
+	}
 	app.Before = func(c *cli.Context) error {
 		return nil
 	}
@@ -14,14 +14,13 @@
 package config
 
 import (
-	"regexp"
-
+	"fmt"
 	"io"
 	"os"
+	"regexp"
 
 	"github.com/BurntSushi/toml"
 	"github.com/op/go-logging"
-	"fmt"
 )
 
 var log = logging.MustGetLogger("honeytrap:config")
@@ -40,6 +39,18 @@ type Config struct {
 
 	Web toml.Primitive `toml:"web"`
 
+	Artifacts toml.Primitive `toml:"artifacts"`
+
+	Limits toml.Primitive `toml:"limits"`
+
+	Metrics toml.Primitive `toml:"metrics"`
+
+	GeoIP toml.Primitive `toml:"geoip"`
+
+	Profiles toml.Primitive `toml:"profiles"`
+
+	Certificates toml.Primitive `toml:"certificates"`
+
 	Services  map[string]toml.Primitive `toml:"service"`
 	Ports     []toml.Primitive          `toml:"port"`
 	Directors map[string]toml.Primitive `toml:"director"`
@@ -47,6 +58,8 @@ type Config struct {
 
 	Filters []toml.Primitive `toml:"filter"`
 
+	Processors []toml.Primitive `toml:"processor"`
+
 	Logging []struct {
 		Output string `toml:"output"`
 		Level  string `toml:"level"`
@@ -58,15 +71,31 @@ var Default = Config{}
 
 // Load attempts to load the giving toml configuration file.
 func (c *Config) Load(r io.Reader) error {
+	if err := c.Decode(r); err != nil {
+		return err
+	}
+
+	return c.SetupLogging()
+}
+
+// Decode decodes the giving toml configuration without applying any of it,
+// this allows a new configuration to be validated against the running one.
+func (c *Config) Decode(r io.Reader) error {
 	md, err := toml.DecodeReader(r, c)
 	if err != nil {
 		return err
 	}
+
 	c.MetaData = md
+	return nil
+}
 
+// SetupLogging configures the logging backends of the configuration.
+func (c *Config) SetupLogging() error {
 	if len(c.Logging) == 0 {
 		fmt.Println("Warning: no logging backends configured. Add one to view log messages.")
 	}
+
 	var logBackends []logging.Backend
 	for _, log := range c.Logging {
 		var err error
@@ -83,7 +112,7 @@ func (c *Config) Load(r io.Reader) error {
 		}
 
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("error opening log output %s: %s", log.Output, err.Error())
 		}
 
 		backend := logging.NewLogBackend(output, "", 0)
@@ -92,7 +121,7 @@ func (c *Config) Load(r io.Reader) error {
 
 		level, err := logging.LogLevel(log.Level)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("error parsing log level %s: %s", log.Level, err.Error())
 		}
 
 		backendLeveled.SetLevel(level, "")
@@ -67,12 +67,22 @@ func (e Event) Store(s string, v interface{}) {
 	e.sm.Store(s, v)
 }
 
+// Delete removes the key from the event.
+func (e Event) Delete(s string) {
+	e.sm.Delete(s)
+}
+
 // Has returns true/false if the giving key exists.
 func (e Event) Has(s string) bool {
 	_, ok := e.sm.Load(s)
 	return ok
 }
 
+// Load returns the value of the key, of any type.
+func (e Event) Load(s string) (interface{}, bool) {
+	return e.sm.Load(s)
+}
+
 // Get retrieves a giving value for a key has string.
 func (e Event) Get(s string) string {
 	if v, ok := e.sm.Load(s); !ok {
@@ -24,6 +24,12 @@ type AddAddresser interface {
 	AddAddress(net.Addr)
 }
 
+// RemoveAddresser is implemented by listeners that are able to stop
+// listening on an address while running.
+type RemoveAddresser interface {
+	RemoveAddress(net.Addr)
+}
+
 func WithAddress(protocol, address string) func(Listener) error {
 	return func(l Listener) error {
 		if a, ok := l.(AddAddresser); ok {
@@ -16,7 +16,10 @@ package network
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/listener"
@@ -34,6 +37,13 @@ type socketListener struct {
 
 	ch chan net.Conn
 
+	m       sync.Mutex
+	started bool
+
+	// closers contains the listeners started per address, used to stop
+	// listening when an address is removed.
+	closers map[string]io.Closer
+
 	net.Listener
 }
 
@@ -51,6 +61,7 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	l := socketListener{
 		socketConfig: socketConfig{},
 		ch:           ch,
+		closers:      map[string]io.Closer{},
 	}
 
 	for _, option := range options {
@@ -60,58 +71,124 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	return &l, nil
 }
 
-func (sl *socketListener) Start(ctx context.Context) error {
-	for _, address := range sl.Addresses {
-		if _, ok := address.(*net.TCPAddr); ok {
-			l, err := net.Listen(address.Network(), address.String())
-			if err != nil {
-				fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
-				continue
-			}
+// AddAddress adds the address, it will be listened on immediately when the
+// listener has already been started.
+func (sl *socketListener) AddAddress(a net.Addr) {
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	sl.socketConfig.AddAddress(a)
 
-			log.Infof("Listener started: tcp/%s", address)
+	if !sl.started {
+		return
+	}
+
+	sl.listen(a)
+}
 
-			go func() {
-				for {
-					c, err := l.Accept()
-					if err != nil {
-						log.Errorf("Error accepting connection: %s", err.Error())
-						continue
-					}
+// RemoveAddress stops listening on the address.
+func (sl *socketListener) RemoveAddress(a net.Addr) {
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	key := a.Network() + "/" + a.String()
+
+	for i, address := range sl.Addresses {
+		if address.Network()+"/"+address.String() != key {
+			continue
+		}
 
-					sl.ch <- c
+		sl.Addresses = append(sl.Addresses[:i], sl.Addresses[i+1:]...)
+		break
+	}
+
+	c, ok := sl.closers[key]
+	if !ok {
+		return
+	}
+
+	delete(sl.closers, key)
+
+	if err := c.Close(); err != nil {
+		log.Errorf("Error stopping listener %s: %s", key, err.Error())
+		return
+	}
+
+	log.Infof("Listener stopped: %s", key)
+}
+
+func (sl *socketListener) listen(address net.Addr) {
+	key := address.Network() + "/" + address.String()
+
+	if _, ok := address.(*net.TCPAddr); ok {
+		l, err := net.Listen(address.Network(), address.String())
+		if err != nil {
+			fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
+			return
+		}
+
+		closed := &closer{Closer: l}
+		sl.closers[key] = closed
+
+		log.Infof("Listener started: tcp/%s", address)
+
+		go func() {
+			for {
+				c, err := l.Accept()
+				if closed.IsClosed() {
+					return
+				} else if err != nil {
+					log.Errorf("Error accepting connection: %s", err.Error())
+					continue
 				}
-			}()
-		} else if ua, ok := address.(*net.UDPAddr); ok {
-			l, err := net.ListenUDP(address.Network(), ua)
-			if err != nil {
-				fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
-				continue
+
+				sl.ch <- c
 			}
+		}()
+	} else if ua, ok := address.(*net.UDPAddr); ok {
+		l, err := net.ListenUDP(address.Network(), ua)
+		if err != nil {
+			fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
+			return
+		}
 
-			log.Infof("Listener started: udp/%s", address)
+		closed := &closer{Closer: l}
+		sl.closers[key] = closed
 
-			go func() {
-				for {
-					var buf [65535]byte
+		log.Infof("Listener started: udp/%s", address)
 
-					n, raddr, err := l.ReadFromUDP(buf[:])
-					if err != nil {
-						log.Error("Error reading udp:", err.Error())
-						continue
-					}
+		go func() {
+			for {
+				var buf [65535]byte
 
-					sl.ch <- &listener.DummyUDPConn{
-						Buffer: buf[:n],
-						Laddr:  l.LocalAddr(),
-						Raddr:  raddr,
-						Fn:     l.WriteToUDP,
-					}
+				n, raddr, err := l.ReadFromUDP(buf[:])
+				if closed.IsClosed() {
+					return
+				} else if err != nil {
+					log.Error("Error reading udp:", err.Error())
+					continue
 				}
-			}()
-		}
+
+				sl.ch <- &listener.DummyUDPConn{
+					Buffer: buf[:n],
+					Laddr:  l.LocalAddr(),
+					Raddr:  raddr,
+					Fn:     l.WriteToUDP,
+				}
+			}
+		}()
+	}
+}
+
+func (sl *socketListener) Start(ctx context.Context) error {
+	sl.m.Lock()
+	defer sl.m.Unlock()
+
+	for _, address := range sl.Addresses {
+		sl.listen(address)
 	}
 
+	sl.started = true
 	return nil
 }
 
@@ -119,3 +196,20 @@ func (sl *socketListener) Accept() (net.Conn, error) {
 	c := <-sl.ch
 	return c, nil
 }
+
+// closer remembers whether the listener has been closed on purpose, to
+// distinguish it from accept errors.
+type closer struct {
+	io.Closer
+
+	closed int32
+}
+
+func (c *closer) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Closer.Close()
+}
+
+func (c *closer) IsClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
@@ -0,0 +1,491 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics contains counters, gauges and histograms which are exposed
+// in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are the buckets of histograms, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector is a metric which can be registered.
+type Collector interface {
+	// Name returns the name of the metric
+	Name() string
+
+	// Write writes the metric in the text format
+	Write(w io.Writer) error
+}
+
+// Registry contains the collectors which are exposed, collectors are
+// identified by name.
+type Registry struct {
+	m sync.Mutex
+
+	collectors map[string]Collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]Collector{},
+	}
+}
+
+// Default is the registry of the metrics created by the New functions.
+var Default = NewRegistry()
+
+// Register adds the collector, replacing a collector with the same name.
+func (r *Registry) Register(c Collector) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.collectors[c.Name()] = c
+}
+
+// Unregister removes the collector with name.
+func (r *Registry) Unregister(name string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.collectors, name)
+}
+
+// Write writes the metrics of the registry, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.m.Lock()
+
+	collectors := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+
+	r.m.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name() < collectors[j].Name()
+	})
+
+	bw := bufio.NewWriter(w)
+
+	for _, c := range collectors {
+		if err := c.Write(bw); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// desc describes a metric.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// key returns the key of the label values.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// writeSample writes a sample with the label values and the extra label.
+func (d *desc) writeSample(w io.Writer, suffix string, values []string, extra string, value float64) {
+	w.Write([]byte(d.name + suffix))
+
+	labels := []string{}
+
+	for i, l := range d.labels {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", l, escapeValue(values[i])))
+	}
+
+	if extra != "" {
+		labels = append(labels, extra)
+	}
+
+	if len(labels) > 0 {
+		fmt.Fprintf(w, "{%s}", strings.Join(labels, ","))
+	}
+
+	fmt.Fprintf(w, " %s\n", formatFloat(value))
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sample is the value of a metric with label values.
+type sample struct {
+	values []string
+	value  float64
+}
+
+// vec contains the samples of a counter or gauge.
+type vec struct {
+	desc
+
+	m       sync.Mutex
+	samples map[string]*sample
+}
+
+func (v *vec) add(delta float64, values []string) {
+	key := v.key(values)
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{values: append([]string{}, values...)}
+		v.samples[key] = s
+	}
+
+	s.value += delta
+}
+
+func (v *vec) set(value float64, values []string) {
+	key := v.key(values)
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{values: append([]string{}, values...)}
+		v.samples[key] = s
+	}
+
+	s.value = value
+}
+
+func (v *vec) get(values []string) float64 {
+	key := v.key(values)
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	if s, ok := v.samples[key]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+func (v *vec) Write(w io.Writer) error {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.writeHeader(w)
+
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.samples[k]
+		v.writeSample(w, "", s.values, "", s.value)
+	}
+
+	return nil
+}
+
+// Counter is a metric which only increases.
+type Counter struct {
+	vec
+}
+
+// NewCounter returns a counter with the labels, registered in the default
+// registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		vec: vec{
+			desc:    desc{name: name, help: help, typ: TypeCounter, labels: labels},
+			samples: map[string]*sample{},
+		},
+	}
+
+	Default.Register(c)
+	return c
+}
+
+// Inc increments the counter of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.add(1, values)
+}
+
+// Add adds delta to the counter of the label values, delta can't be
+// negative.
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metric %s: counter can't decrease", c.name))
+	}
+
+	c.add(delta, values)
+}
+
+// Value returns the counter of the label values.
+func (c *Counter) Value(values ...string) float64 {
+	return c.get(values)
+}
+
+// Gauge is a metric which can increase and decrease.
+type Gauge struct {
+	vec
+}
+
+// NewGauge returns a gauge with the labels, registered in the default
+// registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		vec: vec{
+			desc:    desc{name: name, help: help, typ: TypeGauge, labels: labels},
+			samples: map[string]*sample{},
+		},
+	}
+
+	Default.Register(g)
+	return g
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.set(value, values)
+}
+
+// Add adds delta to the gauge of the label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.add(delta, values)
+}
+
+// Value returns the gauge of the label values.
+func (g *Gauge) Value(values ...string) float64 {
+	return g.get(values)
+}
+
+// Func is a counter or gauge of which the samples are collected when the
+// metrics are written.
+type Func struct {
+	desc
+
+	fn func(emit func(value float64, values ...string))
+}
+
+// NewCounterFunc returns a counter of which the samples are emitted by fn,
+// registered in the default registry.
+func NewCounterFunc(name, help string, labels []string, fn func(emit func(value float64, values ...string))) *Func {
+	f := &Func{
+		desc: desc{name: name, help: help, typ: TypeCounter, labels: labels},
+		fn:   fn,
+	}
+
+	Default.Register(f)
+	return f
+}
+
+// NewGaugeFunc returns a gauge of which the samples are emitted by fn,
+// registered in the default registry.
+func NewGaugeFunc(name, help string, labels []string, fn func(emit func(value float64, values ...string))) *Func {
+	f := &Func{
+		desc: desc{name: name, help: help, typ: TypeGauge, labels: labels},
+		fn:   fn,
+	}
+
+	Default.Register(f)
+	return f
+}
+
+func (f *Func) Write(w io.Writer) error {
+	samples := []sample{}
+
+	f.fn(func(value float64, values ...string) {
+		f.key(values)
+
+		samples = append(samples, sample{
+			values: append([]string{}, values...),
+			value:  value,
+		})
+	})
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return f.key(samples[i].values) < f.key(samples[j].values)
+	})
+
+	f.writeHeader(w)
+
+	for _, s := range samples {
+		f.writeSample(w, "", s.values, "", s.value)
+	}
+
+	return nil
+}
+
+// histogramSample contains the observations of a histogram with label
+// values.
+type histogramSample struct {
+	values []string
+
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	desc
+
+	buckets []float64
+
+	m       sync.Mutex
+	samples map[string]*histogramSample
+}
+
+// NewHistogram returns a histogram with the buckets and labels, registered
+// in the default registry. DefaultBuckets are used when buckets is empty.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: TypeHistogram, labels: labels},
+		buckets: buckets,
+		samples: map[string]*histogramSample{},
+	}
+
+	Default.Register(h)
+	return h
+}
+
+// Observe adds the value to the histogram of the label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.key(values)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	s, ok := h.samples[key]
+	if !ok {
+		s = &histogramSample{
+			values: append([]string{}, values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+
+		h.samples[key] = s
+	}
+
+	for i, b := range h.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+
+	s.count++
+	s.sum += value
+}
+
+// Count returns the number of observations of the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if s, ok := h.samples[key]; ok {
+		return s.count
+	}
+
+	return 0
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.writeHeader(w)
+
+	keys := make([]string, 0, len(h.samples))
+	for k := range h.samples {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.samples[k]
+
+		for i, b := range h.buckets {
+			h.writeSample(w, "_bucket", s.values, fmt.Sprintf("le=\"%s\"", formatFloat(b)), float64(s.counts[i]))
+		}
+
+		h.writeSample(w, "_bucket", s.values, "le=\"+Inf\"", float64(s.count))
+		h.writeSample(w, "_sum", s.values, "", s.sum)
+		h.writeSample(w, "_count", s.values, "", float64(s.count))
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, c Collector) string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := c.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "Test counter.", "port", "service")
+	defer Default.Unregister(c.Name())
+
+	c.Inc("tcp/22", "ssh")
+	c.Inc("tcp/22", "ssh")
+	c.Add(3, "tcp/80", "http")
+
+	expected := `# HELP test_counter_total Test counter.
+# TYPE test_counter_total counter
+test_counter_total{port="tcp/22",service="ssh"} 2
+test_counter_total{port="tcp/80",service="http"} 3
+`
+
+	if s := write(t, c); s != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, s)
+	}
+
+	if v := c.Value("tcp/22", "ssh"); v != 2 {
+		t.Errorf("Expected 2, got %f", v)
+	}
+}
+
+func TestGaugeEscape(t *testing.T) {
+	g := NewGauge("test_gauge", "Test\ngauge.", "name")
+	defer Default.Unregister(g.Name())
+
+	g.Set(1.5, "a\"b\\c")
+
+	expected := `# HELP test_gauge Test\ngauge.
+# TYPE test_gauge gauge
+test_gauge{name="a\"b\\c"} 1.5
+`
+
+	if s := write(t, g); s != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Test histogram.", []float64{1, 5}, "service")
+	defer Default.Unregister(h.Name())
+
+	h.Observe(0.5, "ssh")
+	h.Observe(2, "ssh")
+	h.Observe(10, "ssh")
+
+	expected := `# HELP test_duration_seconds Test histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{service="ssh",le="1"} 1
+test_duration_seconds_bucket{service="ssh",le="5"} 2
+test_duration_seconds_bucket{service="ssh",le="+Inf"} 3
+test_duration_seconds_sum{service="ssh"} 12.5
+test_duration_seconds_count{service="ssh"} 3
+`
+
+	if s := write(t, h); s != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	f := NewGaugeFunc("test_queue_depth", "Test func.", []string{"channel"}, func(emit func(float64, ...string)) {
+		emit(2, "kafka")
+		emit(1, "elasticsearch")
+	})
+	defer Default.Unregister(f.Name())
+
+	expected := `# HELP test_queue_depth Test func.
+# TYPE test_queue_depth gauge
+test_queue_depth{channel="elasticsearch"} 1
+test_queue_depth{channel="kafka"} 2
+`
+
+	if s := write(t, f); s != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+
+	c := NewCounter("test_handler_total", "Test handler.")
+	defer Default.Unregister(c.Name())
+
+	r.Register(c)
+	c.Inc()
+
+	rec := httptest.NewRecorder()
+	Handler(r).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %s, got %s", ContentType, ct)
+	}
+
+	if !strings.Contains(rec.Body.String(), "test_handler_total 1\n") {
+		t.Errorf("Expected counter, got:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/BurntSushi/toml"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:metrics")
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Server exposes the metrics of a registry over http.
+//
+//	[metrics]
+//	enabled = true
+//	listen = "127.0.0.1:9200"
+//	path = "/metrics"
+type Server struct {
+	Enabled       bool   `toml:"enabled"`
+	ListenAddress string `toml:"listen"`
+	Path          string `toml:"path"`
+
+	registry *Registry
+
+	server *http.Server
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the metrics section of the configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Server) error {
+	return func(s *Server) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// WithRegistry sets the registry which is exposed, the default registry is
+// used otherwise.
+func WithRegistry(r *Registry) func(*Server) error {
+	return func(s *Server) error {
+		s.registry = r
+		return nil
+	}
+}
+
+// New returns a metrics server, which is disabled by default.
+func New(options ...func(*Server) error) (*Server, error) {
+	s := &Server{
+		Enabled:       false,
+		ListenAddress: "127.0.0.1:9200",
+		Path:          "/metrics",
+		registry:      Default,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Handler returns the handler writing the metrics of the registry.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+
+		if err := r.Write(w); err != nil {
+			log.Errorf("Error writing metrics: %s", err.Error())
+		}
+	})
+}
+
+// Start starts the listener of the server, when enabled.
+func (s *Server) Start() {
+	if !s.Enabled {
+		return
+	}
+
+	handler := http.NewServeMux()
+	handler.Handle(s.Path, Handler(s.registry))
+
+	s.server = &http.Server{
+		Addr:    s.ListenAddress,
+		Handler: handler,
+	}
+
+	go func() {
+		log.Infof("Metrics listener started: %s%s", s.ListenAddress, s.Path)
+
+		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("Error starting metrics listener: %s", err.Error())
+		}
+	}()
+}
+
+// Close stops the listener of the server.
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+
+	return s.server.Close()
+}
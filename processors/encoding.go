@@ -0,0 +1,205 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package processors
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+var (
+	_ = Register("hash", Hash)
+	_ = Register("digest", Digest)
+	_ = Register("decode", Decode)
+)
+
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+func sum(algorithm string, salt string, data []byte) string {
+	h := algorithms[algorithm]()
+	h.Write([]byte(salt))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash replaces the values of the fields matching the patterns with their
+// hash, e.g. to share passwords with third parties.
+//
+//	type = "hash"
+//	fields = ["ssh.password"]
+//	algorithm = "sha256"
+//	salt = "..."
+func Hash(options ...func(Processor) error) (Processor, error) {
+	p := &hashProcessor{
+		Algorithm: "sha256",
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := algorithms[p.Algorithm]; !ok {
+		return nil, fmt.Errorf("unknown algorithm %s", p.Algorithm)
+	}
+
+	return p, nil
+}
+
+type hashProcessor struct {
+	Fields    []string `toml:"fields"`
+	Algorithm string   `toml:"algorithm"`
+	Salt      string   `toml:"salt"`
+}
+
+func (p *hashProcessor) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		v, _ := e.Load(k)
+
+		data, ok := bytesOf(v)
+		if !ok {
+			data = []byte(fmt.Sprint(v))
+		}
+
+		e.Store(k, sum(p.Algorithm, p.Salt, data))
+	}
+}
+
+// Digest adds the hashes of the fields matching the patterns, as
+// <field>.<algorithm>.
+//
+//	type = "digest"
+//	fields = ["payload"]
+//	algorithms = ["md5", "sha256"]
+func Digest(options ...func(Processor) error) (Processor, error) {
+	p := &digest{
+		Algorithms: []string{"sha256"},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, algorithm := range p.Algorithms {
+		if _, ok := algorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("unknown algorithm %s", algorithm)
+		}
+	}
+
+	return p, nil
+}
+
+type digest struct {
+	Fields     []string `toml:"fields"`
+	Algorithms []string `toml:"algorithms"`
+}
+
+func (p *digest) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		v, _ := e.Load(k)
+
+		data, ok := bytesOf(v)
+		if !ok {
+			continue
+		}
+
+		for _, algorithm := range p.Algorithms {
+			e.Store(k+"."+algorithm, sum(algorithm, "", data))
+		}
+	}
+}
+
+// Decode decodes base64 or hex encoded values of the fields matching the
+// patterns into <field>.decoded, or in place when replace is set. Values
+// which can't be decoded are left untouched.
+//
+//	type = "decode"
+//	fields = ["payload-hex"]
+//	encoding = "hex"
+func Decode(options ...func(Processor) error) (Processor, error) {
+	p := &decode{
+		Encoding: "base64",
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	switch p.Encoding {
+	case "base64", "hex":
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", p.Encoding)
+	}
+
+	return p, nil
+}
+
+type decode struct {
+	Fields   []string `toml:"fields"`
+	Encoding string   `toml:"encoding"`
+	Replace  bool     `toml:"replace"`
+}
+
+func (p *decode) decode(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+
+	if p.Encoding == "hex" {
+		return hex.DecodeString(s)
+	}
+
+	if data, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (p *decode) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		v, _ := e.Load(k)
+
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		data, err := p.decode(s)
+		if err != nil {
+			continue
+		}
+
+		if p.Replace {
+			e.Store(k, string(data))
+		} else {
+			e.Store(k+".decoded", string(data))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package processors
+
+import (
+	"fmt"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+var (
+	_ = Register("rename", Rename)
+	_ = Register("drop", Drop)
+	_ = Register("redact", Redact)
+	_ = Register("tag", Tag)
+	_ = Register("truncate", Truncate)
+)
+
+// Rename renames fields.
+//
+//	type = "rename"
+//	fields = { "source-ip" = "src" }
+func Rename(options ...func(Processor) error) (Processor, error) {
+	p := &rename{}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type rename struct {
+	Fields map[string]string `toml:"fields"`
+}
+
+func (p *rename) Process(e event.Event) {
+	for from, to := range p.Fields {
+		v, ok := e.Load(from)
+		if !ok {
+			continue
+		}
+
+		e.Delete(from)
+		e.Store(to, v)
+	}
+}
+
+// Drop removes the fields matching the patterns.
+//
+//	type = "drop"
+//	fields = ["payload*", "http.header.cookie"]
+func Drop(options ...func(Processor) error) (Processor, error) {
+	p := &drop{}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type drop struct {
+	Fields []string `toml:"fields"`
+}
+
+func (p *drop) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		e.Delete(k)
+	}
+}
+
+// Redact replaces the values of the fields matching the patterns.
+//
+//	type = "redact"
+//	fields = ["http.header.authorization"]
+//	replacement = "[redacted]"
+func Redact(options ...func(Processor) error) (Processor, error) {
+	p := &redact{
+		Replacement: "[redacted]",
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type redact struct {
+	Fields      []string `toml:"fields"`
+	Replacement string   `toml:"replacement"`
+}
+
+func (p *redact) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		e.Store(k, p.Replacement)
+	}
+}
+
+// Tag adds static fields.
+//
+//	type = "tag"
+//	fields = { "sensor.location" = "ams-1" }
+func Tag(options ...func(Processor) error) (Processor, error) {
+	p := &tag{}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type tag struct {
+	Fields map[string]string `toml:"fields"`
+}
+
+func (p *tag) Process(e event.Event) {
+	for k, v := range p.Fields {
+		e.Store(k, v)
+	}
+}
+
+// Truncate truncates the values of the fields matching the patterns to
+// size bytes, truncated fields are marked with <field>.truncated.
+//
+//	type = "truncate"
+//	fields = ["payload", "http.body"]
+//	size = 4096
+func Truncate(options ...func(Processor) error) (Processor, error) {
+	p := &truncate{
+		Size: 4096,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", p.Size)
+	}
+
+	return p, nil
+}
+
+type truncate struct {
+	Fields []string `toml:"fields"`
+	Size   int      `toml:"size"`
+}
+
+func (p *truncate) Process(e event.Event) {
+	for _, k := range fields(e, p.Fields) {
+		v, _ := e.Load(k)
+
+		switch v := v.(type) {
+		case string:
+			if len(v) <= p.Size {
+				continue
+			}
+
+			e.Store(k, v[:p.Size])
+		case []byte:
+			if len(v) <= p.Size {
+				continue
+			}
+
+			e.Store(k, v[:p.Size])
+		default:
+			continue
+		}
+
+		e.Store(k+".truncated", true)
+	}
+}
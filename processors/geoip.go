@@ -0,0 +1,306 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package processors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	_ = Register("geoip", GeoIP)
+)
+
+// the database files looked up in the path of the geoip processor
+var geoipFiles = map[string]string{
+	"country": "GeoLite2-Country.mmdb",
+	"city":    "GeoLite2-City.mmdb",
+	"asn":     "GeoLite2-ASN.mmdb",
+}
+
+// GeoIP adds the country, city and autonomous system of an ip address,
+// looked up in local MaxMind databases. The databases are reopened when the
+// files change, no network access is needed.
+//
+//	[geoip]
+//	path = "/var/lib/geoip"
+//	# or the files
+//	country = "/var/lib/geoip/GeoLite2-Country.mmdb"
+//	city = "/var/lib/geoip/GeoLite2-City.mmdb"
+//	asn = "/var/lib/geoip/GeoLite2-ASN.mmdb"
+//	field = "source-ip"
+//	prefix = "source"
+//	reload-interval = "1m"
+//
+// This adds source.country, source.city, source.asn and source.as-org.
+func GeoIP(options ...func(Processor) error) (Processor, error) {
+	p := &geoip{
+		Field:          "source-ip",
+		Prefix:         "source",
+		ReloadInterval: config.Delay(time.Minute),
+		dbs:            map[string]*mmdb{},
+		done:           make(chan struct{}),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(p); err != nil {
+			return nil, err
+		}
+	}
+
+	files := map[string]string{
+		"country": p.Country,
+		"city":    p.City,
+		"asn":     p.ASN,
+	}
+
+	for kind, name := range geoipFiles {
+		if files[kind] != "" || p.Path == "" {
+			continue
+		}
+
+		// databases missing in the path are skipped
+		if file := filepath.Join(p.Path, name); fileExists(file) {
+			files[kind] = file
+		}
+	}
+
+	for kind, file := range files {
+		if file == "" {
+			continue
+		}
+
+		db, err := openMMDB(file)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("error opening %s database: %s", kind, err.Error())
+		}
+
+		p.dbs[kind] = db
+	}
+
+	if len(p.dbs) == 0 {
+		return nil, fmt.Errorf("no databases found")
+	}
+
+	if p.ReloadInterval > 0 {
+		go p.watch()
+	}
+
+	return p, nil
+}
+
+type geoip struct {
+	Path    string `toml:"path"`
+	Country string `toml:"country"`
+	City    string `toml:"city"`
+	ASN     string `toml:"asn"`
+
+	// Field is the field containing the ip address
+	Field string `toml:"field"`
+
+	// Prefix is the prefix of the added fields
+	Prefix string `toml:"prefix"`
+
+	// ReloadInterval is the interval the files are checked for changes
+	ReloadInterval config.Delay `toml:"reload-interval"`
+
+	m   sync.RWMutex
+	dbs map[string]*mmdb
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// mmdb is an opened database file.
+type mmdb struct {
+	*maxminddb.Reader
+
+	file    string
+	modTime time.Time
+	size    int64
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// openMMDB reads the database into memory, the file is not mapped as it
+// may be overwritten in place when updated.
+func openMMDB(file string) (*mmdb, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmdb{
+		Reader:  r,
+		file:    file,
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}, nil
+}
+
+// geoipRecord contains the fields of the country, city and asn databases.
+type geoipRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+func (p *geoip) Process(e event.Event) {
+	v, ok := e.Load(p.Field)
+	if !ok {
+		return
+	}
+
+	var ip net.IP
+
+	switch v := v.(type) {
+	case string:
+		ip = net.ParseIP(v)
+	case net.IP:
+		ip = v
+	}
+
+	if ip == nil {
+		return
+	}
+
+	record := geoipRecord{}
+
+	p.m.RLock()
+
+	for kind, db := range p.dbs {
+		if err := db.Lookup(ip, &record); err != nil {
+			log.Debugf("Error looking up %s in %s database: %s", ip, kind, err.Error())
+		}
+	}
+
+	p.m.RUnlock()
+
+	if record.Country.ISOCode != "" {
+		e.Store(p.Prefix+".country", record.Country.ISOCode)
+	}
+
+	if city := record.City.Names["en"]; city != "" {
+		e.Store(p.Prefix+".city", city)
+	}
+
+	if record.ASN != 0 {
+		e.Store(p.Prefix+".asn", record.ASN)
+	}
+
+	if record.ASOrg != "" {
+		e.Store(p.Prefix+".as-org", record.ASOrg)
+	}
+}
+
+// reload reopens the databases of which the files changed.
+func (p *geoip) reload() {
+	p.m.RLock()
+
+	changed := map[string]*mmdb{}
+
+	for kind, db := range p.dbs {
+		fi, err := os.Stat(db.file)
+		if err != nil {
+			continue
+		} else if fi.ModTime().Equal(db.modTime) && fi.Size() == db.size {
+			continue
+		}
+
+		ndb, err := openMMDB(db.file)
+		if err != nil {
+			// the file may be written, try again next interval
+			log.Errorf("Error reopening %s database: %s", kind, err.Error())
+			continue
+		}
+
+		changed[kind] = ndb
+	}
+
+	p.m.RUnlock()
+
+	if len(changed) == 0 {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for kind, db := range changed {
+		log.Infof("Reloaded %s database %s", kind, db.file)
+
+		p.dbs[kind].Close()
+		p.dbs[kind] = db
+	}
+}
+
+func (p *geoip) watch() {
+	ticker := time.NewTicker(p.ReloadInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.reload()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Close stops watching the files and closes the databases.
+func (p *geoip) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		p.m.Lock()
+		defer p.m.Unlock()
+
+		for _, db := range p.dbs {
+			db.Close()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,289 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package processors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// mmdbEncode encodes the value in the MaxMind DB data format.
+func mmdbEncode(buf *bytes.Buffer, v interface{}) {
+	control := func(typ int, size int) {
+		// sizes of 29 and up are stored in the next byte
+		extra := []byte{}
+		if size >= 29 {
+			extra, size = []byte{byte(size - 29)}, 29
+		}
+
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+		} else {
+			buf.WriteByte(byte(typ<<5 | size))
+		}
+
+		buf.Write(extra)
+	}
+
+	uint := func(typ int, n uint64) {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+
+		b = bytes.TrimLeft(b, "\x00")
+
+		control(typ, len(b))
+		buf.Write(b)
+	}
+
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		uint(5, uint64(v))
+	case uint32:
+		uint(6, uint64(v))
+	case uint64:
+		uint(9, v)
+	case []string:
+		control(11, len(v))
+
+		for _, s := range v {
+			mmdbEncode(buf, s)
+		}
+	case map[string]interface{}:
+		control(7, len(v))
+
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// writeMMDB writes an ipv4 MaxMind DB with the records of the networks.
+func writeMMDB(t *testing.T, file string, records map[string]map[string]interface{}) {
+	t.Helper()
+
+	type node struct {
+		children [2]*node
+		data     int
+	}
+
+	root := &node{data: -1}
+
+	data := &bytes.Buffer{}
+
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ones, _ := network.Mask.Size()
+
+		offset := data.Len()
+		mmdbEncode(data, record)
+
+		n := root
+		for i := 0; i < ones; i++ {
+			bit := (network.IP.To4()[i/8] >> uint(7-i%8)) & 1
+
+			if n.children[bit] == nil {
+				n.children[bit] = &node{data: -1}
+			}
+
+			n = n.children[bit]
+		}
+
+		n.data = offset
+	}
+
+	// number the inner nodes
+	nodes := []*node{}
+	numbers := map[*node]int{}
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.data >= 0 {
+			return
+		}
+
+		numbers[n] = len(nodes)
+		nodes = append(nodes, n)
+
+		for _, c := range n.children {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+
+	walk(root)
+
+	buf := &bytes.Buffer{}
+
+	for _, n := range nodes {
+		for _, c := range n.children {
+			value := len(nodes)
+
+			if c == nil {
+			} else if c.data >= 0 {
+				value = len(nodes) + 16 + c.data
+			} else {
+				value = numbers[c]
+			}
+
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+
+	mmdbEncode(buf, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{"en": "Test"},
+		"ip_version":                  uint16(4),
+		"languages":                   []string{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	})
+
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cityRecord(country, city string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": country},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": city}},
+	}
+}
+
+func TestGeoIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeMMDB(t, filepath.Join(dir, "GeoLite2-City.mmdb"), map[string]map[string]interface{}{
+		"198.51.100.0/24": cityRecord("NL", "Amsterdam"),
+	})
+
+	writeMMDB(t, filepath.Join(dir, "GeoLite2-ASN.mmdb"), map[string]map[string]interface{}{
+		"198.51.0.0/16": {
+			"autonomous_system_number":       uint32(64496),
+			"autonomous_system_organization": "Example AS",
+		},
+	})
+
+	p, err := GeoIP(func(p Processor) error {
+		p.(*geoip).Path = dir
+		p.(*geoip).ReloadInterval = config.Delay(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer p.(*geoip).Close()
+
+	e := event.New(event.SourceIP(net.ParseIP("198.51.100.7")))
+	p.Process(e)
+
+	expected := map[string]interface{}{
+		"source.country": "NL",
+		"source.city":    "Amsterdam",
+		"source.asn":     uint(64496),
+		"source.as-org":  "Example AS",
+	}
+
+	for k, v := range expected {
+		if value(e, k) != v {
+			t.Errorf("Expected %v for %s, got %v", v, k, value(e, k))
+		}
+	}
+
+	// not in the databases
+	e = event.New(event.SourceIP(net.ParseIP("192.0.2.1")))
+	p.Process(e)
+
+	if e.Has("source.country") || e.Has("source.asn") {
+		t.Errorf("Expected no enrichment for unknown address")
+	}
+
+	// the database is reloaded when the file changes
+	writeMMDB(t, filepath.Join(dir, "GeoLite2-City.mmdb"), map[string]map[string]interface{}{
+		"198.51.100.0/24": cityRecord("BE", "Brussels"),
+	})
+
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "GeoLite2-City.mmdb"), future, future)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		e = event.New(event.SourceIP(net.ParseIP("198.51.100.7")))
+		p.Process(e)
+
+		if e.Get("source.country") == "BE" {
+			return
+		}
+	}
+
+	t.Errorf("Expected database to be reloaded, got %s", e.Get("source.country"))
+}
+
+func TestGeoIPMissing(t *testing.T) {
+	if _, err := GeoIP(func(p Processor) error {
+		p.(*geoip).Country = "/nonexistent/GeoLite2-Country.mmdb"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for missing database")
+	}
+
+	if _, err := GeoIP(func(p Processor) error {
+		p.(*geoip).Path = "/nonexistent"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error when no databases are found")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package processors contains the stages which transform events before they
+// are delivered to the channels. Processors are configured in named chains:
+//
+//	[[processor]]
+//	name = "third-party"
+//	type = "hash"
+//	fields = ["ssh.password"]
+//
+//	[[processor]]
+//	name = "third-party"
+//	type = "drop"
+//	fields = ["payload*"]
+//
+//	[[filter]]
+//	channel = ["dshield"]
+//	processor = "third-party"
+package processors
+
+import (
+	"path"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:processors")
+
+var (
+	processors = map[string]func(...func(Processor) error) (Processor, error){}
+)
+
+func Register(key string, fn func(...func(Processor) error) (Processor, error)) func(...func(Processor) error) (Processor, error) {
+	processors[key] = fn
+	return fn
+}
+
+func Get(key string) (func(...func(Processor) error) (Processor, error), bool) {
+	if fn, ok := processors[key]; ok {
+		return fn, true
+	}
+
+	return nil, false
+}
+
+// Processor transforms events, the event can be modified in place as the
+// chain hands it a copy.
+type Processor interface {
+	Process(event.Event)
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(Processor) error {
+	return func(p Processor) error {
+		return decoder.PrimitiveDecode(c, p)
+	}
+}
+
+// Chain runs processors in order.
+type Chain []Processor
+
+// Process returns a copy of the event transformed by the processors of the
+// chain, the event itself is shared with other channels and left untouched.
+func (c Chain) Process(e event.Event) event.Event {
+	e = event.New(event.CopyFrom(event.ToMap(e)))
+
+	for _, p := range c {
+		p.Process(e)
+	}
+
+	return e
+}
+
+type processorChannel struct {
+	pushers.Channel
+
+	chain Chain
+}
+
+func (pc processorChannel) Send(e event.Event) {
+	pc.Channel.Send(pc.chain.Process(e))
+}
+
+// Channel returns a channel which sends the events transformed by the
+// chain to channel.
+func Channel(channel pushers.Channel, chain Chain) pushers.Channel {
+	return processorChannel{
+		Channel: channel,
+		chain:   chain,
+	}
+}
+
+// fields returns the keys of the event matching one of the patterns, which
+// can contain wildcards, e.g. http.header.*
+func fields(e event.Event, patterns []string) []string {
+	keys := []string{}
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, k); matched {
+				keys = append(keys, k)
+				break
+			}
+		}
+
+		return true
+	})
+
+	return keys
+}
+
+// bytesOf returns the value as bytes, the second value is false for values
+// which aren't strings.
+func bytesOf(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return v, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,192 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package processors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+)
+
+// chain builds the chain of the processor configuration.
+func chain(t *testing.T, data string) Chain {
+	t.Helper()
+
+	conf := struct {
+		Processors []toml.Primitive `toml:"processor"`
+	}{}
+
+	md, err := toml.DecodeReader(bytes.NewBufferString(data), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Chain{}
+
+	for _, s := range conf.Processors {
+		x := struct {
+			Type string `toml:"type"`
+		}{}
+
+		if err := md.PrimitiveDecode(s, &x); err != nil {
+			t.Fatal(err)
+		}
+
+		fn, ok := Get(x.Type)
+		if !ok {
+			t.Fatalf("Processor %s not registered", x.Type)
+		}
+
+		p, err := fn(WithConfig(s, &md))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c = append(c, p)
+	}
+
+	return c
+}
+
+func value(e event.Event, key string) interface{} {
+	v, _ := e.Load(key)
+	return v
+}
+
+func TestChain(t *testing.T) {
+	c := chain(t, `
+[[processor]]
+type = "hash"
+fields = ["ssh.password"]
+
+[[processor]]
+type = "rename"
+fields = { "source-ip" = "src" }
+
+[[processor]]
+type = "drop"
+fields = ["http.header.*"]
+
+[[processor]]
+type = "redact"
+fields = ["ssh.username"]
+
+[[processor]]
+type = "tag"
+fields = { "sensor.location" = "ams-1" }
+
+[[processor]]
+type = "digest"
+fields = ["payload"]
+algorithms = ["md5", "sha256"]
+
+[[processor]]
+type = "truncate"
+fields = ["payload"]
+size = 4
+`)
+
+	e := event.New(
+		event.Custom("ssh.password", "secret"),
+		event.Custom("ssh.username", "root"),
+		event.Custom("source-ip", "198.51.100.1"),
+		event.Custom("http.header.cookie", "session"),
+		event.Custom("http.method", "GET"),
+		event.Custom("payload", "hello world"),
+	)
+
+	tc := &pushertest.Channel{}
+	Channel(tc, c).Send(e)
+
+	if len(tc.Events()) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(tc.Events()))
+	}
+
+	p := tc.Events()[0]
+
+	expected := map[string]interface{}{
+		"ssh.password":       "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		"ssh.username":       "[redacted]",
+		"src":                "198.51.100.1",
+		"source-ip":          nil,
+		"http.header.cookie": nil,
+		"http.method":        "GET",
+		"sensor.location":    "ams-1",
+		"payload":            "hell",
+		"payload.truncated":  true,
+		"payload.md5":        "5eb63bbbe01eeed093cb22bb8f5acdc3",
+		"payload.sha256":     "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	}
+
+	for k, v := range expected {
+		if value(p, k) != v {
+			t.Errorf("Expected %v for %s, got %v", v, k, value(p, k))
+		}
+	}
+
+	// the event shared with other channels is untouched
+	if value(e, "ssh.password") != "secret" || value(e, "payload") != "hello world" {
+		t.Errorf("Expected original event to be untouched")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	c := chain(t, `
+[[processor]]
+type = "decode"
+fields = ["payload-hex"]
+encoding = "hex"
+
+[[processor]]
+type = "decode"
+fields = ["http.body"]
+replace = true
+`)
+
+	p := c.Process(event.New(
+		event.Custom("payload-hex", "68656c6c6f"),
+		event.Custom("http.body", "aGVsbG8"),
+	))
+
+	if v := value(p, "payload-hex.decoded"); v != "hello" {
+		t.Errorf("Expected hex to be decoded, got %v", v)
+	}
+
+	if v := value(p, "http.body"); v != "hello" {
+		t.Errorf("Expected base64 to be decoded in place, got %v", v)
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	if _, err := Hash(func(p Processor) error {
+		p.(*hashProcessor).Algorithm = "crc32"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for unknown algorithm")
+	}
+}
+
+func TestTruncateSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		if _, err := Truncate(func(p Processor) error {
+			p.(*truncate).Size = size
+			return nil
+		}); err == nil {
+			t.Errorf("Expected size %d to be refused", size)
+		}
+	}
+}
@@ -0,0 +1,622 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profiles aggregates the activity of attackers per source ip. The
+// profiles are updated from the events on the bus and persisted in the
+// storage, so analysts can pivot on an attacker instead of on events.
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:profiles")
+
+var (
+	// ErrNotFound is returned when there is no profile of the ip
+	ErrNotFound = errors.New("profile not found")
+
+	// ErrInvalidSort is returned by List for an unknown sort
+	ErrInvalidSort = errors.New("invalid sort")
+)
+
+// Credential is a username and password tried on a service.
+type Credential struct {
+	Service  string `json:"service"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Command is a command run on a service.
+type Command struct {
+	Service string `json:"service"`
+	Command string `json:"command"`
+}
+
+// Profile contains the activity of a source ip.
+type Profile struct {
+	IP string `json:"ip"`
+
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+
+	// Events is the number of events of the source ip
+	Events int `json:"events"`
+
+	// Categories, Ports and Services count the events per category,
+	// destination port and service
+	Categories map[string]int `json:"categories"`
+	Ports      map[string]int `json:"ports"`
+	Services   map[string]int `json:"services"`
+
+	Credentials []Credential `json:"credentials"`
+	Commands    []Command    `json:"commands"`
+
+	// Fingerprints contains the client fingerprints by kind, like ja3
+	// and hassh
+	Fingerprints map[string][]string `json:"fingerprints"`
+
+	// Artifacts contains the sha256 hashes of the dropped artifacts
+	Artifacts []string `json:"artifacts"`
+}
+
+func newProfile(ip string) *Profile {
+	return &Profile{
+		IP:           ip,
+		Categories:   map[string]int{},
+		Ports:        map[string]int{},
+		Services:     map[string]int{},
+		Credentials:  []Credential{},
+		Commands:     []Command{},
+		Fingerprints: map[string][]string{},
+		Artifacts:    []string{},
+	}
+}
+
+// Store keeps the profiles. Events are queued by Send and applied in the
+// background, updated profiles are buffered in memory and written every
+// flush interval.
+//
+//	[profiles]
+//	max-entries = 100
+//	max-pending = 10000
+//	flush-interval = "10s"
+type Store struct {
+	// MaxEntries limits the number of credentials, commands,
+	// fingerprints and artifacts kept per profile
+	MaxEntries int `toml:"max-entries"`
+
+	// MaxPending limits the number of queued events, events are dropped
+	// when the queue is full
+	MaxPending int `toml:"max-pending"`
+
+	// FlushInterval is the interval updated profiles are written
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	storage interface {
+		Get(string) ([]byte, error)
+		Set(string, []byte) error
+		Range(string, func(string, []byte) bool) error
+	}
+
+	// m protects dirty and index
+	m sync.Mutex
+
+	// dirty contains the profiles updated since the last flush
+	dirty map[string]*Profile
+
+	// index contains the sort keys of all profiles, so List only has to
+	// load the profiles of the requested page
+	index map[string]*entry
+
+	// pendingM protects pending, Send only takes this lock so it never
+	// waits on the storage
+	pendingM sync.Mutex
+	pending  []event.Event
+	dropped  int
+
+	notify chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// entry is the sort keys of a profile.
+type entry struct {
+	IP        string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Events    int
+}
+
+func newEntry(p *Profile) *entry {
+	return &entry{
+		IP:        p.IP,
+		FirstSeen: p.FirstSeen,
+		LastSeen:  p.LastSeen,
+		Events:    p.Events,
+	}
+}
+
+// New returns a store, which persists the profiles in the profiles
+// namespace of the storage.
+func New(options ...func(*Store) error) (*Store, error) {
+	st, err := storage.Namespace("profiles")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		MaxEntries:    100,
+		MaxPending:    10000,
+		FlushInterval: config.Delay(10 * time.Second),
+		storage:       st,
+		dirty:         map[string]*Profile{},
+		index:         map[string]*entry{},
+		notify:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	for _, fn := range options {
+		if err := fn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := st.Range("", func(key string, data []byte) bool {
+		p := newProfile(key)
+		if err := json.Unmarshal(data, p); err != nil {
+			log.Errorf("Error decoding profile %s: %s", key, err.Error())
+			return true
+		}
+
+		s.index[key] = newEntry(p)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+var (
+	defaultStore *Store
+	defaultM     sync.Mutex
+)
+
+// SetDefault sets the store used by the package functions.
+func SetDefault(s *Store) {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	defaultStore = s
+}
+
+// Default returns the store used by the package functions, nil when not
+// set.
+func Default() *Store {
+	defaultM.Lock()
+	defer defaultM.Unlock()
+
+	return defaultStore
+}
+
+func (s *Store) run() {
+	ticker := time.NewTicker(s.FlushInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.notify:
+			s.apply()
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Errorf("Error writing profiles: %s", err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// load returns the profile of ip, should be called with the lock held.
+func (s *Store) load(ip string) (*Profile, error) {
+	if p, ok := s.dirty[ip]; ok {
+		return p, nil
+	}
+
+	data, err := s.storage.Get(ip)
+	if err == storage.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := newProfile(ip)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Send queues the event to update the profile of its source ip. Send doesn't
+// block the bus, the event is dropped when the queue is full.
+func (s *Store) Send(e event.Event) {
+	if net.ParseIP(e.Get("source-ip")) == nil {
+		return
+	}
+
+	s.pendingM.Lock()
+	if len(s.pending) >= s.MaxPending {
+		s.dropped++
+		s.pendingM.Unlock()
+		return
+	}
+
+	s.pending = append(s.pending, e)
+	s.pendingM.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// apply updates the profiles with the queued events. The queue is taken with
+// the lock held, so a Flush after Send always contains the event.
+func (s *Store) apply() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.pendingM.Lock()
+	pending, dropped := s.pending, s.dropped
+	s.pending, s.dropped = nil, 0
+	s.pendingM.Unlock()
+
+	if dropped > 0 {
+		log.Warningf("Dropped %d events, the profiles queue is full", dropped)
+	}
+
+	for _, e := range pending {
+		ip := net.ParseIP(e.Get("source-ip")).String()
+
+		p, err := s.load(ip)
+		if err == ErrNotFound {
+			p = newProfile(ip)
+		} else if err != nil {
+			log.Errorf("Error loading profile %s: %s", ip, err.Error())
+			continue
+		}
+
+		s.update(p, e)
+
+		s.dirty[p.IP] = p
+		s.index[p.IP] = newEntry(p)
+	}
+}
+
+// appendUnique appends v when it is not in values and the maximum number of
+// entries isn't reached.
+func appendUnique(values []string, v string, max int) []string {
+	if v == "" || len(values) >= max {
+		return values
+	}
+
+	for _, value := range values {
+		if value == v {
+			return values
+		}
+	}
+
+	return append(values, v)
+}
+
+// fingerprint returns the kind of fingerprint of the field, if any.
+func fingerprint(key string) string {
+	switch {
+	case strings.HasSuffix(key, "ja3-digest"), strings.HasSuffix(key, ".ja3"):
+		return "ja3"
+	case strings.HasSuffix(key, "ja3s-digest"), strings.HasSuffix(key, ".ja3s"):
+		return "ja3s"
+	case strings.HasSuffix(key, ".ja4"):
+		return "ja4"
+	case strings.HasSuffix(key, ".hassh"):
+		return "hassh"
+	}
+
+	return ""
+}
+
+func (s *Store) update(p *Profile, e event.Event) {
+	now := time.Now()
+
+	if p.FirstSeen.IsZero() {
+		p.FirstSeen = now
+	}
+
+	p.LastSeen = now
+	p.Events++
+
+	category := e.Get("category")
+	if category != "" {
+		p.Categories[category]++
+	}
+
+	service := e.Get("service")
+	if service == "" {
+		service = category
+	}
+
+	if service != "" {
+		p.Services[service]++
+	}
+
+	if v, ok := e.Load("destination-port"); ok {
+		p.Ports[fmt.Sprint(v)]++
+	}
+
+	if hash := e.Get("artifact.sha256"); hash != "" {
+		p.Artifacts = appendUnique(p.Artifacts, hash, s.MaxEntries)
+	}
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		v, ok := value.(string)
+		if !ok || v == "" {
+			return true
+		}
+
+		if kind := fingerprint(k); kind != "" {
+			// prefer the digest when the event contains both
+			if e.Get(k+"-digest") != "" {
+				return true
+			}
+
+			p.Fingerprints[kind] = appendUnique(p.Fingerprints[kind], v, s.MaxEntries)
+			return true
+		}
+
+		// commands are stored as <service>.command
+		if i := strings.LastIndex(k, "."); i > 0 && k[i+1:] == "command" && len(p.Commands) < s.MaxEntries {
+			p.Commands = append(p.Commands, Command{
+				Service: k[:i],
+				Command: v,
+			})
+		}
+
+		return true
+	})
+
+	// credentials are stored as <service>.username and <service>.password
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok || !strings.HasSuffix(k, ".password") {
+			return true
+		}
+
+		prefix := strings.TrimSuffix(k, ".password")
+
+		c := Credential{
+			Service:  prefix,
+			Username: e.Get(prefix + ".username"),
+			Password: e.Get(k),
+		}
+
+		for _, v := range p.Credentials {
+			if v == c {
+				return true
+			}
+		}
+
+		if len(p.Credentials) < s.MaxEntries {
+			p.Credentials = append(p.Credentials, c)
+		}
+
+		return true
+	})
+}
+
+// Flush applies the queued events and writes the updated profiles.
+func (s *Store) Flush() error {
+	s.apply()
+
+	s.m.Lock()
+	dirty := s.dirty
+	s.dirty = map[string]*Profile{}
+	s.m.Unlock()
+
+	var lastErr error
+
+	for ip, p := range dirty {
+		data, err := json.Marshal(p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := s.storage.Set(ip, data); err != nil {
+			lastErr = err
+
+			// keep the profile for the next flush, unless it has been
+			// updated since
+			s.m.Lock()
+			if _, ok := s.dirty[ip]; !ok {
+				s.dirty[ip] = p
+			}
+			s.m.Unlock()
+		}
+	}
+
+	return lastErr
+}
+
+// Close writes the updated profiles and stops the store.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	return s.Flush()
+}
+
+// Get returns the profile of the ip.
+func (s *Store) Get(ip string) (*Profile, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	s.apply()
+
+	return s.get(ip)
+}
+
+// get returns a copy of the profile of the ip.
+func (s *Store) get(ip string) (*Profile, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	p, err := s.load(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// return a copy, the profile may be updated concurrently
+	v := newProfile(ip)
+	return v, json.Unmarshal(data, v)
+}
+
+// Sort orders of List.
+const (
+	SortLastSeen  = "last-seen"
+	SortFirstSeen = "first-seen"
+	SortEvents    = "events"
+	SortIP        = "ip"
+)
+
+// MaxLimit is the maximum number of profiles returned by List.
+const MaxLimit = 1000
+
+// Query selects the profiles returned by List.
+type Query struct {
+	// Sort is the order of the profiles, last-seen by default
+	Sort string
+
+	// Ascending reverses the default descending order
+	Ascending bool
+
+	Offset int
+
+	// Limit is the number of profiles, at most MaxLimit
+	Limit int
+}
+
+// List returns the page of profiles selected by the query, with the total
+// number of profiles. The profiles are sorted on the index, only the
+// profiles of the page are loaded.
+func (s *Store) List(q Query) ([]Profile, int, error) {
+	var less func(a, b *entry) bool
+
+	switch q.Sort {
+	case "", SortLastSeen:
+		less = func(a, b *entry) bool { return a.LastSeen.Before(b.LastSeen) }
+	case SortFirstSeen:
+		less = func(a, b *entry) bool { return a.FirstSeen.Before(b.FirstSeen) }
+	case SortEvents:
+		less = func(a, b *entry) bool { return a.Events < b.Events }
+	case SortIP:
+		less = func(a, b *entry) bool { return a.IP < b.IP }
+	default:
+		return nil, 0, ErrInvalidSort
+	}
+
+	if q.Limit <= 0 || q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+
+	s.apply()
+
+	s.m.Lock()
+	entries := make([]entry, 0, len(s.index))
+	for _, e := range s.index {
+		entries = append(entries, *e)
+	}
+	s.m.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if !q.Ascending {
+			a, b = b, a
+		}
+
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+
+		// keep the order of equal profiles stable between pages
+		return entries[i].IP < entries[j].IP
+	})
+
+	total := len(entries)
+
+	if q.Offset > total {
+		q.Offset = total
+	}
+
+	entries = entries[q.Offset:]
+
+	if q.Limit < len(entries) {
+		entries = entries[:q.Limit]
+	}
+
+	profiles := make([]Profile, 0, len(entries))
+
+	for _, e := range entries {
+		p, err := s.get(e.IP)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		profiles = append(profiles, *p)
+	}
+
+	return profiles, total, nil
+}
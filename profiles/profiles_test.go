@@ -0,0 +1,298 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package profiles
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "honeytrap-profiles")
+	if err != nil {
+		panic(err)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newStore(t *testing.T) *Store {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestProfile(t *testing.T) {
+	s := newStore(t)
+	defer s.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}
+
+	s.Send(event.New(
+		event.Category("ssh"),
+		event.Service("ssh"),
+		event.SourceAddr(src),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 22}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+		event.Custom("ssh.hassh", "ec7378c1a92f5a8dde7e8b7a1ddf33d1"),
+	))
+
+	// the same credentials are recorded once
+	s.Send(event.New(
+		event.Category("ssh"),
+		event.Service("ssh"),
+		event.SourceAddr(src),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 22}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+		event.Custom("ssh.command", "uname -a"),
+	))
+
+	s.Send(event.New(
+		event.Category("http"),
+		event.SourceAddr(src),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}),
+		event.Custom("https.ja3", "769,47-53,0-10,23,0"),
+		event.Custom("https.ja3-digest", "e7d705a3286e19ea42f587b344ee6865"),
+		event.Custom("https.ja3s", "771,49195,65281-16"),
+		event.Custom("artifact.sha256", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+	))
+
+	// events without source ip are ignored
+	s.Send(event.New(event.Category("heartbeat")))
+
+	p, err := s.Get("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Events != 3 {
+		t.Errorf("Expected 3 events, got %d", p.Events)
+	}
+
+	if p.FirstSeen.IsZero() || p.LastSeen.Before(p.FirstSeen) {
+		t.Errorf("Unexpected first seen %s and last seen %s", p.FirstSeen, p.LastSeen)
+	}
+
+	if p.Ports["22"] != 2 || p.Ports["443"] != 1 {
+		t.Errorf("Unexpected ports %v", p.Ports)
+	}
+
+	if p.Services["ssh"] != 2 || p.Services["http"] != 1 {
+		t.Errorf("Unexpected services %v", p.Services)
+	}
+
+	if len(p.Credentials) != 1 || p.Credentials[0] != (Credential{"ssh", "root", "toor"}) {
+		t.Errorf("Unexpected credentials %v", p.Credentials)
+	}
+
+	if len(p.Commands) != 1 || p.Commands[0] != (Command{"ssh", "uname -a"}) {
+		t.Errorf("Unexpected commands %v", p.Commands)
+	}
+
+	// the raw ja3 is only recorded without digest
+	if len(p.Fingerprints["ja3"]) != 1 || len(p.Fingerprints["ja3s"]) != 1 || len(p.Fingerprints["hassh"]) != 1 {
+		t.Errorf("Unexpected fingerprints %v", p.Fingerprints)
+	}
+
+	if len(p.Artifacts) != 1 {
+		t.Errorf("Unexpected artifacts %v", p.Artifacts)
+	}
+
+	if _, err := s.Get("192.0.2.254"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	s := newStore(t)
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 51234}
+
+	s.Send(event.New(event.Category("telnet"), event.SourceAddr(src)))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store continues the stored profile
+	s = newStore(t)
+	defer s.Close()
+
+	s.Send(event.New(event.Category("telnet"), event.SourceAddr(src)))
+
+	p, err := s.Get("192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Events != 2 {
+		t.Errorf("Expected 2 events, got %d", p.Events)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	s := newStore(t)
+	defer s.Close()
+
+	s.MaxEntries = 2
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 51234}
+
+	for _, cmd := range []string{"id", "whoami", "uname"} {
+		s.Send(event.New(event.SourceAddr(src), event.Custom("telnet.command", cmd)))
+	}
+
+	p, err := s.Get("192.0.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Commands) != 2 {
+		t.Errorf("Expected 2 commands, got %d", len(p.Commands))
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newStore(t)
+	defer s.Close()
+
+	for i, ip := range []string{"198.51.100.10", "198.51.100.11", "198.51.100.12"} {
+		src := &net.TCPAddr{IP: net.ParseIP(ip), Port: 51234}
+
+		for j := 0; j <= i; j++ {
+			s.Send(event.New(event.SourceAddr(src)))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	all, total, err := s.List(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != len(all) || total < 3 {
+		t.Fatalf("Unexpected total %d of %d profiles", total, len(all))
+	}
+
+	for i := 1; i < len(all); i++ {
+		if all[i].LastSeen.After(all[i-1].LastSeen) {
+			t.Errorf("Profiles not sorted by last seen descending")
+		}
+	}
+
+	page, _, err := s.List(Query{Sort: SortEvents, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page) != 1 {
+		t.Fatalf("Expected 1 profile, got %d", len(page))
+	}
+
+	first, _, _ := s.List(Query{Sort: SortEvents, Limit: 2})
+	if len(first) != 2 || first[1].IP != page[0].IP {
+		t.Errorf("Expected page to contain %v, got %s", first, page[0].IP)
+	}
+
+	asc, _, err := s.List(Query{Sort: SortIP, Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < len(asc); i++ {
+		if asc[i].IP < asc[i-1].IP {
+			t.Errorf("Profiles not sorted by ip ascending")
+		}
+	}
+
+	if _, _, err := s.List(Query{Sort: "unknown"}); err != ErrInvalidSort {
+		t.Errorf("Expected ErrInvalidSort, got %v", err)
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	s := newStore(t)
+	defer s.Close()
+
+	s.MaxPending = 2
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.4"), Port: 51234}
+
+	// block the background apply, so the events stay queued
+	s.m.Lock()
+	for i := 0; i < 5; i++ {
+		s.Send(event.New(event.SourceAddr(src)))
+	}
+	s.m.Unlock()
+
+	p, err := s.Get("192.0.2.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Events != 2 {
+		t.Errorf("Expected 2 events, got %d", p.Events)
+	}
+}
+
+func TestListIndex(t *testing.T) {
+	s := newStore(t)
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.5"), Port: 51234}
+
+	s.Send(event.New(event.SourceAddr(src)))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store indexes the stored profiles
+	s = newStore(t)
+	defer s.Close()
+
+	list, total, err := s.List(Query{Sort: SortIP, Limit: MaxLimit + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != len(list) {
+		t.Errorf("Expected %d profiles, got %d", total, len(list))
+	}
+
+	found := false
+	for _, p := range list {
+		found = found || (p.IP == "192.0.2.5" && p.Events == 1)
+	}
+
+	if !found {
+		t.Errorf("Expected profile 192.0.2.5 in %v", list)
+	}
+}
@@ -14,6 +14,8 @@
 package pushers
 
 import (
+	"sync/atomic"
+
 	"github.com/BurntSushi/toml"
 	"github.com/honeytrap/honeytrap/event"
 )
@@ -24,6 +26,84 @@ type Channel interface {
 	Send(event.Event)
 }
 
+// Flusher is implemented by channels which buffer events, Flush delivers the
+// buffered events.
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is implemented by channels which have to release resources on
+// shutdown, the queued events are delivered before closing.
+type Closer interface {
+	Close() error
+}
+
+// Close flushes and closes the channel, when it supports this.
+func Close(c Channel) error {
+	if f, ok := c.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if cl, ok := c.(Closer); ok {
+		return cl.Close()
+	}
+
+	return nil
+}
+
+// Queuer is implemented by channels which queue events, QueueLen returns the
+// number of queued events.
+type Queuer interface {
+	QueueLen() int
+}
+
+// Counter is implemented by channels which count the results of delivering
+// events.
+type Counter interface {
+	Counts() Counts
+}
+
+// Counts are the results of delivering the events of a channel.
+type Counts struct {
+	Delivered int64
+	Failed    int64
+	Dropped   int64
+}
+
+// Counters counts the results of delivering events, channels embed a
+// pointer to it to implement Counter.
+type Counters struct {
+	delivered int64
+	failed    int64
+	dropped   int64
+}
+
+// Delivered counts n delivered events.
+func (c *Counters) Delivered(n int) {
+	atomic.AddInt64(&c.delivered, int64(n))
+}
+
+// Failed counts n events of which delivery failed.
+func (c *Counters) Failed(n int) {
+	atomic.AddInt64(&c.failed, int64(n))
+}
+
+// Dropped counts n dropped events.
+func (c *Counters) Dropped(n int) {
+	atomic.AddInt64(&c.dropped, int64(n))
+}
+
+// Counts returns the counts.
+func (c *Counters) Counts() Counts {
+	return Counts{
+		Delivered: atomic.LoadInt64(&c.delivered),
+		Failed:    atomic.LoadInt64(&c.failed),
+		Dropped:   atomic.LoadInt64(&c.dropped),
+	}
+}
+
 type ChannelFunc func(...func(Channel) error) (Channel, error)
 
 var (
@@ -24,6 +24,7 @@ import (
 	"net/http/httputil"
 	"os"
 	"runtime"
+	"strconv"
 
 	"time"
 
@@ -63,6 +64,8 @@ type Backend struct {
 	MyIP string
 
 	ch chan json.Marshaler
+
+	client *http.Client
 }
 
 var types = []string{"email", "firewall", "sshlogin", "telnetlogin", "404report", "httprequest", "webhoneypot"}
@@ -81,6 +84,18 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		optionFn(&c)
 	}
 
+	tlsClientConfig := &tls.Config{}
+
+	if c.Insecure {
+		tlsClientConfig = Insecure(tlsClientConfig)
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsClientConfig,
+		},
+	}
+
 	myIP, err := GetMyIP()
 	if err != nil {
 		return nil, err
@@ -155,19 +170,6 @@ func (hc Backend) MakeAuthHeader() (string, error) {
 }
 
 func (hc Backend) run() {
-
-	tlsClientConfig := &tls.Config{}
-
-	if hc.Insecure {
-		tlsClientConfig = Insecure(tlsClientConfig)
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsClientConfig,
-		},
-	}
-
 	docs := make([]json.Marshaler, 0)
 
 	send := func(docs []json.Marshaler) {
@@ -175,63 +177,9 @@ func (hc Backend) run() {
 			return
 		}
 
-		authHeader := ""
-		if val, err := hc.MakeAuthHeader(); err == nil {
-			authHeader = val
-		} else {
-			log.Errorf("Error creating DShield authentication header: %s", err.Error())
-		}
-
-		l := Submit{
-			AuthHeader: authHeader,
-			Type:       "multiple",
-			Logs:       docs,
-		}
-
-		pr, pw := io.Pipe()
-
-		hash := sha1.New()
-
-		r := io.TeeReader(pr, hash)
-		r = io.TeeReader(r, os.Stdout)
-
-		go func(l Submit) {
-			var err error
-
-			defer pw.CloseWithError(err)
-
-			if err := json.NewEncoder(pw).Encode(l); err != nil {
-				log.Errorf("Error json encoding: %s", err.Error())
-			}
-		}(l)
-
-		req, err := http.NewRequest(http.MethodPost, "https://www.dshield.org/submitapi/", r)
-		if err != nil {
-			log.Errorf("Could create new request: %s", err.Error())
-			return
-		}
-
-		req.Header.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
-		if err != nil {
+		if err := hc.submit(docs); err != nil {
 			log.Errorf("Could not submit event to DShield: %s", err.Error())
-			return
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			log.Errorf("Could not submit event to DShield: %d", resp.StatusCode)
-			return
 		}
-
-		if !hc.Debug {
-		} else if val, err := httputil.DumpResponse(resp, true); err == nil {
-			log.Debug(string(val))
-		}
-
-		// verify hash!
-		fmt.Printf("%x\n", hash.Sum(nil))
 	}
 
 	for {
@@ -254,6 +202,67 @@ func (hc Backend) run() {
 	}
 }
 
+// submit submits the documents to DShield.
+func (hc Backend) submit(docs []json.Marshaler) error {
+	authHeader := ""
+	if val, err := hc.MakeAuthHeader(); err == nil {
+		authHeader = val
+	} else {
+		log.Errorf("Error creating DShield authentication header: %s", err.Error())
+	}
+
+	l := Submit{
+		AuthHeader: authHeader,
+		Type:       "multiple",
+		Logs:       docs,
+	}
+
+	pr, pw := io.Pipe()
+
+	hash := sha1.New()
+
+	r := io.TeeReader(pr, hash)
+	r = io.TeeReader(r, os.Stdout)
+
+	go func(l Submit) {
+		var err error
+
+		defer pw.CloseWithError(err)
+
+		if err := json.NewEncoder(pw).Encode(l); err != nil {
+			log.Errorf("Error json encoding: %s", err.Error())
+		}
+	}(l)
+
+	req, err := http.NewRequest(http.MethodPost, "https://www.dshield.org/submitapi/", r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if !hc.Debug {
+	} else if val, err := httputil.DumpResponse(resp, true); err == nil {
+		log.Debug(string(val))
+	}
+
+	// verify hash!
+	fmt.Printf("%x\n", hash.Sum(nil))
+	return nil
+}
+
 func (hc Backend) send(msg json.Marshaler) {
 	select {
 	case hc.ch <- msg:
@@ -264,71 +273,67 @@ func (hc Backend) send(msg json.Marshaler) {
 
 // Send delivers the giving push messages into dshield endpoint.
 func (hc Backend) Send(message event.Event) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error("Error sending event: %+v", r)
-		}
-	}()
+	if doc := document(message); doc != nil {
+		hc.send(doc)
+	}
+}
+
+// Deliver submits the event directly, used by the spool. Events which
+// aren't reported to DShield are ignored.
+func (hc Backend) Deliver(message event.Event) error {
+	doc := document(message)
+	if doc == nil {
+		return nil
+	}
+
+	return hc.submit([]json.Marshaler{doc})
+}
+
+// document returns the DShield document of the event, nil for events which
+// aren't reported. The values are converted leniently, events restored by
+// the spool contain numbers and dates decoded from json.
+func document(message event.Event) json.Marshaler {
+	prefix := ""
 
 	switch message.Get("category") {
 	case "telnet":
-		if message.Get("type") != "password-authentication" {
-		} else {
-			evt := &SSHEvent{}
-
-			message.Range(func(key, val interface{}) bool {
-				switch key.(string) {
-				case "date":
-					evt.Date = val.(time.Time)
-				case "source-ip":
-					evt.SourceIP = val.(string)
-				case "destination-ip":
-					evt.DestinationIP = val.(string)
-				case "source-port":
-					evt.SourcePort = val.(int)
-				case "destination-port":
-					evt.DestinationPort = val.(int)
-				case "telnet.username":
-					evt.Username = val.(string)
-				case "telnet.password":
-					evt.Password = val.(string)
-				}
-
-				return true
-			})
-
-			hc.send(evt)
-		}
+		prefix = "telnet"
 	case "ssh":
-		if message.Get("type") != "password-authentication" {
-		} else {
-			evt := &SSHEvent{}
-
-			message.Range(func(key, val interface{}) bool {
-				switch key.(string) {
-				case "date":
-					evt.Date = val.(time.Time)
-				case "source-ip":
-					evt.SourceIP = val.(string)
-				case "destination-ip":
-					evt.DestinationIP = val.(string)
-				case "source-port":
-					evt.SourcePort = val.(int)
-				case "destination-port":
-					evt.DestinationPort = val.(int)
-				case "ssh.username":
-					evt.Username = val.(string)
-				case "ssh.password":
-					evt.Password = val.(string)
-				}
-
-				return true
-			})
-
-			hc.send(evt)
-		}
-	case "http":
-		// not yet supported
+		prefix = "ssh"
 	default:
+		// http is not yet supported
+		return nil
 	}
+
+	if message.Get("type") != "password-authentication" {
+		return nil
+	}
+
+	evt := &SSHEvent{
+		SourceIP:      message.Get("source-ip"),
+		DestinationIP: message.Get("destination-ip"),
+		Username:      message.Get(prefix + ".username"),
+		Password:      message.Get(prefix + ".password"),
+	}
+
+	evt.SourcePort = port(message, "source-port")
+	evt.DestinationPort = port(message, "destination-port")
+
+	if date, ok := message.Load("date"); !ok {
+	} else if t, ok := date.(time.Time); ok {
+		evt.Date = t
+	}
+
+	return evt
+}
+
+// port returns the port of the key, ports are ints or json numbers.
+func port(message event.Event, key string) int {
+	v, ok := message.Load(key)
+	if !ok {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(fmt.Sprint(v))
+	return n
 }
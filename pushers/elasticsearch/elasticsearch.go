@@ -15,6 +15,7 @@ package elasticsearch
 
 import (
 	"context"
+	"sync"
 
 	"time"
 
@@ -39,15 +40,29 @@ var log = logging.MustGetLogger("channels/elasticsearch")
 type Backend struct {
 	Config
 
+	*pushers.Counters
+
 	es *elastic.Client
 	ch chan map[string]interface{}
+
+	flush chan chan error
+
+	// closing is closed when the backend is closed, the run loop stops and
+	// events sent afterwards are dropped.
+	closing chan struct{}
+
+	closeOnce *sync.Once
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	ch := make(chan map[string]interface{}, 100)
 
 	c := Backend{
-		ch: ch,
+		Counters:  &pushers.Counters{},
+		ch:        ch,
+		flush:     make(chan chan error),
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
 
 	for _, optionFn := range options {
@@ -76,39 +91,115 @@ func (hc Backend) run() {
 
 	count := 0
 	for {
+		var errc chan error
+
 		select {
 		case doc := <-hc.ch:
-			messageID := uuid.NewV4()
-
-			bulk = bulk.Add(elastic.NewBulkIndexRequest().
-				Index(hc.index).
-				Type("event").
-				Id(messageID.String()).
-				Doc(doc),
-			)
+			hc.add(bulk, doc)
 
 			if bulk.NumberOfActions() < 10 {
 				continue
 			}
+		case errc = <-hc.flush:
+			// index the queued documents as well
+			for queued := true; queued; {
+				select {
+				case doc := <-hc.ch:
+					hc.add(bulk, doc)
+				default:
+					queued = false
+				}
+			}
 		case <-time.After(time.Second * 10):
+		case <-hc.closing:
+			return
 		}
 
-		if bulk.NumberOfActions() == 0 {
-		} else if response, err := bulk.Do(context.Background()); err != nil {
-			log.Errorf("Error indexing: %s", err.Error())
-		} else {
-			indexed := response.Indexed()
-			count += len(indexed)
-
-			for _, item := range response.Failed() {
-				log.Errorf("Error indexing item: %s with error: %+v", item.Id, *item.Error)
-			}
+		err := hc.commit(bulk, &count)
 
-			log.Debugf("Bulk indexing: %d total %d", len(indexed), count)
+		if errc != nil {
+			errc <- err
 		}
 	}
 }
 
+func (hc Backend) add(bulk *elastic.BulkService, doc map[string]interface{}) {
+	messageID := uuid.NewV4()
+
+	bulk.Add(elastic.NewBulkIndexRequest().
+		Index(hc.index).
+		Type("event").
+		Id(messageID.String()).
+		Doc(doc),
+	)
+}
+
+func (hc Backend) commit(bulk *elastic.BulkService, count *int) error {
+	if bulk.NumberOfActions() == 0 {
+		return nil
+	}
+
+	actions := bulk.NumberOfActions()
+
+	response, err := bulk.Do(context.Background())
+	if err != nil {
+		log.Errorf("Error indexing: %s", err.Error())
+
+		hc.Failed(actions)
+		return err
+	}
+
+	indexed := response.Indexed()
+	*count += len(indexed)
+
+	hc.Delivered(len(indexed))
+	hc.Failed(len(response.Failed()))
+
+	for _, item := range response.Failed() {
+		log.Errorf("Error indexing item: %s with error: %+v", item.Id, *item.Error)
+	}
+
+	log.Debugf("Bulk indexing: %d total %d", len(indexed), *count)
+	return nil
+}
+
+// Flush indexes the queued events.
+func (hc Backend) Flush() error {
+	errc := make(chan error)
+
+	select {
+	case hc.flush <- errc:
+	case <-hc.closing:
+		return nil
+	}
+
+	return <-errc
+}
+
+// Deliver indexes the event directly, used by the spool.
+func (hc Backend) Deliver(message event.Event) error {
+	messageID := uuid.NewV4()
+
+	_, err := hc.es.Index().
+		Index(hc.index).
+		Type("event").
+		Id(messageID.String()).
+		BodyJson(event.ToMap(message)).
+		Do(context.Background())
+	if err != nil {
+		hc.Failed(1)
+		return err
+	}
+
+	hc.Delivered(1)
+	return nil
+}
+
+// QueueLen returns the number of queued events.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	mp := make(map[string]interface{})
@@ -120,5 +211,17 @@ func (hc Backend) Send(message event.Event) {
 		return true
 	})
 
-	hc.ch <- mp
+	select {
+	case hc.ch <- mp:
+	case <-hc.closing:
+		hc.Dropped(1)
+	}
+}
+
+// Close stops the indexer, events sent afterwards are dropped.
+func (hc Backend) Close() error {
+	hc.closeOnce.Do(func() {
+		close(hc.closing)
+	})
+	return nil
 }
@@ -0,0 +1,577 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// ExpressionFilterFunc compiles the boolean expression into a filter
+// function. Expressions compare event fields with literals:
+//
+//	category == "ssh" and type == "password-authentication"
+//	not source-ip in ["10.0.0.0/8", "192.0.2.1"]
+//	http.method =~ "^(PUT|DELETE)$" or exists http.body
+//	destination-port >= 1024 && !(category == "heartbeat")
+//
+// The operators are ==, !=, =~, !~, <, <=, >, >=, in, not in and exists,
+// combined with and (&&), or (||), not (!) and parentheses. List elements
+// of in which are CIDR notations match ip addresses in the network.
+func ExpressionFilterFunc(expression string) (FilterFunc, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		tokens: tokens,
+	}
+
+	fn, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+
+	return FilterFunc(fn), nil
+}
+
+// ExpressionError is an error in an expression, at the position of the
+// offending token.
+type ExpressionError struct {
+	Pos     int
+	Message string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+
+	return fmt.Sprintf("%q", t.value)
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' {
+		return true
+	} else if first {
+		return false
+	}
+
+	return unicode.IsDigit(r) || r == '.' || r == '-'
+}
+
+// lex splits the expression into tokens.
+func lex(s string) ([]token, error) {
+	tokens := []token{}
+
+	rs := []rune(s)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+			continue
+		case r == '[':
+			tokens = append(tokens, token{tokenLBracket, "[", i})
+			i++
+			continue
+		case r == ']':
+			tokens = append(tokens, token{tokenRBracket, "]", i})
+			i++
+			continue
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+			continue
+		case r == '"' || r == '\'':
+			start := i
+
+			var sb strings.Builder
+
+			for i++; ; i++ {
+				if i >= len(rs) {
+					return nil, &ExpressionError{start, "unterminated string"}
+				} else if rs[i] == r {
+					i++
+					break
+				} else if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+
+				sb.WriteRune(rs[i])
+			}
+
+			tokens = append(tokens, token{tokenString, sb.String(), start})
+			continue
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			start := i
+
+			for i++; i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.'); i++ {
+			}
+
+			tokens = append(tokens, token{tokenNumber, string(rs[start:i]), start})
+			continue
+		case isIdentRune(r, true):
+			start := i
+
+			for i++; i < len(rs) && isIdentRune(rs[i], false); i++ {
+			}
+
+			tokens = append(tokens, token{tokenIdent, string(rs[start:i]), start})
+			continue
+		}
+
+		// operators
+		op := ""
+
+		for _, o := range []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!"} {
+			if strings.HasPrefix(string(rs[i:]), o) {
+				op = o
+				break
+			}
+		}
+
+		if op == "" {
+			return nil, &ExpressionError{i, fmt.Sprintf("unexpected character %q", r)}
+		}
+
+		tokens = append(tokens, token{tokenOperator, op, i})
+		i += len(op)
+	}
+
+	return append(tokens, token{tokenEOF, "", len(rs)}), nil
+}
+
+type matchFunc func(event.Event) bool
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) errorf(t token, format string, a ...interface{}) error {
+	return &ExpressionError{t.pos, fmt.Sprintf(format, a...)}
+}
+
+// keyword returns whether the token is one of the keywords or operators.
+func keyword(t token, words ...string) bool {
+	if t.kind != tokenIdent && t.kind != tokenOperator {
+		return false
+	}
+
+	for _, w := range words {
+		if t.value == w {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) or() (matchFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for keyword(p.peek(), "or", "||") {
+		p.next()
+
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = func(l, r matchFunc) matchFunc {
+			return func(e event.Event) bool { return l(e) || r(e) }
+		}(left, right)
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (matchFunc, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for keyword(p.peek(), "and", "&&") {
+		p.next()
+
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		left = func(l, r matchFunc) matchFunc {
+			return func(e event.Event) bool { return l(e) && r(e) }
+		}(left, right)
+	}
+
+	return left, nil
+}
+
+func (p *parser) not() (matchFunc, error) {
+	if !keyword(p.peek(), "not", "!") {
+		return p.primary()
+	}
+
+	p.next()
+
+	fn, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e event.Event) bool { return !fn(e) }, nil
+}
+
+func (p *parser) primary() (matchFunc, error) {
+	t := p.next()
+
+	switch {
+	case t.kind == tokenLParen:
+		fn, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected \")\", got %s", t)
+		}
+
+		return fn, nil
+	case keyword(t, "exists"):
+		field := p.next()
+		if field.kind != tokenIdent {
+			return nil, p.errorf(field, "expected field after exists, got %s", field)
+		}
+
+		return func(e event.Event) bool { return e.Has(field.value) }, nil
+	case t.kind == tokenIdent:
+		return p.comparison(t.value)
+	}
+
+	return nil, p.errorf(t, "expected field, got %s", t)
+}
+
+func (p *parser) comparison(field string) (matchFunc, error) {
+	op := p.next()
+
+	switch {
+	case keyword(op, "in"):
+		return p.in(field)
+	case keyword(op, "not"):
+		if t := p.next(); !keyword(t, "in") {
+			return nil, p.errorf(t, "expected in after not, got %s", t)
+		}
+
+		fn, err := p.in(field)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e event.Event) bool { return !fn(e) }, nil
+	case op.kind != tokenOperator:
+		return nil, p.errorf(op, "expected operator after %s, got %s", field, op)
+	}
+
+	operand := p.next()
+
+	switch op.value {
+	case "=~", "!~":
+		if operand.kind != tokenString {
+			return nil, p.errorf(operand, "expected regular expression, got %s", operand)
+		}
+
+		re, err := regexp.Compile(operand.value)
+		if err != nil {
+			return nil, p.errorf(operand, "invalid regular expression: %s", err.Error())
+		}
+
+		negate := op.value == "!~"
+
+		return func(e event.Event) bool {
+			v, ok := e.Load(field)
+			if !ok {
+				return negate
+			}
+
+			return re.MatchString(toString(v)) != negate
+		}, nil
+	case "==", "!=":
+		lit, err := p.literal(operand)
+		if err != nil {
+			return nil, err
+		}
+
+		negate := op.value == "!="
+
+		return func(e event.Event) bool {
+			v, ok := e.Load(field)
+			if !ok {
+				return negate
+			}
+
+			return lit.equal(v) != negate
+		}, nil
+	case "<", "<=", ">", ">=":
+		if operand.kind != tokenNumber {
+			return nil, p.errorf(operand, "expected number, got %s", operand)
+		}
+
+		n, err := strconv.ParseFloat(operand.value, 64)
+		if err != nil {
+			return nil, p.errorf(operand, "invalid number %s", operand.value)
+		}
+
+		cmp := map[string]func(a, b float64) bool{
+			"<":  func(a, b float64) bool { return a < b },
+			"<=": func(a, b float64) bool { return a <= b },
+			">":  func(a, b float64) bool { return a > b },
+			">=": func(a, b float64) bool { return a >= b },
+		}[op.value]
+
+		return func(e event.Event) bool {
+			v, ok := e.Load(field)
+			if !ok {
+				return false
+			}
+
+			f, ok := toFloat(v)
+			return ok && cmp(f, n)
+		}, nil
+	}
+
+	return nil, p.errorf(op, "unexpected operator %s", op)
+}
+
+// in parses the list of an in comparison, a single literal is accepted as
+// well.
+func (p *parser) in(field string) (matchFunc, error) {
+	literals := []literal{}
+
+	if t := p.peek(); t.kind != tokenLBracket {
+		lit, err := p.literal(p.next())
+		if err != nil {
+			return nil, err
+		}
+
+		literals = append(literals, lit)
+	} else {
+		p.next()
+
+		for {
+			lit, err := p.literal(p.next())
+			if err != nil {
+				return nil, err
+			}
+
+			literals = append(literals, lit)
+
+			if t := p.next(); t.kind == tokenRBracket {
+				break
+			} else if t.kind != tokenComma {
+				return nil, p.errorf(t, "expected \",\" or \"]\", got %s", t)
+			}
+		}
+	}
+
+	return func(e event.Event) bool {
+		v, ok := e.Load(field)
+		if !ok {
+			return false
+		}
+
+		for _, lit := range literals {
+			if lit.equal(v) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// literal is a value in an expression.
+type literal struct {
+	s string
+
+	number  bool
+	f       float64
+	network *net.IPNet
+}
+
+func (p *parser) literal(t token) (literal, error) {
+	switch t.kind {
+	case tokenString:
+		lit := literal{s: t.value}
+
+		if _, network, err := net.ParseCIDR(t.value); err == nil {
+			lit.network = network
+		}
+
+		return lit, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return literal{}, p.errorf(t, "invalid number %s", t.value)
+		}
+
+		return literal{s: t.value, number: true, f: f}, nil
+	case tokenIdent:
+		if t.value == "true" || t.value == "false" {
+			return literal{s: t.value}, nil
+		}
+	}
+
+	return literal{}, p.errorf(t, "expected value, got %s", t)
+}
+
+// equal returns whether the value of the event equals the literal, numbers
+// are compared numerically and networks match the addresses they contain.
+func (lit literal) equal(v interface{}) bool {
+	if lit.number {
+		f, ok := toFloat(v)
+		return ok && f == lit.f
+	}
+
+	s := toString(v)
+
+	if lit.network == nil {
+		return s == lit.s
+	}
+
+	if ip, ok := v.(net.IP); ok {
+		return lit.network.Contains(ip)
+	} else if ip := net.ParseIP(s); ip != nil {
+		return lit.network.Contains(ip)
+	}
+
+	return false
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
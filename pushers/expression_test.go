@@ -0,0 +1,98 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestExpressionFilterFunc(t *testing.T) {
+	e := event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.SourceIP(net.ParseIP("198.51.100.7")),
+		event.DestinationPort(2222),
+		event.Custom("ssh.password", "root"),
+		event.Custom("ssh.recv-size", 1500),
+	)
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`category == "ssh"`, true},
+		{`category != "ssh"`, false},
+		{`category == 'ssh' and type == "password-authentication"`, true},
+		{`category == "http" or type == "password-authentication"`, true},
+		{`not category == "heartbeat"`, true},
+		{`!(category == "ssh")`, false},
+		{`category == "ssh" && (type == "x" || type == "password-authentication")`, true},
+		{`ssh.password =~ "^ro+t$"`, true},
+		{`ssh.password !~ "^ro+t$"`, false},
+		{`source-ip in ["10.0.0.0/8", "198.51.100.0/24"]`, true},
+		{`source-ip in "198.51.100.7"`, true},
+		{`source-ip not in ["198.51.100.0/24"]`, false},
+		{`destination-port >= 1024`, true},
+		{`destination-port == 2222`, true},
+		{`ssh.recv-size < 1000`, false},
+		{`category in ["ssh", "telnet"]`, true},
+		{`exists ssh.password`, true},
+		{`exists http.method`, false},
+		{`http.method == "GET"`, false},
+		{`http.method != "GET"`, true},
+		{`http.size > 0`, false},
+	}
+
+	for _, tt := range tests {
+		fn, err := ExpressionFilterFunc(tt.expression)
+		if err != nil {
+			t.Errorf("Error compiling %s: %s", tt.expression, err.Error())
+			continue
+		}
+
+		if v := fn(e); v != tt.expected {
+			t.Errorf("Expected %v for %s, got %v", tt.expected, tt.expression, v)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{`category ==`, `column 12: expected value, got end of expression`},
+		{`category "ssh"`, `column 10: expected operator after category, got "ssh"`},
+		{`(category == "ssh"`, `column 19: expected ")", got end of expression`},
+		{`category =~ "("`, `column 13: invalid regular expression`},
+		{`size > "a"`, `column 8: expected number, got "a"`},
+		{`category == "ssh`, `column 13: unterminated string`},
+		{`category == "ssh" and`, `column 22: expected field, got end of expression`},
+		{`category == "ssh" type`, `column 19: unexpected "type"`},
+		{`category # "ssh"`, `column 10: unexpected character '#'`},
+	}
+
+	for _, tt := range tests {
+		_, err := ExpressionFilterFunc(tt.expression)
+		if err == nil {
+			t.Errorf("Expected error for %s", tt.expression)
+		} else if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("Expected error %q for %s, got %q", tt.expected, tt.expression, err.Error())
+		}
+	}
+}
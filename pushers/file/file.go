@@ -21,6 +21,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
@@ -47,6 +48,8 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 			Mode:    os.FileMode(0600),
 		},
 		request: make(chan map[string]interface{}),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 
 	for _, optionFn := range options {
@@ -89,10 +92,25 @@ type FileBackend struct {
 	FileConfig
 
 	request chan map[string]interface{}
+
+	// closing is closed when the backend is closed, events sent afterwards
+	// are discarded
+	closing chan struct{}
+
+	// done is closed when the write loop has written the queued events
+	done chan struct{}
+
+	closeOnce sync.Once
 }
 
-func (f *FileBackend) Close() {
-	close(f.request)
+// Close writes the queued events to the file and closes it.
+func (f *FileBackend) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.closing)
+	})
+
+	<-f.done
+	return nil
 }
 
 // Send delivers the giving if it passes all filtering criteria into the
@@ -107,11 +125,17 @@ func (f *FileBackend) Send(message event.Event) {
 		return true
 	})
 
-	f.request <- mp
+	select {
+	case f.request <- mp:
+	case <-f.closing:
+	case <-f.done:
+	}
 }
 
 // syncLoop handles configuration of the giving loop for writing to file.
 func (f *FileBackend) writeLoop() {
+	defer close(f.done)
+
 	dest, err := OpenRotateFile(f.File, f.Mode, f.MaxSize)
 	if err != nil {
 		log.Errorf("Failed create destination file: %s", err)
@@ -122,13 +146,11 @@ func (f *FileBackend) writeLoop() {
 
 	var buf bytes.Buffer
 
-	for {
+	for closed := false; !closed; {
 		select {
-		case req, ok := <-f.request:
-			if !ok {
-				return
-			}
-
+		case <-f.closing:
+			closed = true
+		case req := <-f.request:
 			if err := json.NewEncoder(&buf).Encode(req); err != nil {
 				log.Errorf("Failed to marshal PushMessage to JSON : %+q", err)
 				continue
@@ -15,7 +15,9 @@ package fschannel_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,3 +70,34 @@ func TestStress(t *testing.T) {
 		t.Errorf("Could not remove directory: %s: %s", dir, err)
 	}
 }
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	channel, err := fschannel.New(WithPath(path.Join(dir, "test.log")))
+	if err != nil {
+		t.Fatalf("Error creating new file channel: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		channel.Send(event.New(event.Custom("sequence", i)))
+	}
+
+	if err := pushers.Close(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Errorf("Expected 3 events written on close, got %d", n)
+	}
+}
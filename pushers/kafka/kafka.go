@@ -15,6 +15,8 @@ package kafka
 
 import (
 	"encoding/json"
+	"errors"
+	"sync"
 
 	sarama "github.com/Shopify/sarama"
 
@@ -30,21 +32,46 @@ var (
 
 var log = logging.MustGetLogger("channels/kafka")
 
+var errClosed = errors.New("kafka channel closed")
+
 // Backend defines a struct which provides a channel for delivery
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
 
+	*pushers.Counters
+
 	producer sarama.AsyncProducer
 
 	ch chan map[string]interface{}
+
+	flush chan chan error
+
+	// closing is closed when the backend is closed, the run loop stops and
+	// events sent afterwards are dropped.
+	closing chan struct{}
+
+	closeOnce *sync.Once
+
+	deliver chan delivery
+}
+
+// delivery is an event produced by Deliver.
+type delivery struct {
+	data map[string]interface{}
+	errc chan error
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	ch := make(chan map[string]interface{}, 100)
 
 	c := Backend{
-		ch: ch,
+		Counters:  &pushers.Counters{},
+		ch:        ch,
+		flush:     make(chan chan error),
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
+		deliver:   make(chan delivery),
 	}
 
 	for _, optionFn := range options {
@@ -69,25 +96,85 @@ func (hc Backend) run() {
 	defer hc.producer.AsyncClose()
 
 	for {
-		data := <-hc.ch
-		marshalledData, err := json.Marshal(data)
-		if err != nil {
-			log.Errorf("Error marshaling event: %s", err.Error())
-			continue
+		select {
+		case data := <-hc.ch:
+			hc.produce(data)
+		case errc := <-hc.flush:
+			// produce the queued events
+			for queued := true; queued; {
+				select {
+				case data := <-hc.ch:
+					hc.produce(data)
+				default:
+					queued = false
+				}
+			}
+
+			errc <- nil
+		case d := <-hc.deliver:
+			d.errc <- hc.produce(d.data)
+		case <-hc.closing:
+			return
 		}
+	}
+}
 
-		hc.producer.Input() <- &sarama.ProducerMessage{
-			Topic: hc.Topic,
-			Key:   nil,
-			Value: sarama.ByteEncoder(marshalledData),
-		}
+func (hc Backend) produce(data map[string]interface{}) error {
+	marshalledData, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("Error marshaling event: %s", err.Error())
 
-		select {
-		case <-hc.producer.Successes():
-		case msg := <-hc.producer.Errors():
-			log.Errorf("Error producing event to kafka: %s", msg)
-		}
+		hc.Dropped(1)
+		return nil
+	}
+
+	hc.producer.Input() <- &sarama.ProducerMessage{
+		Topic: hc.Topic,
+		Key:   nil,
+		Value: sarama.ByteEncoder(marshalledData),
+	}
+
+	select {
+	case <-hc.producer.Successes():
+		hc.Delivered(1)
+		return nil
+	case msg := <-hc.producer.Errors():
+		log.Errorf("Error producing event to kafka: %s", msg)
+
+		hc.Failed(1)
+		return msg
+	}
+}
+
+// QueueLen returns the number of queued events.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
+
+// Flush produces the queued events.
+func (hc Backend) Flush() error {
+	errc := make(chan error)
+
+	select {
+	case hc.flush <- errc:
+	case <-hc.closing:
+		return nil
+	}
+
+	return <-errc
+}
+
+// Deliver produces the event directly, used by the spool.
+func (hc Backend) Deliver(message event.Event) error {
+	errc := make(chan error)
+
+	select {
+	case hc.deliver <- delivery{event.ToMap(message), errc}:
+	case <-hc.closing:
+		return errClosed
 	}
+
+	return <-errc
 }
 
 // Send delivers the giving push messages into the internal elastic search endpoint.
@@ -101,5 +188,17 @@ func (hc Backend) Send(message event.Event) {
 		return true
 	})
 
-	hc.ch <- mp
+	select {
+	case hc.ch <- mp:
+	case <-hc.closing:
+		hc.Dropped(1)
+	}
+}
+
+// Close stops the producer, events sent afterwards are dropped.
+func (hc Backend) Close() error {
+	hc.closeOnce.Do(func() {
+		close(hc.closing)
+	})
+	return nil
 }
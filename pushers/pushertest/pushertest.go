@@ -0,0 +1,65 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package pushertest contains a channel that keeps the events sent to it, it
+// is used by the tests of the services and pushers.
+package pushertest
+
+import (
+	"sync"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Channel keeps the events sent to it.
+type Channel struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+// Send appends the event.
+func (c *Channel) Send(e event.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.events = append(c.events, e)
+}
+
+// Events returns the events sent.
+func (c *Channel) Events() []event.Event {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]event.Event{}, c.events...)
+}
+
+// Find returns the events sent with the type.
+func (c *Channel) Find(typ string) []event.Event {
+	events := []event.Event{}
+	for _, e := range c.Events() {
+		if e.Get("type") == typ {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// First returns the first event sent with the type.
+func (c *Channel) First(typ string) (event.Event, bool) {
+	if events := c.Find(typ); len(events) > 0 {
+		return events[0], true
+	}
+
+	return event.Event{}, false
+}
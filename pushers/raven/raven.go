@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -41,13 +42,41 @@ type Backend struct {
 	Config
 
 	ch chan event.Event
+
+	conn *connection
+}
+
+// connection is the connection to the Raven server, writes of the run loop
+// and of Deliver are serialized.
+type connection struct {
+	m sync.Mutex
+	c *websocket.Conn
+}
+
+func (c *connection) set(conn *websocket.Conn) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.c = conn
+}
+
+func (c *connection) write(data []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.c == nil {
+		return fmt.Errorf("not connected to Raven server")
+	}
+
+	return c.c.WriteMessage(websocket.BinaryMessage, data)
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	ch := make(chan event.Event, 100)
 
 	c := Backend{
-		ch: ch,
+		ch:   ch,
+		conn: &connection{},
 	}
 
 	for _, optionFn := range options {
@@ -93,6 +122,9 @@ func (hc Backend) run() {
 
 			defer c.Close()
 
+			hc.conn.set(c)
+			defer hc.conn.set(nil)
+
 			readChan := make(chan []byte)
 
 			go func(c *websocket.Conn) {
@@ -131,7 +163,7 @@ func (hc Backend) run() {
 							continue
 						}
 
-						err = c.WriteMessage(websocket.BinaryMessage, data)
+						err = hc.conn.write(data)
 						if err != nil {
 							log.Errorf("Could not write: %s", err.Error())
 							return
@@ -147,6 +179,22 @@ func (hc Backend) run() {
 	}
 }
 
+// Deliver writes the event to the connection directly, used by the spool. An
+// error is returned while not connected to the Raven server.
+func (hc Backend) Deliver(message event.Event) error {
+	// we'll ignore heartbeats, those are generated within the protocol
+	if message.Get("category") == "heartbeat" {
+		return nil
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return hc.conn.write(data)
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	select {
@@ -15,6 +15,7 @@ package splunk
 
 import (
 	"net/http"
+	"sync"
 
 	"time"
 
@@ -37,20 +38,45 @@ var log = logging.MustGetLogger("channels:splunk")
 type Backend struct {
 	Config
 
+	*pushers.Counters
+
+	client hec.HEC
+
 	ch chan map[string]interface{}
+
+	flush chan chan error
+
+	// closing is closed when the backend is closed, the run loop stops and
+	// events sent afterwards are dropped.
+	closing chan struct{}
+
+	closeOnce *sync.Once
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	ch := make(chan map[string]interface{}, 100)
 
 	c := Backend{
-		ch: ch,
+		Counters:  &pushers.Counters{},
+		ch:        ch,
+		flush:     make(chan chan error),
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
 
 	for _, optionFn := range options {
 		optionFn(&c)
 	}
 
+	c.client = hec.NewCluster(
+		c.Config.Endpoints,
+		c.Config.Token,
+	)
+
+	c.client.SetHTTPClient(&http.Client{Transport: &http.Transport{
+		TLSClientConfig: c.tlsConfig,
+	}})
+
 	go c.run()
 
 	return &c, nil
@@ -60,45 +86,94 @@ func (hc Backend) run() {
 	log.Debug("Splunk indexer started...")
 	defer log.Debug("Splunk indexer stopped...")
 
-	client := hec.NewCluster(
-		hc.Config.Endpoints,
-		hc.Config.Token,
-	)
+	batch := []*hec.Event{}
 
-	client.SetHTTPClient(&http.Client{Transport: &http.Transport{
-		TLSClientConfig: hc.tlsConfig,
-	}})
+	add := func(doc map[string]interface{}) {
+		event := hec.NewEvent(doc)
+		event.SetTime(time.Now())
 
-	batch := []*hec.Event{}
+		batch = append(batch, event)
+	}
 
 	count := 0
 	for {
+		var errc chan error
+
 		select {
 		case doc := <-hc.ch:
-			event := hec.NewEvent(doc)
-			event.SetTime(time.Now())
+			add(doc)
 
-			batch = append(batch, event)
 			if len(batch) < 10 {
 				continue
 			}
+		case errc = <-hc.flush:
+			// index the queued events as well
+			for queued := true; queued; {
+				select {
+				case doc := <-hc.ch:
+					add(doc)
+				default:
+					queued = false
+				}
+			}
 		case <-time.After(time.Second * 10):
+		case <-hc.closing:
+			return
 		}
 
-		if len(batch) == 0 {
-			continue
-		}
+		var err error
 
-		if err := client.WriteBatch(batch); err != nil {
+		if len(batch) == 0 {
+		} else if err = hc.client.WriteBatch(batch); err != nil {
 			log.Errorf("Error indexing: %s", err.Error())
+
+			hc.Failed(len(batch))
 		} else {
 			count += len(batch)
 
+			hc.Delivered(len(batch))
+
 			log.Infof("Bulk indexing: %d total %d", len(batch), count)
 
 			batch = []*hec.Event{}
 		}
+
+		if errc != nil {
+			errc <- err
+		}
+	}
+}
+
+// Flush indexes the queued events.
+func (hc Backend) Flush() error {
+	errc := make(chan error)
+
+	select {
+	case hc.flush <- errc:
+	case <-hc.closing:
+		return nil
 	}
+
+	return <-errc
+}
+
+// Deliver indexes the event directly, used by the spool.
+func (hc Backend) Deliver(message event.Event) error {
+	e := hec.NewEvent(event.ToMap(message))
+	e.SetTime(time.Now())
+
+	if err := hc.client.WriteEvent(e); err != nil {
+		hc.Failed(1)
+		return err
+	}
+
+	hc.Delivered(1)
+	return nil
+}
+
+// QueueLen returns the number of queued events.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
 }
 
 // Send delivers the giving push messages into the internal elastic search endpoint.
@@ -112,5 +187,17 @@ func (hc Backend) Send(message event.Event) {
 		return true
 	})
 
-	hc.ch <- mp
+	select {
+	case hc.ch <- mp:
+	case <-hc.closing:
+		hc.Dropped(1)
+	}
+}
+
+// Close stops the indexer, events sent afterwards are dropped.
+func (hc Backend) Close() error {
+	hc.closeOnce.Do(func() {
+		close(hc.closing)
+	})
+	return nil
 }
@@ -12,3 +12,62 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package splunk_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/splunk"
+)
+
+func TestClose(t *testing.T) {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(`
+[P]
+endpoints = ["http://127.0.0.1:1"]
+token = "token"
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := splunk.New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pushers.Close(c); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// more events than the queue holds, the indexer has stopped
+		for i := 0; i < 200; i++ {
+			c.Send(event.New())
+		}
+
+		c.(pushers.Flusher).Flush()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Send and Flush not to block after Close")
+	}
+
+	if counts := c.(pushers.Counter).Counts(); counts.Dropped < 100 {
+		t.Errorf("Expected at least 100 dropped events, got %d", counts.Dropped)
+	}
+}
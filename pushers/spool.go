@@ -0,0 +1,518 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:pushers")
+
+// Deliverer is implemented by channels which can deliver an event
+// synchronously, the spool retries delivery when Deliver returns an error.
+// Only channels implementing Deliverer can be spooled, events handed over
+// with Send would be lost when the backend is unavailable.
+type Deliverer interface {
+	Deliver(event.Event) error
+}
+
+// SpoolConfig configures the spool of a channel, zero values are replaced
+// by the defaults.
+//
+//	[channel.elasticsearch.spool]
+//	max-size = 134217728
+//	max-age = "24h"
+//	max-backoff = "5m"
+type SpoolConfig struct {
+	// MaxSize is the maximum size of the spooled events in bytes, new
+	// events are dropped when the spool is full
+	MaxSize int64 `toml:"max-size"`
+
+	// MaxAge is the age after which undelivered events are dropped
+	MaxAge config.Delay `toml:"max-age"`
+
+	// MaxBackoff is the maximum interval between delivery attempts
+	MaxBackoff config.Delay `toml:"max-backoff"`
+}
+
+// SpoolStats are the statistics of a spool.
+type SpoolStats struct {
+	// Depth is the number of spooled events
+	Depth int64
+
+	// Size is the size of the spooled events in bytes
+	Size int64
+
+	// Dropped is the number of events dropped because the spool was full
+	// or the events expired
+	Dropped int64
+
+	// Delivered is the number of events delivered
+	Delivered int64
+
+	// Failed is the number of failed delivery attempts
+	Failed int64
+}
+
+type spoolRecord struct {
+	Time  time.Time              `json:"time"`
+	Event map[string]interface{} `json:"event"`
+}
+
+// spoolState is shared by the spools of a channel. When a channel is
+// replaced on reload, the old spool keeps spooling events until it is
+// closed. The spools share the spooled events, so the depth and size are
+// shared as well.
+type spoolState struct {
+	// delivering makes sure a single spool delivers the events, a spool
+	// which is closed while waiting for it gives up
+	delivering chan struct{}
+
+	m   sync.Mutex
+	seq uint64
+
+	depth int64
+	size  int64
+}
+
+// reserve accounts for an event of n bytes and returns its sequence number,
+// false when the spooled events would exceed max bytes.
+func (st *spoolState) reserve(n int64, max int64) (uint64, bool) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	if st.size+n > max {
+		return 0, false
+	}
+
+	st.depth++
+	st.size += n
+
+	st.seq++
+	return st.seq, true
+}
+
+// release removes an event of n bytes.
+func (st *spoolState) release(n int64) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	st.depth--
+	st.size -= n
+}
+
+// usage returns the number and size of the spooled events.
+func (st *spoolState) usage() (int64, int64) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	return st.depth, st.size
+}
+
+var (
+	spools  = map[string]*Spool{}
+	spoolsM sync.Mutex
+
+	spoolStates = map[string]*spoolState{}
+)
+
+// RangeSpools calls fn for the running spools.
+func RangeSpools(fn func(name string, s *Spool)) {
+	spoolsM.Lock()
+	defer spoolsM.Unlock()
+
+	for name, s := range spools {
+		fn(name, s)
+	}
+}
+
+// Spool persists the events of a channel in a write-ahead log in the data
+// directory, and delivers them to the channel in the background. Delivery
+// is retried with backoff, events survive outages of the backend and
+// restarts of honeytrap.
+type Spool struct {
+	SpoolConfig
+
+	Channel Channel
+
+	name string
+
+	// events receives the statistics of the spool
+	events Channel
+
+	storage interface {
+		Set(string, []byte) error
+		Delete(string) error
+		Range(string, func(string, []byte) bool) error
+	}
+
+	state *spoolState
+
+	m     sync.Mutex
+	stats SpoolStats
+
+	reported SpoolStats
+
+	notify  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewSpool returns a spool for the channel, identified by name. Events
+// spooled by a previous run with the same name are delivered as well.
+// The statistics of the spool are sent to events, if set. The channel
+// needs to implement Deliverer.
+func NewSpool(name string, c Channel, conf SpoolConfig, events Channel) (*Spool, error) {
+	if _, ok := c.(Deliverer); !ok {
+		return nil, fmt.Errorf("spool %s: channel doesn't support spooling", name)
+	}
+
+	if storage.DataDir() == "" {
+		return nil, fmt.Errorf("spool %s: data directory not set", name)
+	}
+
+	st, err := storage.Namespace("spool")
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = 128 * 1024 * 1024
+	}
+
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = config.Delay(24 * time.Hour)
+	}
+
+	if conf.MaxBackoff <= 0 {
+		conf.MaxBackoff = config.Delay(5 * time.Minute)
+	}
+
+	s := &Spool{
+		SpoolConfig: conf,
+		Channel:     c,
+		name:        name,
+		events:      events,
+		storage:     st,
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	spoolsM.Lock()
+	defer spoolsM.Unlock()
+
+	state, ok := spoolStates[name]
+	if !ok {
+		state = &spoolState{
+			delivering: make(chan struct{}, 1),
+		}
+	}
+
+	s.state = state
+
+	// recover the state of the previous run, the state is kept when the
+	// spool replaces a running spool
+	if !ok {
+		if err := st.Range(s.prefix(), func(key string, data []byte) bool {
+			var seq uint64
+			if _, err := fmt.Sscanf(key[len(s.prefix()):], "%016x", &seq); err == nil && seq > state.seq {
+				state.seq = seq
+			}
+
+			state.depth++
+			state.size += int64(len(data))
+			return true
+		}); err != nil {
+			return nil, err
+		}
+
+		if state.depth > 0 {
+			log.Infof("Spool %s: %d event(s) of a previous run queued", name, state.depth)
+		}
+	}
+
+	spools[name] = s
+	spoolStates[name] = state
+
+	go s.run()
+
+	return s, nil
+}
+
+// prefix returns the prefix of the keys of the spool, the name is hex
+// encoded so it can't contain the delimiter.
+func (s *Spool) prefix() string {
+	return fmt.Sprintf("%x.", s.name)
+}
+
+// Stats returns the statistics of the spool, the depth and size are shared
+// with the spool it replaced or is replaced by.
+func (s *Spool) Stats() SpoolStats {
+	s.m.Lock()
+	stats := s.stats
+	s.m.Unlock()
+
+	stats.Depth, stats.Size = s.state.usage()
+	return stats
+}
+
+// Counts returns the delivery results of the spool.
+func (s *Spool) Counts() Counts {
+	stats := s.Stats()
+
+	return Counts{
+		Delivered: stats.Delivered,
+		Failed:    stats.Failed,
+		Dropped:   stats.Dropped,
+	}
+}
+
+// QueueLen returns the number of spooled events.
+func (s *Spool) QueueLen() int {
+	return int(s.Stats().Depth)
+}
+
+// Send spools the event, it is dropped when the spool is full.
+func (s *Spool) Send(e event.Event) {
+	data, err := json.Marshal(spoolRecord{
+		Time:  time.Now(),
+		Event: event.ToMap(e),
+	})
+	if err != nil {
+		log.Errorf("Spool %s: error encoding event, delivering directly: %s", s.name, err.Error())
+		s.Channel.Send(e)
+		return
+	}
+
+	seq, ok := s.state.reserve(int64(len(data)), s.MaxSize)
+	if !ok {
+		s.m.Lock()
+		s.stats.Dropped++
+		s.m.Unlock()
+		return
+	}
+
+	key := fmt.Sprintf("%s%016x", s.prefix(), seq)
+
+	if err := s.storage.Set(key, data); err != nil {
+		log.Errorf("Spool %s: error storing event: %s", s.name, err.Error())
+
+		s.state.release(int64(len(data)))
+
+		s.m.Lock()
+		s.stats.Dropped++
+		s.m.Unlock()
+		return
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops delivering and closes the channel, the spooled events are
+// delivered on the next start.
+func (s *Spool) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	<-s.stopped
+
+	spoolsM.Lock()
+	if spools[s.name] == s {
+		delete(spools, s.name)
+	}
+	spoolsM.Unlock()
+
+	return Close(s.Channel)
+}
+
+// next returns the oldest spooled event.
+func (s *Spool) next() (string, []byte, bool) {
+	var (
+		key  string
+		data []byte
+	)
+
+	if err := s.storage.Range(s.prefix(), func(k string, v []byte) bool {
+		key, data = k, v
+		return false
+	}); err != nil {
+		log.Errorf("Spool %s: error reading event: %s", s.name, err.Error())
+		return "", nil, false
+	}
+
+	return key, data, key != ""
+}
+
+func (s *Spool) remove(key string, size int, delivered bool) {
+	if err := s.storage.Delete(key); err != nil {
+		log.Errorf("Spool %s: error removing event: %s", s.name, err.Error())
+	}
+
+	s.state.release(int64(size))
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if delivered {
+		s.stats.Delivered++
+	} else {
+		s.stats.Dropped++
+	}
+}
+
+func (s *Spool) deliver(e event.Event) error {
+	return s.Channel.(Deliverer).Deliver(e)
+}
+
+// report sends the statistics when events are queued or dropped.
+func (s *Spool) report() {
+	stats := s.Stats()
+
+	if s.events == nil {
+		return
+	} else if stats.Depth == 0 && stats.Dropped == s.reported.Dropped {
+		return
+	}
+
+	s.reported = stats
+
+	s.events.Send(event.New(
+		event.Sensor("honeytrap"),
+		event.Category("spool"),
+		event.Type("stats"),
+		event.Custom("spool.channel", s.name),
+		event.Custom("spool.depth", stats.Depth),
+		event.Custom("spool.size", stats.Size),
+		event.Custom("spool.dropped", stats.Dropped),
+		event.Custom("spool.delivered", stats.Delivered),
+	))
+}
+
+func (s *Spool) run() {
+	defer close(s.stopped)
+
+	select {
+	case s.state.delivering <- struct{}{}:
+	case <-s.done:
+		return
+	}
+
+	defer func() {
+		<-s.state.delivering
+	}()
+
+	bo := &backoff.ExponentialBackOff{
+		InitialInterval:     backoff.DefaultInitialInterval,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         s.MaxBackoff.Duration(),
+		MaxElapsedTime:      0,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+
+	bo.Reset()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.report()
+		default:
+		}
+
+		key, data, ok := s.next()
+		if !ok {
+			select {
+			case <-s.notify:
+			case <-ticker.C:
+				s.report()
+			case <-s.done:
+				return
+			}
+
+			continue
+		}
+
+		record := spoolRecord{}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&record); err != nil {
+			log.Errorf("Spool %s: error decoding event: %s", s.name, err.Error())
+			s.remove(key, len(data), false)
+			continue
+		}
+
+		if time.Since(record.Time) > s.MaxAge.Duration() {
+			s.remove(key, len(data), false)
+			continue
+		}
+
+		options := []event.Option{}
+		for k, v := range record.Event {
+			options = append(options, event.Custom(k, v))
+		}
+
+		// the date of the event is decoded as string
+		if date, ok := record.Event["date"].(string); !ok {
+		} else if t, err := time.Parse(time.RFC3339Nano, date); err == nil {
+			options = append(options, event.Custom("date", t))
+		}
+
+		if err := s.deliver(event.New(options...)); err != nil {
+			s.m.Lock()
+			s.stats.Failed++
+			s.m.Unlock()
+
+			d := bo.NextBackOff()
+
+			log.Errorf("Spool %s: error delivering event, retrying in %s: %s", s.name, d, err.Error())
+
+			select {
+			case <-time.After(d):
+			case <-s.done:
+				return
+			}
+
+			continue
+		}
+
+		bo.Reset()
+
+		s.remove(key, len(data), true)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "honeytrap-spool")
+	if err != nil {
+		panic(err)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// deliverChannel fails the first failures deliveries.
+type deliverChannel struct {
+	m sync.Mutex
+
+	failures  int
+	attempts  int
+	delivered []event.Event
+}
+
+func (c *deliverChannel) Send(e event.Event) {
+	c.Deliver(e)
+}
+
+func (c *deliverChannel) Deliver(e event.Event) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.attempts++
+
+	if c.failures != 0 {
+		c.failures--
+		return errors.New("backend unavailable")
+	}
+
+	c.delivered = append(c.delivered, e)
+	return nil
+}
+
+func (c *deliverChannel) events() []event.Event {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]event.Event{}, c.delivered...)
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if fn() {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("Timeout waiting for condition")
+}
+
+func TestSpool(t *testing.T) {
+	c := &deliverChannel{}
+
+	s, err := NewSpool("deliver", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		s.Send(event.New(
+			event.Category("test"),
+			event.Custom("test.seq", fmt.Sprint(i)),
+		))
+	}
+
+	waitFor(t, func() bool { return len(c.events()) == 3 })
+
+	for i, e := range c.events() {
+		if v := e.Get("category"); v != "test" {
+			t.Errorf("Expected category test, got %s", v)
+		}
+
+		if v := e.Get("test.seq"); v != fmt.Sprint(i) {
+			t.Errorf("Expected events in order, got %s at %d", v, i)
+		}
+	}
+
+	stats := s.Stats()
+	if stats.Depth != 0 || stats.Size != 0 || stats.Delivered != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSpoolRetry(t *testing.T) {
+	c := &deliverChannel{
+		failures: 2,
+	}
+
+	s, err := NewSpool("retry", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	s.Send(event.New(event.Category("test")))
+
+	waitFor(t, func() bool { return len(c.events()) == 1 })
+
+	if c.attempts != 3 {
+		t.Errorf("Expected 3 delivery attempts, got %d", c.attempts)
+	}
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	c := &deliverChannel{
+		failures: -1,
+	}
+
+	s, err := NewSpool("max-size", c, SpoolConfig{MaxSize: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		s.Send(event.New(event.Category("test")))
+	}
+
+	if stats := s.Stats(); stats.Dropped != 3 || stats.Depth != 0 {
+		t.Errorf("Expected 3 dropped events, got %+v", stats)
+	}
+}
+
+func TestSpoolRecover(t *testing.T) {
+	c := &deliverChannel{
+		failures: -1,
+	}
+
+	s, err := NewSpool("recover", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.Send(event.New(event.Category("test")))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = &deliverChannel{}
+
+	s, err = NewSpool("recover", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if stats := s.Stats(); stats.Depth != 2 {
+		t.Errorf("Expected 2 recovered events, got %+v", stats)
+	}
+
+	waitFor(t, func() bool { return len(c.events()) == 2 })
+
+	// the date is restored as time
+	c.events()[0].Range(func(key, value interface{}) bool {
+		if key != "date" {
+		} else if _, ok := value.(time.Time); !ok {
+			t.Errorf("Expected date of type time.Time, got %T", value)
+		}
+
+		return true
+	})
+}
+
+func TestSpoolReplace(t *testing.T) {
+	old, err := NewSpool("replace", &deliverChannel{failures: -1}, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &deliverChannel{}
+
+	s, err := NewSpool("replace", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	// the old spool keeps spooling until it is closed
+	for i := 0; i < 4; i++ {
+		sp := s
+		if i%2 == 0 {
+			sp = old
+		}
+
+		sp.Send(event.New(
+			event.Category("test"),
+			event.Custom("test.seq", fmt.Sprint(i)),
+		))
+	}
+
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return len(c.events()) == 4 })
+
+	for i, e := range c.events() {
+		if v := e.Get("test.seq"); v != fmt.Sprint(i) {
+			t.Errorf("Expected events in order, got %s at %d", v, i)
+		}
+	}
+
+	// the depth is shared, the new spool delivered the events of both
+	if stats := s.Stats(); stats.Depth != 0 || stats.Size != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSpoolReplaceMaxSize(t *testing.T) {
+	old, err := NewSpool("replace-max-size", &deliverChannel{failures: -1}, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old.Send(event.New(event.Category("test")))
+
+	size := old.Stats().Size
+
+	s, err := NewSpool("replace-max-size", &deliverChannel{failures: -1}, SpoolConfig{MaxSize: size + size/2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events spooled by the old spool count towards the maximum size
+	s.Send(event.New(event.Category("test")))
+
+	if stats := s.Stats(); stats.Depth != 1 || stats.Dropped != 1 {
+		t.Errorf("Expected 1 spooled and 1 dropped event, got %+v", stats)
+	}
+
+	// the old spool delivers until it is closed
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolPrefix(t *testing.T) {
+	c := &deliverChannel{failures: -1}
+
+	s, err := NewSpool("prefix.extended", c, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Send(event.New(event.Category("test")))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewSpool("prefix", &deliverChannel{}, SpoolConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if stats := s.Stats(); stats.Depth != 0 {
+		t.Errorf("Expected no events of other channels, got %+v", stats)
+	}
+}
+
+func TestSpoolNotDeliverer(t *testing.T) {
+	if _, err := NewSpool("dummy", MustDummy(), SpoolConfig{}, nil); err == nil {
+		t.Errorf("Expected channels without Deliver to be refused")
+	}
+}
@@ -17,18 +17,25 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/mattn/go-isatty"
 
 	"github.com/fatih/color"
 
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/certificates"
 	"github.com/honeytrap/honeytrap/cmd"
 	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/metrics"
+	"github.com/honeytrap/honeytrap/profiles"
 
 	"github.com/honeytrap/honeytrap/director"
 	_ "github.com/honeytrap/honeytrap/director/forward"
@@ -39,6 +46,7 @@ import (
 
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/web"
 
 	"github.com/honeytrap/honeytrap/services"
 	_ "github.com/honeytrap/honeytrap/services/bannerfmt"
@@ -48,7 +56,9 @@ import (
 	_ "github.com/honeytrap/honeytrap/services/ethereum"
 	_ "github.com/honeytrap/honeytrap/services/ftp"
 	_ "github.com/honeytrap/honeytrap/services/ipp"
+	_ "github.com/honeytrap/honeytrap/services/kubernetes"
 	_ "github.com/honeytrap/honeytrap/services/ldap"
+	_ "github.com/honeytrap/honeytrap/services/mysql"
 	_ "github.com/honeytrap/honeytrap/services/redis"
 	_ "github.com/honeytrap/honeytrap/services/smtp"
 	_ "github.com/honeytrap/honeytrap/services/snmp"
@@ -105,8 +115,46 @@ type Honeytrap struct {
 
 	dataDir string
 
+	// configFn returns the configuration source again, used on reload
+	configFn func() ([]byte, error)
+
+	listener listener.Listener
+
+	// listenerType is the type of the configured listener
+	listenerType string
+
+	// metrics exposes the metrics, when enabled
+	metrics *metrics.Server
+
+	// profiles aggregates the events per source ip
+	profiles *profiles.Store
+
+	// channels contains the filtered channels of the running configuration
+	channels *channelGroup
+
+	// setup contains the instances built from the running configuration
+	setup *setup
+
+	// protects ports, setup and config, which are swapped on reload
+	m sync.RWMutex
+
+	// serializes reloads
+	reloadM sync.Mutex
+
 	// Maps a port and a protocol to an array of pointers to services
 	ports map[net.Addr][]*ServiceMap
+
+	// ctx is the context connections are handled with, it is cancelled
+	// on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// conns contains the connections being handled
+	conns    map[net.Conn]struct{}
+	connsM   sync.Mutex
+	stopping bool
+
+	shutdownTimeout time.Duration
 }
 
 // New returns a new instance of a Honeytrap struct.
@@ -117,11 +165,18 @@ func New(options ...OptionFn) (*Honeytrap, error) {
 	// Initialize all channels within the provided config.
 	conf := &config.Default
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	h := &Honeytrap{
-		config:   conf,
-		director: director.MustDummy(),
-		bus:      bus,
-		profiler: profiler.Dummy(),
+		config:          conf,
+		director:        director.MustDummy(),
+		bus:             bus,
+		profiler:        profiler.Dummy(),
+		channels:        &channelGroup{},
+		ctx:             ctx,
+		cancel:          cancel,
+		conns:           map[net.Conn]struct{}{},
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
 
 	for _, fn := range options {
@@ -157,10 +212,16 @@ type ServiceMap struct {
 
 	Name string
 	Type string
+
+	// the configuration and director the service was created with, used
+	// to detect changes on reload
+	primitive toml.Primitive
+	director  director.Director
 }
 
 var (
 	ErrNoServicesGivenPort = fmt.Errorf("no services for the given ports")
+	ErrNoSuitableService   = fmt.Errorf("no suitable service for the given port")
 )
 
 /* Finds a service that can handle the given connection.
@@ -178,6 +239,7 @@ func (hc *Honeytrap) findService(conn net.Conn) (*ServiceMap, net.Conn, error) {
 
 	var serviceCandidates []*ServiceMap
 
+	hc.m.RLock()
 	for k, sc := range hc.ports {
 		if !compareAddr(k, localAddr) {
 			continue
@@ -185,9 +247,10 @@ func (hc *Honeytrap) findService(conn net.Conn) (*ServiceMap, net.Conn, error) {
 
 		serviceCandidates = sc
 	}
+	hc.m.RUnlock()
 
 	if len(serviceCandidates) == 0 {
-		return nil, nil, fmt.Errorf("No service configured for the given port")
+		return nil, nil, ErrNoServicesGivenPort
 	} else if len(serviceCandidates) == 1 {
 		return serviceCandidates[0], conn, nil
 	}
@@ -223,7 +286,7 @@ func (hc *Honeytrap) findService(conn net.Conn) (*ServiceMap, net.Conn, error) {
 	}
 	// There are some services for that port, but non can handle the connection.
 	// Let the caller deal with it.
-	return nil, nil, fmt.Errorf("No suitable service for the given port")
+	return nil, nil, ErrNoSuitableService
 }
 
 func (hc *Honeytrap) heartbeat() {
@@ -232,6 +295,8 @@ func (hc *Honeytrap) heartbeat() {
 	count := 0
 
 	for range beat {
+		heartbeatTimestamp.Set(float64(time.Now().Unix()))
+
 		hc.bus.Send(event.New(
 			event.Sensor("honeytrap"),
 			event.Category("heartbeat"),
@@ -347,116 +412,70 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 
 	hc.profiler.Start()
 
-	channels := map[string]pushers.Channel{}
-	isChannelUsed := make(map[string]bool)
-	// sane defaults!
-
-	for key, s := range hc.config.Channels {
-		x := struct {
-			Type string `toml:"type"`
-		}{}
-
-		err := hc.config.PrimitiveDecode(s, &x)
-		if err != nil {
-			log.Error("Error parsing configuration of channel: %s", err.Error())
-			continue
-		}
-
-		if x.Type == "" {
-			log.Error("Error parsing configuration of channel %s: type not set", key)
-			continue
-		}
-
-		if channelFunc, ok := pushers.Get(x.Type); !ok {
-			log.Error("Channel %s not supported on platform (%s)", x.Type, key)
-		} else if d, err := channelFunc(
-			pushers.WithConfig(s, hc.config),
-		); err != nil {
-			log.Fatalf("Error initializing channel %s(%s): %s", key, x.Type, err)
-		} else {
-			channels[key] = d
-			isChannelUsed[key] = false
-		}
-	}
-
 	// subscribe default to global bus
 	// maybe we can rewrite pushers / channels to use global bus instead
 	bc := pushers.NewBusChannel()
 	hc.bus.Subscribe(bc)
 
-	for _, s := range hc.config.Filters {
-		x := struct {
-			Channels   []string `toml:"channel"`
-			Services   []string `toml:"services"`
-			Categories []string `toml:"categories"`
-		}{}
-
-		err := hc.config.PrimitiveDecode(s, &x)
-		if err != nil {
-			log.Error("Error parsing configuration of filter: %s", err.Error())
-			continue
-		}
+	hc.bus.Subscribe(hc.channels)
 
-		for _, name := range x.Channels {
-			channel, ok := channels[name]
-			if !ok {
-				log.Error("Could not find channel %s for filter", name)
-				continue
-			}
+	if store, err := artifacts.New(
+		artifacts.WithDataDir(hc.dataDir),
+		artifacts.WithConfig(hc.config.Artifacts, hc.config),
+	); err != nil {
+		log.Errorf("Error parsing configuration of artifacts: %s", err.Error())
+	} else {
+		artifacts.SetDefault(store)
+	}
 
-			isChannelUsed[name] = true
-			channel = pushers.TokenChannel(channel, hc.token)
+	if m, err := certificates.New(
+		certificates.WithConfig(hc.config.Certificates, hc.config),
+	); err != nil {
+		log.Errorf("Error parsing configuration of certificates: %s", err.Error())
+	} else {
+		certificates.SetDefault(m)
+	}
 
-			if len(x.Categories) != 0 {
-				channel = pushers.FilterChannel(channel, pushers.RegexFilterFunc("category", x.Categories))
-			}
+	if store, err := profiles.New(
+		profiles.WithConfig(hc.config.Profiles, hc.config),
+	); err != nil {
+		log.Errorf("Error parsing configuration of profiles: %s", err.Error())
+	} else {
+		profiles.SetDefault(store)
 
-			if len(x.Services) != 0 {
-				channel = pushers.FilterChannel(channel, pushers.RegexFilterFunc("service", x.Services))
-			}
+		hc.bus.Subscribe(store)
 
-			if err := hc.bus.Subscribe(channel); err != nil {
-				log.Error("Could not add channel %s to bus: %s", name, err.Error())
-			}
-		}
+		hc.profiles = store
 	}
 
-	for name, isUsed := range isChannelUsed {
-		if !isUsed {
-			log.Warningf("Channel %s is unused. Did you forget to add a filter?", name)
-		}
+	if w, err := web.New(
+		web.WithDataDir(hc.dataDir),
+		web.WithConfig(hc.config.Web, hc.config),
+		web.WithHandler("/api/v1/reload", http.HandlerFunc(hc.ServeReload)),
+	); err != nil {
+		log.Errorf("Error parsing configuration of web: %s", err.Error())
+	} else if w.Enabled {
+		w.Start()
+
+		// the web interface shows the enriched events
+		hc.channels.Subscribe(w)
 	}
 
-	// initialize directors
-	directors := map[string]director.Director{}
-	availableDirectorNames := director.GetAvailableDirectorNames()
+	hc.registerMetrics()
 
-	for key, s := range hc.config.Directors {
-		x := struct {
-			Type string `toml:"type"`
-		}{}
+	if m, err := metrics.New(
+		metrics.WithConfig(hc.config.Metrics, hc.config),
+	); err != nil {
+		log.Errorf("Error parsing configuration of metrics: %s", err.Error())
+	} else {
+		m.Start()
 
-		err := hc.config.PrimitiveDecode(s, &x)
-		if err != nil {
-			log.Error("Error parsing configuration of director: %s", err.Error())
-			continue
-		}
-
-		if x.Type == "" {
-			log.Error("Error parsing configuration of service %s: type not set", key)
-			continue
-		}
+		hc.metrics = m
+	}
 
-		if directorFunc, ok := director.Get(x.Type); !ok {
-			log.Error("Director type=%s not supported on platform (director=%s). Available directors: %s", x.Type, key, strings.Join(availableDirectorNames, ", "))
-		} else if d, err := directorFunc(
-			director.WithChannel(hc.bus),
-			director.WithConfig(s, hc.config),
-		); err != nil {
-			log.Fatalf("Error initializing director %s(%s): %s", key, x.Type, err)
-		} else {
-			directors[key] = d
-		}
+	su, errs := hc.build(hc.config, nil)
+	if errs.fatal {
+		log.Fatalf("Error initializing configuration: %s", errs.Error())
 	}
 
 	// initialize listener
@@ -473,61 +492,6 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		fmt.Println(color.RedString("Listener not set"))
 	}
 
-	var enabledDirectorNames []string
-	for key := range directors {
-		enabledDirectorNames = append(enabledDirectorNames, key)
-	}
-
-	serviceList := make(map[string]*ServiceMap)
-	isServiceUsed := make(map[string]bool) // Used to check that every service is used by a port
-	// same for proxies
-	for key, s := range hc.config.Services {
-		x := struct {
-			Type     string `toml:"type"`
-			Director string `toml:"director"`
-			Port     string `toml:"port"`
-		}{}
-
-		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
-			log.Error("Error parsing configuration of service %s: %s", key, err.Error())
-			continue
-		}
-
-		if x.Port != "" {
-			log.Error("Ports in services are deprecated, add services to ports instead")
-			continue
-		}
-
-		// individual configuration per service
-		options := []services.ServicerFunc{
-			services.WithChannel(hc.bus),
-			services.WithConfig(s, hc.config),
-		}
-
-		if x.Director == "" {
-		} else if d, ok := directors[x.Director]; ok {
-			options = append(options, services.WithDirector(d))
-		} else {
-			log.Error(color.RedString("Could not find director=%s for service=%s. Enabled directors: %s", x.Director, key, strings.Join(enabledDirectorNames, ", ")))
-			continue
-		}
-
-		fn, ok := services.Get(x.Type)
-		if !ok {
-			log.Error(color.RedString("Could not find type %s for service %s", x.Type, key))
-			continue
-		}
-
-		service := fn(options...)
-		serviceList[key] = &ServiceMap{
-			Service: service,
-			Name:    key,
-			Type:    x.Type,
-		}
-		isServiceUsed[key] = false
-		log.Infof("Configured service %s (%s)", x.Type, key)
-	}
-
 	listenerFunc, ok := listener.Get(x.Type)
 	if !ok {
 		fmt.Println(color.RedString("Listener %s not support on platform", x.Type))
@@ -542,95 +506,20 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		log.Fatalf("Error initializing listener %s: %s", x.Type, err)
 	}
 
-	hc.ports = make(map[net.Addr][]*ServiceMap)
-	for _, s := range hc.config.Ports {
-		x := struct {
-			Port     string   `toml:"port"`
-			Ports    []string `toml:"ports"`
-			Services []string `toml:"services"`
-		}{}
+	hc.listener = l
+	hc.listenerType = x.Type
 
-		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
-			log.Error("Error parsing configuration of generic ports: %s", err.Error())
-			continue
-		}
+	hc.apply(su)
 
-		var ports []string
-		if x.Ports != nil {
-			ports = x.Ports
-		}
-		if x.Port != "" {
-			ports = append(ports, x.Port)
-		}
-		if x.Port != "" && x.Ports != nil {
-			log.Warning("Both \"port\" and \"ports\" were defined, this can be confusing")
-		} else if x.Port == "" && x.Ports == nil {
-			log.Error("Neither \"port\" nor \"ports\" were defined")
+	for addr := range su.ports {
+		a, ok := l.(listener.AddAddresser)
+		if !ok {
+			log.Error("Listener error")
 			continue
 		}
+		a.AddAddress(addr)
 
-		if len(x.Services) == 0 {
-			log.Warning("No services defined for port(s) " + strings.Join(ports, ", "))
-		}
-
-		for _, portStr := range ports {
-			addr, _, _, err := ToAddr(portStr)
-			if err != nil {
-				log.Error("Error parsing port string: %s", err.Error())
-				continue
-			}
-			if addr == nil {
-				log.Error("Failed to bind: addr is nil")
-				continue
-			}
-
-			// Get the services from their names
-			var servicePtrs []*ServiceMap
-			for _, serviceName := range x.Services {
-				ptr, ok := serviceList[serviceName]
-				if !ok {
-					log.Error("Unknown service '%s' for port %s", serviceName, portStr)
-					continue
-				}
-				servicePtrs = append(servicePtrs, ptr)
-				isServiceUsed[serviceName] = true
-			}
-			if len(servicePtrs) == 0 {
-				log.Errorf("Port %s has no valid services, it won't be listened on", portStr)
-				continue
-			}
-
-			found := false
-			for k, _ := range hc.ports {
-				if !compareAddr(k, addr) {
-					continue
-				}
-
-				found = true
-			}
-
-			if found {
-				log.Error("Port %s was already defined, ignoring the newer definition", portStr)
-				continue
-			}
-
-			hc.ports[addr] = servicePtrs
-
-			a, ok := l.(listener.AddAddresser)
-			if !ok {
-				log.Error("Listener error")
-				continue
-			}
-			a.AddAddress(addr)
-
-			log.Infof("Configured port %s/%s", addr.Network(), addr.String())
-		}
-	}
-
-	for name, isUsed := range isServiceUsed {
-		if !isUsed {
-			log.Warningf("Service %s is defined but not used", name)
-		}
+		log.Infof("Configured port %s/%s", addr.Network(), addr.String())
 	}
 
 	if len(hc.config.Undecoded()) != 0 {
@@ -648,10 +537,24 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		for {
 			conn, err := l.Accept()
 			if err != nil {
-				panic(err)
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				log.Errorf("Error accepting connection: %s", err.Error())
+
+				time.Sleep(100 * time.Millisecond)
+				continue
 			}
 
-			incoming <- conn
+			select {
+			case incoming <- conn:
+			case <-ctx.Done():
+				conn.Close()
+				return
+			}
 
 			// in case of goroutine starvation
 			// with many connection and single procs
@@ -680,10 +583,22 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 		}
 	}()
 
+	if !hc.track(conn) {
+		conn.Close()
+		return
+	}
+
+	defer hc.untrack(conn)
+
 	defer conn.Close()
 
+	// the name of the service handling the connection, once found
+	service := ""
+
 	defer func() {
 		if r := recover(); r != nil {
+			panicsTotal.Inc(service)
+
 			message := event.Message("%+v", r)
 			if err, ok := r.(error); ok {
 				message = event.Message("%+v", err)
@@ -702,27 +617,66 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 	log.Debug("Accepted connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 	defer log.Debug("Disconnected connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 
+	release, ok := hc.limit(conn)
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	if config := hc.tlsConfig(conn.LocalAddr()); config == nil {
+	} else if tlsConn, err := hc.terminateTLS(conn, config); err != nil {
+		log.Debug("TLS handshake failed for %s => %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err.Error())
+		return
+	} else {
+		conn = tlsConn
+	}
+
 	/* conn is the original connection. newConn can be either the same
 	 * connection, or a wrapper in the form of a PeekConnection.
 	 */
 	sm, newConn, err := hc.findService(conn)
+
+	findServiceTotal.Inc(outcome(err))
+
 	if sm == nil {
 		log.Debug("No suitable handler for %s => %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err.Error())
 		return
 	}
 
+	service = sm.Name
+
 	log.Debug("Handling connection for %s => %s %s(%s)", conn.RemoteAddr(), conn.LocalAddr(), sm.Name, sm.Type)
 
+	connectionsTotal.Inc(portLabel(conn.LocalAddr()), sm.Name, hc.listenerType)
+
 	newConn = TimeoutConn(newConn, time.Second*30)
 
-	ctx := context.Background()
-	if err := sm.Service.Handle(ctx, newConn); err != nil {
+	start := time.Now()
+	defer func() {
+		sessionDuration.Observe(time.Since(start).Seconds(), sm.Name)
+	}()
+
+	if err := sm.Service.Handle(hc.ctx, newConn); err != nil {
 		log.Errorf(color.RedString("Error handling service: %s: %s", sm.Name, err.Error()))
 	}
 }
 
-// Stop will stop Honeytrap
+// Stop will stop Honeytrap, the connections are drained and the channels
+// flushed
 func (hc *Honeytrap) Stop() {
+	hc.Shutdown(hc.shutdownTimeout)
+
+	if hc.profiles != nil {
+		if err := hc.profiles.Close(); err != nil {
+			log.Errorf("Error writing profiles: %s", err.Error())
+		}
+	}
+
+	if hc.metrics != nil {
+		hc.metrics.Close()
+	}
+
 	hc.profiler.Stop()
 
 	fmt.Println(color.YellowString("Honeytrap stopped."))
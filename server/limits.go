@@ -0,0 +1,350 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+const (
+	ActionDrop   = "drop"
+	ActionTarpit = "tarpit"
+
+	ReasonMaxConnections      = "max-connections"
+	ReasonMaxConnectionsPerIP = "max-connections-per-ip"
+	ReasonRatePerIP           = "rate-per-ip"
+	ReasonRatePerNetwork      = "rate-per-network"
+)
+
+// maxTarpitted limits the number of connections held open by tarpits, more
+// connections over the limits are dropped.
+const maxTarpitted = 1024
+
+var tarpitted int64
+
+// Limits configures the connections which are accepted, globally in the
+// limits section or per port. Zero values are unlimited.
+//
+//	[limits]
+//	max-connections = 4096
+//	max-connections-per-ip = 16
+//	rate-per-ip = 60
+//	rate-per-network = 600
+//	action = "tarpit"
+//	tarpit-delay = "30s"
+//	window = "1m"
+type Limits struct {
+	// MaxConnections is the maximum number of concurrent connections
+	MaxConnections int `toml:"max-connections"`
+
+	// MaxConnectionsPerIP is the maximum number of concurrent connections
+	// of a source ip
+	MaxConnectionsPerIP int `toml:"max-connections-per-ip"`
+
+	// RatePerIP is the number of new connections per minute of a source ip
+	RatePerIP int `toml:"rate-per-ip"`
+
+	// RatePerNetwork is the number of new connections per minute of a /24
+	// (ipv4) or /64 (ipv6) network
+	RatePerNetwork int `toml:"rate-per-network"`
+
+	// Action is the action for connections over the limits, drop or tarpit
+	Action string `toml:"action"`
+
+	// TarpitDelay is the time tarpitted connections are held open
+	TarpitDelay config.Delay `toml:"tarpit-delay"`
+
+	// Window is the interval in which a single rate-limited event is sent
+	// per source
+	Window config.Delay `toml:"window"`
+}
+
+func (l Limits) validate() error {
+	if l.Action != "" && l.Action != ActionDrop && l.Action != ActionTarpit {
+		return fmt.Errorf("unknown action %s", l.Action)
+	}
+
+	if l.MaxConnections < 0 || l.MaxConnectionsPerIP < 0 || l.RatePerIP < 0 || l.RatePerNetwork < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+
+	return nil
+}
+
+// limiter enforces limits, the state is kept for reuse on reload when the
+// limits are unchanged.
+type limiter struct {
+	Limits
+
+	// port is the port of the limits, empty for the global limits
+	port string
+
+	m sync.Mutex
+
+	active int
+	perIP  map[string]int
+
+	ipRate      *services.Limiter
+	networkRate *services.Limiter
+
+	// reported contains the last rate-limited event per source
+	reported map[string]time.Time
+
+	purged time.Time
+}
+
+func newLimiter(port string, l Limits) *limiter {
+	if l.Action == "" {
+		l.Action = ActionDrop
+	}
+
+	if l.TarpitDelay == 0 {
+		l.TarpitDelay = config.Delay(30 * time.Second)
+	}
+
+	if l.Window == 0 {
+		l.Window = config.Delay(time.Minute)
+	}
+
+	lim := &limiter{
+		Limits:   l,
+		port:     port,
+		perIP:    map[string]int{},
+		reported: map[string]time.Time{},
+		purged:   time.Now(),
+	}
+
+	if l.RatePerIP > 0 {
+		lim.ipRate = services.NewLimiter(services.WithRate(time.Minute/time.Duration(l.RatePerIP), l.RatePerIP))
+	}
+
+	if l.RatePerNetwork > 0 {
+		lim.networkRate = services.NewLimiter(services.WithRate(time.Minute/time.Duration(l.RatePerNetwork), l.RatePerNetwork))
+	}
+
+	return lim
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	return nil
+}
+
+func network(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// acquire registers a new connection of ip. When the connection is over
+// the limits the reason is returned, otherwise the returned function
+// releases the connection.
+func (l *limiter) acquire(ip net.IP) (func(), string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.purge()
+
+	key := ip.String()
+
+	if l.MaxConnections > 0 && l.active >= l.MaxConnections {
+		return nil, ReasonMaxConnections
+	}
+
+	if l.MaxConnectionsPerIP > 0 && l.perIP[key] >= l.MaxConnectionsPerIP {
+		return nil, ReasonMaxConnectionsPerIP
+	}
+
+	if l.ipRate != nil && !l.ipRate.AllowKey(key) {
+		return nil, ReasonRatePerIP
+	}
+
+	if l.networkRate != nil && !l.networkRate.AllowKey(network(ip)) {
+		return nil, ReasonRatePerNetwork
+	}
+
+	l.active++
+	l.perIP[key]++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.m.Lock()
+			defer l.m.Unlock()
+
+			l.active--
+
+			if l.perIP[key]--; l.perIP[key] <= 0 {
+				delete(l.perIP, key)
+			}
+		})
+	}, ""
+}
+
+// purge removes the state of sources which haven't been seen for a window.
+func (l *limiter) purge() {
+	window := l.Window.Duration()
+
+	if time.Since(l.purged) < window {
+		return
+	}
+
+	l.purged = time.Now()
+
+	for k, t := range l.reported {
+		if time.Since(t) >= window {
+			delete(l.reported, k)
+		}
+	}
+
+	// the buckets are full again after a minute
+	if l.ipRate != nil {
+		l.ipRate.Purge(time.Minute)
+	}
+
+	if l.networkRate != nil {
+		l.networkRate.Purge(time.Minute)
+	}
+}
+
+// report returns whether a rate-limited event should be sent for the
+// source, only a single event per window is sent.
+func (l *limiter) report(ip net.IP, reason string) bool {
+	// the global ceiling isn't caused by a single source
+	key := ""
+
+	switch reason {
+	case ReasonMaxConnectionsPerIP, ReasonRatePerIP:
+		key = ip.String()
+	case ReasonRatePerNetwork:
+		key = network(ip)
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if t, ok := l.reported[reason+"/"+key]; ok && time.Since(t) < l.Window.Duration() {
+		return false
+	}
+
+	l.reported[reason+"/"+key] = time.Now()
+	return true
+}
+
+// refuse handles a connection over the limits, by dropping or tarpitting
+// it. The connection is closed by the caller.
+func (l *limiter) refuse(c pushers.Channel, conn net.Conn, ip net.IP, reason string) {
+	action := l.Action
+
+	if action == ActionTarpit && atomic.LoadInt64(&tarpitted) >= maxTarpitted {
+		action = ActionDrop
+	}
+
+	refusedTotal.Inc(reason)
+
+	if l.report(ip, reason) {
+		c.Send(event.New(
+			event.Sensor("honeytrap"),
+			event.Category("limits"),
+			event.Type("rate-limited"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("limits.reason", reason),
+			event.Custom("limits.action", action),
+			event.Custom("limits.port", l.port),
+			event.Custom("limits.window", l.Window.Duration().String()),
+		))
+	}
+
+	log.Debugf("Connection %s => %s over the limits (%s), %s", conn.RemoteAddr(), conn.LocalAddr(), reason, action)
+
+	if action != ActionTarpit {
+		return
+	}
+
+	atomic.AddInt64(&tarpitted, 1)
+	defer atomic.AddInt64(&tarpitted, -1)
+
+	time.Sleep(l.TarpitDelay.Duration())
+}
+
+// limit applies the global limits and the limits of the port to the
+// connection. It returns false when the connection has been refused,
+// otherwise the returned function releases the connection.
+func (hc *Honeytrap) limit(conn net.Conn) (func(), bool) {
+	ip := remoteIP(conn.RemoteAddr())
+	if ip == nil {
+		return func() {}, true
+	}
+
+	hc.m.RLock()
+	su := hc.setup
+	hc.m.RUnlock()
+
+	if su == nil {
+		return func() {}, true
+	}
+
+	limiters := []*limiter{}
+
+	if su.limits != nil {
+		limiters = append(limiters, su.limits)
+	}
+
+	for addr, l := range su.portLimits {
+		if compareAddr(addr, conn.LocalAddr()) {
+			limiters = append(limiters, l)
+		}
+	}
+
+	releases := []func(){}
+
+	release := func() {
+		for _, fn := range releases {
+			fn()
+		}
+	}
+
+	for _, l := range limiters {
+		fn, reason := l.acquire(ip)
+		if reason != "" {
+			release()
+
+			l.refuse(hc.bus, conn, ip, reason)
+			return nil, false
+		}
+
+		releases = append(releases, fn)
+	}
+
+	return release, true
+}
@@ -0,0 +1,187 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type limitsConn struct {
+	net.Conn
+
+	local, remote net.Addr
+}
+
+func (c *limitsConn) LocalAddr() net.Addr  { return c.local }
+func (c *limitsConn) RemoteAddr() net.Addr { return c.remote }
+
+func newLimitsConn(src string, port int) net.Conn {
+	return &limitsConn{
+		local:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: port},
+		remote: &net.TCPAddr{IP: net.ParseIP(src), Port: 40000},
+	}
+}
+
+type limitsChannel struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+func (c *limitsChannel) Send(e event.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e.Get("type") == "rate-limited" {
+		c.events = append(c.events, e)
+	}
+}
+
+const limitsConfig = `
+[limits]
+max-connections-per-ip = 2
+rate-per-network = 3
+
+[service.echo01]
+type="echo"
+
+[[port]]
+ports=["tcp/8001"]
+services=["echo01"]
+
+[[port]]
+ports=["tcp/8002"]
+services=["echo01"]
+
+[port.limits]
+max-connections = 1
+`
+
+func newLimitsHoneytrap(t *testing.T, data string) (*Honeytrap, *limitsChannel) {
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{}
+	if err := conf.Decode(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	su, errs := hc.build(conf, nil)
+	if errs.Len() != 0 {
+		t.Fatal(errs)
+	}
+
+	hc.config = conf
+	hc.listener = &testListener{}
+	hc.apply(su)
+
+	c := &limitsChannel{}
+	hc.bus.Subscribe(c)
+
+	return hc, c
+}
+
+func TestLimitsPerIP(t *testing.T) {
+	hc, c := newLimitsHoneytrap(t, limitsConfig)
+
+	release1, ok1 := hc.limit(newLimitsConn("198.51.100.1", 8001))
+	_, ok2 := hc.limit(newLimitsConn("198.51.100.1", 8001))
+	_, ok3 := hc.limit(newLimitsConn("198.51.100.1", 8001))
+	_, ok4 := hc.limit(newLimitsConn("198.51.100.1", 8001))
+
+	if !ok1 || !ok2 || ok3 || ok4 {
+		t.Fatalf("Expected the third and fourth connection to be refused, got %v %v %v %v", ok1, ok2, ok3, ok4)
+	}
+
+	if len(c.events) != 1 {
+		t.Fatalf("Expected a single rate-limited event, got %d", len(c.events))
+	} else if c.events[0].Get("limits.reason") != ReasonMaxConnectionsPerIP || c.events[0].Get("limits.action") != ActionDrop {
+		t.Errorf("Unexpected event %s %s", c.events[0].Get("limits.reason"), c.events[0].Get("limits.action"))
+	}
+
+	release1()
+
+	if _, ok := hc.limit(newLimitsConn("198.51.100.1", 8001)); !ok {
+		t.Errorf("Expected connection to be accepted after release")
+	}
+
+	// the fourth accepted connection of the network in a minute
+	if _, ok := hc.limit(newLimitsConn("198.51.100.2", 8001)); ok {
+		t.Errorf("Expected connection to be refused by the network rate")
+	}
+
+	if len(c.events) != 2 || c.events[1].Get("limits.reason") != ReasonRatePerNetwork {
+		t.Errorf("Expected a rate-per-network event")
+	}
+}
+
+func TestLimitsPerPort(t *testing.T) {
+	hc, c := newLimitsHoneytrap(t, limitsConfig)
+
+	release, ok := hc.limit(newLimitsConn("198.51.100.1", 8002))
+	if !ok {
+		t.Fatal("Expected connection to be accepted")
+	}
+
+	if _, ok := hc.limit(newLimitsConn("203.0.113.1", 8002)); ok {
+		t.Errorf("Expected connection to be refused by the port ceiling")
+	}
+
+	if _, ok := hc.limit(newLimitsConn("203.0.113.1", 8001)); !ok {
+		t.Errorf("Expected connection on another port to be accepted")
+	}
+
+	if len(c.events) != 1 || c.events[0].Get("limits.reason") != ReasonMaxConnections || c.events[0].Get("limits.port") != "tcp/8002" {
+		t.Errorf("Expected a max-connections event for the port")
+	}
+
+	release()
+
+	// the refused connection didn't count against the global limits
+	if n := hc.setup.limits.perIP["203.0.113.1"]; n != 1 {
+		t.Errorf("Expected 1 connection of the source, got %d", n)
+	}
+
+	if _, ok := hc.limit(newLimitsConn("203.0.113.1", 8002)); !ok {
+		t.Errorf("Expected connection to be accepted after release")
+	}
+}
+
+func TestLimitsReload(t *testing.T) {
+	hc, _ := newLimitsHoneytrap(t, limitsConfig)
+
+	limits := hc.setup.limits
+
+	if _, err := hc.ReloadFrom([]byte(limitsConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	if hc.setup.limits != limits {
+		t.Errorf("Expected the state of unchanged limits to be kept")
+	}
+
+	if _, err := hc.ReloadFrom([]byte(`
+[limits]
+action = "reject"
+`)); err == nil {
+		t.Errorf("Expected an error for an unknown action")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/metrics"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// outcomes of finding the service of a connection
+const (
+	OutcomeFound      = "found"
+	OutcomeNoService  = "no-service"
+	OutcomePeekFailed = "peek-failed"
+	OutcomeNoMatch    = "no-match"
+)
+
+var (
+	connectionsTotal = metrics.NewCounter(
+		"honeytrap_connections_total",
+		"Connections handled by a service.",
+		"port", "service", "listener",
+	)
+
+	refusedTotal = metrics.NewCounter(
+		"honeytrap_connections_refused_total",
+		"Connections refused because of the limits.",
+		"reason",
+	)
+
+	findServiceTotal = metrics.NewCounter(
+		"honeytrap_find_service_total",
+		"Outcomes of finding the service of a connection.",
+		"outcome",
+	)
+
+	panicsTotal = metrics.NewCounter(
+		"honeytrap_panics_total",
+		"Panics recovered while handling a connection.",
+		"service",
+	)
+
+	sessionDuration = metrics.NewHistogram(
+		"honeytrap_session_duration_seconds",
+		"Duration of the sessions handled by a service.",
+		[]float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		"service",
+	)
+
+	eventsTotal = metrics.NewCounter(
+		"honeytrap_events_total",
+		"Events sent to the channels.",
+		"category",
+	)
+
+	heartbeatTimestamp = metrics.NewGauge(
+		"honeytrap_heartbeat_timestamp_seconds",
+		"Time of the last heartbeat.",
+	)
+
+	startTimestamp = metrics.NewGauge(
+		"honeytrap_start_timestamp_seconds",
+		"Time honeytrap was started.",
+	)
+)
+
+// outcome returns the outcome of findService for the metrics.
+func outcome(err error) string {
+	switch err {
+	case nil:
+		return OutcomeFound
+	case ErrNoServicesGivenPort:
+		return OutcomeNoService
+	case ErrNoSuitableService:
+		return OutcomeNoMatch
+	}
+
+	return OutcomePeekFailed
+}
+
+// portLabel returns the label of the local address, e.g. tcp/22.
+func portLabel(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return fmt.Sprintf("tcp/%d", a.Port)
+	case *net.UDPAddr:
+		return fmt.Sprintf("udp/%d", a.Port)
+	}
+
+	return addr.Network()
+}
+
+// sentChannel counts the events sent to a channel.
+type sentChannel struct {
+	ce *channelEntry
+}
+
+func (sc *sentChannel) Send(e event.Event) {
+	atomic.AddInt64(&sc.ce.sent, 1)
+
+	sc.ce.channel.Send(e)
+}
+
+// registerMetrics registers the metrics of which the values are collected
+// from the running honeytrap.
+func (hc *Honeytrap) registerMetrics() {
+	startTimestamp.Set(float64(time.Now().Unix()))
+	heartbeatTimestamp.Set(float64(time.Now().Unix()))
+
+	metrics.NewGaugeFunc(
+		"honeytrap_goroutines",
+		"Number of goroutines.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(runtime.NumGoroutine()))
+		},
+	)
+
+	metrics.NewGaugeFunc(
+		"honeytrap_connections_active",
+		"Connections being handled.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(hc.active()))
+		},
+	)
+
+	metrics.NewCounterFunc(
+		"honeytrap_channel_events_total",
+		"Events of a channel, by result: sent, delivered, failed or dropped.",
+		[]string{"channel", "result"},
+		func(emit func(float64, ...string)) {
+			hc.rangeChannels(func(name string, ce *channelEntry) {
+				emit(float64(atomic.LoadInt64(&ce.sent)), name, "sent")
+
+				c, ok := ce.channel.(pushers.Counter)
+				if !ok {
+					return
+				}
+
+				counts := c.Counts()
+
+				emit(float64(counts.Delivered), name, "delivered")
+				emit(float64(counts.Failed), name, "failed")
+				emit(float64(counts.Dropped), name, "dropped")
+			})
+		},
+	)
+
+	metrics.NewGaugeFunc(
+		"honeytrap_channel_queue_depth",
+		"Events queued by a channel.",
+		[]string{"channel"},
+		func(emit func(float64, ...string)) {
+			hc.rangeChannels(func(name string, ce *channelEntry) {
+				if q, ok := ce.channel.(pushers.Queuer); ok {
+					emit(float64(q.QueueLen()), name)
+				}
+			})
+		},
+	)
+
+	metrics.NewGaugeFunc(
+		"honeytrap_channel_spool_bytes",
+		"Size of the events spooled by a channel.",
+		[]string{"channel"},
+		func(emit func(float64, ...string)) {
+			hc.rangeChannels(func(name string, ce *channelEntry) {
+				if s, ok := ce.channel.(*pushers.Spool); ok {
+					emit(float64(s.Stats().Size), name)
+				}
+			})
+		},
+	)
+}
+
+// rangeChannels calls fn for the channels of the running configuration.
+func (hc *Honeytrap) rangeChannels(fn func(string, *channelEntry)) {
+	hc.m.RLock()
+	su := hc.setup
+	hc.m.RUnlock()
+
+	if su == nil {
+		return
+	}
+
+	for name, ce := range su.channels {
+		fn(name, ce)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/metrics"
+)
+
+func TestMetricsFindService(t *testing.T) {
+	hc, _ := newLimitsHoneytrap(t, limitsConfig)
+
+	before := findServiceTotal.Value(OutcomeNoService)
+
+	_, _, err := hc.findService(newLimitsConn("198.51.100.1", 9999))
+	findServiceTotal.Inc(outcome(err))
+
+	if v := findServiceTotal.Value(OutcomeNoService); v != before+1 {
+		t.Errorf("Expected no-service outcome, got %s", outcome(err))
+	}
+
+	if _, _, err := hc.findService(newLimitsConn("198.51.100.1", 8001)); outcome(err) != OutcomeFound {
+		t.Errorf("Expected found outcome, got %s", outcome(err))
+	}
+}
+
+func TestMetricsChannels(t *testing.T) {
+	hc, _ := newLimitsHoneytrap(t, limitsConfig)
+
+	c := &limitsChannel{}
+
+	ce := &channelEntry{channel: c}
+	hc.setup.channels["test"] = ce
+
+	hc.registerMetrics()
+
+	sc := &sentChannel{ce}
+	sc.Send(event.New())
+	sc.Send(event.New())
+
+	buf := &bytes.Buffer{}
+	if err := metrics.Default.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`honeytrap_channel_events_total{channel="test",result="sent"} 2`,
+		"# TYPE honeytrap_goroutines gauge",
+		"# TYPE honeytrap_connections_total counter",
+		"# TYPE honeytrap_session_duration_seconds histogram",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in metrics", line)
+		}
+	}
+}
+
+func TestMetricsChannelFilters(t *testing.T) {
+	hc, _ := newLimitsHoneytrap(t, limitsConfig+`
+[channel.console]
+type="console"
+
+[[filter]]
+channel=["console"]
+
+[[filter]]
+channel=["console"]
+categories=["test"]
+`)
+
+	hc.channels.Send(event.New(event.Category("test")))
+
+	// the event matches both filters of the channel, it is sent once
+	if sent := atomic.LoadInt64(&hc.setup.channels["console"].sent); sent != 1 {
+		t.Errorf("Expected 1 event sent, got %d", sent)
+	}
+}
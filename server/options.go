@@ -21,6 +21,7 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"time"
 
 	_ "net/http/pprof"
 
@@ -47,6 +48,15 @@ func WithCPUProfiler() OptionFn {
 	}
 }
 
+// WithShutdownTimeout sets the time connections and channels are given to
+// finish on shutdown.
+func WithShutdownTimeout(d time.Duration) OptionFn {
+	return func(b *Honeytrap) error {
+		b.shutdownTimeout = d
+		return nil
+	}
+}
+
 func WithConfig(s string) (OptionFn, error) {
 	data, err := ioutil.ReadFile(s)
 	if err != nil {
@@ -54,35 +64,41 @@ func WithConfig(s string) (OptionFn, error) {
 	}
 
 	return func(b *Honeytrap) error {
+		b.configFn = func() ([]byte, error) {
+			return ioutil.ReadFile(s)
+		}
+
 		return b.config.Load(bytes.NewBuffer(data))
 	}, nil
 }
 
 func WithRemoteConfig(s string) (OptionFn, error) {
-	resp, err := http.Get(s)
+	body, err := fetchConfig(s)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
 	return func(b *Honeytrap) error {
+		b.configFn = func() ([]byte, error) {
+			return fetchConfig(s)
+		}
+
 		return b.config.Load(bytes.NewBuffer(body))
 	}, nil
 }
 
-func WithDataDir(s string) (OptionFn, error) {
-	var err error
-
-	p, err := expand(s)
+func fetchConfig(s string) ([]byte, error) {
+	resp, err := http.Get(s)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	p, err = filepath.Abs(p)
+	return ioutil.ReadAll(resp.Body)
+}
+
+func WithDataDir(s string) (OptionFn, error) {
+	p, err := ExpandDataDir(s)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +120,17 @@ func WithDataDir(s string) (OptionFn, error) {
 	}, nil
 }
 
+// ExpandDataDir returns the absolute path of the data directory, expanding
+// the home directory (~).
+func ExpandDataDir(s string) (string, error) {
+	p, err := expand(s)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(p)
+}
+
 func expand(path string) (string, error) {
 	if len(path) == 0 || path[0] != '~' {
 		return path, nil
@@ -0,0 +1,378 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+var (
+	ErrReloadNotSupported = fmt.Errorf("configuration reload not supported, honeytrap has not been started from a configuration source")
+)
+
+// ReloadError is returned when the new configuration is invalid, the running
+// configuration is kept.
+type ReloadError struct {
+	Errors []string
+}
+
+func (re *ReloadError) Error() string {
+	return fmt.Sprintf("invalid configuration, %d error(s): %s", len(re.Errors), strings.Join(re.Errors, "; "))
+}
+
+// ReloadResult describes the changes of a configuration reload.
+type ReloadResult struct {
+	PortsAdded   []string `json:"ports_added"`
+	PortsRemoved []string `json:"ports_removed"`
+
+	ServicesAdded   []string `json:"services_added"`
+	ServicesRemoved []string `json:"services_removed"`
+	ServicesChanged []string `json:"services_changed"`
+
+	ChannelsAdded   []string `json:"channels_added"`
+	ChannelsRemoved []string `json:"channels_removed"`
+	ChannelsChanged []string `json:"channels_changed"`
+
+	DirectorsAdded   []string `json:"directors_added"`
+	DirectorsRemoved []string `json:"directors_removed"`
+	DirectorsChanged []string `json:"directors_changed"`
+}
+
+// Reload reads the configuration again from its source and applies it.
+func (hc *Honeytrap) Reload() (*ReloadResult, error) {
+	if hc.configFn == nil {
+		return nil, ErrReloadNotSupported
+	}
+
+	data, err := hc.configFn()
+	if err != nil {
+		return nil, hc.reloadFailed(err.Error())
+	}
+
+	return hc.ReloadFrom(data)
+}
+
+// ServeReload reloads the configuration from its source, /api/v1/reload. The
+// changes are returned, an invalid configuration is rejected with its errors
+// and the running configuration is kept.
+func (hc *Honeytrap) ServeReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Infof("Reloading configuration, requested by %s", r.RemoteAddr)
+
+	result, err := hc.Reload()
+	if err == ErrReloadNotSupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if re, ok := err.(*ReloadError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		if err := json.NewEncoder(w).Encode(struct {
+			Errors []string `json:"errors"`
+		}{
+			Errors: re.Errors,
+		}); err != nil {
+			log.Errorf("Error encoding reload errors: %s", err.Error())
+		}
+		return
+	} else if err != nil {
+		log.Errorf("Error reloading configuration: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error encoding reload result: %s", err.Error())
+	}
+}
+
+// ReloadFrom applies the giving configuration to the running honeytrap. The
+// configuration is validated as a whole first, when it is invalid nothing
+// is changed and a ReloadError is returned. Ports are added to and removed
+// from the listener, services and channels are only recreated when their
+// configuration changed. Existing connections keep their service instance.
+func (hc *Honeytrap) ReloadFrom(data []byte) (*ReloadResult, error) {
+	hc.reloadM.Lock()
+	defer hc.reloadM.Unlock()
+
+	conf := &config.Config{}
+	if err := conf.Decode(bytes.NewBuffer(data)); err != nil {
+		return nil, hc.reloadFailed(fmt.Sprintf("Error parsing configuration: %s", err.Error()))
+	}
+
+	hc.m.RLock()
+	prev := hc.setup
+	prevConf := hc.config
+	hc.m.RUnlock()
+
+	if prev == nil {
+		return nil, fmt.Errorf("honeytrap is not running")
+	}
+
+	su, errs := hc.build(conf, prev)
+	if errs.Len() > 0 {
+		// the channels initialized for the failed configuration
+		closeReplaced(su, prev)
+		return nil, hc.reloadFailed(errs.errors...)
+	}
+
+	if !reflect.DeepEqual(conf.Listener, prevConf.Listener) {
+		log.Warning("Listener configuration changed, this requires a restart")
+	}
+
+	if err := conf.SetupLogging(); err != nil {
+		closeReplaced(su, prev)
+		return nil, hc.reloadFailed(err.Error())
+	}
+
+	result := diff(prev, su)
+
+	for _, addr := range removedPorts(prev, su) {
+		if a, ok := hc.listener.(listener.RemoveAddresser); ok {
+			a.RemoveAddress(addr)
+		} else {
+			log.Warningf("Listener doesn't support removing port %s/%s, new connections won't be handled", addr.Network(), addr.String())
+		}
+	}
+
+	for _, addr := range removedPorts(su, prev) {
+		a, ok := hc.listener.(listener.AddAddresser)
+		if !ok {
+			log.Error("Listener error")
+			continue
+		}
+
+		a.AddAddress(addr)
+
+		log.Infof("Configured port %s/%s", addr.Network(), addr.String())
+	}
+
+	hc.apply(su)
+
+	closeReplaced(prev, su)
+
+	hc.m.Lock()
+	hc.config = conf
+	hc.m.Unlock()
+
+	hc.bus.Send(event.New(
+		event.Sensor("honeytrap"),
+		event.Category("config-reload"),
+		event.SeverityInfo,
+		event.Custom("config-reload.ports-added", result.PortsAdded),
+		event.Custom("config-reload.ports-removed", result.PortsRemoved),
+		event.Custom("config-reload.services-added", result.ServicesAdded),
+		event.Custom("config-reload.services-removed", result.ServicesRemoved),
+		event.Custom("config-reload.services-changed", result.ServicesChanged),
+		event.Custom("config-reload.channels-added", result.ChannelsAdded),
+		event.Custom("config-reload.channels-removed", result.ChannelsRemoved),
+		event.Custom("config-reload.channels-changed", result.ChannelsChanged),
+		event.Custom("config-reload.directors-added", result.DirectorsAdded),
+		event.Custom("config-reload.directors-removed", result.DirectorsRemoved),
+		event.Custom("config-reload.directors-changed", result.DirectorsChanged),
+	))
+
+	log.Infof("Configuration reloaded: %d port(s) added, %d removed, %d service(s) added, %d removed, %d changed, %d channel(s) added, %d removed, %d changed",
+		len(result.PortsAdded), len(result.PortsRemoved),
+		len(result.ServicesAdded), len(result.ServicesRemoved), len(result.ServicesChanged),
+		len(result.ChannelsAdded), len(result.ChannelsRemoved), len(result.ChannelsChanged),
+	)
+
+	return result, nil
+}
+
+func (hc *Honeytrap) reloadFailed(errors ...string) error {
+	hc.bus.Send(event.New(
+		event.Sensor("honeytrap"),
+		event.Category("config-reload"),
+		event.SeverityError,
+		event.Custom("config-reload.errors", errors),
+	))
+
+	err := &ReloadError{
+		Errors: errors,
+	}
+
+	log.Errorf("Configuration not reloaded: %s", err.Error())
+	return err
+}
+
+// closeProcessors closes the processors of prev which are not used by next.
+func closeProcessors(prev, next *setup) {
+	closers := prev.processors
+	if prev.geoip != nil && (next == nil || prev.geoip != next.geoip) {
+		closers = append(closers, prev.geoip)
+	}
+
+	for _, p := range closers {
+		if c, ok := p.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// closeReplaced closes the channels, services, directors and processors of
+// prev which are not used by next, releasing spools, files, databases and
+// goroutines held by them.
+func closeReplaced(prev, next *setup) {
+	closeProcessors(prev, next)
+
+	for name, ce := range prev.channels {
+		if nce, ok := next.channels[name]; ok && nce == ce {
+			continue
+		}
+
+		go func(name string, c pushers.Channel) {
+			if err := pushers.Close(c); err != nil {
+				log.Errorf("Error closing channel %s: %s", name, err.Error())
+			}
+		}(name, ce.channel)
+	}
+
+	for name, sm := range prev.services {
+		if nsm, ok := next.services[name]; ok && nsm == sm {
+			continue
+		}
+
+		closeAsync("service", name, sm.Service)
+	}
+
+	for name, de := range prev.directors {
+		if nde, ok := next.directors[name]; ok && nde == de {
+			continue
+		}
+
+		closeAsync("director", name, de.director)
+	}
+}
+
+// closeAsync closes v in the background if it implements io.Closer.
+func closeAsync(kind, name string, v interface{}) {
+	c, ok := v.(io.Closer)
+	if !ok {
+		return
+	}
+
+	go func() {
+		if err := c.Close(); err != nil {
+			log.Errorf("Error closing %s %s: %s", kind, name, err.Error())
+		}
+	}()
+}
+
+// removedPorts returns the ports of prev which are not in next.
+func removedPorts(prev, next *setup) []net.Addr {
+	var addrs []net.Addr
+
+	for a := range prev.ports {
+		found := false
+
+		for b := range next.ports {
+			if a.Network() == b.Network() && a.String() == b.String() {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
+
+func diff(prev, next *setup) *ReloadResult {
+	result := &ReloadResult{}
+
+	for _, addr := range removedPorts(prev, next) {
+		result.PortsRemoved = append(result.PortsRemoved, fmt.Sprintf("%s/%s", addr.Network(), addr.String()))
+	}
+
+	for _, addr := range removedPorts(next, prev) {
+		result.PortsAdded = append(result.PortsAdded, fmt.Sprintf("%s/%s", addr.Network(), addr.String()))
+	}
+
+	for name, sm := range next.services {
+		if psm, ok := prev.services[name]; !ok {
+			result.ServicesAdded = append(result.ServicesAdded, name)
+		} else if psm != sm {
+			result.ServicesChanged = append(result.ServicesChanged, name)
+		}
+	}
+
+	for name := range prev.services {
+		if _, ok := next.services[name]; !ok {
+			result.ServicesRemoved = append(result.ServicesRemoved, name)
+		}
+	}
+
+	for name, ce := range next.channels {
+		if pce, ok := prev.channels[name]; !ok {
+			result.ChannelsAdded = append(result.ChannelsAdded, name)
+		} else if pce != ce {
+			result.ChannelsChanged = append(result.ChannelsChanged, name)
+		}
+	}
+
+	for name := range prev.channels {
+		if _, ok := next.channels[name]; !ok {
+			result.ChannelsRemoved = append(result.ChannelsRemoved, name)
+		}
+	}
+
+	for name, de := range next.directors {
+		if pde, ok := prev.directors[name]; !ok {
+			result.DirectorsAdded = append(result.DirectorsAdded, name)
+		} else if pde != de {
+			result.DirectorsChanged = append(result.DirectorsChanged, name)
+		}
+	}
+
+	for name := range prev.directors {
+		if _, ok := next.directors[name]; !ok {
+			result.DirectorsRemoved = append(result.DirectorsRemoved, name)
+		}
+	}
+
+	for _, v := range [][]string{
+		result.PortsAdded, result.PortsRemoved,
+		result.ServicesAdded, result.ServicesRemoved, result.ServicesChanged,
+		result.ChannelsAdded, result.ChannelsRemoved, result.ChannelsChanged,
+		result.DirectorsAdded, result.DirectorsRemoved, result.DirectorsChanged,
+	} {
+		sort.Strings(v)
+	}
+
+	return result
+}
@@ -0,0 +1,402 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type testListener struct {
+	added   []string
+	removed []string
+}
+
+func (l *testListener) Start(ctx context.Context) error { return nil }
+
+func (l *testListener) Accept() (net.Conn, error) { return nil, nil }
+
+func (l *testListener) AddAddress(a net.Addr) {
+	l.added = append(l.added, a.String())
+}
+
+func (l *testListener) RemoveAddress(a net.Addr) {
+	l.removed = append(l.removed, a.String())
+}
+
+const reloadConfig = `
+[service.echo01]
+type="echo"
+
+[service.echo02]
+type="echo"
+
+[[port]]
+ports=["tcp/8001", "tcp/8002"]
+services=["echo01"]
+`
+
+func newReloadHoneytrap(t *testing.T) (*Honeytrap, *testListener) {
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{}
+	if err := conf.Decode(bytes.NewBufferString(reloadConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	su, errs := hc.build(conf, nil)
+	if errs.Len() != 0 {
+		t.Fatal(errs)
+	}
+
+	l := &testListener{}
+
+	hc.config = conf
+	hc.listener = l
+	hc.apply(su)
+	return hc, l
+}
+
+func TestReload(t *testing.T) {
+	hc, l := newReloadHoneytrap(t)
+
+	echo01 := hc.setup.services["echo01"]
+	echo02 := hc.setup.services["echo02"]
+
+	result, err := hc.ReloadFrom([]byte(`
+[service.echo01]
+type="echo"
+
+[service.echo02]
+type="echo"
+director="unused"
+
+[director.unused]
+type="forward"
+
+[[port]]
+ports=["tcp/8002", "tcp/8003"]
+services=["echo01"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result.PortsAdded, []string{"tcp/:8003"}) {
+		t.Errorf("Expected port tcp/:8003 added, got %v", result.PortsAdded)
+	}
+
+	if !reflect.DeepEqual(result.PortsRemoved, []string{"tcp/:8001"}) {
+		t.Errorf("Expected port tcp/:8001 removed, got %v", result.PortsRemoved)
+	}
+
+	if !reflect.DeepEqual(l.added, []string{":8003"}) || !reflect.DeepEqual(l.removed, []string{":8001"}) {
+		t.Errorf("Unexpected listener changes, added %v, removed %v", l.added, l.removed)
+	}
+
+	if hc.setup.services["echo01"] != echo01 {
+		t.Errorf("Expected unchanged service echo01 to be kept")
+	}
+
+	if hc.setup.services["echo02"] == echo02 {
+		t.Errorf("Expected changed service echo02 to be recreated")
+	}
+
+	if !reflect.DeepEqual(result.ServicesChanged, []string{"echo02"}) {
+		t.Errorf("Expected service echo02 changed, got %v", result.ServicesChanged)
+	}
+
+	if len(hc.ports) != 2 {
+		t.Errorf("Expected 2 ports, got %d", len(hc.ports))
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	hc, l := newReloadHoneytrap(t)
+
+	ports := hc.ports
+
+	_, err := hc.ReloadFrom([]byte(`
+[service.echo01]
+type="echo"
+
+[[port]]
+ports=["tcp/8001", "tcp/8004"]
+services=["echo01", "unknown"]
+`))
+
+	re, ok := err.(*ReloadError)
+	if !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+
+	if len(re.Errors) != 2 {
+		t.Errorf("Expected 2 errors, got %v", re.Errors)
+	}
+
+	if len(l.added) != 0 || len(l.removed) != 0 {
+		t.Errorf("Expected no listener changes, added %v, removed %v", l.added, l.removed)
+	}
+
+	if !reflect.DeepEqual(hc.ports, ports) {
+		t.Errorf("Expected running ports to be kept")
+	}
+}
+
+func TestReloadInvalidExpression(t *testing.T) {
+	hc, _ := newReloadHoneytrap(t)
+
+	_, err := hc.ReloadFrom([]byte(`
+[service.echo01]
+type="echo"
+
+[[port]]
+ports=["tcp/8001"]
+services=["echo01"]
+
+[channel.console]
+type="console"
+
+[[filter]]
+channel=["console"]
+
+[[filter]]
+channel=["console"]
+expression='category == "ssh" and'
+`))
+
+	re, ok := err.(*ReloadError)
+	if !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+
+	expected := `Error parsing expression of filter 2 (channel console): column 22: expected field, got end of expression`
+	if len(re.Errors) != 1 || re.Errors[0] != expected {
+		t.Errorf("Expected %q, got %v", expected, re.Errors)
+	}
+}
+
+func TestReloadUnknownProcessor(t *testing.T) {
+	hc, _ := newReloadHoneytrap(t)
+
+	_, err := hc.ReloadFrom([]byte(`
+[service.echo01]
+type="echo"
+
+[[port]]
+ports=["tcp/8001"]
+services=["echo01"]
+
+[channel.console]
+type="console"
+
+[[processor]]
+name="anonymize"
+type="hash"
+fields=["ssh.password"]
+
+[[filter]]
+channel=["console"]
+processor="anonymise"
+`))
+
+	re, ok := err.(*ReloadError)
+	if !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+
+	expected := `Could not find processor anonymise for filter 1`
+	if len(re.Errors) != 1 || re.Errors[0] != expected {
+		t.Errorf("Expected %q, got %v", expected, re.Errors)
+	}
+}
+
+func TestReloadInvalidLogging(t *testing.T) {
+	hc, l := newReloadHoneytrap(t)
+
+	conf := hc.config
+	ports := hc.ports
+
+	_, err := hc.ReloadFrom([]byte(`
+[service.echo01]
+type="echo"
+
+[[port]]
+ports=["tcp/8001", "tcp/8004"]
+services=["echo01"]
+
+[[logging]]
+output = "stdout"
+level = "unknown"
+`))
+
+	if _, ok := err.(*ReloadError); !ok {
+		t.Fatalf("Expected a ReloadError, got %v", err)
+	}
+
+	if len(l.added) != 0 || len(l.removed) != 0 {
+		t.Errorf("Expected no listener changes, added %v, removed %v", l.added, l.removed)
+	}
+
+	if hc.config != conf || !reflect.DeepEqual(hc.ports, ports) {
+		t.Errorf("Expected running configuration to be kept")
+	}
+}
+
+// closer is a service and director which records that it has been closed.
+type closer struct {
+	closed chan struct{}
+}
+
+func newCloser() *closer {
+	return &closer{closed: make(chan struct{})}
+}
+
+func (c *closer) Handle(context.Context, net.Conn) error { return nil }
+
+func (c *closer) SetChannel(pushers.Channel) {}
+
+func (c *closer) Dial(net.Conn) (net.Conn, error) { return nil, nil }
+
+func (c *closer) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestReloadClosesReplaced(t *testing.T) {
+	kept, replaced := newCloser(), newCloser()
+	keptDirector, replacedDirector := newCloser(), newCloser()
+
+	keptService := &ServiceMap{Service: kept}
+	keptEntry := &directorEntry{director: keptDirector}
+
+	prev := &setup{
+		services: map[string]*ServiceMap{
+			"kept":     keptService,
+			"replaced": {Service: replaced},
+		},
+		directors: map[string]*directorEntry{
+			"kept":     keptEntry,
+			"replaced": {director: replacedDirector},
+		},
+	}
+
+	next := &setup{
+		services: map[string]*ServiceMap{
+			"kept":     keptService,
+			"replaced": {Service: newCloser()},
+		},
+		directors: map[string]*directorEntry{
+			"kept": keptEntry,
+		},
+	}
+
+	closeReplaced(prev, next)
+
+	for name, c := range map[string]*closer{"service": replaced, "director": replacedDirector} {
+		select {
+		case <-c.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected replaced %s to be closed", name)
+		}
+	}
+
+	for name, c := range map[string]*closer{"service": kept, "director": keptDirector} {
+		select {
+		case <-c.closed:
+			t.Errorf("Expected kept %s not to be closed", name)
+		default:
+		}
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	hc, _ := newReloadHoneytrap(t)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		hc.ServeReload(w, httptest.NewRequest(method, "/api/v1/reload", nil))
+		return w
+	}
+
+	if w := serve(http.MethodPost); w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d without configuration source, got %d", http.StatusNotImplemented, w.Code)
+	}
+
+	conf := reloadConfig + `
+[service.echo03]
+type="echo"
+`
+
+	hc.configFn = func() ([]byte, error) {
+		return []byte(conf), nil
+	}
+
+	if w := serve(http.MethodGet); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w := serve(http.MethodPost)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	result := ReloadResult{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result.ServicesAdded, []string{"echo03"}) {
+		t.Errorf("Expected service echo03 added, got %v", result.ServicesAdded)
+	}
+
+	conf = `
+[service.echo01]
+type="unknown"
+`
+
+	w = serve(http.MethodPost)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	response := struct {
+		Errors []string `json:"errors"`
+	}{}
+
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Errors) == 0 {
+		t.Errorf("Expected the configuration errors")
+	}
+
+	if _, ok := hc.setup.services["echo03"]; !ok {
+		t.Errorf("Expected running configuration to be kept")
+	}
+}
@@ -0,0 +1,635 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/processors"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+// setup contains the channels, directors, services and ports built from a
+// configuration. Instances of which the configuration didn't change are
+// carried over from the previous setup on reload.
+type setup struct {
+	channels  map[string]*channelEntry
+	directors map[string]*directorEntry
+	services  map[string]*ServiceMap
+
+	// the filtered channels subscribed to the bus
+	filters []pushers.Channel
+
+	ports map[net.Addr][]*ServiceMap
+
+	// tls contains the tls configuration of the ports with implicit tls
+	tls map[net.Addr]*tls.Config
+
+	// limits contains the global limits, portLimits the limits per port
+	limits     *limiter
+	portLimits map[net.Addr]*limiter
+
+	// geoip enriches the events before they are sent to the channels
+	geoip          processors.Processor
+	geoipPrimitive toml.Primitive
+
+	// processors contains the processors of the chains, which are closed
+	// when the setup is replaced
+	processors []processors.Processor
+}
+
+type channelEntry struct {
+	// sent is the number of events sent to the channel, it is the first
+	// field for alignment of the atomic operations
+	sent int64
+
+	channel   pushers.Channel
+	primitive toml.Primitive
+}
+
+type directorEntry struct {
+	director  director.Director
+	primitive toml.Primitive
+}
+
+// configErrors collects the errors found while building a setup.
+type configErrors struct {
+	errors []string
+
+	// fatal is set when a channel or director failed to initialize
+	fatal bool
+}
+
+func (ce *configErrors) Errorf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Error(msg)
+
+	ce.errors = append(ce.errors, msg)
+}
+
+func (ce *configErrors) Fatalf(format string, a ...interface{}) {
+	ce.Errorf(format, a...)
+	ce.fatal = true
+}
+
+func (ce *configErrors) Len() int {
+	return len(ce.errors)
+}
+
+func (ce *configErrors) Error() string {
+	return strings.Join(ce.errors, "; ")
+}
+
+// channelGroup delivers events to the filtered channels of the running
+// configuration. It is subscribed to the bus once, the channels are swapped
+// on reload.
+type channelGroup struct {
+	m sync.RWMutex
+
+	channels []pushers.Channel
+
+	// enrich processes the events before they are sent to the channels
+	enrich processors.Processor
+
+	// subscribers receive all enriched events, independent of the
+	// filters
+	subscribers []pushers.Channel
+}
+
+// Subscribe adds a channel receiving all events after enrichment.
+func (cg *channelGroup) Subscribe(c pushers.Channel) {
+	cg.m.Lock()
+	defer cg.m.Unlock()
+
+	cg.subscribers = append(cg.subscribers, c)
+}
+
+func (cg *channelGroup) Set(channels []pushers.Channel, enrich processors.Processor) {
+	cg.m.Lock()
+	defer cg.m.Unlock()
+
+	cg.channels = channels
+	cg.enrich = enrich
+}
+
+func (cg *channelGroup) Send(e event.Event) {
+	cg.m.RLock()
+	channels := cg.channels
+	enrich := cg.enrich
+	subscribers := cg.subscribers
+	cg.m.RUnlock()
+
+	if enrich != nil {
+		enrich.Process(e)
+	}
+
+	eventsTotal.Inc(e.Get("category"))
+
+	for _, channel := range channels {
+		channel.Send(e)
+	}
+
+	for _, channel := range subscribers {
+		channel.Send(e)
+	}
+}
+
+// filter is a filter block of the configuration.
+type filter struct {
+	funcs []pushers.FilterFunc
+
+	// chain processes the events matching the filter
+	chain processors.Chain
+}
+
+func (f filter) match(e event.Event) bool {
+	for _, fn := range f.funcs {
+		if !fn(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filteredChannel sends the events matching one of the filters of a
+// channel. An event matching multiple filters is sent once, processed by
+// the chain of the first filter matching.
+type filteredChannel struct {
+	filters []filter
+
+	channel pushers.Channel
+}
+
+func (fc *filteredChannel) Send(e event.Event) {
+	for _, f := range fc.filters {
+		if !f.match(e) {
+			continue
+		}
+
+		if len(f.chain) != 0 {
+			e = f.chain.Process(e)
+		}
+
+		fc.channel.Send(e)
+		return
+	}
+}
+
+// build initializes the configuration, reusing the instances of prev where
+// the configuration is unchanged. Errors are logged and collected, the
+// faulty parts of the configuration are skipped.
+func (hc *Honeytrap) build(conf *config.Config, prev *setup) (*setup, *configErrors) {
+	errs := &configErrors{}
+
+	if prev == nil {
+		prev = &setup{}
+	}
+
+	su := &setup{
+		channels:   map[string]*channelEntry{},
+		directors:  map[string]*directorEntry{},
+		services:   map[string]*ServiceMap{},
+		ports:      map[net.Addr][]*ServiceMap{},
+		tls:        map[net.Addr]*tls.Config{},
+		portLimits: map[net.Addr]*limiter{},
+	}
+
+	limits := Limits{}
+
+	if err := conf.PrimitiveDecode(conf.Limits, &limits); err != nil {
+		errs.Errorf("Error parsing configuration of limits: %s", err.Error())
+	} else if err := limits.validate(); err != nil {
+		errs.Errorf("Error parsing configuration of limits: %s", err.Error())
+	} else if su.limits = newLimiter("", limits); prev.limits != nil && reflect.DeepEqual(prev.limits.Limits, su.limits.Limits) {
+		// keep the state of unchanged limits
+		su.limits = prev.limits
+	}
+
+	if !conf.IsDefined("geoip") {
+	} else if prev.geoip != nil && reflect.DeepEqual(prev.geoipPrimitive, conf.GeoIP) {
+		su.geoip, su.geoipPrimitive = prev.geoip, prev.geoipPrimitive
+	} else if p, err := processors.GeoIP(
+		processors.WithConfig(conf.GeoIP, conf),
+	); err != nil {
+		errs.Errorf("Error initializing geoip: %s", err.Error())
+	} else {
+		su.geoip, su.geoipPrimitive = p, conf.GeoIP
+	}
+
+	isChannelUsed := make(map[string]bool)
+	// sane defaults!
+
+	for key, s := range conf.Channels {
+		if ce, ok := prev.channels[key]; ok && reflect.DeepEqual(ce.primitive, s) {
+			su.channels[key] = ce
+			isChannelUsed[key] = false
+			continue
+		}
+
+		x := struct {
+			Type  string               `toml:"type"`
+			Spool *pushers.SpoolConfig `toml:"spool"`
+		}{}
+
+		err := conf.PrimitiveDecode(s, &x)
+		if err != nil {
+			errs.Errorf("Error parsing configuration of channel: %s", err.Error())
+			continue
+		}
+
+		if x.Type == "" {
+			errs.Errorf("Error parsing configuration of channel %s: type not set", key)
+			continue
+		}
+
+		if channelFunc, ok := pushers.Get(x.Type); !ok {
+			errs.Errorf("Channel %s not supported on platform (%s)", x.Type, key)
+		} else if d, err := channelFunc(
+			pushers.WithConfig(s, conf),
+		); err != nil {
+			errs.Fatalf("Error initializing channel %s(%s): %s", key, x.Type, err)
+		} else if x.Spool == nil {
+			su.channels[key] = &channelEntry{
+				channel:   d,
+				primitive: s,
+			}
+			isChannelUsed[key] = false
+		} else if sd, err := pushers.NewSpool(key, d, *x.Spool, hc.bus); err != nil {
+			errs.Fatalf("Error initializing spool of channel %s(%s): %s", key, x.Type, err)
+		} else {
+			su.channels[key] = &channelEntry{
+				channel:   sd,
+				primitive: s,
+			}
+			isChannelUsed[key] = false
+		}
+	}
+
+	// processor chains by name, the processors are appended in order
+	chains := map[string]processors.Chain{}
+
+	for i, s := range conf.Processors {
+		x := struct {
+			Name string `toml:"name"`
+			Type string `toml:"type"`
+		}{}
+
+		if err := conf.PrimitiveDecode(s, &x); err != nil {
+			errs.Errorf("Error parsing configuration of processor %d: %s", i+1, err.Error())
+			continue
+		}
+
+		if x.Name == "" {
+			errs.Errorf("Error parsing configuration of processor %d: name not set", i+1)
+			continue
+		}
+
+		if x.Type == "" {
+			errs.Errorf("Error parsing configuration of processor %d (%s): type not set", i+1, x.Name)
+			continue
+		}
+
+		if processorFunc, ok := processors.Get(x.Type); !ok {
+			errs.Errorf("Processor %s not supported (processor %d, %s)", x.Type, i+1, x.Name)
+		} else if p, err := processorFunc(
+			processors.WithConfig(s, conf),
+		); err != nil {
+			errs.Errorf("Error initializing processor %d (%s): %s", i+1, x.Name, err.Error())
+		} else {
+			chains[x.Name] = append(chains[x.Name], p)
+
+			su.processors = append(su.processors, p)
+		}
+	}
+
+	// filtered contains the filters of the channels by name, the channels
+	// are wrapped once so events are sent and counted once per channel
+	filtered := map[string]*filteredChannel{}
+
+	for i, s := range conf.Filters {
+		x := struct {
+			Channels   []string `toml:"channel"`
+			Services   []string `toml:"services"`
+			Categories []string `toml:"categories"`
+			Expression string   `toml:"expression"`
+			Processor  string   `toml:"processor"`
+		}{}
+
+		err := conf.PrimitiveDecode(s, &x)
+		if err != nil {
+			errs.Errorf("Error parsing configuration of filter: %s", err.Error())
+			continue
+		}
+
+		chain, ok := chains[x.Processor]
+		if x.Processor != "" && !ok {
+			errs.Errorf("Could not find processor %s for filter %d", x.Processor, i+1)
+			continue
+		}
+
+		f := filter{
+			chain: chain,
+		}
+
+		if len(x.Categories) != 0 {
+			f.funcs = append(f.funcs, pushers.RegexFilterFunc("category", x.Categories))
+		}
+
+		if len(x.Services) != 0 {
+			f.funcs = append(f.funcs, pushers.RegexFilterFunc("service", x.Services))
+		}
+
+		if x.Expression == "" {
+		} else if expression, err := pushers.ExpressionFilterFunc(x.Expression); err != nil {
+			errs.Errorf("Error parsing expression of filter %d (channel %s): %s", i+1, strings.Join(x.Channels, ", "), err.Error())
+			continue
+		} else {
+			f.funcs = append(f.funcs, expression)
+		}
+
+		for _, name := range x.Channels {
+			ce, ok := su.channels[name]
+			if !ok {
+				errs.Errorf("Could not find channel %s for filter", name)
+				continue
+			}
+
+			isChannelUsed[name] = true
+
+			fc, ok := filtered[name]
+			if !ok {
+				fc = &filteredChannel{
+					channel: pushers.TokenChannel(&sentChannel{ce}, hc.token),
+				}
+
+				filtered[name] = fc
+				su.filters = append(su.filters, fc)
+			}
+
+			fc.filters = append(fc.filters, f)
+		}
+	}
+
+	for name, isUsed := range isChannelUsed {
+		if !isUsed {
+			log.Warningf("Channel %s is unused. Did you forget to add a filter?", name)
+		}
+	}
+
+	// initialize directors
+	availableDirectorNames := director.GetAvailableDirectorNames()
+
+	for key, s := range conf.Directors {
+		if de, ok := prev.directors[key]; ok && reflect.DeepEqual(de.primitive, s) {
+			su.directors[key] = de
+			continue
+		}
+
+		x := struct {
+			Type string `toml:"type"`
+		}{}
+
+		err := conf.PrimitiveDecode(s, &x)
+		if err != nil {
+			errs.Errorf("Error parsing configuration of director: %s", err.Error())
+			continue
+		}
+
+		if x.Type == "" {
+			errs.Errorf("Error parsing configuration of service %s: type not set", key)
+			continue
+		}
+
+		if directorFunc, ok := director.Get(x.Type); !ok {
+			errs.Errorf("Director type=%s not supported on platform (director=%s). Available directors: %s", x.Type, key, strings.Join(availableDirectorNames, ", "))
+		} else if d, err := directorFunc(
+			director.WithChannel(hc.bus),
+			director.WithConfig(s, conf),
+		); err != nil {
+			errs.Fatalf("Error initializing director %s(%s): %s", key, x.Type, err)
+		} else {
+			su.directors[key] = &directorEntry{
+				director:  d,
+				primitive: s,
+			}
+		}
+	}
+
+	var enabledDirectorNames []string
+	for key := range su.directors {
+		enabledDirectorNames = append(enabledDirectorNames, key)
+	}
+
+	isServiceUsed := make(map[string]bool) // Used to check that every service is used by a port
+	// same for proxies
+	for key, s := range conf.Services {
+		x := struct {
+			Type     string `toml:"type"`
+			Director string `toml:"director"`
+			Port     string `toml:"port"`
+		}{}
+
+		if err := conf.PrimitiveDecode(s, &x); err != nil {
+			errs.Errorf("Error parsing configuration of service %s: %s", key, err.Error())
+			continue
+		}
+
+		if x.Port != "" {
+			errs.Errorf("Ports in services are deprecated, add services to ports instead")
+			continue
+		}
+
+		var d director.Director
+
+		if x.Director == "" {
+		} else if de, ok := su.directors[x.Director]; ok {
+			d = de.director
+		} else {
+			errs.Errorf("%s", color.RedString("Could not find director=%s for service=%s. Enabled directors: %s", x.Director, key, strings.Join(enabledDirectorNames, ", ")))
+			continue
+		}
+
+		isServiceUsed[key] = false
+
+		if sm, ok := prev.services[key]; ok && sm.director == d && reflect.DeepEqual(sm.primitive, s) {
+			su.services[key] = sm
+			continue
+		}
+
+		// individual configuration per service
+		options := []services.ServicerFunc{
+			services.WithChannel(hc.bus),
+			services.WithConfig(s, conf),
+		}
+
+		if d != nil {
+			options = append(options, services.WithDirector(d))
+		}
+
+		fn, ok := services.Get(x.Type)
+		if !ok {
+			errs.Errorf("%s", color.RedString("Could not find type %s for service %s", x.Type, key))
+			delete(isServiceUsed, key)
+			continue
+		}
+
+		service := fn(options...)
+		su.services[key] = &ServiceMap{
+			Service:   service,
+			Name:      key,
+			Type:      x.Type,
+			primitive: s,
+			director:  d,
+		}
+		log.Infof("Configured service %s (%s)", x.Type, key)
+	}
+
+	for _, s := range conf.Ports {
+		x := struct {
+			Port     string   `toml:"port"`
+			Ports    []string `toml:"ports"`
+			Services []string `toml:"services"`
+			Limits   *Limits  `toml:"limits"`
+
+			// TLS terminates tls before the connection is handled by
+			// the services, the certificates are issued with the
+			// subject of Certificate
+			TLS         bool                 `toml:"tls"`
+			Certificate certificates.Subject `toml:"certificate"`
+		}{}
+
+		if err := conf.PrimitiveDecode(s, &x); err != nil {
+			errs.Errorf("Error parsing configuration of generic ports: %s", err.Error())
+			continue
+		}
+
+		if x.Limits == nil {
+		} else if err := x.Limits.validate(); err != nil {
+			errs.Errorf("Error parsing configuration of port limits: %s", err.Error())
+			continue
+		}
+
+		var ports []string
+		if x.Ports != nil {
+			ports = x.Ports
+		}
+		if x.Port != "" {
+			ports = append(ports, x.Port)
+		}
+		if x.Port != "" && x.Ports != nil {
+			log.Warning("Both \"port\" and \"ports\" were defined, this can be confusing")
+		} else if x.Port == "" && x.Ports == nil {
+			errs.Errorf("Neither \"port\" nor \"ports\" were defined")
+			continue
+		}
+
+		if len(x.Services) == 0 {
+			log.Warning("No services defined for port(s) " + strings.Join(ports, ", "))
+		}
+
+		for _, portStr := range ports {
+			addr, _, _, err := ToAddr(portStr)
+			if err != nil {
+				errs.Errorf("Error parsing port string: %s", err.Error())
+				continue
+			}
+			if addr == nil {
+				errs.Errorf("Failed to bind: addr is nil")
+				continue
+			}
+
+			// Get the services from their names
+			var servicePtrs []*ServiceMap
+			for _, serviceName := range x.Services {
+				ptr, ok := su.services[serviceName]
+				if !ok {
+					errs.Errorf("Unknown service '%s' for port %s", serviceName, portStr)
+					continue
+				}
+				servicePtrs = append(servicePtrs, ptr)
+				isServiceUsed[serviceName] = true
+			}
+			if len(servicePtrs) == 0 {
+				errs.Errorf("Port %s has no valid services, it won't be listened on", portStr)
+				continue
+			}
+
+			found := false
+			for k := range su.ports {
+				if !compareAddr(k, addr) {
+					continue
+				}
+
+				found = true
+			}
+
+			if found {
+				errs.Errorf("Port %s was already defined, ignoring the newer definition", portStr)
+				continue
+			}
+
+			su.ports[addr] = servicePtrs
+
+			if x.TLS {
+				su.tls[addr] = portTLSConfig(x.Certificate)
+			}
+
+			if x.Limits == nil {
+				continue
+			}
+
+			su.portLimits[addr] = newLimiter(portStr, *x.Limits)
+
+			// keep the state of unchanged limits
+			for paddr, l := range prev.portLimits {
+				if compareAddr(paddr, addr) && reflect.DeepEqual(l.Limits, su.portLimits[addr].Limits) {
+					su.portLimits[addr] = l
+				}
+			}
+		}
+	}
+
+	for name, isUsed := range isServiceUsed {
+		if !isUsed {
+			log.Warningf("Service %s is defined but not used", name)
+		}
+	}
+
+	return su, errs
+}
+
+// apply makes the setup the running one. Connections that are being handled
+// keep using the service instance they were assigned to.
+func (hc *Honeytrap) apply(su *setup) {
+	hc.m.Lock()
+	defer hc.m.Unlock()
+
+	hc.setup = su
+	hc.ports = su.ports
+
+	hc.channels.Set(su.filters, su.geoip)
+}
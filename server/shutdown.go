@@ -0,0 +1,133 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// DefaultShutdownTimeout is the time connections are given to finish on
+// shutdown, and the time channels are given to deliver their events.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// track registers a connection being handled, it returns false when
+// honeytrap is shutting down.
+func (hc *Honeytrap) track(conn net.Conn) bool {
+	hc.connsM.Lock()
+	defer hc.connsM.Unlock()
+
+	if hc.stopping {
+		return false
+	}
+
+	hc.conns[conn] = struct{}{}
+	return true
+}
+
+func (hc *Honeytrap) untrack(conn net.Conn) {
+	hc.connsM.Lock()
+	defer hc.connsM.Unlock()
+
+	delete(hc.conns, conn)
+}
+
+func (hc *Honeytrap) active() int {
+	hc.connsM.Lock()
+	defer hc.connsM.Unlock()
+
+	return len(hc.conns)
+}
+
+// Shutdown stops accepting connections and cancels the context of the
+// connections being handled. The connections are given the timeout to
+// finish, after which they are closed. Then the channels are flushed and
+// closed, again bounded by the timeout.
+func (hc *Honeytrap) Shutdown(timeout time.Duration) {
+	hc.m.RLock()
+	su := hc.setup
+	hc.m.RUnlock()
+
+	if su == nil {
+		hc.cancel()
+		return
+	}
+
+	if a, ok := hc.listener.(listener.RemoveAddresser); ok {
+		for addr := range su.ports {
+			a.RemoveAddress(addr)
+		}
+	}
+
+	hc.connsM.Lock()
+	hc.stopping = true
+	hc.connsM.Unlock()
+
+	hc.cancel()
+
+	deadline := time.Now().Add(timeout)
+
+	for hc.active() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	hc.connsM.Lock()
+	if n := len(hc.conns); n > 0 {
+		log.Warningf("Closing %d connection(s) which didn't finish in %s", n, timeout)
+	}
+
+	for conn := range hc.conns {
+		conn.Close()
+	}
+	hc.connsM.Unlock()
+
+	hc.closeChannels(su, timeout)
+
+	closeProcessors(su, nil)
+}
+
+// closeChannels flushes and closes the channels of the setup in parallel,
+// it waits at most timeout.
+func (hc *Honeytrap) closeChannels(su *setup, timeout time.Duration) {
+	wg := sync.WaitGroup{}
+
+	for name, ce := range su.channels {
+		wg.Add(1)
+
+		go func(name string, c pushers.Channel) {
+			defer wg.Done()
+
+			if err := pushers.Close(c); err != nil {
+				log.Errorf("Error closing channel %s: %s", name, err.Error())
+			}
+		}(name, ce.channel)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Errorf("Channels not closed within %s, events may be lost", timeout)
+	}
+}
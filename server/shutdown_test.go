@@ -0,0 +1,99 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type flushChannel struct {
+	m sync.Mutex
+
+	flushed bool
+	closed  bool
+}
+
+func (c *flushChannel) Send(e event.Event) {}
+
+func (c *flushChannel) Flush() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.flushed = true
+	return nil
+}
+
+func (c *flushChannel) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.closed = c.flushed
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	hc, l := newReloadHoneytrap(t)
+
+	c := &flushChannel{}
+	hc.setup.channels["test"] = &channelEntry{channel: c}
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+
+	// the echo service blocks reading the connection
+	go func() {
+		hc.handle(&limitsConn{
+			Conn:   server,
+			local:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8001},
+			remote: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40000},
+		})
+
+		close(done)
+	}()
+
+	for hc.active() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hc.Shutdown(100 * time.Millisecond)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to be closed")
+	}
+
+	if hc.ctx.Err() == nil {
+		t.Errorf("Expected the connection context to be cancelled")
+	}
+
+	if len(l.removed) != 2 {
+		t.Errorf("Expected the ports to be removed, got %v", l.removed)
+	}
+
+	if !c.flushed || !c.closed {
+		t.Errorf("Expected the channel to be flushed and closed")
+	}
+
+	if hc.track(client) {
+		t.Errorf("Expected new connections to be refused")
+	}
+}
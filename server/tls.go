@@ -0,0 +1,65 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/event"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+	"github.com/honeytrap/honeytrap/services/tlshello"
+)
+
+// portTLSConfig returns the tls configuration of a port with implicit tls,
+// the certificates are issued with the subject of the port.
+func portTLSConfig(subject certificates.Subject) *tls.Config {
+	return &tls.Config{
+		GetCertificate: tlshello.GetCertificate(certificates.Default(), subject),
+	}
+}
+
+// tlsConfig returns the tls configuration of the port of addr, nil when the
+// port has no implicit tls.
+func (hc *Honeytrap) tlsConfig(addr net.Addr) *tls.Config {
+	hc.m.RLock()
+	defer hc.m.RUnlock()
+
+	if hc.setup == nil {
+		return nil
+	}
+
+	for k, config := range hc.setup.tls {
+		if compareAddr(k, addr) {
+			return config
+		}
+	}
+
+	return nil
+}
+
+// terminateTLS terminates tls on conn, before the service is found. The
+// fingerprints of the ClientHello are added to the options of the
+// connection.
+func (hc *Honeytrap) terminateTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn, hello, err := tlshello.Server(hc.bus, TimeoutConn(conn, time.Second*30), config, "tls",
+		event.Category("tls"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.WithConn(tlsConn, hello), nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "honeytrap-server")
+	if err != nil {
+		panic(err)
+	}
+
+	// the local ca is persisted in the storage
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type tlsChannel struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+func (c *tlsChannel) Send(e event.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.events = append(c.events, e)
+}
+
+func TestImplicitTLS(t *testing.T) {
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{}
+	if err := conf.Decode(bytes.NewBufferString(`
+[service.echo]
+type="echo"
+
+[[port]]
+port="tcp/8465"
+services=["echo"]
+tls=true
+
+[port.certificate]
+organization=["Example"]
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	su, errs := hc.build(conf, nil)
+	if errs.Len() != 0 {
+		t.Fatal(errs)
+	}
+
+	hc.apply(su)
+
+	c := &tlsChannel{}
+	hc.bus.Subscribe(c)
+
+	ca, err := certificates.Default().CA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	server, client := net.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		hc.handle(&limitsConn{
+			Conn:   server,
+			local:  &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8465},
+			remote: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40000},
+		})
+
+		close(done)
+	}()
+
+	// the certificate is issued by the local ca for the server name
+	conn := tls.Client(client, &tls.Config{
+		ServerName: "mail.example.com",
+		RootCAs:    roots,
+		MaxVersion: tls.VersionTLS12,
+	})
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "hello" {
+		t.Errorf("Expected echo, got %q", buf)
+	}
+
+	state := conn.ConnectionState()
+	if v := state.PeerCertificates[0].Subject.Organization; len(v) != 1 || v[0] != "Example" {
+		t.Errorf("Unexpected organization %v", v)
+	}
+
+	client.Close()
+	<-done
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if len(c.events) == 0 || c.events[0].Get("type") != "tls-hello" {
+		t.Fatalf("Expected tls-hello event")
+	}
+
+	if v := c.events[0].Get("tls.server-name"); v != "mail.example.com" {
+		t.Errorf("Unexpected server name %q", v)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package asciicast records interactive sessions in the asciicast v2 format,
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md.
+// Recordings are stored in the recordings directory of the data directory
+// and named after the session id.
+package asciicast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/asciicast")
+
+// Event types
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+	EventMarker = "m"
+)
+
+// DefaultMaxSize is the default maximum size of a recording.
+const DefaultMaxSize = 16 * 1024 * 1024
+
+// truncatedMarker is the label of the marker written when a recording
+// reaches its maximum size.
+const truncatedMarker = "truncated"
+
+var (
+	ErrInvalidID = fmt.Errorf("invalid session id")
+)
+
+// id matches session ids (xid), optionally suffixed with the sequence number
+// of the recording within the session.
+var id = regexp.MustCompile(`^[0-9a-v]{20}(-[0-9]+)?$`)
+
+// Header is the first line of a recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Dir returns the directory recordings are stored in.
+func Dir(dataDir string) string {
+	return filepath.Join(dataDir, "recordings")
+}
+
+// Path returns the path of the recording with the id.
+func Path(dataDir, s string) (string, error) {
+	if !id.MatchString(s) {
+		return "", ErrInvalidID
+	}
+
+	return filepath.Join(Dir(dataDir), s+".cast"), nil
+}
+
+// Open opens the recording with the id, which is the session id or the
+// session id followed by the sequence number, eg. <id>-1.
+func Open(dataDir, id string) (*os.File, error) {
+	p, err := Path(dataDir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+// Option defines a function for configuring a Recorder.
+type Option func(*Recorder)
+
+// WithSize sets the initial terminal size.
+func WithSize(width, height int) Option {
+	return func(r *Recorder) {
+		r.header.Width = width
+		r.header.Height = height
+	}
+}
+
+// WithMaxSize sets the maximum size of the recording in bytes, the recording
+// stops with a truncated marker when the size is reached.
+func WithMaxSize(n int64) Option {
+	return func(r *Recorder) {
+		r.maxSize = n
+	}
+}
+
+// WithTitle sets the title of the recording.
+func WithTitle(title string) Option {
+	return func(r *Recorder) {
+		r.header.Title = title
+	}
+}
+
+// Recorder records a session. The recording is created when the first data
+// is recorded, so sessions without any data don't leave empty recordings.
+type Recorder struct {
+	dataDir string
+	id      string
+
+	m sync.Mutex
+
+	header Header
+
+	f     io.WriteCloser
+	start time.Time
+
+	// size is the number of bytes written to the recording
+	size    int64
+	maxSize int64
+
+	err       error
+	closed    bool
+	truncated bool
+}
+
+// New returns a recorder for the session, storing the recording in the data
+// directory.
+func New(dataDir, id string, options ...Option) *Recorder {
+	r := &Recorder{
+		dataDir: dataDir,
+		id:      id,
+		header: Header{
+			Version: 2,
+			Width:   80,
+			Height:  24,
+		},
+		maxSize: DefaultMaxSize,
+	}
+
+	for _, fn := range options {
+		fn(r)
+	}
+
+	return r
+}
+
+// Input records data sent by the attacker.
+func (r *Recorder) Input(p []byte) {
+	r.record(EventInput, string(p))
+}
+
+// Output records data sent to the attacker.
+func (r *Recorder) Output(p []byte) {
+	r.record(EventOutput, string(p))
+}
+
+// Resize records a change of the terminal size, the size is stored in the
+// header if the recording hasn't been started yet.
+func (r *Recorder) Resize(width, height int) {
+	r.m.Lock()
+	if r.f == nil {
+		r.header.Width, r.header.Height = width, height
+		r.m.Unlock()
+		return
+	}
+	r.m.Unlock()
+
+	r.record(EventResize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// SetEnv adds an environment variable to the header, variables set after
+// the recording has been started are ignored.
+func (r *Recorder) SetEnv(name, value string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.header.Env == nil {
+		r.header.Env = map[string]string{}
+	}
+
+	r.header.Env[name] = value
+}
+
+// Truncated returns true when the recording reached its maximum size.
+func (r *Recorder) Truncated() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.truncated
+}
+
+// Close closes the recording.
+func (r *Recorder) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.closed = true
+
+	if r.f == nil {
+		return nil
+	}
+
+	return r.f.Close()
+}
+
+func (r *Recorder) record(typ string, data string) {
+	if len(data) == 0 {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.err != nil || r.closed || r.truncated {
+		return
+	}
+
+	if r.f == nil {
+		if r.err = r.create(); r.err != nil {
+			log.Errorf("Error creating recording for session %s: %s", r.id, r.err.Error())
+			return
+		}
+	}
+
+	line, err := r.event(typ, data)
+	if err != nil {
+		log.Errorf("Error encoding event: %s", err.Error())
+		return
+	}
+
+	// the marker is written instead of the event exceeding the maximum
+	// size, it is small enough to always fit
+	if r.size+int64(len(line)) > r.maxSize {
+		r.truncated = true
+
+		if line, err = r.event(EventMarker, truncatedMarker); err != nil {
+			log.Errorf("Error encoding event: %s", err.Error())
+			return
+		}
+	}
+
+	r.write(line)
+}
+
+// event returns the line of an event.
+func (r *Recorder) event(typ string, data string) ([]byte, error) {
+	line, err := json.Marshal([]interface{}{
+		time.Since(r.start).Seconds(),
+		typ,
+		data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+func (r *Recorder) write(line []byte) {
+	var n int
+	n, r.err = r.f.Write(line)
+	r.size += int64(n)
+
+	if r.err != nil {
+		log.Errorf("Error writing recording for session %s: %s", r.id, r.err.Error())
+	}
+}
+
+// create creates the recording and writes the header. A session can have
+// multiple recordings (eg. multiple ssh channels), these are suffixed with a
+// sequence number.
+func (r *Recorder) create() error {
+	dir := Dir(r.dataDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		name := r.id
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", r.id, i)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, name+".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		r.f = f
+		break
+	}
+
+	r.start = time.Now()
+	r.header.Timestamp = r.start.Unix()
+
+	data, err := json.Marshal(r.header)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.f.Write(append(data, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// Wrap returns a ReadWriteCloser recording the data read as input and the
+// data written as output.
+func (r *Recorder) Wrap(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	return &readWriteCloser{
+		ReadWriteCloser: rwc,
+		r:               r,
+	}
+}
+
+type readWriteCloser struct {
+	io.ReadWriteCloser
+
+	r *Recorder
+}
+
+func (rwc *readWriteCloser) Read(p []byte) (int, error) {
+	n, err := rwc.ReadWriteCloser.Read(p)
+	rwc.r.Input(p[:n])
+	return n, err
+}
+
+func (rwc *readWriteCloser) Write(p []byte) (int, error) {
+	n, err := rwc.ReadWriteCloser.Write(p)
+	rwc.r.Output(p[:n])
+	return n, err
+}
+
+// WrapConn returns a connection recording the data read as input and the
+// data written as output.
+func (r *Recorder) WrapConn(conn net.Conn) net.Conn {
+	return &recordedConn{
+		Conn: conn,
+		r:    r,
+	}
+}
+
+type recordedConn struct {
+	net.Conn
+
+	r *Recorder
+}
+
+func (c *recordedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.r.Input(p[:n])
+	return n, err
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.r.Output(p[:n])
+	return n, err
+}
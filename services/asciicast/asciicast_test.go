@@ -0,0 +1,193 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asciicast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	id := xid.New().String()
+
+	r := New(dir, id)
+	r.Resize(120, 40)
+	r.SetEnv("TERM", "xterm")
+
+	r.Output([]byte("$ "))
+	r.Input([]byte("ls\r"))
+	r.Output([]byte("ls\r\n\x1b[0m"))
+	r.Resize(100, 30)
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second recording of the same session
+	r2 := New(dir, id)
+	r2.Output([]byte("second"))
+	r2.Close()
+
+	f, err := Open(dir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	rr, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header.Width != 120 || rr.Header.Height != 40 || rr.Header.Env["TERM"] != "xterm" {
+		t.Errorf("Unexpected header %+v", rr.Header)
+	}
+
+	var got [][]string
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, []string{e.Type, e.Data})
+	}
+
+	want := [][]string{
+		{"o", "$ "},
+		{"i", "ls\r"},
+		{"o", "ls\r\n\x1b[0m"},
+		{"r", "100x30"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	f2, err := Open(dir, id+"-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f2.Close()
+
+	buf := &bytes.Buffer{}
+	if err := Play(context.Background(), buf, f2, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "second" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asciicast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	id := xid.New().String()
+
+	r := New(dir, id, WithMaxSize(256))
+	for i := 0; i < 10; i++ {
+		r.Output(bytes.Repeat([]byte("a"), 50))
+	}
+
+	r.Close()
+
+	if !r.Truncated() {
+		t.Error("Expected truncated recording")
+	}
+
+	f, err := Open(dir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	rr, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, e.Type)
+	}
+
+	if want := []string{"o", "o", "m"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEmptyRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asciicast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	id := xid.New().String()
+
+	r := New(dir, id)
+	r.Resize(100, 30)
+	r.Close()
+
+	if _, err := Open(dir, id); !os.IsNotExist(err) {
+		t.Errorf("Expected no recording for session without data, got %v", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, id := range []string{"../etc/passwd", "", "bpk5ll8l0s3g00a9qbb0/..", "BPK5LL8L0S3G00A9QBB0"} {
+		if _, err := Path("/tmp", id); err != ErrInvalidID {
+			t.Errorf("Expected id %q to be invalid", id)
+		}
+	}
+
+	if p, err := Path("/data", "bpk5ll8l0s3g00a9qbb0-2"); err != nil {
+		t.Fatal(err)
+	} else if p != "/data/recordings/bpk5ll8l0s3g00a9qbb0-2.cast" {
+		t.Errorf("Unexpected path %s", p)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package asciicast
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Event is a single event of a recording.
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// UnmarshalJSON decodes the event from its array representation.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	v := []interface{}{&e.Time, &e.Type, &e.Data}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	if len(v) != 3 {
+		return fmt.Errorf("invalid event, expected 3 elements got %d", len(v))
+	}
+
+	return nil
+}
+
+// Reader reads a recording.
+type Reader struct {
+	Header Header
+
+	s *bufio.Scanner
+}
+
+// NewReader returns a reader for the recording, the header is read
+// immediately.
+func NewReader(r io.Reader) (*Reader, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	rr := &Reader{
+		s: s,
+	}
+
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if err := json.Unmarshal(s.Bytes(), &rr.Header); err != nil {
+		return nil, fmt.Errorf("invalid header: %s", err.Error())
+	}
+
+	if rr.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", rr.Header.Version)
+	}
+
+	return rr, nil
+}
+
+// Next returns the next event, io.EOF at the end of the recording.
+func (rr *Reader) Next() (*Event, error) {
+	for rr.s.Scan() {
+		if len(rr.s.Bytes()) == 0 {
+			continue
+		}
+
+		e := &Event{}
+		if err := json.Unmarshal(rr.s.Bytes(), e); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	}
+
+	if err := rr.s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// Play writes the output of the recording to w, in the pace it has been
+// recorded. The pace is multiplied by speed, pauses are limited to
+// idleLimit when it is not zero.
+func Play(ctx context.Context, w io.Writer, r io.Reader, speed float64, idleLimit time.Duration) error {
+	rr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	if speed <= 0 {
+		speed = 1
+	}
+
+	last := 0.0
+
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if e.Type != EventOutput {
+			continue
+		}
+
+		wait := time.Duration((e.Time - last) / speed * float64(time.Second))
+		if idleLimit > 0 && wait > idleLimit {
+			wait = idleLimit
+		}
+
+		last = e.Time
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+}
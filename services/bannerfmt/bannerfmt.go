@@ -78,6 +78,19 @@ func (b *BannerFmt) String() string {
 	return parsed.String()
 }
 
+// Format returns the banner rendered from data, without replacing the data
+// of the banner, so it is safe for concurrent use.
+// On error it returns an empty or partially formatted string.
+func (b *BannerFmt) Format(data interface{}) string {
+	var parsed strings.Builder
+
+	if err := b.templ.Execute(&parsed, data); err != nil {
+		log.Debug(err.Error())
+	}
+
+	return parsed.String()
+}
+
 // Set replaces the data where the banner is rendered from
 //   this should be of the same type
 func (b *BannerFmt) Set(data interface{}) {
@@ -0,0 +1,233 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain is the maximum number of aliases followed within the zones
+const maxCNAMEChain = 8
+
+// dnsZones contains the records the dns service answers with.
+type dnsZones struct {
+	// records contains the records by lower case name and type
+	records map[string]map[uint16][]dns.RR
+}
+
+func newDNSZones() *dnsZones {
+	return &dnsZones{
+		records: map[string]map[uint16][]dns.RR{},
+	}
+}
+
+func (z *dnsZones) add(rr dns.RR) {
+	name := strings.ToLower(rr.Header().Name)
+
+	types, ok := z.records[name]
+	if !ok {
+		types = map[uint16][]dns.RR{}
+		z.records[name] = types
+	}
+
+	types[rr.Header().Rrtype] = append(types[rr.Header().Rrtype], rr)
+}
+
+// parse adds the records of a zone file, relative names are relative to
+// origin unless the file sets $ORIGIN.
+func (z *dnsZones) parse(r io.Reader, origin, file string) error {
+	var err error
+
+	// the tokens are drained, so the parser finishes
+	for token := range dns.ParseZone(r, origin, file) {
+		if token.Error != nil {
+			if err == nil {
+				err = token.Error
+			}
+			continue
+		}
+
+		z.add(token.RR)
+	}
+
+	return err
+}
+
+func (z *dnsZones) parseFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return z.parse(f, ".", name)
+}
+
+// rename returns copies of rrs with the owner name set to name.
+func rename(rrs []dns.RR, name string) []dns.RR {
+	copies := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		copies[i] = dns.Copy(rr)
+		copies[i].Header().Name = name
+	}
+
+	return copies
+}
+
+// lookup returns the records of name, when name doesn't exist the records
+// of the wildcard of the closest existing ancestor are returned.
+func (z *dnsZones) lookup(name string) (map[uint16][]dns.RR, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	if types, ok := z.records[name]; ok {
+		return types, true
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := 1; i <= len(labels); i++ {
+		ancestor := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		if types, ok := z.records["*."+strings.TrimPrefix(ancestor, ".")]; ok {
+			renamed := map[uint16][]dns.RR{}
+			for typ, rrs := range types {
+				renamed[typ] = rename(rrs, name)
+			}
+
+			return renamed, true
+		}
+
+		if _, ok := z.records[ancestor]; ok {
+			break
+		}
+	}
+
+	return nil, false
+}
+
+// types returns the sorted record types.
+func types(records map[uint16][]dns.RR) []uint16 {
+	values := []uint16{}
+	for typ := range records {
+		values = append(values, typ)
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// resolve returns the answers for name, aliases within the zones are
+// followed. Found is false when name doesn't exist.
+func (z *dnsZones) resolve(name string, qtype uint16) (answers []dns.RR, found bool) {
+	for i := 0; i < maxCNAMEChain; i++ {
+		records, ok := z.lookup(name)
+		if !ok {
+			break
+		}
+
+		found = true
+
+		if qtype == dns.TypeANY {
+			for _, typ := range types(records) {
+				answers = append(answers, records[typ]...)
+			}
+			break
+		}
+
+		if rrs := records[qtype]; len(rrs) > 0 {
+			answers = append(answers, rrs...)
+			break
+		}
+
+		cnames := records[dns.TypeCNAME]
+		if len(cnames) == 0 || qtype == dns.TypeCNAME {
+			break
+		}
+
+		answers = append(answers, cnames[0])
+		name = cnames[0].(*dns.CNAME).Target
+	}
+
+	return answers, found
+}
+
+// additional returns the addresses of the mail exchanges and name servers
+// in answers.
+func (z *dnsZones) additional(answers []dns.RR) []dns.RR {
+	extra := []dns.RR{}
+
+	for _, rr := range answers {
+		var target string
+		switch v := rr.(type) {
+		case *dns.MX:
+			target = v.Mx
+		case *dns.NS:
+			target = v.Ns
+		default:
+			continue
+		}
+
+		records, ok := z.records[strings.ToLower(target)]
+		if !ok {
+			continue
+		}
+
+		extra = append(extra, records[dns.TypeA]...)
+		extra = append(extra, records[dns.TypeAAAA]...)
+	}
+
+	return extra
+}
+
+// soa returns the start of authority of the closest zone containing name.
+func (z *dnsZones) soa(name string) dns.RR {
+	labels := dns.SplitDomainName(strings.ToLower(dns.Fqdn(name)))
+	for i := 0; i <= len(labels); i++ {
+		ancestor := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		if rrs := z.records[ancestor][dns.TypeSOA]; len(rrs) > 0 {
+			return rrs[0]
+		}
+	}
+
+	return nil
+}
+
+// canary returns the address records of name resolving to the canary
+// addresses.
+func canary(name string, qtype uint16, addresses []net.IP) []dns.RR {
+	answers := []dns.RR{}
+
+	for _, ip := range addresses {
+		if ip4 := ip.To4(); ip4 != nil && (qtype == dns.TypeA || qtype == dns.TypeANY) {
+			answers = append(answers, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   ip4,
+			})
+		} else if ip4 == nil && (qtype == dns.TypeAAAA || qtype == dns.TypeANY) {
+			answers = append(answers, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 300},
+				AAAA: ip,
+			})
+		}
+	}
+
+	return answers
+}
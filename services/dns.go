@@ -14,15 +14,19 @@
 package services
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
-	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 
 	"github.com/honeytrap/honeytrap/event"
-	"github.com/honeytrap/honeytrap/listener"
 	"github.com/honeytrap/honeytrap/pushers"
 )
 
@@ -30,16 +34,74 @@ var (
 	_ = Register("dns", DNS)
 )
 
-// Dns is a placeholder
+const (
+	// dnsTimeout is the idle timeout of tcp connections
+	dnsTimeout = 30 * time.Second
+)
+
+// DNS answers queries from the configured zones.
 func DNS(options ...ServicerFunc) Servicer {
-	s := &dnsService{}
+	s := &dnsService{
+		dnsServiceConfig: dnsServiceConfig{
+			Unknown:    "nxdomain",
+			Version:    "9.11.3-1ubuntu1.18-Ubuntu",
+			MaxUDPSize: 1232,
+			RateLimit:  60,
+		},
+	}
 	for _, o := range options {
 		o(s)
 	}
+
+	s.load()
+
 	return s
 }
 
+type dnsServiceConfig struct {
+	// Zones contains the zone files to answer from
+	Zones []string `toml:"zones"`
+
+	// Records contains records in zone file format, in addition to the
+	// zone files
+	Records []string `toml:"records"`
+
+	// Unknown is the response for names not in the zones: nxdomain,
+	// wildcard or canary
+	Unknown string `toml:"unknown"`
+
+	// Wildcard is the name whose records answer unknown names in
+	// wildcard mode
+	Wildcard string `toml:"wildcard"`
+
+	// Canary contains the addresses unknown names resolve to in canary
+	// mode
+	Canary []string `toml:"canary"`
+
+	// Version is returned for version.bind queries
+	Version string `toml:"version"`
+
+	// MaxUDPSize is the maximum size of udp responses, larger responses
+	// are truncated
+	MaxUDPSize int `toml:"max-udp-size"`
+
+	// RateLimit is the maximum number of udp responses per minute for an
+	// ip address
+	RateLimit int `toml:"rate-limit"`
+}
+
 type dnsService struct {
+	dnsServiceConfig
+
+	zones  *dnsZones
+	canary []net.IP
+
+	limiter *Limiter
+
+	// m protects purged, the last time the limiter was purged
+	m      sync.Mutex
+	purged time.Time
+
 	c pushers.Channel
 }
 
@@ -47,46 +109,298 @@ func (s *dnsService) SetChannel(c pushers.Channel) {
 	s.c = c
 }
 
+// load reads the zones and the records of the configuration.
+func (s *dnsService) load() {
+	s.zones = newDNSZones()
+
+	for _, name := range s.Zones {
+		if err := s.zones.parseFile(name); err != nil {
+			log.Errorf("Error reading zone %s: %s", name, err.Error())
+		}
+	}
+
+	if err := s.zones.parse(strings.NewReader(strings.Join(s.Records, "\n")), ".", "records"); err != nil {
+		log.Errorf("Error reading dns records: %s", err.Error())
+	}
+
+	s.canary = nil
+	for _, address := range s.Canary {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			log.Errorf("Invalid canary address %s", address)
+			continue
+		}
+
+		s.canary = append(s.canary, ip)
+	}
+
+	switch s.Unknown {
+	case "nxdomain", "wildcard", "canary":
+	default:
+		log.Errorf("Invalid response for unknown names %s, using nxdomain", s.Unknown)
+		s.Unknown = "nxdomain"
+	}
+
+	if s.RateLimit > 0 {
+		s.limiter = NewLimiter(WithRate(time.Minute/time.Duration(s.RateLimit), s.RateLimit))
+	}
+}
+
 func (s *dnsService) Handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
-	buff := make([]byte, 65535)
+	if conn.RemoteAddr().Network() == "udp" {
+		buff := make([]byte, 65535)
 
-	if _, ok := conn.(*listener.DummyUDPConn); ok {
 		n, err := conn.Read(buff[:])
 		if err != nil {
 			return err
 		}
 
-		buff = buff[:n]
-	} else if _, ok := conn.(*net.TCPConn); ok {
-		n, err := conn.Read(buff[:])
-		if err != nil {
+		return s.serve(conn, buff[:n], true)
+	}
+
+	// tcp messages are prefixed with their length
+	r := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(dnsTimeout))
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err == io.EOF {
+			return nil
+		} else if err != nil {
 			return err
 		}
 
-		buff = buff[:n]
-	} else {
-		log.Error("Unsupported connection type: %s", reflect.TypeOf(conn))
-		return nil
+		buff := make([]byte, length)
+		if _, err := io.ReadFull(r, buff); err != nil {
+			return err
+		}
+
+		if err := s.serve(conn, buff, false); err != nil {
+			return err
+		}
+	}
+}
+
+// allow returns whether a udp response may be sent to addr. The limiter is
+// purged every minute, the buckets are full again after a minute.
+func (s *dnsService) allow(addr net.Addr) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	s.m.Lock()
+	if time.Since(s.purged) >= time.Minute {
+		s.purged = time.Now()
+		s.limiter.Purge(time.Minute)
+	}
+	s.m.Unlock()
+
+	return s.limiter.Allow(addr)
+}
+
+// amplification returns whether the query looks like an amplification
+// probe: a query over udp for a type with large responses.
+func amplification(q dns.Question, ednsSize uint16, udp bool) bool {
+	if !udp {
+		return false
+	}
+
+	switch q.Qtype {
+	case dns.TypeANY, dns.TypeDNSKEY, dns.TypeRRSIG:
+		return true
+	case dns.TypeTXT:
+		return ednsSize >= 4096
+	default:
+		return false
 	}
+}
 
+// serve answers the query in buff.
+func (s *dnsService) serve(conn net.Conn, buff []byte, udp bool) error {
 	req := new(dns.Msg)
 	if err := req.Unpack(buff[:]); err != nil {
 		return err
 	}
 
+	resp, options := s.answer(req)
+
+	ednsSize := uint16(0)
+	if opt := req.IsEdns0(); opt != nil {
+		ednsSize = opt.UDPSize()
+		options = append(options,
+			event.Custom("dns.edns-size", int(ednsSize)),
+			event.Custom("dns.edns-do", opt.Do()),
+		)
+	}
+
+	if len(req.Question) > 0 {
+		q := req.Question[0]
+
+		options = append(options,
+			event.Custom("dns.qname", q.Name),
+			event.Custom("dns.qtype", dns.TypeToString[q.Qtype]),
+			event.Custom("dns.qclass", dns.ClassToString[q.Qclass]),
+			event.Custom("dns.amplification-probe", amplification(q, ednsSize, udp)),
+		)
+	}
+
+	limited := udp && !s.allow(conn.RemoteAddr())
+
+	if !limited && udp {
+		s.truncate(resp, req)
+	}
+
 	s.c.Send(event.New(
 		EventOptions,
 		event.Category("dns"),
 		event.Type("dns"),
+		event.Protocol(conn.RemoteAddr().Network()),
 		event.SourceAddr(conn.RemoteAddr()),
 		event.DestinationAddr(conn.LocalAddr()),
 		event.Custom("dns.id", fmt.Sprintf("%d", req.Id)),
 		event.Custom("dns.opcode", fmt.Sprintf("%d", req.Opcode)),
 		event.Custom("dns.message", fmt.Sprintf("Querying for: %#q", req.Question)),
 		event.Custom("dns.questions", req.Question),
+		event.Custom("dns.rcode", dns.RcodeToString[resp.Rcode]),
+		event.Custom("dns.answers", len(resp.Answer)),
+		event.Custom("dns.truncated", resp.Truncated),
+		event.Custom("dns.rate-limited", limited),
+		event.NewWith(options...),
 	))
 
-	return nil
+	// queries over the rate are dropped, to prevent amplification attacks
+	if limited {
+		return nil
+	}
+
+	data, err := resp.Pack()
+	if err != nil {
+		return err
+	}
+
+	if !udp {
+		data = append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+
+	_, err = conn.Write(data)
+	return err
+}
+
+// answer returns the response for req, with the options for the event.
+func (s *dnsService) answer(req *dns.Msg) (*dns.Msg, []event.Option) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Compress = true
+
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(uint16(s.MaxUDPSize), opt.Do())
+	}
+
+	if req.Opcode != dns.OpcodeQuery {
+		resp.Rcode = dns.RcodeNotImplemented
+		return resp, nil
+	} else if len(req.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		return resp, nil
+	}
+
+	q := req.Question[0]
+
+	switch q.Qclass {
+	case dns.ClassINET, dns.ClassANY:
+	case dns.ClassCHAOS:
+		if name := strings.ToLower(q.Name); (name == "version.bind." || name == "version.server.") && (q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY) {
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+				Txt: []string{s.Version},
+			})
+		} else {
+			resp.Rcode = dns.RcodeRefused
+		}
+		return resp, nil
+	default:
+		resp.Rcode = dns.RcodeRefused
+		return resp, nil
+	}
+
+	answers, found := s.zones.resolve(q.Name, q.Qtype)
+	if found {
+		resp.Authoritative = true
+		resp.Answer = answers
+		resp.Extra = append(s.zones.additional(answers), resp.Extra...)
+
+		// no data, the authority tells resolvers how long to cache it
+		if len(answers) == 0 {
+			if soa := s.zones.soa(q.Name); soa != nil {
+				resp.Ns = append(resp.Ns, soa)
+			}
+		}
+
+		return resp, nil
+	}
+
+	switch s.Unknown {
+	case "wildcard":
+		if answers, found := s.zones.resolve(s.Wildcard, q.Qtype); found {
+			resp.RecursionAvailable = true
+			resp.Answer = rename(answers, q.Name)
+			return resp, []event.Option{event.Custom("dns.wildcard", true)}
+		}
+	case "canary":
+		resp.RecursionAvailable = true
+		resp.Answer = canary(q.Name, q.Qtype, s.canary)
+		return resp, []event.Option{event.Custom("dns.canary", true)}
+	}
+
+	resp.Rcode = dns.RcodeNameError
+
+	if soa := s.zones.soa(q.Name); soa != nil {
+		resp.Authoritative = true
+		resp.Ns = append(resp.Ns, soa)
+	}
+
+	return resp, nil
+}
+
+// truncate limits the size of udp responses to the size the client
+// supports and the maximum size. Additional records are removed first,
+// when the response still doesn't fit records are removed and the
+// truncated flag is set.
+func (s *dnsService) truncate(resp, req *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+
+	if size > s.MaxUDPSize {
+		size = s.MaxUDPSize
+	}
+
+	if resp.Len() <= size {
+		return
+	}
+
+	// keep the opt record, additional records are dropped first
+	var opt []dns.RR
+	if rr := resp.IsEdns0(); rr != nil {
+		opt = []dns.RR{rr}
+	}
+
+	resp.Extra = opt
+	if resp.Len() <= size {
+		return
+	}
+
+	resp.Truncated = true
+
+	for len(resp.Ns) > 0 && resp.Len() > size {
+		resp.Ns = resp.Ns[:len(resp.Ns)-1]
+	}
+
+	for len(resp.Answer) > 0 && resp.Len() > size {
+		resp.Answer = resp.Answer[:len(resp.Answer)-1]
+	}
 }
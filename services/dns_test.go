@@ -0,0 +1,317 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+)
+
+var testRecords = []string{
+	"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 3600",
+	"example.com. 3600 IN NS ns1.example.com.",
+	"example.com. 3600 IN MX 10 mail.example.com.",
+	"example.com. 3600 IN TXT \"v=spf1 mx -all\"",
+	"ns1.example.com. 3600 IN A 192.0.2.53",
+	"mail.example.com. 3600 IN A 192.0.2.25",
+	"www.example.com. 3600 IN A 192.0.2.80",
+	"www.example.com. 3600 IN AAAA 2001:db8::80",
+	"ftp.example.com. 3600 IN CNAME www.example.com.",
+	"*.dyn.example.com. 60 IN A 192.0.2.100",
+}
+
+func newDNS(fn func(*dnsService)) (*dnsService, *pushertest.Channel) {
+	s := DNS().(*dnsService)
+	s.Records = testRecords
+	if fn != nil {
+		fn(s)
+	}
+	s.load()
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	return s, c
+}
+
+// queryUDP sends the query as udp packet, the response is nil when
+// dropped.
+func queryUDP(t *testing.T, s *dnsService, req *dns.Msg) *dns.Msg {
+	data, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []byte
+
+	conn := &listener.DummyUDPConn{
+		Buffer: data,
+		Laddr:  &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53},
+		Raddr:  &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 31337},
+		Fn: func(b []byte, addr *net.UDPAddr) (int, error) {
+			written = append([]byte{}, b...)
+			return len(b), nil
+		},
+	}
+
+	if err := s.Handle(context.TODO(), conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if written == nil {
+		return nil
+	}
+
+	if len(written) > s.MaxUDPSize {
+		t.Errorf("Response of %d bytes exceeds the maximum udp size", len(written))
+	}
+
+	// truncated responses are unpacked as far as possible
+	resp := new(dns.Msg)
+	if err := resp.Unpack(written); err != nil && err != dns.ErrTruncated {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func question(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	return req
+}
+
+func answers(resp *dns.Msg) string {
+	values := []string{}
+	for _, rr := range resp.Answer {
+		values = append(values, strings.Replace(rr.String(), "\t", " ", -1))
+	}
+	return strings.Join(values, "\n")
+}
+
+func TestDNSZone(t *testing.T) {
+	s, _ := newDNS(nil)
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		rcode    int
+		expected string
+	}{
+		{"www.example.com.", dns.TypeA, dns.RcodeSuccess, "www.example.com. 3600 IN A 192.0.2.80"},
+		{"WWW.example.com.", dns.TypeAAAA, dns.RcodeSuccess, "www.example.com. 3600 IN AAAA 2001:db8::80"},
+		{"example.com.", dns.TypeTXT, dns.RcodeSuccess, "example.com. 3600 IN TXT \"v=spf1 mx -all\""},
+		{"example.com.", dns.TypeMX, dns.RcodeSuccess, "example.com. 3600 IN MX 10 mail.example.com."},
+		{"ftp.example.com.", dns.TypeA, dns.RcodeSuccess, "ftp.example.com. 3600 IN CNAME www.example.com.\nwww.example.com. 3600 IN A 192.0.2.80"},
+		{"host1.dyn.example.com.", dns.TypeA, dns.RcodeSuccess, "host1.dyn.example.com. 60 IN A 192.0.2.100"},
+		{"www.example.com.", dns.TypeMX, dns.RcodeSuccess, ""},
+		{"missing.example.com.", dns.TypeA, dns.RcodeNameError, ""},
+		{"example.org.", dns.TypeA, dns.RcodeNameError, ""},
+	}
+
+	for _, tst := range tests {
+		resp := queryUDP(t, s, question(tst.name, tst.qtype))
+		if resp.Rcode != tst.rcode {
+			t.Errorf("%s %s: expected rcode %s, got %s", tst.name, dns.TypeToString[tst.qtype], dns.RcodeToString[tst.rcode], dns.RcodeToString[resp.Rcode])
+		}
+
+		if got := answers(resp); got != tst.expected {
+			t.Errorf("%s %s: expected %q, got %q", tst.name, dns.TypeToString[tst.qtype], tst.expected, got)
+		}
+	}
+
+	// mail exchanges are returned with their addresses
+	resp := queryUDP(t, s, question("example.com.", dns.TypeMX))
+	if len(resp.Extra) != 1 || resp.Extra[0].(*dns.A).A.String() != "192.0.2.25" {
+		t.Errorf("Expected address of the mail exchange, got %v", resp.Extra)
+	}
+
+	// names in the zone without records return the authority
+	resp = queryUDP(t, s, question("missing.example.com.", dns.TypeA))
+	if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA || !resp.Authoritative {
+		t.Errorf("Expected authoritative soa, got %v", resp.Ns)
+	}
+}
+
+func TestDNSUnknown(t *testing.T) {
+	s, c := newDNS(func(s *dnsService) {
+		s.Unknown = "canary"
+		s.Canary = []string{"203.0.113.7", "2001:db8::7"}
+	})
+
+	resp := queryUDP(t, s, question("c2.example.net.", dns.TypeA))
+	if got := answers(resp); got != "c2.example.net. 300 IN A 203.0.113.7" {
+		t.Errorf("Expected canary address, got %q", got)
+	}
+
+	e, _ := c.First("dns")
+	if v := value(e, "dns.canary"); v != true {
+		t.Errorf("Expected canary event, got %v", v)
+	}
+
+	s, _ = newDNS(func(s *dnsService) {
+		s.Unknown = "wildcard"
+		s.Wildcard = "www.example.com."
+	})
+
+	resp = queryUDP(t, s, question("c2.example.net.", dns.TypeA))
+	if got := answers(resp); got != "c2.example.net. 3600 IN A 192.0.2.80" {
+		t.Errorf("Expected wildcard address, got %q", got)
+	}
+}
+
+func TestDNSEDNS(t *testing.T) {
+	s, c := newDNS(nil)
+
+	req := question("www.example.com.", dns.TypeA)
+	req.SetEdns0(4096, true)
+
+	resp := queryUDP(t, s, req)
+
+	opt := resp.IsEdns0()
+	if opt == nil {
+		t.Fatalf("Expected edns0 in response")
+	} else if opt.UDPSize() != 1232 {
+		t.Errorf("Expected udp size 1232, got %d", opt.UDPSize())
+	}
+
+	e, _ := c.First("dns")
+	if v := value(e, "dns.edns-size"); v != 4096 {
+		t.Errorf("Expected edns size 4096, got %v", v)
+	}
+
+	if v := value(e, "dns.qtype"); v != "A" {
+		t.Errorf("Expected qtype A, got %v", v)
+	}
+}
+
+func TestDNSAmplification(t *testing.T) {
+	records := append([]string{}, testRecords...)
+	for i := 0; i < 20; i++ {
+		records = append(records, fmt.Sprintf("example.com. 3600 IN TXT \"%s\"", strings.Repeat("x", 200)))
+	}
+
+	s, c := newDNS(func(s *dnsService) {
+		s.Records = records
+		s.RateLimit = 2
+	})
+
+	resp := queryUDP(t, s, question("example.com.", dns.TypeANY))
+	if !resp.Truncated {
+		t.Errorf("Expected truncated response")
+	}
+
+	e, _ := c.First("dns")
+	if v := value(e, "dns.amplification-probe"); v != true {
+		t.Errorf("Expected amplification probe, got %v", v)
+	}
+
+	queryUDP(t, s, question("example.com.", dns.TypeANY))
+
+	if resp := queryUDP(t, s, question("example.com.", dns.TypeANY)); resp != nil {
+		t.Errorf("Expected rate limited query to be dropped")
+	}
+
+	// tcp responses are not limited
+	server, client, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	for i := 0; i < 3; i++ {
+		data, _ := question("example.com.", dns.TypeANY).Pack()
+		data = append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		var length uint16
+		if err := binary.Read(client, binary.BigEndian, &length); err != nil {
+			t.Fatal(err)
+		}
+
+		data = make([]byte, length)
+		if _, err := io.ReadFull(client, data); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := new(dns.Msg)
+		if err := resp.Unpack(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Truncated || len(resp.Answer) != 24 {
+			t.Errorf("Expected complete response over tcp, got %d answers", len(resp.Answer))
+		}
+	}
+}
+
+func TestDNSLimiterPurge(t *testing.T) {
+	s, _ := newDNS(func(s *dnsService) {
+		s.RateLimit = 2
+	})
+
+	idle := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	active := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 53}
+
+	s.allow(idle)
+
+	v, ok := s.limiter.m.Load("192.0.2.1")
+	if !ok {
+		t.Fatal("Expected limiter entry")
+	}
+
+	// the entry hasn't been used for two minutes, the limiter is purged
+	// after a minute
+	atomic.StoreInt64(&v.(*limiterEntry).seen, time.Now().Add(-2*time.Minute).UnixNano())
+	s.purged = time.Now().Add(-2 * time.Minute)
+
+	s.allow(active)
+
+	if _, ok := s.limiter.m.Load("192.0.2.1"); ok {
+		t.Errorf("Expected idle entry to be purged")
+	}
+
+	if _, ok := s.limiter.m.Load("192.0.2.2"); !ok {
+		t.Errorf("Expected active entry")
+	}
+}
+
+func TestDNSVersion(t *testing.T) {
+	s, _ := newDNS(nil)
+
+	req := question("version.bind.", dns.TypeTXT)
+	req.Question[0].Qclass = dns.ClassCHAOS
+
+	resp := queryUDP(t, s, req)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.TXT).Txt[0] != s.Version {
+		t.Errorf("Expected version, got %v", resp.Answer)
+	}
+}
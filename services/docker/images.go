@@ -0,0 +1,304 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// maxDockerfileSize is the maximum size of a Dockerfile in a build context
+const maxDockerfileSize = 1024 * 1024
+
+func (s *dockerService) listImages(ctx *dockerContext) error {
+	ctx.host.Lock()
+	defer ctx.host.Unlock()
+
+	images := []interface{}{}
+	for _, img := range ctx.host.images {
+		images = append(images, map[string]interface{}{
+			"Id":          img.ID,
+			"ParentId":    "",
+			"RepoTags":    img.RepoTags,
+			"RepoDigests": []string{},
+			"Created":     img.Created.Unix(),
+			"Size":        img.Size,
+			"VirtualSize": img.Size,
+			"SharedSize":  -1,
+			"Labels":      map[string]string{},
+			"Containers":  -1,
+		})
+	}
+
+	return s.write(ctx, http.StatusOK, images)
+}
+
+// reference splits an image reference into registry, repository, tag and
+// digest.
+func reference(ref, tag string) (registry, repository, t, digest string) {
+	registry = "docker.io"
+
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref, digest = ref[:i], ref[i+1:]
+	}
+
+	// the first component is a registry when it looks like a host name
+	if i := strings.Index(ref, "/"); i >= 0 {
+		if host := ref[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, ref = host, ref[i+1:]
+		}
+	}
+
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		ref, t = ref[:i], ref[i+1:]
+	}
+
+	if tag != "" {
+		t = tag
+	} else if t == "" && digest == "" {
+		t = "latest"
+	}
+
+	return registry, ref, t, digest
+}
+
+// writeStream writes the messages as stream of json objects.
+func (s *dockerService) writeStream(ctx *dockerContext, messages []interface{}) error {
+	buff := bytes.Buffer{}
+
+	encoder := json.NewEncoder(&buff)
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+	}
+
+	return s.writeRaw(ctx, http.StatusOK, "application/json", buff.Bytes())
+}
+
+func (s *dockerService) createImage(ctx *dockerContext) error {
+	query := ctx.req.URL.Query()
+
+	// images imported from a tarball in the body
+	if query.Get("fromSrc") != "" {
+		return s.importImage(ctx)
+	}
+
+	ref := query.Get("fromImage")
+	if ref == "" {
+		return s.write(ctx, http.StatusBadRequest, map[string]interface{}{
+			"message": "invalid reference format",
+		})
+	}
+
+	registry, repository, tag, digest := reference(ref, query.Get("tag"))
+
+	s.send(ctx,
+		event.Type("image-pull"),
+		event.Custom("docker.registry", registry),
+		event.Custom("docker.image", repository),
+		event.Custom("docker.tag", tag),
+		event.Custom("docker.digest", digest),
+	)
+
+	name := repository
+	if registry != "docker.io" {
+		name = registry + "/" + repository
+	}
+
+	if tag != "" {
+		name += ":" + tag
+	} else {
+		name += "@" + digest
+	}
+
+	ctx.host.Lock()
+	ctx.host.image(name)
+	ctx.host.Unlock()
+
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) == 1 {
+		parts = strings.SplitN(name, "@", 2)
+	}
+
+	return s.writeStream(ctx, imageCreateResp(parts[0], parts[1]))
+}
+
+// importImage saves the tarball of an imported image as artifact.
+func (s *dockerService) importImage(ctx *dockerContext) error {
+	query := ctx.req.URL.Query()
+
+	options := []event.Option{
+		event.Custom("docker.source", query.Get("fromSrc")),
+		event.Custom("docker.repo", query.Get("repo")),
+	}
+
+	if query.Get("fromSrc") == "-" && len(ctx.body) > 0 {
+		a, err := artifacts.Save(s.c, bytes.NewReader(ctx.body), "image.tar", ctx.options...)
+		if err != nil {
+			log.Errorf("Error saving imported image: %s", err.Error())
+		} else {
+			options = append(options, event.Custom("docker.sha256", a.SHA256))
+		}
+	}
+
+	s.send(ctx, append(options, event.Type("image-import"))...)
+
+	id := randomID()
+	if repo := query.Get("repo"); repo != "" {
+		ctx.host.Lock()
+		id = ctx.host.image(repo).ID
+		ctx.host.Unlock()
+	}
+
+	return s.writeStream(ctx, []interface{}{
+		map[string]interface{}{"status": id},
+	})
+}
+
+// dockerfile returns the Dockerfile of the build context, a tar archive
+// which may be compressed with gzip.
+func dockerfile(context []byte, name string) (string, error) {
+	var r io.Reader = bytes.NewReader(context)
+
+	if bytes.HasPrefix(context, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("Cannot locate specified Dockerfile: %s", name)
+		} else if err != nil {
+			return "", err
+		}
+
+		if path.Clean(hdr.Name) != path.Clean(name) {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(tr, maxDockerfileSize))
+		return string(data), err
+	}
+}
+
+// instructions returns the instructions of the Dockerfile, continued lines
+// are joined.
+func instructions(dockerfile string) []string {
+	values := []string{}
+
+	current := ""
+	for _, line := range strings.Split(dockerfile, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		values = append(values, current+line)
+		current = ""
+	}
+
+	if current != "" {
+		values = append(values, strings.TrimSpace(current))
+	}
+
+	return values
+}
+
+func (s *dockerService) build(ctx *dockerContext) error {
+	query := ctx.req.URL.Query()
+
+	name := query.Get("dockerfile")
+	if name == "" {
+		name = "Dockerfile"
+	}
+
+	tag := query.Get("t")
+
+	options := []event.Option{
+		event.Custom("docker.tag", tag),
+		event.Custom("docker.remote", query.Get("remote")),
+	}
+
+	if len(ctx.body) > 0 {
+		a, err := artifacts.Save(s.c, bytes.NewReader(ctx.body), "build-context.tar", ctx.options...)
+		if err != nil {
+			log.Errorf("Error saving build context: %s", err.Error())
+		} else {
+			options = append(options, event.Custom("docker.sha256", a.SHA256))
+		}
+	}
+
+	content, err := dockerfile(ctx.body, name)
+	if err != nil && query.Get("remote") == "" {
+		s.send(ctx, append(options, event.Type("build"), event.Custom("docker.error", err.Error()))...)
+
+		return s.write(ctx, http.StatusInternalServerError, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+
+	s.send(ctx, append(options, event.Type("build"), event.Custom("docker.dockerfile", content))...)
+
+	steps := instructions(content)
+
+	messages := []interface{}{}
+	for i, step := range steps {
+		messages = append(messages, map[string]interface{}{
+			"stream": fmt.Sprintf("Step %d/%d : %s\n", i+1, len(steps), step),
+		})
+	}
+
+	id := randomID()
+	if tag != "" {
+		ctx.host.Lock()
+		id = strings.TrimPrefix(ctx.host.image(tag).ID, "sha256:")
+		ctx.host.Unlock()
+	}
+
+	messages = append(messages,
+		map[string]interface{}{"aux": map[string]string{"ID": "sha256:" + id}},
+		map[string]interface{}{"stream": fmt.Sprintf("Successfully built %s\n", id[:12])},
+	)
+
+	if tag != "" {
+		messages = append(messages, map[string]interface{}{
+			"stream": fmt.Sprintf("Successfully tagged %s\n", tag),
+		})
+	}
+
+	return s.writeStream(ctx, messages)
+}
@@ -0,0 +1,237 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxContainers is the maximum number of containers of an attacker
+	maxContainers = 100
+
+	// maxExecs is the maximum number of execs of an attacker, the oldest
+	// are removed
+	maxExecs = 100
+
+	// maxImages is the maximum number of images of an attacker
+	maxImages = 100
+
+	// maxLogSize is the maximum size of the logs of a container
+	maxLogSize = 64 * 1024
+)
+
+// dockerHost contains the state of the docker host of an attacker.
+type dockerHost struct {
+	sync.Mutex
+
+	containers []*container
+	execs      []*dockerExec
+	images     []*image
+
+	lastUsed time.Time
+}
+
+type container struct {
+	ID      string
+	Name    string
+	Image   string
+	Cmd     []string
+	Env     []string
+	Tty     bool
+	Created time.Time
+
+	Binds      []string
+	Privileged bool
+	PidMode    string
+	Network    string
+
+	Running  bool
+	ExitCode int
+
+	logs bytes.Buffer
+}
+
+type dockerExec struct {
+	ID          string
+	ContainerID string
+	Cmd         []string
+	Tty         bool
+	AttachStdin bool
+	Privileged  bool
+	User        string
+
+	Running  bool
+	ExitCode int
+}
+
+type image struct {
+	ID       string
+	RepoTags []string
+	Created  time.Time
+	Size     int64
+}
+
+// randomID returns a random id like the container and image ids of
+// docker.
+func randomID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var (
+	adjectives = []string{"admiring", "brave", "eager", "focused", "happy", "jolly", "nifty", "quirky", "serene", "vibrant"}
+	surnames   = []string{"bohr", "curie", "darwin", "euler", "fermi", "hopper", "lovelace", "newton", "tesla", "turing"}
+)
+
+// randomName returns a random container name.
+func randomName() string {
+	return fmt.Sprintf("%s_%s", adjectives[mrand.Intn(len(adjectives))], surnames[mrand.Intn(len(surnames))])
+}
+
+// host returns the docker host of the attacker at addr.
+func (s *dockerService) host(addr net.Addr) *dockerHost {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	h, ok := s.hosts[ip]
+	if !ok {
+		if len(s.hosts) >= s.MaxHosts {
+			s.evict()
+		}
+
+		h = &dockerHost{}
+		s.hosts[ip] = h
+	}
+
+	h.Lock()
+	h.lastUsed = time.Now()
+	h.Unlock()
+
+	return h
+}
+
+// evict removes the least recently used host.
+func (s *dockerService) evict() {
+	oldest := ""
+
+	var last time.Time
+	for ip, h := range s.hosts {
+		h.Lock()
+		used := h.lastUsed
+		h.Unlock()
+
+		if oldest == "" || used.Before(last) {
+			oldest, last = ip, used
+		}
+	}
+
+	delete(s.hosts, oldest)
+}
+
+// container returns the container with the id, id prefix or name.
+func (h *dockerHost) container(id string) *container {
+	for _, c := range h.containers {
+		if c.ID == id || c.Name == strings.TrimPrefix(id, "/") {
+			return c
+		}
+	}
+
+	for _, c := range h.containers {
+		if len(id) >= 4 && strings.HasPrefix(c.ID, id) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (h *dockerHost) removeContainer(c *container) {
+	for i, v := range h.containers {
+		if v == c {
+			h.containers = append(h.containers[:i], h.containers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (h *dockerHost) exec(id string) *dockerExec {
+	for _, e := range h.execs {
+		if e.ID == id {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (h *dockerHost) addExec(e *dockerExec) {
+	if len(h.execs) >= maxExecs {
+		h.execs = h.execs[1:]
+	}
+
+	h.execs = append(h.execs, e)
+}
+
+// image returns the image with the reference, adding it when missing.
+func (h *dockerHost) image(ref string) *image {
+	if !strings.Contains(ref, ":") && !strings.Contains(ref, "@") {
+		ref += ":latest"
+	}
+
+	for _, img := range h.images {
+		for _, tag := range img.RepoTags {
+			if tag == ref {
+				return img
+			}
+		}
+	}
+
+	img := &image{
+		ID:       "sha256:" + randomID(),
+		RepoTags: []string{ref},
+		Created:  time.Now(),
+		Size:     int64(5000000 + mrand.Intn(100000000)),
+	}
+
+	if len(h.images) >= maxImages {
+		h.images = h.images[1:]
+	}
+
+	h.images = append(h.images, img)
+	return img
+}
+
+// log appends output to the logs of the container.
+func (c *container) log(p []byte) {
+	if c.logs.Len()+len(p) > maxLogSize {
+		return
+	}
+
+	c.logs.Write(p)
+}
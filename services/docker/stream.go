@@ -0,0 +1,180 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services/shell"
+)
+
+const (
+	stdout = 1
+	stderr = 2
+)
+
+// streamWriter writes output as docker raw stream, each write is prefixed
+// with the stream header unless a tty is used.
+type streamWriter struct {
+	w      io.Writer
+	tty    bool
+	stream byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.tty {
+		return sw.w.Write(p)
+	}
+
+	header := []byte{sw.stream, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(p)))
+
+	if _, err := sw.w.Write(append(header, p...)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// quote returns the arguments as command line, quoted for the shell.
+func quote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// interactive returns whether the command starts a shell reading commands
+// from stdin.
+func interactive(cmd []string) bool {
+	if len(cmd) == 0 {
+		return true
+	}
+
+	switch cmd[0] {
+	case "sh", "bash", "/bin/sh", "/bin/bash", "ash", "/bin/ash":
+		return len(cmd) == 1 || (len(cmd) == 2 && cmd[1] == "-i")
+	default:
+		return false
+	}
+}
+
+// shellCmd runs the command of -c, other shells are ignored.
+func shellCmd(sh *shell.Shell, args []string) int {
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] == "-c" {
+			sh.Run(args[i+1])
+			return sh.ExitStatus()
+		}
+	}
+
+	return 0
+}
+
+// chroot runs the command in the new root, without changing the root, as
+// used to escape to a host mounted in the container.
+func chroot(sh *shell.Shell, args []string) int {
+	if len(args) < 2 {
+		sh.Printf("chroot: missing operand\n")
+		return 1
+	} else if len(args) == 2 {
+		return 0
+	}
+
+	sh.Run(quote(args[2:]))
+	return sh.ExitStatus()
+}
+
+func id(sh *shell.Shell, args []string) int {
+	sh.Printf("uid=0(root) gid=0(root) groups=0(root),1(bin),2(daemon),3(sys),4(adm),6(disk),10(wheel),11(floppy),20(dialout),26(tape),27(video)\n")
+	return 0
+}
+
+// newShell returns the shell of the container, writing to w.
+func (s *dockerService) newShell(ctx *dockerContext, c *container, w io.Writer) *shell.Shell {
+	hostname := c.ID[:12]
+
+	options := []shell.Option{
+		shell.WithHostname(hostname),
+		shell.WithUser("root"),
+		shell.WithCommand("sh", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("ash", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("bash", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("chroot", shell.CommandFunc(chroot)),
+		shell.WithCommand("id", shell.CommandFunc(id)),
+		shell.WithCommand("hostname", shell.CommandFunc(func(sh *shell.Shell, args []string) int {
+			sh.Printf("%s\n", hostname)
+			return 0
+		})),
+	}
+
+	if s.Download {
+		options = append(options, shell.WithDownloader(func(rawurl string) (io.ReadCloser, error) {
+			return artifacts.Download(s.c, rawurl, append(ctx.options, event.Custom("docker.container-id", c.ID))...)
+		}))
+	}
+
+	return shell.New(w, options...)
+}
+
+// run runs the command in the container, writing the output to w.
+func (s *dockerService) run(ctx *dockerContext, c *container, cmd []string, w io.Writer, options ...event.Option) int {
+	sh := s.newShell(ctx, c, w)
+
+	emulated := sh.Run(quote(cmd))
+
+	s.send(ctx, append(options,
+		event.Type("exec"),
+		event.Custom("docker.container-id", c.ID),
+		event.Custom("docker.command", strings.Join(cmd, " ")),
+		event.Custom("docker.emulated", emulated),
+	)...)
+
+	return sh.ExitStatus()
+}
+
+// interact reads commands from the connection and runs them, until the
+// connection is closed or the shell exits.
+func (s *dockerService) interact(ctx *dockerContext, c *container, w io.Writer, options ...event.Option) int {
+	sh := s.newShell(ctx, c, w)
+
+	scanner := bufio.NewScanner(ctx.br)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		emulated := sh.Run(line)
+
+		s.send(ctx, append(options,
+			event.Type("exec"),
+			event.Custom("docker.container-id", c.ID),
+			event.Custom("docker.command", line),
+			event.Custom("docker.emulated", emulated),
+		)...)
+
+		if sh.Exited() {
+			break
+		}
+	}
+
+	return sh.ExitStatus()
+}
@@ -15,6 +15,8 @@ package ftp
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 )
@@ -986,7 +988,41 @@ func (cmd commandStor) Execute(conn *Conn, param string) {
 		conn.appendData = false
 	}()
 
-	bytes, err := conn.driver.PutFile(param, conn.dataConn, conn.appendData)
+	var data io.Reader = conn.dataConn
+
+	done := make(chan struct{})
+
+	var pw *io.PipeWriter
+
+	if fn := conn.server.artifact; fn != nil {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+
+		data = io.TeeReader(conn.dataConn, pw)
+
+		go func() {
+			defer close(done)
+
+			fn(conn, param, pr)
+
+			// drain, in case the data has not been consumed completely
+			io.Copy(ioutil.Discard, pr)
+		}()
+	} else {
+		close(done)
+	}
+
+	bytes, err := conn.driver.PutFile(param, data, conn.appendData)
+
+	if pw == nil {
+	} else if err != nil {
+		pw.CloseWithError(err)
+	} else {
+		pw.Close()
+	}
+
+	<-done
+
 	if err == nil {
 		msg := "OK, received " + strconv.Itoa(int(bytes)) + " bytes"
 		conn.writeMessage(226, msg)
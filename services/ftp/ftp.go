@@ -15,9 +15,12 @@ package ftp
 
 import (
 	"context"
+	"io"
 	"net"
 	"strings"
 
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/certificates"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
@@ -37,11 +40,6 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 		log.Errorf("FTP: Could not initialize storage. %s", err.Error())
 	}
 
-	cert, err := store.Certificate()
-	if err != nil {
-		log.Errorf("TLS error: %s", err.Error())
-	}
-
 	s := &ftpService{
 		Opts: Opts{},
 		recv: make(chan string),
@@ -64,7 +62,7 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 
 	s.server = NewServer(opts)
 
-	s.server.tlsConfig = simpleTLSConfig(cert)
+	s.server.tlsConfig = simpleTLSConfig(certificates.Default().GetCertificate(certificates.Subject{}))
 	if s.server.tlsConfig != nil {
 		//s.server.TLS = true
 		s.server.ExplicitFTPS = true
@@ -84,6 +82,8 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 
 	s.driver = NewFileDriver(fs)
 
+	s.server.artifact = s.artifact
+
 	return s
 }
 
@@ -113,6 +113,20 @@ func (s *ftpService) SetChannel(c pushers.Channel) {
 	s.c = c
 }
 
+// artifact saves uploaded files as artifacts.
+func (s *ftpService) artifact(conn *Conn, name string, r io.Reader) {
+	_, err := artifacts.Save(s.c, r, name,
+		services.EventOptions,
+		event.Category("ftp"),
+		event.SourceAddr(conn.conn.RemoteAddr()),
+		event.DestinationAddr(conn.conn.LocalAddr()),
+		event.Custom("ftp.sessionid", conn.sessionid),
+	)
+	if err != nil {
+		log.Errorf("Error saving upload %s: %s", name, err.Error())
+	}
+}
+
 func (s *ftpService) Handle(ctx context.Context, conn net.Conn) error {
 
 	ftpConn := s.server.newConn(conn, s.driver, s.recv)
@@ -16,6 +16,7 @@ package ftp
 import (
 	"bufio"
 	"crypto/tls"
+	"io"
 	"net"
 )
 
@@ -52,6 +53,9 @@ type ServerOpts struct {
 type Server struct {
 	*ServerOpts
 	tlsConfig *tls.Config
+
+	// artifact is called with the data of uploaded files
+	artifact func(conn *Conn, name string, r io.Reader)
 }
 
 // serverOptsWithDefaults copies an ServerOpts struct into a new struct,
@@ -122,13 +126,9 @@ func (server *Server) newConn(tcpConn net.Conn, driver Driver, recv chan string)
 	return c
 }
 
-func simpleTLSConfig(cert *tls.Certificate) *tls.Config {
-	if cert == nil {
-		return nil
-	}
-
+func simpleTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
 	return &tls.Config{
-		Certificates:       []tls.Certificate{*cert},
+		GetCertificate:     getCertificate,
 		InsecureSkipVerify: true,
 	}
 }
@@ -0,0 +1,209 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+const (
+	// maxPayloadSize is the part of the body that is added to the event,
+	// larger bodies are stored as artifact
+	maxPayloadSize = 1024
+
+	// maxFormFields limits the number of form fields added to the event
+	maxFormFields = 128
+
+	// maxJSONDepth limits the depth of flattened json objects
+	maxJSONDepth = 4
+)
+
+// readBody reads at most max bytes of the body, the remainder is discarded.
+// The returned size is the size of the complete body.
+func readBody(r io.Reader, max int64) ([]byte, int64, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, max))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return body, int64(len(body)) + n, nil
+}
+
+// bodyOptions returns the event options describing the body: the payload,
+// the hash of the stored body and the decoded form fields and files. Files
+// are stored as artifacts, the artifact events are sent to c with the
+// options of the connection.
+func bodyOptions(c pushers.Channel, req *http.Request, body []byte, size int64, options ...event.Option) []event.Option {
+	payload := body
+	if len(payload) > maxPayloadSize {
+		payload = payload[:maxPayloadSize]
+	}
+
+	result := []event.Option{
+		event.Payload(payload),
+		event.Custom("http.body.size", size),
+		event.Custom("http.body.truncated", size > int64(len(body))),
+	}
+
+	if len(body) > maxPayloadSize {
+		if a, err := artifacts.Save(nil, bytes.NewReader(body), req.URL.Path); err != nil {
+			log.Errorf("Error storing body: %s", err.Error())
+		} else {
+			result = append(result, event.Custom("http.body.sha256", a.SHA256))
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return result
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil && len(values) == 0 {
+			return result
+		}
+
+		result = append(result, formFields(values))
+	case mediaType == "multipart/form-data":
+		values, files := multipartForm(c, body, params["boundary"], options...)
+
+		result = append(result, formFields(values))
+
+		if len(files) > 0 {
+			result = append(result, event.Custom("http.files", files))
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return result
+		}
+
+		fields := map[string]interface{}{}
+		flatten(fields, "", v, 0)
+
+		result = append(result, func(e event.Event) {
+			for k, v := range fields {
+				e.Store("http.form."+k, v)
+			}
+		})
+	}
+
+	return result
+}
+
+func formFields(values url.Values) event.Option {
+	return func(e event.Event) {
+		n := 0
+
+		for k, v := range values {
+			if n >= maxFormFields {
+				break
+			}
+
+			n++
+
+			e.Store("http.form."+k, v)
+		}
+	}
+}
+
+// flatten flattens nested json objects, using dots to separate the keys.
+func flatten(fields map[string]interface{}, prefix string, v interface{}, depth int) {
+	m, ok := v.(map[string]interface{})
+	if !ok || depth >= maxJSONDepth {
+		if prefix == "" {
+			prefix = "_"
+		}
+
+		fields[prefix] = v
+		return
+	}
+
+	for k, v := range m {
+		if len(fields) >= maxFormFields {
+			return
+		}
+
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		flatten(fields, k, v, depth+1)
+	}
+}
+
+// multipartForm decodes the multipart form, the values are returned and the
+// files are saved as artifact.
+func multipartForm(c pushers.Channel, body []byte, boundary string, options ...event.Option) (url.Values, []map[string]interface{}) {
+	values := url.Values{}
+
+	files := []map[string]interface{}{}
+
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+
+	for i := 0; i < maxFormFields; i++ {
+		part, err := mr.NextPart()
+		if err != nil {
+			// the end of the form, or a truncated or invalid body
+			break
+		}
+
+		if part.FileName() == "" {
+			data, err := ioutil.ReadAll(io.LimitReader(part, maxPayloadSize))
+			if err != nil {
+				break
+			}
+
+			values.Add(part.FormName(), string(data))
+			continue
+		}
+
+		file := map[string]interface{}{
+			"field":        part.FormName(),
+			"filename":     part.FileName(),
+			"content-type": part.Header.Get("Content-Type"),
+		}
+
+		a, err := artifacts.Save(c, part, part.FileName(), options...)
+		if err != nil {
+			log.Errorf("Error storing file %s: %s", part.FileName(), err.Error())
+		} else {
+			file["size"] = a.Size
+			file["sha256"] = a.SHA256
+		}
+
+		files = append(files, file)
+	}
+
+	return values, files
+}
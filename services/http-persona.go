@@ -0,0 +1,256 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/services/bannerfmt"
+)
+
+// httpPersona is a set of routes emulating a web application. Personas are
+// defined in toml:
+//
+//	name = "wordpress"
+//
+//	[[route]]
+//	name = "wp-login"
+//	method = "GET"
+//	path = "^/wp-login\\.php$"
+//	status = 200
+//	delay = "200ms"
+//	body = "<html>{{.Host}}</html>"
+//
+//	[route.headers]
+//	Content-Type = "text/html"
+//
+// The body and headers are templates, see httpRouteData for the available fields.
+type httpPersona struct {
+	Name   string       `toml:"name"`
+	Routes []*httpRoute `toml:"route"`
+}
+
+type httpRoute struct {
+	Name string `toml:"name"`
+
+	// Method matches the request method, all methods when empty
+	Method string `toml:"method"`
+
+	// Path is a regular expression matching the path of the request
+	Path string `toml:"path"`
+
+	Status  int               `toml:"status"`
+	Headers map[string]string `toml:"headers"`
+	Body    string            `toml:"body"`
+
+	// Delay delays the response, eg. "500ms"
+	Delay string `toml:"delay"`
+
+	persona string
+
+	path    *regexp.Regexp
+	body    *bannerfmt.BannerFmt
+	headers map[string]*bannerfmt.BannerFmt
+	delay   time.Duration
+}
+
+// httpRouteData is the data the body templates are rendered with.
+type httpRouteData struct {
+	Method string
+	Host   string
+	Path   string
+	URL    string
+	Query  url.Values
+	Header http.Header
+
+	// Server is the configured server header
+	Server string
+
+	// Match contains the submatches of the path expression
+	Match []string
+}
+
+func (r *httpRoute) compile(persona string) error {
+	r.persona = persona
+
+	if r.Name == "" {
+		return fmt.Errorf("route without name")
+	}
+
+	var err error
+
+	if r.path, err = regexp.Compile(r.Path); err != nil {
+		return fmt.Errorf("route %s: invalid path: %s", r.Name, err.Error())
+	}
+
+	if r.body, err = bannerfmt.New(r.Body, nil); err != nil {
+		return fmt.Errorf("route %s: invalid body: %s", r.Name, err.Error())
+	}
+
+	r.headers = map[string]*bannerfmt.BannerFmt{}
+
+	for k, v := range r.Headers {
+		if r.headers[k], err = bannerfmt.New(v, nil); err != nil {
+			return fmt.Errorf("route %s: invalid header %s: %s", r.Name, k, err.Error())
+		}
+	}
+
+	if r.Delay == "" {
+	} else if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+		return fmt.Errorf("route %s: invalid delay: %s", r.Name, err.Error())
+	}
+
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	return nil
+}
+
+func (r *httpRoute) match(req *http.Request) []string {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return nil
+	}
+
+	return r.path.FindStringSubmatch(req.URL.Path)
+}
+
+func (p *httpPersona) compile() error {
+	if p.Name == "" {
+		return fmt.Errorf("persona without name")
+	}
+
+	for _, r := range p.Routes {
+		if err := r.compile(p.Name); err != nil {
+			return fmt.Errorf("persona %s: %s", p.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func decodePersona(data string) (*httpPersona, error) {
+	p := &httpPersona{}
+
+	if _, err := toml.Decode(data, p); err != nil {
+		return nil, err
+	}
+
+	return p, p.compile()
+}
+
+// loadPersonas loads the routes of the persona directory and the built-in
+// personas, the routes of the directory take precedence.
+func (s *httpService) loadPersonas() {
+	s.routes = nil
+
+	if s.PersonaDir != "" {
+		files, err := filepath.Glob(filepath.Join(s.PersonaDir, "*.toml"))
+		if err != nil {
+			log.Errorf("Error reading persona directory %s: %s", s.PersonaDir, err.Error())
+		}
+
+		for _, name := range files {
+			p := &httpPersona{}
+
+			if _, err := toml.DecodeFile(name, p); err != nil {
+				log.Errorf("Error reading persona %s: %s", name, err.Error())
+				continue
+			} else if err := p.compile(); err != nil {
+				log.Errorf("Error reading persona %s: %s", name, err.Error())
+				continue
+			}
+
+			s.routes = append(s.routes, p.Routes...)
+		}
+	}
+
+	for _, name := range s.Personas {
+		data, ok := builtinPersonas[name]
+		if !ok {
+			log.Errorf("Unknown http persona %s", name)
+			continue
+		}
+
+		p, err := decodePersona(data)
+		if err != nil {
+			log.Errorf("Error reading persona %s: %s", name, err.Error())
+			continue
+		}
+
+		s.routes = append(s.routes, p.Routes...)
+	}
+}
+
+// route returns the first route matching the request.
+func (s *httpService) route(req *http.Request) (*httpRoute, []string) {
+	for _, r := range s.routes {
+		if match := r.match(req); match != nil {
+			return r, match
+		}
+	}
+
+	return nil, nil
+}
+
+// response returns the response of the route for the request.
+func (s *httpService) response(req *http.Request, r *httpRoute, match []string) *http.Response {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     http.StatusText(http.StatusOK),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header: http.Header{
+			"Server": []string{s.Server},
+		},
+	}
+
+	if r == nil {
+		return resp
+	}
+
+	resp.StatusCode = r.Status
+	resp.Status = http.StatusText(r.Status)
+
+	data := &httpRouteData{
+		Method: req.Method,
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		URL:    req.URL.String(),
+		Query:  req.URL.Query(),
+		Header: req.Header,
+		Server: s.Server,
+		Match:  match,
+	}
+
+	for k, v := range r.headers {
+		resp.Header.Set(k, v.Format(data))
+	}
+
+	body := r.body.Format(data)
+
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	return resp
+}
@@ -0,0 +1,511 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+// builtinPersonas contains the personas which can be enabled by name, using
+// the personas option of the http service.
+var builtinPersonas = map[string]string{
+	"wordpress":  wordpressPersona,
+	"jenkins":    jenkinsPersona,
+	"confluence": confluencePersona,
+	"exchange":   exchangePersona,
+	"router":     routerPersona,
+}
+
+const wordpressPersona = `
+name = "wordpress"
+
+[[route]]
+name = "wp-login"
+method = "GET"
+path = '^/wp-login\.php$'
+body = '''<!DOCTYPE html>
+<html lang="en-US">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+<title>Log In &lsaquo; Blog &#8212; WordPress</title>
+<link rel='stylesheet' href='http://{{.Host}}/wp-admin/load-styles.php?c=0&amp;dir=ltr&amp;load%5B%5D=dashicons,buttons,forms,l10n,login&amp;ver=5.0.3' type='text/css' media='all' />
+</head>
+<body class="login login-action-login wp-core-ui locale-en-us">
+<div id="login">
+<h1><a href="https://wordpress.org/" title="Powered by WordPress" tabindex="-1">Blog</a></h1>
+<form name="loginform" id="loginform" action="http://{{.Host}}/wp-login.php" method="post">
+<p><label for="user_login">Username or Email Address<br />
+<input type="text" name="log" id="user_login" class="input" value="" size="20" autocapitalize="off" /></label></p>
+<p><label for="user_pass">Password<br />
+<input type="password" name="pwd" id="user_pass" class="input" value="" size="20" /></label></p>
+<p class="submit"><input type="submit" name="wp-submit" id="wp-submit" class="button button-primary button-large" value="Log In" />
+<input type="hidden" name="redirect_to" value="http://{{.Host}}/wp-admin/" />
+<input type="hidden" name="testcookie" value="1" /></p>
+</form>
+</div>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=UTF-8"
+Set-Cookie = "wordpress_test_cookie=WP+Cookie+check; path=/"
+
+[[route]]
+name = "wp-login-attempt"
+method = "POST"
+path = '^/wp-login\.php$'
+delay = "800ms"
+body = '''<!DOCTYPE html>
+<html lang="en-US">
+<head><title>Log In &lsaquo; Blog &#8212; WordPress</title></head>
+<body class="login login-action-login wp-core-ui locale-en-us">
+<div id="login">
+<div id="login_error"><strong>ERROR</strong>: The password you entered is incorrect. <a href="http://{{.Host}}/wp-login.php?action=lostpassword">Lost your password?</a><br /></div>
+</div>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=UTF-8"
+
+[[route]]
+name = "xmlrpc"
+method = "POST"
+path = '^/xmlrpc\.php$'
+body = '''<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse>
+  <fault>
+    <value>
+      <struct>
+        <member><name>faultCode</name><value><int>403</int></value></member>
+        <member><name>faultString</name><value><string>Incorrect username or password.</string></value></member>
+      </struct>
+    </value>
+  </fault>
+</methodResponse>
+'''
+
+[route.headers]
+Content-Type = "text/xml; charset=UTF-8"
+
+[[route]]
+name = "xmlrpc-get"
+path = '^/xmlrpc\.php$'
+status = 405
+body = "XML-RPC server accepts POST requests only."
+
+[route.headers]
+Content-Type = "text/plain;charset=UTF-8"
+Allow = "POST"
+
+[[route]]
+name = "wp-admin"
+path = '^/wp-admin(/.*)?$'
+status = 302
+body = ""
+
+[route.headers]
+Location = "http://{{.Host}}/wp-login.php?redirect_to=http%3A%2F%2F{{.Host}}%2Fwp-admin%2F&reauth=1"
+Content-Type = "text/html; charset=UTF-8"
+
+[[route]]
+name = "wp-json-users"
+path = '^/wp-json/wp/v2/users/?$'
+body = '[{"id":1,"name":"admin","url":"","description":"","link":"http:\/\/{{.Host}}\/author\/admin\/","slug":"admin","avatar_urls":{}}]'
+
+[route.headers]
+Content-Type = "application/json; charset=UTF-8"
+X-WP-Total = "1"
+X-WP-TotalPages = "1"
+
+[[route]]
+name = "readme"
+path = '^/readme\.html$'
+body = '''<!DOCTYPE html>
+<html>
+<head><title>WordPress &#8250; ReadMe</title></head>
+<body>
+<h1 id="logo"><a href="https://wordpress.org/"><img alt="WordPress" src="wp-admin/images/wordpress-logo.png" /></a><br /> Version 5.0.3</h1>
+<p>Semantic Personal Publishing Platform</p>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html"
+
+[[route]]
+name = "wp-plugin"
+path = '^/wp-content/plugins/([^/]+)/'
+status = 403
+body = '''<!DOCTYPE HTML PUBLIC "-//IETF//DTD HTML 2.0//EN">
+<html><head><title>403 Forbidden</title></head><body><h1>Forbidden</h1>
+<p>You don't have permission to access {{.Path}} on this server.</p></body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=iso-8859-1"
+
+[[route]]
+name = "home"
+method = "GET"
+path = '^/$'
+body = '''<!DOCTYPE html>
+<html lang="en-US" class="no-js no-svg">
+<head>
+<meta charset="UTF-8">
+<title>Blog &#8211; Just another WordPress site</title>
+<link rel='https://api.w.org/' href='http://{{.Host}}/wp-json/' />
+<link rel="EditURI" type="application/rsd+xml" title="RSD" href="http://{{.Host}}/xmlrpc.php?rsd" />
+<meta name="generator" content="WordPress 5.0.3" />
+</head>
+<body class="home blog hfeed">
+<h1 class="site-title"><a href="http://{{.Host}}/" rel="home">Blog</a></h1>
+<p class="site-description">Just another WordPress site</p>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=UTF-8"
+Link = "<http://{{.Host}}/wp-json/>; rel=\"https://api.w.org/\""
+`
+
+const jenkinsPersona = `
+name = "jenkins"
+
+[[route]]
+name = "login"
+path = '^/(login)?$'
+body = '''<!DOCTYPE html><html><head resURL="/static/a8e2e7f8" data-rooturl="" data-resurl="/static/a8e2e7f8">
+<title>Sign in [Jenkins]</title>
+<meta name="ROBOTS" content="NOFOLLOW">
+</head>
+<body id="jenkins" class="yui-skin-sam jenkins-2.150.1" data-version="2.150.1">
+<div id="main-panel">
+<div name="login"><form method="post" name="login" action="j_acegi_security_check">
+<input autocorrect="off" autocomplete="off" name="j_username" id="j_username" placeholder="Username" type="text" autofocus="autofocus">
+<input name="j_password" placeholder="Password" type="password">
+<button type="submit" name="Submit" class="submit-button primary ">Sign in</button>
+</form></div>
+</div>
+</body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html;charset=utf-8"
+X-Hudson = "1.395"
+X-Jenkins = "2.150.1"
+X-Jenkins-Session = "8ab7c7d4"
+X-Frame-Options = "sameorigin"
+
+[[route]]
+name = "login-attempt"
+method = "POST"
+path = '^/j_acegi_security_check$'
+status = 302
+delay = "500ms"
+
+[route.headers]
+Location = "http://{{.Host}}/loginError"
+X-Jenkins = "2.150.1"
+
+[[route]]
+name = "api"
+path = '^/api/(json|xml)$'
+body = '{"_class":"hudson.model.Hudson","assignedLabels":[{"name":"master"}],"mode":"NORMAL","nodeDescription":"the master Jenkins node","nodeName":"","numExecutors":2,"description":null,"jobs":[{"_class":"hudson.model.FreeStyleProject","name":"deploy","url":"http://{{.Host}}/job/deploy/","color":"blue"}],"quietingDown":false,"slaveAgentPort":50000,"useCrumbs":true,"useSecurity":true}'
+
+[route.headers]
+Content-Type = "application/json;charset=utf-8"
+X-Jenkins = "2.150.1"
+
+[[route]]
+name = "script-console"
+path = '^/(script|scriptText|computer/.*/script)$'
+status = 403
+body = '''<html><head><meta http-equiv='refresh' content='1;url=/login?from=%2Fscript'/></head><body style='background-color:white; color:white;'>
+Authentication required
+</body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html;charset=utf-8"
+X-Jenkins = "2.150.1"
+
+[[route]]
+name = "descriptor-rce"
+path = '^/(securityRealm/user/[^/]+/)?descriptorByName/'
+body = ""
+
+[route.headers]
+Content-Type = "text/html;charset=utf-8"
+X-Jenkins = "2.150.1"
+
+[[route]]
+name = "cli"
+path = '^/cli$'
+status = 403
+body = ""
+
+[route.headers]
+X-Jenkins = "2.150.1"
+X-Jenkins-CLI-Port = "50000"
+`
+
+const confluencePersona = `
+name = "confluence"
+
+[[route]]
+name = "ognl-injection"
+path = '\$\{'
+status = 302
+
+[route.headers]
+Location = "/login.action?os_destination=%2Findex.action"
+X-Confluence-Request-Time = "1548403812310"
+
+[[route]]
+name = "home"
+path = '^/$'
+status = 302
+
+[route.headers]
+Location = "http://{{.Host}}/login.action?os_destination=%2Findex.action&permissionViolation=true"
+
+[[route]]
+name = "login"
+path = '^/login\.action$'
+body = '''<!DOCTYPE html>
+<html>
+<head>
+<title>Log In - Confluence</title>
+<meta name="ajs-version-number" content="6.12.1">
+<meta name="ajs-build-number" content="7901">
+<meta name="ajs-context-path" content="">
+</head>
+<body id="com-atlassian-confluence" class="login aui-layout aui-theme-default">
+<form name="loginform" method="POST" action="/dologin.action" class="aui login-form-container">
+<input type="text" name="os_username" id="os_username" class="text" data-focus="0" placeholder="Username">
+<input type="password" name="os_password" id="os_password" class="password" placeholder="Password">
+<input id="loginButton" class="aui-button aui-button-primary" name="login" type="submit" value="Log in">
+</form>
+<div id="footer-build-information">Powered by Atlassian Confluence 6.12.1</div>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html;charset=UTF-8"
+X-ASEN = "SEN-L13211234"
+X-Confluence-Request-Time = "1548403812310"
+
+[[route]]
+name = "widget-connector"
+method = "POST"
+path = '^/rest/tinymce/1/macro/preview$'
+body = '<div class="wiki-content"></div>'
+
+[route.headers]
+Content-Type = "text/html;charset=UTF-8"
+
+[[route]]
+name = "createpage-entervariables"
+path = '^/pages/(createpage-entervariables|doenterpagevariables|createpage)\.action$'
+body = '''<!DOCTYPE html>
+<html><head><title>Create Page - Confluence</title><meta name="ajs-version-number" content="6.12.1"></head>
+<body id="com-atlassian-confluence"><form method="POST" action="/pages/doenterpagevariables.action" name="entervariables"></form></body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html;charset=UTF-8"
+
+[[route]]
+name = "setup"
+path = '^/setup/'
+status = 302
+
+[route.headers]
+Location = "http://{{.Host}}/login.action"
+`
+
+const exchangePersona = `
+name = "exchange"
+
+[[route]]
+name = "owa"
+path = '^/(owa/?)?$'
+status = 302
+
+[route.headers]
+Location = "https://{{.Host}}/owa/auth/logon.aspx?url=https%3a%2f%2f{{.Host}}%2fowa%2f&reason=0"
+X-OWA-Version = "15.1.2176.2"
+X-FEServer = "EXCH01"
+
+[[route]]
+name = "owa-logon"
+method = "GET"
+path = '^/owa/auth/logon\.aspx$'
+body = '''<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN">
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; CHARSET=utf-8">
+<title>Outlook</title>
+<link rel="shortcut icon" href="/owa/auth/15.1.2176/themes/resources/favicon.ico" type="image/x-icon">
+</head>
+<body class="signInBg">
+<form action="/owa/auth.owa" method="POST" name="logonForm" enctype="application/x-www-form-urlencoded" autocomplete="off">
+<input type="hidden" name="destination" value="https://{{.Host}}/owa/">
+<input type="hidden" name="flags" value="4">
+<input id="username" name="username" placeholder="domain\user name" type="text">
+<input id="password" name="password" placeholder="password" type="password">
+<div class="signinbutton" role="button" tabIndex="0"><span class="signinTxt">sign in</span></div>
+</form>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=utf-8"
+X-OWA-Version = "15.1.2176.2"
+X-FEServer = "EXCH01"
+X-AspNet-Version = "4.0.30319"
+X-Powered-By = "ASP.NET"
+
+[[route]]
+name = "owa-auth"
+method = "POST"
+path = '^/owa/auth\.owa$'
+status = 302
+delay = "1s"
+
+[route.headers]
+Location = "https://{{.Host}}/owa/auth/logon.aspx?url=https%3a%2f%2f{{.Host}}%2fowa%2f&reason=2"
+X-OWA-Version = "15.1.2176.2"
+
+[[route]]
+name = "autodiscover"
+path = '(?i)^/autodiscover/autodiscover\.(json|xml)$'
+status = 401
+body = ""
+
+[route.headers]
+WWW-Authenticate = "Negotiate"
+X-FEServer = "EXCH01"
+X-Powered-By = "ASP.NET"
+
+[[route]]
+name = "ecp"
+path = '(?i)^/ecp/'
+status = 302
+
+[route.headers]
+Location = "https://{{.Host}}/owa/auth/logon.aspx?url=https%3a%2f%2f{{.Host}}%2fecp%2f&reason=0"
+X-FEServer = "EXCH01"
+
+[[route]]
+name = "ews"
+path = '(?i)^/(ews|mapi|rpc|oab|powershell)/'
+status = 401
+body = ""
+
+[route.headers]
+WWW-Authenticate = "Negotiate"
+X-FEServer = "EXCH01"
+`
+
+const routerPersona = `
+name = "router"
+
+[[route]]
+name = "home"
+method = "GET"
+path = '^/(index\.html?|login\.html?)?$'
+body = '''<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>Wireless Router</title>
+</head>
+<body>
+<form name="login" method="post" action="/boaform/admin/formLogin">
+<table>
+<tr><td>Username:</td><td><input type="text" name="username" maxlength="30"></td></tr>
+<tr><td>Password:</td><td><input type="password" name="psd" maxlength="30"></td></tr>
+<tr><td colspan="2"><input type="submit" value="Login"></td></tr>
+</table>
+</form>
+</body>
+</html>
+'''
+
+[route.headers]
+Content-Type = "text/html"
+
+[[route]]
+name = "login-attempt"
+method = "POST"
+path = '^/boaform/admin/formLogin$'
+delay = "300ms"
+body = '''<html><body><script>alert("Login failed, the username or password is incorrect!");location.href="/";</script></body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html"
+
+[[route]]
+name = "gpon-diag"
+path = '^/GponForm/diag_Form$'
+body = ""
+
+[route.headers]
+Content-Type = "text/html"
+
+[[route]]
+name = "hnap"
+path = '^/HNAP1/?$'
+body = '''<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+<soap:Body>
+<GetDeviceSettingsResponse xmlns="http://purenetworks.com/HNAP1/">
+<GetDeviceSettingsResult>OK</GetDeviceSettingsResult>
+<Type>GatewayWithWiFi</Type>
+<DeviceName>DIR-868L</DeviceName>
+<VendorName>D-Link</VendorName>
+<ModelDescription>Wireless AC1750 Dual Band Gigabit Cloud Router</ModelDescription>
+<ModelName>DIR-868L</ModelName>
+<FirmwareVersion>2.03</FirmwareVersion>
+</GetDeviceSettingsResponse>
+</soap:Body>
+</soap:Envelope>
+'''
+
+[route.headers]
+Content-Type = "text/xml; charset=utf-8"
+
+[[route]]
+name = "luci"
+path = '^/cgi-bin/luci'
+status = 403
+body = '''<html><head><title>LuCI - Lua Configuration Interface</title></head>
+<body><form method="post" action="/cgi-bin/luci"><input name="luci_username" type="text"><input name="luci_password" type="password"></form></body></html>
+'''
+
+[route.headers]
+Content-Type = "text/html; charset=utf-8"
+
+[[route]]
+name = "cgi"
+path = '^/(setup\.cgi|shell|apply\.cgi|tmUnblock\.cgi|cgi-bin/.*)$'
+body = ""
+
+[route.headers]
+Content-Type = "text/html"
+`
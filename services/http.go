@@ -19,14 +19,15 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/rs/xid"
+	"golang.org/x/net/http2"
 )
 
 var (
@@ -37,7 +38,8 @@ var (
 func HTTP(options ...ServicerFunc) Servicer {
 	s := &httpService{
 		httpServiceConfig: httpServiceConfig{
-			Server: "Apache",
+			Server:      "Apache",
+			MaxBodySize: 10 * 1024 * 1024,
 		},
 	}
 
@@ -45,16 +47,29 @@ func HTTP(options ...ServicerFunc) Servicer {
 		o(s)
 	}
 
+	s.loadPersonas()
+
 	return s
 }
 
 type httpServiceConfig struct {
 	Server string `toml:"server"`
+
+	// MaxBodySize is the maximum size of the request body that is captured
+	MaxBodySize int64 `toml:"max-body-size"`
+
+	// Personas contains the names of the built-in personas to emulate
+	Personas []string `toml:"personas"`
+
+	// PersonaDir is a directory containing persona definitions (*.toml)
+	PersonaDir string `toml:"persona-dir"`
 }
 
 type httpService struct {
 	httpServiceConfig
 
+	routes []*httpRoute
+
 	c pushers.Channel
 }
 
@@ -77,6 +92,8 @@ func (s *httpService) CanHandle(payload []byte) bool {
 		return true
 	} else if bytes.HasPrefix(payload, []byte("OPTIONS")) {
 		return true
+	} else if bytes.HasPrefix(payload, []byte("PRI * HTTP/2.0")) {
+		return true
 	}
 
 	return false
@@ -102,12 +119,72 @@ func Cookies(cookies []*http.Cookie) event.Option {
 	}
 }
 
+// connOptions returns the event options of the connection.
+func (s *httpService) connOptions(conn net.Conn, id xid.ID) []event.Option {
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	return []event.Option{
+		EventOptions,
+		connOptions,
+		event.Category("http"),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("http.sessionid", id.String()),
+	}
+}
+
+// serve sends the request event and returns the response of the route
+// matching the request.
+func (s *httpService) serve(ctx context.Context, req *http.Request, body []byte, size int64, options ...event.Option) (*http.Response, error) {
+	route, match := s.route(req)
+
+	if route != nil {
+		options = append(options,
+			event.Custom("http.persona", route.persona),
+			event.Custom("http.route", route.Name),
+		)
+	}
+
+	s.c.Send(event.New(
+		append(options,
+			event.Type("request"),
+			event.Custom("http.method", req.Method),
+			event.Custom("http.proto", req.Proto),
+			event.Custom("http.host", req.Host),
+			event.Custom("http.url", req.URL.String()),
+			event.NewWith(bodyOptions(s.c, req, body, size, options...)...),
+			Headers(req.Header),
+			Cookies(req.Cookies()),
+		)...,
+	))
+
+	if route != nil && route.delay > 0 {
+		select {
+		case <-time.After(route.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return s.response(req, route, match), nil
+}
+
 func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
-	for {
-		br := bufio.NewReader(conn)
+	br := bufio.NewReader(conn)
+
+	// the preface is longer than the shortest http/1 request, peeking its
+	// first bytes won't block http/1 clients
+	if prefix, err := br.Peek(4); err == nil && string(prefix) == http2.ClientPreface[:4] {
+		return s.handleHTTP2(ctx, conn, br, id, nil, nil)
+	}
 
+	for {
 		req, err := http.ReadRequest(br)
 		if err == io.EOF {
 			return nil
@@ -117,51 +194,25 @@ func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 
 		defer req.Body.Close()
 
-		body := make([]byte, 1024)
+		body, size, err := readBody(req.Body, s.MaxBodySize)
+		if err != nil {
+			return err
+		}
 
-		n, err := req.Body.Read(body)
-		if err == io.EOF {
+		resp, err := s.serve(ctx, req, body, size, s.connOptions(conn, id)...)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil
 		} else if err != nil {
 			return err
 		}
 
-		body = body[:n]
+		if !isHTTP2Upgrade(req) {
+		} else if settings, err := decodeHTTP2Settings(req.Header.Get("HTTP2-Settings")); err == nil {
+			if _, err := io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+				return err
+			}
 
-		io.Copy(ioutil.Discard, req.Body)
-
-		var connOptions event.Option = nil
-
-		if ec, ok := conn.(*event.Conn); ok {
-			connOptions = ec.Options()
-		}
-
-		s.c.Send(event.New(
-			EventOptions,
-			connOptions,
-			event.Category("http"),
-			event.Type("request"),
-			event.SourceAddr(conn.RemoteAddr()),
-			event.DestinationAddr(conn.LocalAddr()),
-			event.Custom("http.sessionid", id.String()),
-			event.Custom("http.method", req.Method),
-			event.Custom("http.proto", req.Proto),
-			event.Custom("http.host", req.Host),
-			event.Custom("http.url", req.URL.String()),
-			event.Payload(body),
-			Headers(req.Header),
-			Cookies(req.Cookies()),
-		))
-
-		resp := http.Response{
-			StatusCode: http.StatusOK,
-			Status:     http.StatusText(http.StatusOK),
-			Proto:      req.Proto,
-			ProtoMajor: req.ProtoMajor,
-			ProtoMinor: req.ProtoMinor,
-			Request:    req,
-			Header: http.Header{
-				"Server": []string{s.Server},
-			},
+			return s.handleHTTP2(ctx, conn, br, id, settings, resp)
 		}
 
 		if err := resp.Write(conn); err != nil {
@@ -0,0 +1,404 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/rs/xid"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	// maxConcurrentStreams is the number of concurrent streams announced to
+	// the client
+	maxConcurrentStreams = 100
+
+	// maxResets is the number of streams a client can reset on a single
+	// connection, more resets are reported as rapid reset attack and the
+	// connection is closed
+	maxResets = 100
+)
+
+// http2Stream is a request of which the body is being received.
+type http2Stream struct {
+	req  *http.Request
+	body []byte
+	size int64
+}
+
+// http2Settings returns the settings as event value.
+func http2Settings(settings map[http2.SettingID]uint32) map[string]uint32 {
+	m := map[string]uint32{}
+
+	for id, v := range settings {
+		m[id.String()] = v
+	}
+
+	return m
+}
+
+// decodeHTTP2Settings decodes the HTTP2-Settings header of a h2c upgrade.
+func decodeHTTP2Settings(s string) (map[http2.SettingID]uint32, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data)%6 != 0 {
+		return nil, fmt.Errorf("invalid settings length %d", len(data))
+	}
+
+	settings := map[http2.SettingID]uint32{}
+
+	for ; len(data) > 0; data = data[6:] {
+		settings[http2.SettingID(binary.BigEndian.Uint16(data))] = binary.BigEndian.Uint32(data[2:])
+	}
+
+	return settings, nil
+}
+
+// isHTTP2Upgrade returns whether the request is an h2c upgrade request.
+func isHTTP2Upgrade(req *http.Request) bool {
+	if _, ok := req.Header["Http2-Settings"]; !ok {
+		return false
+	}
+
+	for _, v := range strings.Split(req.Header.Get("Upgrade"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "h2c") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleHTTP2 serves a HTTP/2 connection, starting with the client
+// preface. The response of an h2c upgrade request is sent on stream 1.
+func (s *httpService) handleHTTP2(ctx context.Context, conn net.Conn, br *bufio.Reader, id xid.ID, settings map[http2.SettingID]uint32, upgrade *http.Response) error {
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(br, preface); err != nil {
+		return err
+	} else if string(preface) != http2.ClientPreface {
+		return fmt.Errorf("invalid http2 preface")
+	}
+
+	if settings == nil {
+		settings = map[http2.SettingID]uint32{}
+	}
+
+	framer := http2.NewFramer(conn, br)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	framer.MaxHeaderListSize = 1 << 20
+
+	if err := framer.WriteSettings(http2.Setting{
+		ID:  http2.SettingMaxConcurrentStreams,
+		Val: maxConcurrentStreams,
+	}); err != nil {
+		return err
+	}
+
+	if upgrade != nil {
+		if err := s.writeHTTP2Response(framer, 1, upgrade); err != nil {
+			return err
+		}
+	}
+
+	streams := map[uint32]*http2Stream{}
+
+	// buffered is the size of the bodies of all streams, which together
+	// are limited to the maximum body size
+	var buffered int64
+
+	release := func(streamID uint32) {
+		if stream, ok := streams[streamID]; ok {
+			buffered -= int64(len(stream.body))
+			delete(streams, streamID)
+		}
+	}
+
+	resets := 0
+
+	for {
+		f, err := framer.ReadFrame()
+		if err == io.EOF {
+			return nil
+		} else if ce, ok := err.(http2.ConnectionError); ok {
+			framer.WriteGoAway(0, http2.ErrCode(ce), nil)
+			return err
+		} else if se, ok := err.(http2.StreamError); ok {
+			release(se.StreamID)
+
+			if err := framer.WriteRSTStream(se.StreamID, se.Code); err != nil {
+				return err
+			}
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() {
+				continue
+			}
+
+			f.ForeachSetting(func(setting http2.Setting) error {
+				settings[setting.ID] = setting.Val
+				return nil
+			})
+
+			if err := framer.WriteSettingsAck(); err != nil {
+				return err
+			}
+		case *http2.MetaHeadersFrame:
+			req, err := http2Request(f)
+			if err != nil {
+				if err := framer.WriteRSTStream(f.StreamID, http2.ErrCodeProtocol); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			stream := &http2Stream{
+				req: req,
+			}
+
+			if f.StreamEnded() {
+			} else if len(streams) >= maxConcurrentStreams {
+				// the client ignores the announced limit
+				if err := framer.WriteRSTStream(f.StreamID, http2.ErrCodeRefusedStream); err != nil {
+					return err
+				}
+
+				continue
+			} else {
+				streams[f.StreamID] = stream
+				continue
+			}
+
+			if err := s.serveHTTP2(ctx, conn, framer, id, f.StreamID, stream, settings); err != nil {
+				return err
+			}
+		case *http2.DataFrame:
+			stream, ok := streams[f.StreamID]
+			if !ok {
+				continue
+			}
+
+			data := f.Data()
+
+			remaining := s.MaxBodySize - int64(len(stream.body))
+			if r := s.MaxBodySize - buffered; r < remaining {
+				remaining = r
+			}
+
+			if remaining > int64(len(data)) {
+				remaining = int64(len(data))
+			}
+
+			if remaining > 0 {
+				stream.body = append(stream.body, data[:remaining]...)
+				buffered += remaining
+			}
+
+			stream.size += int64(len(data))
+
+			if f.StreamEnded() {
+				release(f.StreamID)
+
+				if err := s.serveHTTP2(ctx, conn, framer, id, f.StreamID, stream, settings); err != nil {
+					return err
+				}
+			} else if n := uint32(f.Length); n > 0 {
+				// keep the client sending, the body is discarded
+				// after the maximum body size
+				if err := framer.WriteWindowUpdate(f.StreamID, n); err != nil {
+					return err
+				}
+			}
+
+			if n := uint32(f.Length); n > 0 {
+				if err := framer.WriteWindowUpdate(0, n); err != nil {
+					return err
+				}
+			}
+		case *http2.RSTStreamFrame:
+			release(f.StreamID)
+
+			resets++
+
+			if resets < maxResets {
+				continue
+			}
+
+			s.c.Send(event.New(
+				append(s.connOptions(conn, id),
+					event.Type("rapid-reset"),
+					event.Custom("http.proto", "HTTP/2.0"),
+					event.Custom("http.resets", resets),
+					event.Custom("http.settings", http2Settings(settings)),
+				)...,
+			))
+
+			framer.WriteGoAway(f.StreamID, http2.ErrCodeEnhanceYourCalm, nil)
+			return nil
+		case *http2.PingFrame:
+			if f.IsAck() {
+				continue
+			}
+
+			if err := framer.WritePing(true, f.Data); err != nil {
+				return err
+			}
+		case *http2.GoAwayFrame:
+			return nil
+		}
+	}
+}
+
+// http2Request returns the request of the headers frame.
+func http2Request(f *http2.MetaHeadersFrame) (*http.Request, error) {
+	method := f.PseudoValue("method")
+	path := f.PseudoValue("path")
+	authority := f.PseudoValue("authority")
+
+	if method == "" || (path == "" && method != http.MethodConnect) {
+		return nil, fmt.Errorf("missing pseudo headers")
+	}
+
+	header := http.Header{}
+
+	for _, hf := range f.RegularFields() {
+		header.Add(http.CanonicalHeaderKey(hf.Name), hf.Value)
+	}
+
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+
+	u := &url.URL{
+		Path: "/",
+	}
+
+	if path != "" {
+		var err error
+		if u, err = url.ParseRequestURI(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		ProtoMinor: 0,
+		Header:     header,
+		Host:       authority,
+		RequestURI: path,
+		Body:       http.NoBody,
+	}, nil
+}
+
+// serveHTTP2 sends the request event of the stream and writes the response.
+func (s *httpService) serveHTTP2(ctx context.Context, conn net.Conn, framer *http2.Framer, id xid.ID, streamID uint32, stream *http2Stream, settings map[http2.SettingID]uint32) error {
+	options := append(s.connOptions(conn, id),
+		event.Custom("http.stream-id", streamID),
+		event.Custom("http.settings", http2Settings(settings)),
+	)
+
+	resp, err := s.serve(ctx, stream.req, stream.body, stream.size, options...)
+	if err != nil {
+		return err
+	}
+
+	return s.writeHTTP2Response(framer, streamID, resp)
+}
+
+// writeHTTP2Response writes the headers and body of the response on the
+// stream.
+func (s *httpService) writeHTTP2Response(framer *http2.Framer, streamID uint32, resp *http.Response) error {
+	var body []byte
+
+	if resp.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+
+		resp.Body.Close()
+	}
+
+	buf := &bytes.Buffer{}
+
+	enc := hpack.NewEncoder(buf)
+	enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(resp.StatusCode)})
+
+	for k, vv := range resp.Header {
+		k = strings.ToLower(k)
+
+		switch k {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "content-length":
+			// connection specific headers are not allowed in http2
+			continue
+		}
+
+		for _, v := range vv {
+			enc.WriteField(hpack.HeaderField{Name: k, Value: v})
+		}
+	}
+
+	enc.WriteField(hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(body))})
+
+	if err := framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: buf.Bytes(),
+		EndStream:     len(body) == 0,
+		EndHeaders:    true,
+	}); err != nil {
+		return err
+	}
+
+	for len(body) > 0 {
+		n := len(body)
+		if n > 16384 {
+			n = 16384
+		}
+
+		if err := framer.WriteData(streamID, n == len(body), body[:n]); err != nil {
+			return err
+		}
+
+		body = body[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// tcpPipe returns a connected loopback tcp connection pair, unlike
+// net.Pipe the connections are buffered.
+func tcpPipe() (net.Conn, net.Conn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server, err := l.Accept()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+
+	return server, client, nil
+}
+
+// h2c returns a http client speaking HTTP/2 with prior knowledge to the
+// service.
+func h2c(s Servicer) *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				server, client, err := tcpPipe()
+				if err != nil {
+					return nil, err
+				}
+
+				go s.Handle(context.TODO(), server)
+
+				return client, nil
+			},
+		},
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	defer withArtifacts(t)()
+
+	s := HTTP().(*httpService)
+	s.Personas = []string{"wordpress"}
+	s.loadPersonas()
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	resp, err := h2c(s).Post("http://example.com/xmlrpc.php", "text/xml", strings.NewReader("<methodCall/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 || !bytes.Contains(body, []byte("Incorrect username or password")) {
+		t.Errorf("Unexpected response %s: %s", resp.Proto, body)
+	}
+
+	e, ok := c.First("request")
+	if !ok {
+		t.Fatal("Expected request event")
+	}
+
+	if e.Get("http.proto") != "HTTP/2.0" || e.Get("http.method") != "POST" || e.Get("http.host") != "example.com" {
+		t.Errorf("Unexpected request event %s %s %s", e.Get("http.proto"), e.Get("http.method"), e.Get("http.host"))
+	}
+
+	if e.Get("http.route") != "xmlrpc" || e.Get("payload") != "<methodCall/>" {
+		t.Errorf("Unexpected route %s or payload %s", e.Get("http.route"), e.Get("payload"))
+	}
+
+	// client initiated streams have odd ids
+	if id, ok := value(e, "http.stream-id").(uint32); !ok || id%2 != 1 {
+		t.Errorf("Unexpected stream id %v", value(e, "http.stream-id"))
+	}
+
+	if _, ok := value(e, "http.settings").(map[string]uint32)["INITIAL_WINDOW_SIZE"]; !ok {
+		t.Errorf("Expected client settings, got %v", value(e, "http.settings"))
+	}
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	defer withArtifacts(t)()
+
+	s := HTTP()
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	server, client, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	go io.WriteString(client, "GET /index.html HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n")
+
+	br := bufio.NewReader(client)
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected switching protocols, got %s", resp.Status)
+	}
+
+	framer := http2.NewFramer(client, br)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	go func() {
+		io.WriteString(client, http2.ClientPreface)
+		framer.WriteSettings()
+	}()
+
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f, ok := f.(*http2.MetaHeadersFrame); ok {
+			if f.StreamID != 1 || f.PseudoValue("status") != "200" {
+				t.Errorf("Unexpected response on stream %d: %s", f.StreamID, f.PseudoValue("status"))
+			}
+
+			break
+		}
+	}
+
+	e, _ := c.First("request")
+
+	if e.Get("http.url") != "/index.html" || value(e, "http.header.upgrade") == nil {
+		t.Errorf("Unexpected upgrade request %s", e.Get("http.url"))
+	}
+}
+
+func TestHTTP2RapidReset(t *testing.T) {
+	defer withArtifacts(t)()
+
+	s := HTTP()
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	server, client, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	done := make(chan error)
+
+	go func() {
+		done <- s.Handle(context.TODO(), server)
+	}()
+
+	framer := http2.NewFramer(client, client)
+
+	// drain the responses of the server
+	go func() {
+		for {
+			if _, err := framer.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}()
+
+	io.WriteString(client, http2.ClientPreface)
+	framer.WriteSettings()
+
+	buf := &bytes.Buffer{}
+
+	enc := hpack.NewEncoder(buf)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+	enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "http"})
+	enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "example.com"})
+	enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+
+	for i := uint32(0); i < maxResets; i++ {
+		streamID := 2*i + 1
+
+		if err := framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      streamID,
+			BlockFragment: buf.Bytes(),
+			EndStream:     true,
+			EndHeaders:    true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := framer.WriteRSTStream(streamID, http2.ErrCodeCancel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if e, ok := c.First("rapid-reset"); !ok {
+		t.Errorf("Expected rapid-reset event")
+	} else if value(e, "http.resets") != maxResets {
+		t.Errorf("Unexpected resets %v", value(e, "http.resets"))
+	}
+}
+
+func TestHTTP2RefusedStream(t *testing.T) {
+	s := HTTP()
+	s.SetChannel(&pushertest.Channel{})
+
+	server, client, err := tcpPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	framer := http2.NewFramer(client, client)
+
+	io.WriteString(client, http2.ClientPreface)
+	framer.WriteSettings()
+
+	buf := &bytes.Buffer{}
+
+	enc := hpack.NewEncoder(buf)
+	enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"})
+	enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "http"})
+	enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "example.com"})
+	enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+
+	// the streams are kept open, waiting for their bodies
+	refused := uint32(2*maxConcurrentStreams + 1)
+
+	for streamID := uint32(1); streamID <= refused; streamID += 2 {
+		if err := framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      streamID,
+			BlockFragment: buf.Bytes(),
+			EndHeaders:    true,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f, ok := f.(*http2.RSTStreamFrame); !ok {
+			continue
+		} else if f.StreamID != refused || f.ErrCode != http2.ErrCodeRefusedStream {
+			t.Fatalf("Unexpected reset of stream %d: %s", f.StreamID, f.ErrCode)
+		}
+
+		break
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+)
+
+func value(e event.Event, key string) interface{} {
+	var v interface{}
+
+	e.Range(func(k, val interface{}) bool {
+		if k == key {
+			v = val
+			return false
+		}
+
+		return true
+	})
+
+	return v
+}
+
+func withArtifacts(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := artifacts.New(artifacts.WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts.SetDefault(store)
+
+	return func() {
+		artifacts.SetDefault(nil)
+		os.RemoveAll(dir)
+	}
+}
+
+// request sends the request to the http service and returns the events.
+func request(t *testing.T, s Servicer, req *http.Request) *pushertest.Channel {
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	go req.Write(client)
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	return c
+}
+
+func TestHTTPMultipart(t *testing.T) {
+	defer withArtifacts(t)()
+
+	body := &bytes.Buffer{}
+
+	mw := multipart.NewWriter(body)
+	mw.WriteField("action", "upload")
+
+	fw, _ := mw.CreateFormFile("file", "shell.php")
+	fw.Write([]byte("<?php system($_GET['c']); ?>"))
+
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "http://localhost/upload.php", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	c := request(t, HTTP(), req)
+
+	e, ok := c.First("request")
+	if !ok {
+		t.Fatal("Expected request event")
+	}
+
+	if v := value(e, "http.form.action"); !reflect.DeepEqual(v, []string{"upload"}) {
+		t.Errorf("Unexpected form field %v", v)
+	}
+
+	files, ok := value(e, "http.files").([]map[string]interface{})
+	if !ok || len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %v", value(e, "http.files"))
+	}
+
+	if files[0]["filename"] != "shell.php" || files[0]["size"] != int64(28) {
+		t.Errorf("Unexpected file %v", files[0])
+	}
+
+	if e, ok := c.First("artifact"); !ok {
+		t.Errorf("Expected artifact event for uploaded file")
+	} else if e.Get("artifact.sha256") != files[0]["sha256"] || e.Get("http.sessionid") == "" {
+		t.Errorf("Unexpected artifact event")
+	}
+}
+
+func TestHTTPBody(t *testing.T) {
+	defer withArtifacts(t)()
+
+	s := HTTP().(*httpService)
+	s.MaxBodySize = 2048
+
+	data := strings.Repeat("A", 4096)
+
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	c := request(t, s, req)
+
+	e, _ := c.First("request")
+
+	if value(e, "http.body.size") != int64(4096) || value(e, "http.body.truncated") != true {
+		t.Errorf("Expected truncated body of 4096 bytes")
+	}
+
+	if len(e.Get("payload")) != 1024 {
+		t.Errorf("Expected payload of 1024 bytes, got %d", len(e.Get("payload")))
+	}
+
+	f, err := artifacts.Default().Open(e.Get("http.body.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if stored, _ := ioutil.ReadAll(f); string(stored) != data[:2048] {
+		t.Errorf("Expected the captured body to be stored, got %d bytes", len(stored))
+	}
+}
+
+func TestHTTPForm(t *testing.T) {
+	defer withArtifacts(t)()
+
+	req, _ := http.NewRequest("POST", "http://localhost/login", strings.NewReader("user=admin&pass=admin&pass=root"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	e, _ := request(t, HTTP(), req).First("request")
+
+	if v := value(e, "http.form.pass"); !reflect.DeepEqual(v, []string{"admin", "root"}) {
+		t.Errorf("Unexpected form field %v", v)
+	}
+
+	req, _ = http.NewRequest("POST", "http://localhost/api", strings.NewReader(`{"user": {"name": "admin"}, "debug": true}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	e, _ = request(t, HTTP(), req).First("request")
+
+	if v := value(e, "http.form.user.name"); v != "admin" {
+		t.Errorf("Unexpected json field %v", v)
+	}
+
+	if v := value(e, "http.form.debug"); v != true {
+		t.Errorf("Unexpected json field %v", v)
+	}
+}
+
+func TestHTTPPersona(t *testing.T) {
+	defer withArtifacts(t)()
+
+	dir, err := ioutil.TempDir("", "personas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	persona := `
+name = "custom"
+
+[[route]]
+name = "admin"
+method = "GET"
+path = '^/admin/(\w+)$'
+status = 401
+body = "{{index .Match 1}} on {{.Host}}"
+
+[route.headers]
+WWW-Authenticate = "Basic realm=\"{{.Host}}\""
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "custom.toml"), []byte(persona), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := HTTP().(*httpService)
+	s.PersonaDir = dir
+	s.Personas = []string{"wordpress"}
+	s.loadPersonas()
+
+	for _, tc := range []struct {
+		method, url string
+		persona     string
+		route       string
+		status      int
+		header      string
+		body        string
+	}{
+		{"GET", "http://example.com/admin/users", "custom", "admin", 401, `Basic realm="example.com"`, "users on example.com"},
+		{"GET", "http://example.com/wp-login.php", "wordpress", "wp-login", 200, "", `action="http://example.com/wp-login.php"`},
+		{"GET", "http://example.com/wp-admin/", "wordpress", "wp-admin", 302, "", ""},
+		{"GET", "http://example.com/unknown", "", "", 200, "", ""},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+
+		c := &pushertest.Channel{}
+		s.SetChannel(c)
+
+		server, client := net.Pipe()
+
+		go s.Handle(context.TODO(), server)
+		go req.Write(client)
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		client.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.url, tc.status, resp.StatusCode)
+		}
+
+		if tc.header != "" && resp.Header.Get("WWW-Authenticate") != tc.header {
+			t.Errorf("%s: unexpected header %q", tc.url, resp.Header.Get("WWW-Authenticate"))
+		}
+
+		if !strings.Contains(string(body), tc.body) {
+			t.Errorf("%s: unexpected body %q", tc.url, body)
+		}
+
+		e, _ := c.First("request")
+
+		if e.Get("http.persona") != tc.persona || e.Get("http.route") != tc.route {
+			t.Errorf("%s: expected route %s/%s, got %s/%s", tc.url, tc.persona, tc.route, e.Get("http.persona"), e.Get("http.route"))
+		}
+	}
+}
+
+func TestBuiltinPersonas(t *testing.T) {
+	for name, data := range builtinPersonas {
+		p, err := decodePersona(data)
+		if err != nil {
+			t.Errorf("Error decoding persona %s: %s", name, err.Error())
+		} else if p.Name != name {
+			t.Errorf("Expected persona %s, got %s", name, p.Name)
+		}
+	}
+}
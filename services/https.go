@@ -15,18 +15,12 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"fmt"
-	"math/big"
 	"net"
-	"sync"
-	"time"
 
+	"github.com/honeytrap/honeytrap/certificates"
 	"github.com/honeytrap/honeytrap/event"
 	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+	"github.com/honeytrap/honeytrap/services/tlshello"
 
 	"github.com/honeytrap/honeytrap/pushers"
 )
@@ -39,19 +33,19 @@ func HTTPS(options ...ServicerFunc) Servicer {
 	s := &httpsService{
 		httpService: httpService{
 			httpServiceConfig: httpServiceConfig{
-				Server: "Apache",
+				Server:      "Apache",
+				MaxBodySize: 10 * 1024 * 1024,
 			},
 		},
 		tlsConfig: &tls.Config{},
-		n:         0,
-		m:         sync.Mutex{},
-		cache:     map[string]*tls.Certificate{},
 	}
 
 	for _, o := range options {
 		o(s)
 	}
 
+	s.httpService.loadPersonas()
+
 	return s
 }
 
@@ -62,105 +56,31 @@ type httpsService struct {
 
 	c pushers.Channel
 
-	n int64
-
-	m sync.Mutex
-
-	cache map[string]*tls.Certificate
+	// Certificate is the subject of the issued certificates
+	Certificate certificates.Subject `toml:"certificate"`
 }
 
 func (s *httpsService) SetChannel(c pushers.Channel) {
 	s.c = c
-}
-
-func (s *httpsService) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	s.m.Lock()
-	defer s.m.Unlock()
-
-	if cert, ok := s.cache[hello.ServerName]; ok {
-		return cert, nil
-	}
-
-	s.n++
-
-	ca := &x509.Certificate{
-		SerialNumber: big.NewInt(s.n),
-		Subject: pkix.Name{
-			Country:            []string{""},
-			Organization:       []string{""},
-			OrganizationalUnit: []string{""},
-		},
-		Issuer: pkix.Name{
-			Country:            []string{""},
-			Organization:       []string{""},
-			OrganizationalUnit: []string{""},
-			Locality:           []string{""},
-			Province:           []string{""},
-			StreetAddress:      []string{""},
-			PostalCode:         []string{""},
-			SerialNumber:       fmt.Sprintf("%d", 0),
-			CommonName:         hello.ServerName,
-		},
-		SignatureAlgorithm:    x509.SHA512WithRSA,
-		PublicKeyAlgorithm:    x509.ECDSA,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(1, 0, 0),
-		SubjectKeyId:          []byte{},
-		BasicConstraintsValid: true,
-		IsCA:        false,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-	}
-
-	priv, _ := rsa.GenerateKey(rand.Reader, 4096)
-
-	pub := &priv.PublicKey
-
-	certBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, pub, priv)
-	if err != nil {
-		return nil, err
-	}
-
-	cert := &tls.Certificate{
-		Certificate: [][]byte{certBytes},
-		PrivateKey:  priv,
-	}
-
-	s.cache[hello.ServerName] = cert
-
-	return cert, nil
+	s.httpService.SetChannel(c)
 }
 
 func (s *httpsService) Handle(ctx context.Context, conn net.Conn) error {
-	ja3Digest := ""
-	serverName := ""
-
-	tlsConn := tls.Server(conn, &tls.Config{
-		Certificates: []tls.Certificate{},
-		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			ja3Digest = hello.JA3Digest()
-			serverName = hello.ServerName
-			return s.getCertificate(hello)
-		},
-	})
-
-	if err := tlsConn.Handshake(); err != nil {
-		s.c.Send(event.New(
-			EventOptions,
-			event.Category("https"),
-			event.Type("handshake-failed"),
-			event.SourceAddr(conn.RemoteAddr()),
-			event.DestinationAddr(conn.LocalAddr()),
-			event.Custom("https.ja3-digest", ja3Digest),
-			event.Custom("https.server-name", serverName),
-		))
-
+	tlsConn, hello, err := tlshello.Server(s.c, conn, &tls.Config{
+		Certificates:   []tls.Certificate{},
+		GetCertificate: tlshello.GetCertificate(certificates.Default(), s.Certificate),
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, "https", EventOptions, event.Category("https"))
+	if err != nil {
+		// the handshake-failed event has been sent by tlshello
 		return err
 	}
 
+	// clients negotiating h2 start with the http2 preface, which is
+	// recognized by the http service
 	return s.httpService.Handle(ctx, event.WithConn(
 		tlsConn,
-		event.Custom("https.ja3-digest", ja3Digest),
-		event.Custom("https.server-name", serverName),
+		hello,
+		event.Custom("https.alpn", tlsConn.ConnectionState().NegotiatedProtocol),
 	))
 }
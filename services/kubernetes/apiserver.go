@@ -0,0 +1,435 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// maxSpecSize is the maximum size of pod specs added to events
+const maxSpecSize = 16 * 1024
+
+func (s *kubernetesService) version(ctx *kubernetesContext) error {
+	major, minor := "1", "28"
+	if parts := strings.SplitN(strings.TrimPrefix(s.Version, "v"), ".", 3); len(parts) >= 2 {
+		major, minor = parts[0], parts[1]
+	}
+
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"major":        major,
+		"minor":        minor,
+		"gitVersion":   s.Version,
+		"gitCommit":    "a8a1abc25cad87333840cd7d54be2efaf31a3177",
+		"gitTreeState": "clean",
+		"buildDate":    "2023-10-18T11:33:18Z",
+		"goVersion":    "go1.20.10",
+		"compiler":     "gc",
+		"platform":     "linux/amd64",
+	})
+}
+
+func (s *kubernetesService) healthz(ctx *kubernetesContext) error {
+	return s.writeRaw(ctx, http.StatusOK, "text/plain; charset=utf-8", []byte("ok"))
+}
+
+func (s *kubernetesService) apiVersions(ctx *kubernetesContext) error {
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"kind":     "APIVersions",
+		"versions": []string{"v1"},
+		"serverAddressByClientCIDRs": []interface{}{
+			map[string]interface{}{
+				"clientCIDR":    "0.0.0.0/0",
+				"serverAddress": "10.0.1.17:6443",
+			},
+		},
+	})
+}
+
+func resource(name string, namespaced bool, kind string, verbs ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         name,
+		"singularName": "",
+		"namespaced":   namespaced,
+		"kind":         kind,
+		"verbs":        verbs,
+	}
+}
+
+var (
+	readVerbs = []string{"get", "list", "watch"}
+	allVerbs  = []string{"create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"}
+)
+
+func (s *kubernetesService) apiResources(ctx *kubernetesContext) error {
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"kind":         "APIResourceList",
+		"groupVersion": "v1",
+		"resources": []interface{}{
+			resource("configmaps", true, "ConfigMap", allVerbs...),
+			resource("namespaces", false, "Namespace", "create", "delete", "get", "list", "patch", "update", "watch"),
+			resource("nodes", false, "Node", allVerbs...),
+			resource("pods", true, "Pod", allVerbs...),
+			resource("pods/exec", true, "PodExecOptions", "create", "get"),
+			resource("pods/log", true, "Pod", "get"),
+			resource("secrets", true, "Secret", allVerbs...),
+			resource("serviceaccounts", true, "ServiceAccount", allVerbs...),
+			resource("services", true, "Service", "create", "delete", "get", "list", "patch", "update", "watch"),
+		},
+	})
+}
+
+func group(name string, versions ...string) map[string]interface{} {
+	gv := []interface{}{}
+	for _, v := range versions {
+		gv = append(gv, map[string]interface{}{
+			"groupVersion": name + "/" + v,
+			"version":      v,
+		})
+	}
+
+	return map[string]interface{}{
+		"name":             name,
+		"versions":         gv,
+		"preferredVersion": gv[0],
+	}
+}
+
+func (s *kubernetesService) apiGroups(ctx *kubernetesContext) error {
+	return s.write(ctx, http.StatusOK, map[string]interface{}{
+		"kind":       "APIGroupList",
+		"apiVersion": "v1",
+		"groups": []interface{}{
+			group("apiregistration.k8s.io", "v1"),
+			group("apps", "v1"),
+			group("events.k8s.io", "v1"),
+			group("authentication.k8s.io", "v1"),
+			group("authorization.k8s.io", "v1"),
+			group("autoscaling", "v2", "v1"),
+			group("batch", "v1"),
+			group("certificates.k8s.io", "v1"),
+			group("networking.k8s.io", "v1"),
+			group("policy", "v1"),
+			group("rbac.authorization.k8s.io", "v1"),
+			group("storage.k8s.io", "v1"),
+			group("apiextensions.k8s.io", "v1"),
+			group("coordination.k8s.io", "v1"),
+			group("discovery.k8s.io", "v1"),
+		},
+	})
+}
+
+// list returns a list object of the items.
+func list(kind string, items interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":       kind,
+		"apiVersion": "v1",
+		"metadata": map[string]interface{}{
+			"resourceVersion": resourceVersion(),
+		},
+		"items": items,
+	}
+}
+
+func (s *kubernetesService) listNamespaces(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	return s.write(ctx, http.StatusOK, list("NamespaceList", ctx.cluster.namespaces))
+}
+
+func (s *kubernetesService) getNamespace(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	ns := ctx.cluster.namespace(ctx.params[0])
+	if ns == nil {
+		return s.notFound(ctx, "namespaces", ctx.params[0])
+	}
+
+	return s.write(ctx, http.StatusOK, ns)
+}
+
+// namespacePods returns the pods of the namespace, of all namespaces when ns is
+// empty.
+func (c *cluster) namespacePods(ns string) []*pod {
+	pods := []*pod{}
+	for _, p := range c.pods {
+		if ns == "" || p.Metadata.Namespace == ns {
+			pods = append(pods, p)
+		}
+	}
+	return pods
+}
+
+func (s *kubernetesService) listPods(ctx *kubernetesContext) error {
+	ns := ""
+	if len(ctx.params) > 0 {
+		ns = ctx.params[0]
+	}
+
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	return s.write(ctx, http.StatusOK, list("PodList", ctx.cluster.namespacePods(ns)))
+}
+
+func (s *kubernetesService) getPod(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	p := ctx.cluster.pod(ctx.params[0], ctx.params[1])
+	if p == nil {
+		return s.notFound(ctx, "pods", ctx.params[1])
+	}
+
+	return s.write(ctx, http.StatusOK, p)
+}
+
+type createPodRequest struct {
+	Metadata struct {
+		Name         string            `json:"name"`
+		GenerateName string            `json:"generateName"`
+		Namespace    string            `json:"namespace"`
+		Labels       map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec podSpec `json:"spec"`
+}
+
+// command returns the command line of the container, the entrypoint of the
+// image is unknown and not used.
+func (c *podContainer) command() []string {
+	return append(append([]string{}, c.Command...), c.Args...)
+}
+
+func (s *kubernetesService) createPod(ctx *kubernetesContext) error {
+	ns := ctx.params[0]
+
+	r := createPodRequest{}
+	if err := json.Unmarshal(ctx.body, &r); err != nil {
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", fmt.Sprintf("the object provided is unrecognized: %s", err.Error()), nil)
+	}
+
+	name := r.Metadata.Name
+	if name == "" && r.Metadata.GenerateName != "" {
+		name = r.Metadata.GenerateName + suffix(5)
+	}
+
+	if name == "" {
+		return s.status(ctx, http.StatusUnprocessableEntity, "Invalid", `Pod "" is invalid: metadata.name: Required value: name or generateName is required`, nil)
+	} else if len(r.Spec.Containers) == 0 {
+		return s.status(ctx, http.StatusUnprocessableEntity, "Invalid", fmt.Sprintf("Pod %q is invalid: spec.containers: Required value", name), nil)
+	}
+
+	for i, c := range r.Spec.Containers {
+		if c.Image == "" {
+			return s.status(ctx, http.StatusUnprocessableEntity, "Invalid", fmt.Sprintf("Pod %q is invalid: spec.containers[%d].image: Required value", name, i), nil)
+		}
+	}
+
+	ctx.cluster.Lock()
+
+	if ctx.cluster.namespace(ns) == nil {
+		ctx.cluster.Unlock()
+		return s.notFound(ctx, "namespaces", ns)
+	} else if ctx.cluster.pod(ns, name) != nil {
+		ctx.cluster.Unlock()
+		return s.status(ctx, http.StatusConflict, "AlreadyExists", fmt.Sprintf("pods %q already exists", name), map[string]interface{}{
+			"name": name,
+			"kind": "pods",
+		})
+	} else if len(ctx.cluster.pods) >= maxPods {
+		ctx.cluster.Unlock()
+		return s.status(ctx, http.StatusForbidden, "Forbidden", fmt.Sprintf("pods %q is forbidden: exceeded quota: compute-resources", name), nil)
+	}
+
+	p := s.newPod(ns, name, time.Now(), r.Metadata.Labels, r.Spec)
+	ctx.cluster.pods = append(ctx.cluster.pods, p)
+
+	ctx.cluster.Unlock()
+
+	commands := []string{}
+	for _, c := range p.Spec.Containers {
+		commands = append(commands, strings.Join(c.command(), " "))
+	}
+
+	spec, _ := json.Marshal(p.Spec)
+	if len(spec) > maxSpecSize {
+		spec = spec[:maxSpecSize]
+	}
+
+	s.send(ctx,
+		event.Type("pod-create"),
+		event.Custom("kubernetes.namespace", ns),
+		event.Custom("kubernetes.pod", name),
+		event.Custom("kubernetes.images", p.images()),
+		event.Custom("kubernetes.commands", commands),
+		event.Custom("kubernetes.host-paths", p.hostPaths()),
+		event.Custom("kubernetes.privileged", p.privileged()),
+		event.Custom("kubernetes.escapes", p.escapes()),
+		event.Custom("kubernetes.service-account", p.Spec.ServiceAccountName),
+		event.Custom("kubernetes.spec", string(spec)),
+	)
+
+	// the commands of the containers run when the pod starts, the output
+	// is available in the logs
+	for i := range p.Spec.Containers {
+		c := &p.Spec.Containers[i]
+
+		cmd := c.command()
+		if interactive(cmd) {
+			continue
+		}
+
+		output := &logWriter{ctx.cluster, p}
+		s.run(ctx, p, c, cmd, output)
+	}
+
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	return s.write(ctx, http.StatusCreated, p)
+}
+
+// logWriter writes to the logs of a pod.
+type logWriter struct {
+	c *cluster
+	p *pod
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.c.Lock()
+	defer w.c.Unlock()
+
+	w.p.log(b)
+	return len(b), nil
+}
+
+func (s *kubernetesService) deletePod(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	p := ctx.cluster.pod(ctx.params[0], ctx.params[1])
+	if p == nil {
+		return s.notFound(ctx, "pods", ctx.params[1])
+	}
+
+	ctx.cluster.removePod(p)
+
+	s.send(ctx,
+		event.Type("pod-delete"),
+		event.Custom("kubernetes.namespace", p.Metadata.Namespace),
+		event.Custom("kubernetes.pod", p.Metadata.Name),
+	)
+
+	p.Status.Phase = "Terminating"
+	return s.write(ctx, http.StatusOK, p)
+}
+
+func (s *kubernetesService) podLog(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	p := ctx.cluster.pod(ctx.params[0], ctx.params[1])
+	if p == nil {
+		return s.notFound(ctx, "pods", ctx.params[1])
+	}
+
+	name := ctx.req.URL.Query().Get("container")
+	if name == "" && len(p.Spec.Containers) > 1 {
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", fmt.Sprintf("a container name must be specified for pod %s", p.Metadata.Name), nil)
+	} else if p.container(name) == nil {
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", fmt.Sprintf("container %s is not valid for pod %s", name, p.Metadata.Name), nil)
+	}
+
+	return s.writeRaw(ctx, http.StatusOK, "text/plain", p.logs.Bytes())
+}
+
+// apiExec executes a command in a container of the pod, the api server
+// proxies the streams of the kubelet.
+func (s *kubernetesService) apiExec(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+
+	p := ctx.cluster.pod(ctx.params[0], ctx.params[1])
+	if p == nil {
+		ctx.cluster.Unlock()
+		return s.notFound(ctx, "pods", ctx.params[1])
+	}
+
+	name := ctx.req.URL.Query().Get("container")
+
+	c := p.container(name)
+
+	ctx.cluster.Unlock()
+
+	if c == nil {
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", fmt.Sprintf("container %s is not valid for pod %s", name, p.Metadata.Name), nil)
+	}
+
+	return s.exec(ctx, p, c, ctx.req.URL.Query()["command"])
+}
+
+// readSecrets sends an event for the secrets read by the attacker.
+func (s *kubernetesService) readSecrets(ctx *kubernetesContext, ns string, secrets []*secret) {
+	names := []string{}
+	for _, v := range secrets {
+		names = append(names, v.Metadata.Namespace+"/"+v.Metadata.Name)
+	}
+
+	s.send(ctx,
+		event.Type("secret-read"),
+		event.Custom("kubernetes.namespace", ns),
+		event.Custom("kubernetes.secrets", names),
+	)
+}
+
+func (s *kubernetesService) listSecrets(ctx *kubernetesContext) error {
+	ns := ""
+	if len(ctx.params) > 0 {
+		ns = ctx.params[0]
+	}
+
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	secrets := []*secret{}
+	for _, v := range ctx.cluster.secrets {
+		if ns == "" || v.Metadata.Namespace == ns {
+			secrets = append(secrets, v)
+		}
+	}
+
+	s.readSecrets(ctx, ns, secrets)
+
+	return s.write(ctx, http.StatusOK, list("SecretList", secrets))
+}
+
+func (s *kubernetesService) getSecret(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	v := ctx.cluster.secret(ctx.params[0], ctx.params[1])
+	if v == nil {
+		return s.notFound(ctx, "secrets", ctx.params[1])
+	}
+
+	s.readSecrets(ctx, ctx.params[0], []*secret{v})
+
+	return s.write(ctx, http.StatusOK, v)
+}
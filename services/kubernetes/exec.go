@@ -0,0 +1,396 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kubernetes
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services/shell"
+)
+
+// channels of the remote command streams
+const (
+	stdin  = 0
+	stdout = 1
+	stderr = 2
+	status = 3
+)
+
+// maxMessageSize is the maximum size of a websocket message, kubectl sends
+// stdin in chunks of 32KB, which are larger when base64 encoded.
+const maxMessageSize = 128 * 1024
+
+// interactive returns whether the command starts a shell reading commands
+// from stdin.
+func interactive(cmd []string) bool {
+	if len(cmd) == 0 {
+		return true
+	}
+
+	switch cmd[0] {
+	case "sh", "bash", "/bin/sh", "/bin/bash", "ash", "/bin/ash":
+		return len(cmd) == 1 || (len(cmd) == 2 && cmd[1] == "-i")
+	default:
+		return false
+	}
+}
+
+// quote returns the arguments as command line, quoted for the shell.
+func quote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellCmd runs the command of -c, other shells are ignored.
+func shellCmd(sh *shell.Shell, args []string) int {
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] == "-c" {
+			sh.Run(args[i+1])
+			return sh.ExitStatus()
+		}
+	}
+
+	return 0
+}
+
+func id(sh *shell.Shell, args []string) int {
+	sh.Printf("uid=0(root) gid=0(root) groups=0(root),1(bin),2(daemon),3(sys),4(adm),6(disk),10(wheel),11(floppy),20(dialout),26(tape),27(video)\n")
+	return 0
+}
+
+// serviceAccountDir contains the service account credentials mounted in
+// pods, the first thing read after getting a shell in a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// serviceAccountFile returns the contents of the file of the mounted
+// service account.
+func serviceAccountFile(p *pod, name string) (string, bool) {
+	// /var/run links to /run
+	if strings.HasPrefix(name, "/run/") {
+		name = "/var" + name
+	}
+
+	if !strings.HasPrefix(name, serviceAccountDir) {
+		return "", false
+	}
+
+	switch strings.TrimPrefix(name, serviceAccountDir) {
+	case "token":
+		return p.token, true
+	case "namespace":
+		return p.Metadata.Namespace, true
+	case "ca.crt":
+		ca, err := certificates.Default().CA()
+		if err != nil {
+			return "", false
+		}
+
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})), true
+	}
+
+	return "", false
+}
+
+// newShell returns the shell of the container, writing to w.
+func (s *kubernetesService) newShell(ctx *kubernetesContext, p *pod, c *podContainer, w io.Writer) *shell.Shell {
+	hostname := p.Metadata.Name
+
+	builtin, _ := shell.New(ioutil.Discard).Lookup("cat")
+
+	options := []shell.Option{
+		shell.WithHostname(hostname),
+		shell.WithUser("root"),
+		shell.WithCommand("sh", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("ash", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("bash", shell.CommandFunc(shellCmd)),
+		shell.WithCommand("id", shell.CommandFunc(id)),
+		shell.WithCommand("hostname", shell.CommandFunc(func(sh *shell.Shell, args []string) int {
+			sh.Printf("%s\n", hostname)
+			return 0
+		})),
+		shell.WithCommand("cat", shell.CommandFunc(func(sh *shell.Shell, args []string) int {
+			others := []string{args[0]}
+
+			for _, arg := range args[1:] {
+				if data, ok := serviceAccountFile(p, arg); ok {
+					sh.Printf("%s", data)
+				} else {
+					others = append(others, arg)
+				}
+			}
+
+			if len(others) == 1 && len(args) > 1 {
+				return 0
+			}
+
+			return builtin.Run(sh, others)
+		})),
+	}
+
+	if s.Download {
+		options = append(options, shell.WithDownloader(func(rawurl string) (io.ReadCloser, error) {
+			return artifacts.Download(s.c, rawurl, append(ctx.options,
+				event.Custom("kubernetes.namespace", p.Metadata.Namespace),
+				event.Custom("kubernetes.pod", p.Metadata.Name),
+			)...)
+		}))
+	}
+
+	return shell.New(w, options...)
+}
+
+// execOptions returns the options of exec events of the container.
+func execOptions(p *pod, c *podContainer, command string, emulated bool) []event.Option {
+	return []event.Option{
+		event.Type("exec"),
+		event.Custom("kubernetes.namespace", p.Metadata.Namespace),
+		event.Custom("kubernetes.pod", p.Metadata.Name),
+		event.Custom("kubernetes.container", c.Name),
+		event.Custom("kubernetes.image", c.Image),
+		event.Custom("kubernetes.command", command),
+		event.Custom("kubernetes.emulated", emulated),
+	}
+}
+
+// run runs the command in the container, writing the output to w.
+func (s *kubernetesService) run(ctx *kubernetesContext, p *pod, c *podContainer, cmd []string, w io.Writer) int {
+	sh := s.newShell(ctx, p, c, w)
+
+	emulated := sh.Run(quote(cmd))
+
+	s.send(ctx, execOptions(p, c, strings.Join(cmd, " "), emulated)...)
+
+	return sh.ExitStatus()
+}
+
+// interact reads commands from r and runs them, until r is closed or the
+// shell exits.
+func (s *kubernetesService) interact(ctx *kubernetesContext, p *pod, c *podContainer, r io.Reader, w io.Writer) int {
+	sh := s.newShell(ctx, p, c, w)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		emulated := sh.Run(line)
+
+		s.send(ctx, execOptions(p, c, line, emulated)...)
+
+		if sh.Exited() {
+			break
+		}
+	}
+
+	return sh.ExitStatus()
+}
+
+// responseWriter allows the websocket upgrader to take over the connection
+// of the request.
+type responseWriter struct {
+	s   *kubernetesService
+	ctx *kubernetesContext
+
+	header http.Header
+	status int
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if err := w.s.writeRaw(w.ctx, w.status, w.header.Get("Content-Type"), p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.ctx.hijacked = true
+	return w.ctx.conn, bufio.NewReadWriter(w.ctx.br, bufio.NewWriter(w.ctx.conn)), nil
+}
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{
+		"v5.channel.k8s.io",
+		"v4.channel.k8s.io",
+		"v4.base64.channel.k8s.io",
+		"v3.channel.k8s.io",
+		"v2.channel.k8s.io",
+		"channel.k8s.io",
+		"base64.channel.k8s.io",
+	},
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// channelWriter writes to a channel of the stream, the data of a message is
+// prefixed with the channel.
+type channelWriter struct {
+	ws      *websocket.Conn
+	channel byte
+}
+
+func (cw *channelWriter) Write(p []byte) (int, error) {
+	var err error
+
+	if strings.Contains(cw.ws.Subprotocol(), "base64") {
+		err = cw.ws.WriteMessage(websocket.TextMessage, append([]byte{'0' + cw.channel}, base64.StdEncoding.EncodeToString(p)...))
+	} else {
+		err = cw.ws.WriteMessage(websocket.BinaryMessage, append([]byte{cw.channel}, p...))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// stdinReader reads the data of the stdin channel of the stream. The reader
+// should be closed when done, so the reading goroutine stops.
+func stdinReader(ws *websocket.Conn) *io.PipeReader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if len(data) == 0 {
+				continue
+			}
+
+			channel := data[0]
+			data = data[1:]
+
+			if strings.Contains(ws.Subprotocol(), "base64") {
+				channel -= '0'
+
+				if data, err = base64.StdEncoding.DecodeString(string(data)); err != nil {
+					continue
+				}
+			}
+
+			if channel != stdin {
+				continue
+			}
+
+			if _, err := pw.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
+// exitStatus returns the status written to the status channel when the
+// command exits.
+func exitStatus(code int) []byte {
+	v := map[string]interface{}{
+		"metadata": map[string]interface{}{},
+		"status":   "Success",
+	}
+
+	if code != 0 {
+		v = map[string]interface{}{
+			"metadata": map[string]interface{}{},
+			"status":   "Failure",
+			"message":  fmt.Sprintf("command terminated with non-zero exit code: error executing command [...], exit code %d", code),
+			"reason":   "NonZeroExitCode",
+			"details": map[string]interface{}{
+				"causes": []interface{}{
+					map[string]interface{}{
+						"reason":  "ExitCode",
+						"message": fmt.Sprintf("%d", code),
+					},
+				},
+			},
+		}
+	}
+
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// exec executes the command in the container, streaming over a websocket
+// with the remote command protocol. Other streaming protocols, like spdy,
+// are refused after capturing the command.
+func (s *kubernetesService) exec(ctx *kubernetesContext, p *pod, c *podContainer, cmd []string) error {
+	if len(cmd) == 0 {
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", "you must specify at least 1 command", nil)
+	}
+
+	q := ctx.req.URL.Query()
+
+	if !websocket.IsWebSocketUpgrade(ctx.req) {
+		s.run(ctx, p, c, cmd, ioutil.Discard)
+		return s.status(ctx, http.StatusBadRequest, "BadRequest", "Upgrade request required", nil)
+	}
+
+	ws, err := upgrader.Upgrade(&responseWriter{s: s, ctx: ctx, header: http.Header{}}, ctx.req, nil)
+	if err != nil {
+		return err
+	}
+
+	defer ws.Close()
+
+	ws.SetReadLimit(maxMessageSize)
+
+	w := &channelWriter{ws, stdout}
+
+	var code int
+	if interactive(cmd) && (q.Get("stdin") == "1" || q.Get("stdin") == "true") {
+		r := stdinReader(ws)
+		code = s.interact(ctx, p, c, r, w)
+
+		// the client may still be sending stdin
+		r.CloseWithError(io.ErrClosedPipe)
+	} else {
+		code = s.run(ctx, p, c, cmd, w)
+	}
+
+	(&channelWriter{ws, status}).Write(exitStatus(code))
+
+	return ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
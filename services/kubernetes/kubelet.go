@@ -0,0 +1,86 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// kubeletPods lists the pods running on the node.
+func (s *kubernetesService) kubeletPods(ctx *kubernetesContext) error {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	return s.write(ctx, http.StatusOK, list("PodList", ctx.cluster.namespacePods("")))
+}
+
+// container returns the pod and container of the kubelet request, a
+// response is written when these don't exist.
+func (s *kubernetesService) container(ctx *kubernetesContext) (*pod, *podContainer, error) {
+	ctx.cluster.Lock()
+	defer ctx.cluster.Unlock()
+
+	p := ctx.cluster.pod(ctx.params[0], ctx.params[1])
+	if p == nil {
+		return nil, nil, s.writeRaw(ctx, http.StatusNotFound, "text/plain; charset=utf-8", []byte("pod does not exist\n"))
+	}
+
+	c := p.container(ctx.params[2])
+	if c == nil {
+		return nil, nil, s.writeRaw(ctx, http.StatusNotFound, "text/plain; charset=utf-8", []byte(fmt.Sprintf("container not found (%q)\n", ctx.params[2])))
+	}
+
+	return p, c, nil
+}
+
+// kubeletRun runs the command of cmd in the container and returns the
+// output, without streaming.
+func (s *kubernetesService) kubeletRun(ctx *kubernetesContext) error {
+	p, c, err := s.container(ctx)
+	if p == nil {
+		return err
+	}
+
+	cmd := ctx.req.URL.Query().Get("cmd")
+	if cmd == "" {
+		if form, err := url.ParseQuery(string(ctx.body)); err == nil {
+			cmd = form.Get("cmd")
+		}
+	}
+
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return s.writeRaw(ctx, http.StatusBadRequest, "text/plain; charset=utf-8", []byte("missing command\n"))
+	}
+
+	buff := bytes.Buffer{}
+	s.run(ctx, p, c, args, &buff)
+
+	return s.writeRaw(ctx, http.StatusOK, "text/plain; charset=utf-8", buff.Bytes())
+}
+
+// kubeletExec executes the command of the command parameters in the
+// container, streaming the output.
+func (s *kubernetesService) kubeletExec(ctx *kubernetesContext) error {
+	p, c, err := s.container(ctx)
+	if p == nil {
+		return err
+	}
+
+	return s.exec(ctx, p, c, ctx.req.URL.Query()["command"])
+}
@@ -0,0 +1,435 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+//  Documentation: https://kubernetes.io/docs/reference/using-api/api-concepts/
+//                 https://kubernetes.io/docs/reference/command-line-tools-reference/kubelet/
+
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+	"github.com/honeytrap/honeytrap/services/tlshello"
+	"github.com/op/go-logging"
+	"github.com/rs/xid"
+)
+
+var log = logging.MustGetLogger("services/kubernetes")
+
+var (
+	_ = services.Register("kubernetes", Kubernetes)
+)
+
+// Kubernetes emulates the api server and the kubelet of a kubernetes
+// cluster, every attacker has its own pods.
+func Kubernetes(options ...services.ServicerFunc) services.Servicer {
+	s := &kubernetesService{
+		kubernetesServiceConfig: kubernetesServiceConfig{
+			Version:     "v1.28.3",
+			NodeName:    "worker-01",
+			Anonymous:   true,
+			MaxBodySize: 1024 * 1024,
+			MaxHosts:    1024,
+		},
+		hosts: map[string]*cluster{},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type kubernetesServiceConfig struct {
+	// Version is the git version of kubernetes
+	Version string `toml:"version"`
+
+	// NodeName is the name of the node the pods are running on
+	NodeName string `toml:"node-name"`
+
+	// Anonymous allows requests without credentials, otherwise these are
+	// refused as unauthorized. Credentials are always accepted.
+	Anonymous bool `toml:"anonymous"`
+
+	// Certificate is the subject of the issued certificates, used when the
+	// client starts a tls handshake
+	Certificate certificates.Subject `toml:"certificate"`
+
+	// MaxBodySize is the maximum size of request bodies
+	MaxBodySize int64 `toml:"max-body-size"`
+
+	// MaxHosts is the maximum number of attackers state is kept for
+	MaxHosts int `toml:"max-hosts"`
+
+	// Download enables the download of urls requested by attackers (wget,
+	// curl) in pods, the downloads are saved as artifacts
+	Download bool `toml:"download"`
+}
+
+type kubernetesService struct {
+	kubernetesServiceConfig
+
+	m     sync.Mutex
+	hosts map[string]*cluster
+
+	c pushers.Channel
+}
+
+func (s *kubernetesService) CanHandle(payload []byte) bool {
+	// tls handshake record
+	if len(payload) >= 3 && payload[0] == 0x16 && payload[1] == 0x03 {
+		return true
+	}
+
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"} {
+		if bytes.HasPrefix(payload, []byte(method+" ")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *kubernetesService) SetChannel(c pushers.Channel) {
+	s.c = c
+}
+
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handler func(*kubernetesService, *kubernetesContext) error
+}
+
+var routes = []route{
+	// api server
+	{"GET", regexp.MustCompile(`^/version/?$`), (*kubernetesService).version},
+	{"GET", regexp.MustCompile(`^/(?:healthz|livez|readyz)$`), (*kubernetesService).healthz},
+	{"GET", regexp.MustCompile(`^/api/?$`), (*kubernetesService).apiVersions},
+	{"GET", regexp.MustCompile(`^/api/v1/?$`), (*kubernetesService).apiResources},
+	{"GET", regexp.MustCompile(`^/apis/?$`), (*kubernetesService).apiGroups},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces$`), (*kubernetesService).listNamespaces},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)$`), (*kubernetesService).getNamespace},
+	{"GET", regexp.MustCompile(`^/api/v1/pods$`), (*kubernetesService).listPods},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods$`), (*kubernetesService).listPods},
+	{"POST", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods$`), (*kubernetesService).createPod},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods/([^/]+)$`), (*kubernetesService).getPod},
+	{"DELETE", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods/([^/]+)$`), (*kubernetesService).deletePod},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods/([^/]+)/log$`), (*kubernetesService).podLog},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods/([^/]+)/exec$`), (*kubernetesService).apiExec},
+	{"POST", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/pods/([^/]+)/exec$`), (*kubernetesService).apiExec},
+	{"GET", regexp.MustCompile(`^/api/v1/secrets$`), (*kubernetesService).listSecrets},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/secrets$`), (*kubernetesService).listSecrets},
+	{"GET", regexp.MustCompile(`^/api/v1/namespaces/([^/]+)/secrets/([^/]+)$`), (*kubernetesService).getSecret},
+
+	// kubelet
+	{"GET", regexp.MustCompile(`^/pods/?$`), (*kubernetesService).kubeletPods},
+	{"GET", regexp.MustCompile(`^/runningpods/?$`), (*kubernetesService).kubeletPods},
+	{"GET", regexp.MustCompile(`^/run/([^/]+)/([^/]+)/(?:[^/]+/)?([^/]+)$`), (*kubernetesService).kubeletRun},
+	{"POST", regexp.MustCompile(`^/run/([^/]+)/([^/]+)/(?:[^/]+/)?([^/]+)$`), (*kubernetesService).kubeletRun},
+	{"GET", regexp.MustCompile(`^/exec/([^/]+)/([^/]+)/(?:[^/]+/)?([^/]+)$`), (*kubernetesService).kubeletExec},
+	{"POST", regexp.MustCompile(`^/exec/([^/]+)/([^/]+)/(?:[^/]+/)?([^/]+)$`), (*kubernetesService).kubeletExec},
+}
+
+// kubernetesContext contains the request being handled.
+type kubernetesContext struct {
+	req  *http.Request
+	body []byte
+
+	// params contains the submatches of the route pattern
+	params []string
+
+	conn net.Conn
+	br   *bufio.Reader
+
+	cluster *cluster
+
+	// options are added to the events sent while handling the request
+	options []event.Option
+
+	// authenticated is set when the request contains credentials
+	authenticated bool
+
+	// hijacked is set when the connection has been taken over by a stream
+	hijacked bool
+}
+
+// send sends an event with the options of the request.
+func (s *kubernetesService) send(ctx *kubernetesContext, options ...event.Option) {
+	s.c.Send(event.New(
+		append(ctx.options, options...)...,
+	))
+}
+
+// bufferedConn reads from the buffered reader, which contains the peeked
+// bytes of the connection.
+type bufferedConn struct {
+	net.Conn
+
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (s *kubernetesService) Handle(ctx context.Context, conn net.Conn) error {
+	id := xid.New()
+
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+
+	// both the api server and the kubelet only serve https, unless tls
+	// has been terminated already
+	if b, err := br.Peek(1); err != nil {
+		return err
+	} else if b[0] == 0x16 {
+		tlsConn, hello, err := tlshello.Server(s.c, &bufferedConn{conn, br}, &tls.Config{
+			GetCertificate: tlshello.GetCertificate(certificates.Default(), s.Certificate),
+			NextProtos:     []string{"http/1.1"},
+		}, "kubernetes", services.EventOptions, event.Category("kubernetes"))
+		if err != nil {
+			return err
+		}
+
+		conn = event.WithConn(tlsConn, hello)
+		br = bufio.NewReader(conn)
+	}
+
+	for {
+		req, err := http.ReadRequest(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, s.MaxBodySize))
+		if err != nil {
+			return err
+		}
+
+		io.Copy(ioutil.Discard, req.Body)
+
+		var connOptions event.Option = nil
+
+		if ec, ok := conn.(*event.Conn); ok {
+			connOptions = ec.Options()
+		}
+
+		kc := &kubernetesContext{
+			req:     req,
+			body:    body,
+			conn:    conn,
+			br:      br,
+			cluster: s.cluster(conn.RemoteAddr()),
+			options: []event.Option{
+				services.EventOptions,
+				connOptions,
+				event.Category("kubernetes"),
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+				event.Custom("http.sessionid", id.String()),
+			},
+		}
+
+		kc.options = append(kc.options, credentials(kc, req)...)
+
+		payload := body
+		if len(payload) > 1024 {
+			payload = payload[:1024]
+		}
+
+		s.send(kc,
+			event.Type("request"),
+			event.Custom("http.method", req.Method),
+			event.Custom("http.proto", req.Proto),
+			event.Custom("http.host", req.Host),
+			event.Custom("http.url", req.URL.String()),
+			event.Custom("http.user-agent", req.UserAgent()),
+			event.Payload(payload),
+			services.Headers(req.Header),
+		)
+
+		if err := s.route(kc); err != nil {
+			return err
+		}
+
+		if kc.hijacked || req.Close {
+			return nil
+		}
+	}
+}
+
+// maxTokenSize is the maximum size of captured tokens
+const maxTokenSize = 4096
+
+// credentials returns the options of the credentials of the request, bearer
+// tokens are captured with the claims of service account tokens.
+func credentials(ctx *kubernetesContext, req *http.Request) []event.Option {
+	if username, password, ok := req.BasicAuth(); ok {
+		ctx.authenticated = true
+
+		return []event.Option{
+			event.Custom("kubernetes.username", username),
+			event.Custom("kubernetes.password", password),
+		}
+	}
+
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return nil
+	}
+
+	token := strings.TrimSpace(auth[7:])
+	if token == "" {
+		return nil
+	}
+
+	ctx.authenticated = true
+
+	if len(token) > maxTokenSize {
+		token = token[:maxTokenSize]
+	}
+
+	options := []event.Option{
+		event.Custom("kubernetes.token", token),
+	}
+
+	// service account tokens are jwts, the claims identify the cluster
+	// and service account the token has been stolen from
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return options
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return options
+	}
+
+	claims := struct {
+		Issuer  string `json:"iss"`
+		Subject string `json:"sub"`
+	}{}
+
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return options
+	}
+
+	return append(options,
+		event.Custom("kubernetes.token-issuer", claims.Issuer),
+		event.Custom("kubernetes.token-subject", claims.Subject),
+	)
+}
+
+// route calls the handler of the request.
+func (s *kubernetesService) route(ctx *kubernetesContext) error {
+	if !ctx.authenticated && !s.Anonymous {
+		return s.status(ctx, http.StatusUnauthorized, "Unauthorized", "Unauthorized", nil)
+	}
+
+	for _, r := range routes {
+		if r.method != ctx.req.Method {
+			continue
+		}
+
+		if m := r.pattern.FindStringSubmatch(ctx.req.URL.Path); m != nil {
+			ctx.params = m[1:]
+			return r.handler(s, ctx)
+		}
+	}
+
+	return s.status(ctx, http.StatusNotFound, "NotFound", "the server could not find the requested resource", nil)
+}
+
+// write writes the response with v encoded as json.
+func (s *kubernetesService) write(ctx *kubernetesContext, status int, v interface{}) error {
+	buff := bytes.Buffer{}
+
+	if err := json.NewEncoder(&buff).Encode(v); err != nil {
+		return err
+	}
+
+	return s.writeRaw(ctx, status, "application/json", buff.Bytes())
+}
+
+// writeRaw writes the response with the body.
+func (s *kubernetesService) writeRaw(ctx *kubernetesContext, status int, contentType string, body []byte) error {
+	resp := http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      ctx.req.Proto,
+		ProtoMajor: ctx.req.ProtoMajor,
+		ProtoMinor: ctx.req.ProtoMinor,
+		Request:    ctx.req,
+		Header:     http.Header{},
+	}
+
+	resp.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
+	resp.Header.Add("Content-Type", contentType)
+	resp.Header.Add("Cache-Control", "no-cache, private")
+	resp.Header.Add("X-Content-Type-Options", "nosniff")
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+
+	return resp.Write(ctx.conn)
+}
+
+// status writes a Status object, as returned by the api server on errors.
+func (s *kubernetesService) status(ctx *kubernetesContext, code int, reason string, message string, details map[string]interface{}) error {
+	status := map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]interface{}{},
+		"status":     "Failure",
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	}
+
+	if details != nil {
+		status["details"] = details
+	}
+
+	return s.write(ctx, code, status)
+}
+
+// notFound writes the Status of a missing object.
+func (s *kubernetesService) notFound(ctx *kubernetesContext, kind string, name string) error {
+	return s.status(ctx, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", kind, name), map[string]interface{}{
+		"name": name,
+		"kind": kind,
+	})
+}
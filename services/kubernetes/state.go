@@ -0,0 +1,526 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPods is the maximum number of pods of an attacker
+	maxPods = 100
+
+	// maxLogSize is the maximum size of the logs of a pod
+	maxLogSize = 64 * 1024
+)
+
+// cluster contains the state of the cluster of an attacker.
+type cluster struct {
+	sync.Mutex
+
+	namespaces []*namespace
+	pods       []*pod
+	secrets    []*secret
+
+	lastUsed time.Time
+}
+
+type objectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels,omitempty"`
+}
+
+type namespace struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Status     struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+type pod struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       podSpec    `json:"spec"`
+	Status     podStatus  `json:"status"`
+
+	// token is the token of the service account mounted in the pod
+	token string
+
+	logs bytes.Buffer
+}
+
+type podSpec struct {
+	Containers         []podContainer `json:"containers"`
+	Volumes            []volume       `json:"volumes,omitempty"`
+	NodeName           string         `json:"nodeName,omitempty"`
+	ServiceAccountName string         `json:"serviceAccountName,omitempty"`
+	HostPID            bool           `json:"hostPID,omitempty"`
+	HostIPC            bool           `json:"hostIPC,omitempty"`
+	HostNetwork        bool           `json:"hostNetwork,omitempty"`
+	RestartPolicy      string         `json:"restartPolicy,omitempty"`
+}
+
+type podContainer struct {
+	Name            string           `json:"name"`
+	Image           string           `json:"image"`
+	Command         []string         `json:"command,omitempty"`
+	Args            []string         `json:"args,omitempty"`
+	Env             []envVar         `json:"env,omitempty"`
+	VolumeMounts    []volumeMount    `json:"volumeMounts,omitempty"`
+	SecurityContext *securityContext `json:"securityContext,omitempty"`
+}
+
+type envVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+type securityContext struct {
+	Privileged               *bool `json:"privileged,omitempty"`
+	AllowPrivilegeEscalation *bool `json:"allowPrivilegeEscalation,omitempty"`
+	RunAsUser                *int  `json:"runAsUser,omitempty"`
+	Capabilities             *struct {
+		Add []string `json:"add,omitempty"`
+	} `json:"capabilities,omitempty"`
+}
+
+type volume struct {
+	Name     string `json:"name"`
+	HostPath *struct {
+		Path string `json:"path"`
+		Type string `json:"type,omitempty"`
+	} `json:"hostPath,omitempty"`
+	Secret *struct {
+		SecretName string `json:"secretName"`
+	} `json:"secret,omitempty"`
+	EmptyDir *struct{} `json:"emptyDir,omitempty"`
+}
+
+type podStatus struct {
+	Phase             string            `json:"phase"`
+	HostIP            string            `json:"hostIP,omitempty"`
+	PodIP             string            `json:"podIP,omitempty"`
+	StartTime         *time.Time        `json:"startTime,omitempty"`
+	ContainerStatuses []containerStatus `json:"containerStatuses,omitempty"`
+}
+
+type containerStatus struct {
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	ImageID      string `json:"imageID"`
+	ContainerID  string `json:"containerID"`
+	Ready        bool   `json:"ready"`
+	RestartCount int    `json:"restartCount"`
+	State        struct {
+		Running *struct {
+			StartedAt time.Time `json:"startedAt"`
+		} `json:"running,omitempty"`
+	} `json:"state"`
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string]string `json:"data"`
+}
+
+// randomID returns a random hex string of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// uid returns a random uid of an object.
+func uid() string {
+	id := randomID(16)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
+
+// resourceVersion returns a resource version, increasing over time.
+func resourceVersion() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano()/int64(time.Millisecond)%100000000)
+}
+
+// suffix returns the random suffix of generated names.
+func suffix(n int) string {
+	const chars = "bcdfghjklmnpqrstvwxz2456789"
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[mrand.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+func meta(ns string, name string, created time.Time, labels map[string]string) objectMeta {
+	return objectMeta{
+		Name:              name,
+		Namespace:         ns,
+		UID:               uid(),
+		ResourceVersion:   resourceVersion(),
+		CreationTimestamp: created.UTC().Truncate(time.Second),
+		Labels:            labels,
+	}
+}
+
+// newCluster returns a cluster with the namespaces, pods and secrets of a
+// small production cluster.
+func (s *kubernetesService) newCluster() *cluster {
+	created := time.Now().Add(-time.Duration(90+mrand.Intn(200)) * 24 * time.Hour)
+
+	c := &cluster{}
+
+	for _, name := range []string{"default", "kube-node-lease", "kube-public", "kube-system", "monitoring"} {
+		ns := &namespace{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Metadata: meta("", name, created, map[string]string{
+				"kubernetes.io/metadata.name": name,
+			}),
+		}
+		ns.Status.Phase = "Active"
+
+		c.namespaces = append(c.namespaces, ns)
+	}
+
+	rs := suffix(10)
+
+	for _, p := range []struct {
+		ns, name, container, image string
+		labels                     map[string]string
+	}{
+		{"kube-system", "coredns-5dd5756b68-" + suffix(5), "coredns", "registry.k8s.io/coredns/coredns:v1.10.1", map[string]string{"k8s-app": "kube-dns"}},
+		{"kube-system", "kube-proxy-" + suffix(5), "kube-proxy", "registry.k8s.io/kube-proxy:" + s.Version, map[string]string{"k8s-app": "kube-proxy"}},
+		{"kube-system", "etcd-" + s.NodeName, "etcd", "registry.k8s.io/etcd:3.5.9-0", map[string]string{"component": "etcd", "tier": "control-plane"}},
+		{"monitoring", "prometheus-server-" + suffix(10) + "-" + suffix(5), "prometheus-server", "quay.io/prometheus/prometheus:v2.47.2", map[string]string{"app": "prometheus"}},
+		{"default", "web-" + rs + "-" + suffix(5), "nginx", "nginx:1.25.3", map[string]string{"app": "web"}},
+		{"default", "web-" + rs + "-" + suffix(5), "nginx", "nginx:1.25.3", map[string]string{"app": "web"}},
+		{"default", "api-" + suffix(10) + "-" + suffix(5), "api", "node:18-alpine", map[string]string{"app": "api"}},
+	} {
+		c.pods = append(c.pods, s.newPod(p.ns, p.name, created, p.labels, podSpec{
+			Containers: []podContainer{
+				{Name: p.container, Image: p.image},
+			},
+		}))
+	}
+
+	for _, v := range []struct {
+		ns, name, typ string
+		data          map[string]string
+	}{
+		{"default", "default-token-" + suffix(5), "kubernetes.io/service-account-token", map[string]string{
+			"namespace": "default",
+			"token":     serviceAccountToken("default", "default"),
+		}},
+		{"default", "api-database", "Opaque", map[string]string{
+			"username": "api",
+			"password": randomID(12),
+			"host":     "postgres.default.svc.cluster.local",
+		}},
+		{"default", "registry-credentials", "kubernetes.io/dockerconfigjson", map[string]string{
+			".dockerconfigjson": fmt.Sprintf(`{"auths":{"registry.gitlab.com":{"username":"deploy","password":"%s"}}}`, randomID(10)),
+		}},
+		{"kube-system", "bootstrap-token-" + suffix(6), "bootstrap.kubernetes.io/token", map[string]string{
+			"token-id":     suffix(6),
+			"token-secret": suffix(16),
+		}},
+	} {
+		data := map[string]string{}
+		for k, v := range v.data {
+			data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+
+		c.secrets = append(c.secrets, &secret{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   meta(v.ns, v.name, created, nil),
+			Type:       v.typ,
+			Data:       data,
+		})
+	}
+
+	return c
+}
+
+// serviceAccountToken returns a token looking like a service account token,
+// the signature is random.
+func serviceAccountToken(ns string, name string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"` + randomID(8) + `"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(
+		`{"iss":"kubernetes/serviceaccount","kubernetes.io/serviceaccount/namespace":"%s","kubernetes.io/serviceaccount/service-account.name":"%s","sub":"system:serviceaccount:%s:%s"}`,
+		ns, name, ns, name,
+	)))
+
+	b := make([]byte, 256)
+	rand.Read(b)
+
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newPod returns a running pod on the node.
+func (s *kubernetesService) newPod(ns string, name string, created time.Time, labels map[string]string, spec podSpec) *pod {
+	spec.NodeName = s.NodeName
+
+	if spec.ServiceAccountName == "" {
+		spec.ServiceAccountName = "default"
+	}
+
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = "Always"
+	}
+
+	p := &pod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata:   meta(ns, name, created, labels),
+		Spec:       spec,
+		token:      serviceAccountToken(ns, spec.ServiceAccountName),
+	}
+
+	started := created.UTC().Truncate(time.Second)
+
+	p.Status.Phase = "Running"
+	p.Status.HostIP = "10.0.1.17"
+	p.Status.PodIP = fmt.Sprintf("10.244.0.%d", 2+mrand.Intn(250))
+	p.Status.StartTime = &started
+
+	if spec.HostNetwork {
+		p.Status.PodIP = p.Status.HostIP
+	}
+
+	for _, c := range spec.Containers {
+		cs := containerStatus{
+			Name:        c.Name,
+			Image:       c.Image,
+			ImageID:     strings.SplitN(c.Image, ":", 2)[0] + "@sha256:" + randomID(32),
+			ContainerID: "containerd://" + randomID(32),
+			Ready:       true,
+		}
+
+		cs.State.Running = &struct {
+			StartedAt time.Time `json:"startedAt"`
+		}{started}
+
+		p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, cs)
+	}
+
+	return p
+}
+
+// cluster returns the cluster of the attacker at addr.
+func (s *kubernetesService) cluster(addr net.Addr) *cluster {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	c, ok := s.hosts[ip]
+	if !ok {
+		if len(s.hosts) >= s.MaxHosts {
+			s.evict()
+		}
+
+		c = s.newCluster()
+		s.hosts[ip] = c
+	}
+
+	c.Lock()
+	c.lastUsed = time.Now()
+	c.Unlock()
+
+	return c
+}
+
+// evict removes the least recently used cluster.
+func (s *kubernetesService) evict() {
+	oldest := ""
+
+	var last time.Time
+	for ip, c := range s.hosts {
+		c.Lock()
+		used := c.lastUsed
+		c.Unlock()
+
+		if oldest == "" || used.Before(last) {
+			oldest, last = ip, used
+		}
+	}
+
+	delete(s.hosts, oldest)
+}
+
+func (c *cluster) namespace(name string) *namespace {
+	for _, ns := range c.namespaces {
+		if ns.Metadata.Name == name {
+			return ns
+		}
+	}
+
+	return nil
+}
+
+func (c *cluster) pod(ns string, name string) *pod {
+	for _, p := range c.pods {
+		if p.Metadata.Namespace == ns && p.Metadata.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+func (c *cluster) removePod(p *pod) {
+	for i, v := range c.pods {
+		if v == p {
+			c.pods = append(c.pods[:i], c.pods[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *cluster) secret(ns string, name string) *secret {
+	for _, v := range c.secrets {
+		if v.Metadata.Namespace == ns && v.Metadata.Name == name {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// container returns the container with the name, the first container when
+// name is empty.
+func (p *pod) container(name string) *podContainer {
+	for i := range p.Spec.Containers {
+		if name == "" || p.Spec.Containers[i].Name == name {
+			return &p.Spec.Containers[i]
+		}
+	}
+
+	return nil
+}
+
+// log appends output to the logs of the pod.
+func (p *pod) log(b []byte) {
+	if p.logs.Len()+len(b) > maxLogSize {
+		return
+	}
+
+	p.logs.Write(b)
+}
+
+// images returns the images of the containers of the pod.
+func (p *pod) images() []string {
+	images := []string{}
+	for _, c := range p.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// hostPaths returns the host paths mounted in the pod.
+func (p *pod) hostPaths() []string {
+	paths := []string{}
+	for _, v := range p.Spec.Volumes {
+		if v.HostPath != nil {
+			paths = append(paths, v.HostPath.Path)
+		}
+	}
+	return paths
+}
+
+// privileged returns whether a container of the pod is privileged.
+func (p *pod) privileged() bool {
+	for _, c := range p.Spec.Containers {
+		if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			return true
+		}
+	}
+	return false
+}
+
+// escapes returns the pod settings used to escape to the node.
+func (p *pod) escapes() []string {
+	escapes := []string{}
+
+	if p.privileged() {
+		escapes = append(escapes, "privileged")
+	}
+
+	for _, hostPath := range p.hostPaths() {
+		switch path.Clean("/" + hostPath) {
+		case "/":
+			escapes = append(escapes, "host-root-mount")
+		case "/var/run/docker.sock", "/run/docker.sock", "/run/containerd/containerd.sock", "/var/run/containerd/containerd.sock":
+			escapes = append(escapes, "container-runtime-mount")
+		case "/etc", "/etc/kubernetes", "/var/lib/kubelet":
+			escapes = append(escapes, "host-config-mount")
+		}
+	}
+
+	if p.Spec.HostPID {
+		escapes = append(escapes, "host-pid")
+	}
+
+	if p.Spec.HostNetwork {
+		escapes = append(escapes, "host-network")
+	}
+
+	if p.Spec.HostIPC {
+		escapes = append(escapes, "host-ipc")
+	}
+
+	for _, c := range p.Spec.Containers {
+		if c.SecurityContext == nil || c.SecurityContext.Capabilities == nil {
+			continue
+		}
+
+		for _, capability := range c.SecurityContext.Capabilities.Add {
+			if strings.TrimPrefix(strings.ToUpper(capability), "CAP_") == "SYS_ADMIN" {
+				escapes = append(escapes, "cap-sys-admin")
+			}
+		}
+	}
+
+	return escapes
+}
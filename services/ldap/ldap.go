@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/honeytrap/honeytrap/certificates"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
@@ -59,16 +60,6 @@ var (
 // LDAP service setup
 func LDAP(options ...services.ServicerFunc) services.Servicer {
 
-	store, err := getStorage()
-	if err != nil {
-		log.Errorf("LDAP: Could not initialize storage. %s", err.Error())
-	}
-
-	cert, err := store.Certificate()
-	if err != nil {
-		log.Errorf("TLS: %s", err.Error())
-	}
-
 	s := &ldapService{
 		Server: Server{
 			Handlers: make([]requestHandler, 0, 4),
@@ -76,7 +67,7 @@ func LDAP(options ...services.ServicerFunc) services.Servicer {
 			Credentials: []string{"root:root"},
 
 			tlsConfig: &tls.Config{
-				Certificates:       []tls.Certificate{*cert},
+				GetCertificate:     certificates.Default().GetCertificate(certificates.Subject{}),
 				InsecureSkipVerify: true,
 			},
 
@@ -15,6 +15,7 @@ package services
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -22,11 +23,29 @@ import (
 	"sync"
 )
 
-func NewLimiter() *Limiter {
-	return &Limiter{
+// LimiterOption configures a Limiter.
+type LimiterOption func(*Limiter)
+
+// WithRate allows burst events at once, refilled with one event every
+// interval.
+func WithRate(interval time.Duration, burst int) LimiterOption {
+	return func(l *Limiter) {
+		l.interval = rate.Every(interval)
+		l.burst = burst
+	}
+}
+
+func NewLimiter(options ...LimiterOption) *Limiter {
+	l := &Limiter{
 		interval: rate.Every(time.Minute * 10),
 		burst:    4,
 	}
+
+	for _, o := range options {
+		o(l)
+	}
+
+	return l
 }
 
 type Limiter struct {
@@ -36,16 +55,49 @@ type Limiter struct {
 	burst    int
 }
 
-func (l *Limiter) Allow(ip net.Addr) bool {
-	limiter := rate.NewLimiter(l.interval, l.burst)
+type limiterEntry struct {
+	*rate.Limiter
 
+	// seen is the last time the entry was used, in unix nanoseconds
+	seen int64
+}
+
+func (l *Limiter) Allow(ip net.Addr) bool {
 	if ta, ok := ip.(*net.TCPAddr); ok {
-		v, _ := l.m.LoadOrStore(ta.IP.String(), limiter)
-		return v.(*rate.Limiter).Allow()
+		return l.AllowKey(ta.IP.String())
 	} else if ua, ok := ip.(*net.UDPAddr); ok {
-		v, _ := l.m.LoadOrStore(ua.IP.String(), limiter)
-		return v.(*rate.Limiter).Allow()
+		return l.AllowKey(ua.IP.String())
 	} else {
 		return false
 	}
 }
+
+// AllowKey returns whether an event for key may happen now.
+func (l *Limiter) AllowKey(key string) bool {
+	now := time.Now()
+
+	v, ok := l.m.Load(key)
+	if !ok {
+		v, _ = l.m.LoadOrStore(key, &limiterEntry{
+			Limiter: rate.NewLimiter(l.interval, l.burst),
+		})
+	}
+
+	entry := v.(*limiterEntry)
+	atomic.StoreInt64(&entry.seen, now.UnixNano())
+
+	return entry.AllowN(now, 1)
+}
+
+// Purge removes the keys which have not been used for the idle duration.
+func (l *Limiter) Purge(idle time.Duration) {
+	deadline := time.Now().Add(-idle).UnixNano()
+
+	l.m.Range(func(key, v interface{}) bool {
+		if atomic.LoadInt64(&v.(*limiterEntry).seen) < deadline {
+			l.m.Delete(key)
+		}
+
+		return true
+	})
+}
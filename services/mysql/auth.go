@@ -0,0 +1,381 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+const (
+	nativePassword      = "mysql_native_password"
+	cachingSHA2Password = "caching_sha2_password"
+)
+
+// maxAttributes is the maximum number of connection attributes added to
+// events
+const maxAttributes = 32
+
+// handshake returns the initial handshake packet of the server.
+func (s *mysqlService) handshake(connID uint32, salt []byte) []byte {
+	capabilities := s.capabilities()
+
+	charset := byte(0x21)
+	if s.Version >= "8" {
+		charset = 0xff
+	}
+
+	b := []byte{0x0a}
+	b = append(b, s.Version...)
+	b = append(b, 0)
+	b = append(b, byte(connID), byte(connID>>8), byte(connID>>16), byte(connID>>24))
+	b = append(b, salt[:8]...)
+	b = append(b, 0)
+	b = append(b, byte(capabilities), byte(capabilities>>8))
+	b = append(b, charset)
+	b = append(b, byte(serverStatusAutocommit), byte(serverStatusAutocommit>>8))
+	b = append(b, byte(capabilities>>16), byte(capabilities>>24))
+	b = append(b, byte(len(salt)+1))
+	b = append(b, make([]byte, 10)...)
+	b = append(b, salt[8:]...)
+	b = append(b, 0)
+	b = append(b, s.AuthPlugin...)
+	b = append(b, 0)
+
+	return b
+}
+
+// newSalt returns the salt of the handshake, the configured salt when it
+// has the length of a salt.
+func (s *mysqlService) newSalt() []byte {
+	if len(s.Salt) == 20 {
+		return []byte(s.Salt)
+	}
+
+	// the salt is sent as null terminated string, and shouldn't contain
+	// null bytes
+	salt := make([]byte, 20)
+	rand.Read(salt)
+
+	for i := range salt {
+		salt[i] = salt[i]&0x7f | 0x01
+		if salt[i] == '$' {
+			salt[i] = '#'
+		}
+	}
+
+	return salt
+}
+
+// capabilities returns the capabilities of the server, tls and compression
+// are not supported.
+func (s *mysqlService) capabilities() uint32 {
+	if s.Capabilities != 0 {
+		return s.Capabilities &^ (clientSSL | clientCompress | clientDeprecateEOF | clientSessionTrack)
+	}
+
+	return clientLongPassword | clientFoundRows | clientLongFlag | clientConnectWithDB |
+		clientNoSchema | clientODBC | clientLocalFiles | clientIgnoreSpace | clientProtocol41 |
+		clientInteractive | clientIgnoreSIGPIPE | clientTransactions | clientReserved |
+		clientSecureConnection | clientMultiStatements | clientMultiResults | clientPSMultiResults |
+		clientPluginAuth | clientConnectAttrs | clientPluginAuthLenEncClientData |
+		clientCanHandleExpiredPasswords
+}
+
+// handshakeResponse is the response of the client to the handshake.
+type handshakeResponse struct {
+	Capabilities uint32
+	Charset      uint8
+	Username     string
+	AuthResponse []byte
+	Database     string
+	Plugin       string
+	Attributes   map[string]string
+}
+
+func parseHandshakeResponse(b []byte) (*handshakeResponse, error) {
+	r := &reader{b: b}
+
+	resp := &handshakeResponse{
+		Attributes: map[string]string{},
+	}
+
+	if len(b) < 4 {
+		return nil, fmt.Errorf("handshake response too short")
+	}
+
+	resp.Capabilities = uint32(r.uint16())
+
+	// clients of the 3.20 protocol
+	if resp.Capabilities&clientProtocol41 == 0 {
+		r.bytes(3)
+		resp.Username = r.nullString()
+		resp.AuthResponse = []byte(r.nullString())
+		return resp, r.err
+	}
+
+	resp.Capabilities |= uint32(r.uint16()) << 16
+	r.uint32()
+	resp.Charset = r.uint8()
+	r.bytes(23)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if resp.Capabilities&clientSSL != 0 && len(r.b) == 0 {
+		return nil, fmt.Errorf("ssl is not supported")
+	}
+
+	resp.Username = r.nullString()
+
+	if resp.Capabilities&clientPluginAuthLenEncClientData != 0 {
+		resp.AuthResponse = []byte(r.lengthEncodedString())
+	} else if resp.Capabilities&clientSecureConnection != 0 {
+		resp.AuthResponse = r.bytes(int(r.uint8()))
+	} else {
+		resp.AuthResponse = []byte(r.nullString())
+	}
+
+	if resp.Capabilities&clientConnectWithDB != 0 {
+		resp.Database = r.nullString()
+	}
+
+	if resp.Capabilities&clientPluginAuth != 0 {
+		resp.Plugin = r.nullString()
+	}
+
+	if resp.Capabilities&clientConnectAttrs != 0 {
+		attrs := &reader{b: []byte(r.lengthEncodedString())}
+		for len(attrs.b) > 0 && attrs.err == nil && len(resp.Attributes) < maxAttributes {
+			key := attrs.lengthEncodedString()
+			value := attrs.lengthEncodedString()
+
+			if attrs.err == nil {
+				resp.Attributes[key] = value
+			}
+		}
+	}
+
+	return resp, r.err
+}
+
+func xor(a []byte, b []byte) []byte {
+	v := make([]byte, len(a))
+	for i := range a {
+		v[i] = a[i] ^ b[i%len(b)]
+	}
+	return v
+}
+
+// nativeScramble returns the auth response of mysql_native_password,
+// SHA1(password) XOR SHA1(salt + SHA1(SHA1(password))).
+func nativeScramble(password string, salt []byte) []byte {
+	if password == "" {
+		return []byte{}
+	}
+
+	h1 := sha1.Sum([]byte(password))
+	h2 := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte{}, salt...), h2[:]...))
+
+	return xor(h1[:], h3[:])
+}
+
+// cachingSHA2Scramble returns the auth response of caching_sha2_password,
+// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + salt).
+func cachingSHA2Scramble(password string, salt []byte) []byte {
+	if password == "" {
+		return []byte{}
+	}
+
+	h1 := sha256.Sum256([]byte(password))
+	h2 := sha256.Sum256(h1[:])
+	h3 := sha256.Sum256(append(h2[:], salt...))
+
+	return xor(h1[:], h3[:])
+}
+
+// privateKey returns the key used for the full authentication of
+// caching_sha2_password, clients encrypt the password with the public key.
+func (s *mysqlService) privateKey() (*rsa.PrivateKey, error) {
+	s.keyOnce.Do(func() {
+		s.key, s.keyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+
+	return s.key, s.keyErr
+}
+
+// login contains the result of the authentication.
+type login struct {
+	*handshakeResponse
+
+	// Password is set when the client sent the password
+	Password string
+
+	Accepted bool
+}
+
+// options returns the event options of the login.
+func (l *login) options(salt []byte) []event.Option {
+	options := []event.Option{
+		event.Custom("mysql.username", l.Username),
+		event.Custom("mysql.auth-plugin", l.Plugin),
+		event.Custom("mysql.auth-response", hex.EncodeToString(l.AuthResponse)),
+		event.Custom("mysql.salt", hex.EncodeToString(salt)),
+		event.Custom("mysql.database", l.Database),
+		event.Custom("mysql.capabilities", l.Capabilities),
+		event.Custom("mysql.accepted", l.Accepted),
+	}
+
+	if l.Password != "" {
+		options = append(options, event.Custom("mysql.password", l.Password))
+	}
+
+	// the format of hashcat and john for cracking the password
+	if l.Plugin == nativePassword && len(l.AuthResponse) == 20 {
+		options = append(options, event.Custom("mysql.hash", fmt.Sprintf("$mysqlna$%s*%s", hex.EncodeToString(salt), hex.EncodeToString(l.AuthResponse))))
+	}
+
+	for k, v := range l.Attributes {
+		options = append(options, event.Custom("mysql.attribute."+k, v))
+	}
+
+	return options
+}
+
+// authenticate reads the handshake response and authenticates the client.
+// The password is captured from the full authentication of
+// caching_sha2_password, of other methods only the scramble is known.
+func (s *mysqlService) authenticate(c *packetConn, salt []byte) (*login, error) {
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := parseHandshakeResponse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &login{handshakeResponse: resp}
+
+	if l.Plugin == "" && l.Capabilities&clientSecureConnection != 0 {
+		l.Plugin = nativePassword
+	}
+
+	// clients using another method are asked to switch to the method of
+	// the server
+	if l.Plugin != nativePassword && l.Plugin != cachingSHA2Password && l.Capabilities&clientPluginAuth != 0 {
+		b := append([]byte{0xfe}, s.AuthPlugin...)
+		b = append(b, 0)
+		b = append(b, salt...)
+		b = append(b, 0)
+
+		if err := c.writePacket(b); err != nil {
+			return nil, err
+		}
+
+		if l.AuthResponse, err = c.readPacket(); err != nil {
+			return nil, err
+		}
+
+		l.Plugin = s.AuthPlugin
+	}
+
+	password, known := s.Users[l.Username]
+
+	switch {
+	case len(l.AuthResponse) == 0:
+		l.Accepted = s.Accept || (known && password == "")
+
+	case l.Plugin == nativePassword:
+		if known && bytes.Equal(l.AuthResponse, nativeScramble(password, salt)) {
+			l.Password = password
+		}
+
+		l.Accepted = s.Accept || l.Password != ""
+
+	case l.Plugin == cachingSHA2Password:
+		if known && bytes.Equal(l.AuthResponse, cachingSHA2Scramble(password, salt)) {
+			// fast authentication, the password is cached
+			l.Password = password
+			l.Accepted = true
+			return l, c.writePacket([]byte{0x01, 0x03})
+		}
+
+		if l.Password, err = s.fullAuthentication(c, salt); err != nil {
+			return nil, err
+		}
+
+		l.Accepted = s.Accept || (known && l.Password == password)
+
+	default:
+		l.Accepted = s.Accept
+	}
+
+	return l, nil
+}
+
+// fullAuthentication asks the client for the password, without tls the
+// client requests the public key to encrypt the password with.
+func (s *mysqlService) fullAuthentication(c *packetConn, salt []byte) (string, error) {
+	if err := c.writePacket([]byte{0x01, 0x04}); err != nil {
+		return "", err
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := s.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.Equal(data, []byte{0x02}) {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return "", err
+		}
+
+		b := append([]byte{0x01}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+		if err := c.writePacket(b); err != nil {
+			return "", err
+		}
+
+		if data, err = c.readPacket(); err != nil {
+			return "", err
+		}
+	}
+
+	if len(data) == key.Size() {
+		if plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, data, nil); err == nil {
+			data = xor(plain, salt)
+		}
+	}
+
+	// the password is null terminated
+	return string(bytes.TrimRight(data, "\x00")), nil
+}
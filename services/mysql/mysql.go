@@ -0,0 +1,426 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+//  Documentation: https://dev.mysql.com/doc/dev/mysql-server/latest/PAGE_PROTOCOL.html
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/mysql")
+
+var (
+	_ = services.Register("mysql", MySQL)
+)
+
+// commands
+const (
+	comQuit        = 0x01
+	comInitDB      = 0x02
+	comQuery       = 0x03
+	comFieldList   = 0x04
+	comStatistics  = 0x09
+	comPing        = 0x0e
+	comStmtPrepare = 0x16
+	comResetConn   = 0x1f
+)
+
+// MySQL emulates a mysql server, the logins are captured and the queries
+// are answered from a fake schema.
+func MySQL(options ...services.ServicerFunc) services.Servicer {
+	s := &mysqlService{
+		mysqlServiceConfig: mysqlServiceConfig{
+			Version:       "8.0.35",
+			AuthPlugin:    cachingSHA2Password,
+			Hostname:      "db-01",
+			MaxInfileSize: 1024 * 1024,
+		},
+		started: time.Now(),
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type mysqlServiceConfig struct {
+	Version string `toml:"version"`
+
+	// AuthPlugin is the authentication method of the handshake, either
+	// caching_sha2_password or mysql_native_password. The password is
+	// captured with caching_sha2_password, with mysql_native_password
+	// only a hash of the password.
+	AuthPlugin string `toml:"auth-plugin"`
+
+	// Salt is the salt of the handshake, a random salt is used unless
+	// it is 20 characters
+	Salt string `toml:"salt"`
+
+	// Capabilities are the capability flags of the handshake, tls and
+	// compression are not supported
+	Capabilities uint32 `toml:"capabilities"`
+
+	Hostname string `toml:"hostname"`
+
+	// Accept accepts every login, otherwise only the logins of Users
+	Accept bool `toml:"accept"`
+
+	// Users contains the usernames and passwords of accepted logins
+	Users map[string]string `toml:"users"`
+
+	// Tables is the schema of the server, a default schema is used when
+	// empty
+	Tables []tableConfig `toml:"table"`
+
+	// Infile contains the files requested from clients with LOAD DATA
+	// LOCAL INFILE, one for every query until all have been requested
+	Infile []string `toml:"infile"`
+
+	// MaxInfileSize is the maximum size of a file sent by a client
+	MaxInfileSize int64 `toml:"max-infile-size"`
+}
+
+type mysqlService struct {
+	mysqlServiceConfig
+
+	c pushers.Channel
+
+	connID uint32
+
+	started time.Time
+
+	schemaOnce sync.Once
+	s          *schema
+
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+	keyErr  error
+}
+
+func (s *mysqlService) SetChannel(c pushers.Channel) {
+	s.c = c
+}
+
+// schema returns the schema of the server.
+func (s *mysqlService) schema() *schema {
+	s.schemaOnce.Do(func() {
+		s.s = newSchema(s.Tables, s.Users)
+	})
+
+	return s.s
+}
+
+// randomString returns a random alphanumeric string.
+func randomString(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	b := make([]byte, n)
+	rand.Read(b)
+
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b)
+}
+
+// session is a logged in connection.
+type session struct {
+	*packetConn
+
+	s *mysqlService
+
+	connID uint32
+	login  *login
+	db     string
+
+	// infiles are the files that still have to be requested from the
+	// client
+	infiles []string
+
+	// options are added to the events of the session
+	options []event.Option
+}
+
+func (sess *session) send(options ...event.Option) {
+	sess.s.c.Send(event.New(
+		append(sess.options, options...)...,
+	))
+}
+
+func (s *mysqlService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	c := newPacketConn(conn)
+
+	connID := atomic.AddUint32(&s.connID, 1)
+	salt := s.newSalt()
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("mysql"),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("mysql.connection-id", connID),
+	}
+
+	if err := c.writePacket(s.handshake(connID, salt)); err != nil {
+		return err
+	}
+
+	l, err := s.authenticate(c, salt)
+	if e, ok := err.(*mysqlError); ok {
+		return c.writeError(e)
+	} else if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return c.writeError(newError(1043, "08S01", "Bad handshake"))
+	}
+
+	s.c.Send(event.New(append(options,
+		append([]event.Option{event.Type("login")}, l.options(salt)...)...,
+	)...))
+
+	host := conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if !l.Accepted {
+		using := "NO"
+		if len(l.AuthResponse) > 0 {
+			using = "YES"
+		}
+
+		return c.writeError(newError(1045, "28000", "Access denied for user '%s'@'%s' (using password: %s)", l.Username, host, using))
+	}
+
+	sess := &session{
+		packetConn: c,
+		s:          s,
+		connID:     connID,
+		login:      l,
+		infiles:    append([]string{}, s.Infile...),
+		options: append(options,
+			event.Custom("mysql.username", l.Username),
+		),
+	}
+
+	if l.Database != "" {
+		if res := sess.use(l.Database); res.err != nil {
+			return c.writeError(res.err)
+		}
+	}
+
+	if err := c.writeOK(0, 0, serverStatusAutocommit, ""); err != nil {
+		return err
+	}
+
+	c.maxSize = maxPacketSize
+
+	for {
+		c.seq = 0
+
+		data, err := c.readPacket()
+		if err == io.EOF {
+			return nil
+		} else if e, ok := err.(*mysqlError); ok {
+			return c.writeError(e)
+		} else if err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case comQuit:
+			return nil
+		case comInitDB:
+			err = sess.writeResult(sess.use(string(data[1:])), serverStatusAutocommit)
+		case comQuery:
+			err = sess.query(string(data[1:]))
+		case comStmtPrepare:
+			sess.send(
+				event.Type("query"),
+				event.Custom("mysql.query", truncate(string(data[1:]))),
+				event.Custom("mysql.database", sess.db),
+				event.Custom("mysql.prepared", true),
+			)
+
+			err = c.writeError(newError(1295, "HY000", "This command is not supported in the prepared statement protocol yet"))
+		case comFieldList:
+			err = c.writeEOF(serverStatusAutocommit)
+		case comStatistics:
+			err = c.writePacket([]byte("Uptime: 1843920  Threads: 3  Questions: 2519871  Slow queries: 0  Opens: 1432  Flush tables: 3  Open tables: 1351  Queries per second avg: 1.366"))
+		case comPing, comResetConn:
+			err = c.writeOK(0, 0, serverStatusAutocommit, "")
+		default:
+			err = c.writeError(newError(1047, "08S01", "Unknown command"))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// truncate limits the length of queries in events.
+func truncate(q string) string {
+	if len(q) > maxQueryLength {
+		return q[:maxQueryLength]
+	}
+	return q
+}
+
+// query executes the statements of the query. While files have to be
+// requested, the file is requested instead.
+func (sess *session) query(q string) error {
+	if len(sess.infiles) > 0 && sess.login.Capabilities&clientLocalFiles != 0 {
+		filename := sess.infiles[0]
+		sess.infiles = sess.infiles[1:]
+
+		sess.send(
+			event.Type("query"),
+			event.Custom("mysql.query", truncate(q)),
+			event.Custom("mysql.database", sess.db),
+			event.Custom("mysql.infile", filename),
+		)
+
+		return sess.writeResult(&result{infile: filename}, serverStatusAutocommit)
+	}
+
+	statements := sess.statements(q)
+
+	for i, stmt := range statements {
+		res := sess.execute(stmt)
+
+		options := []event.Option{
+			event.Type("query"),
+			event.Custom("mysql.query", truncate(strings.TrimSpace(stmt))),
+			event.Custom("mysql.database", sess.db),
+		}
+
+		if res.err != nil {
+			options = append(options,
+				event.Custom("mysql.error-code", res.err.Code),
+				event.Custom("mysql.error", res.err.Message),
+			)
+		} else if res.resultSet {
+			options = append(options, event.Custom("mysql.rows", len(res.rows)))
+		}
+
+		sess.send(options...)
+
+		status := serverStatusAutocommit
+		if i < len(statements)-1 {
+			status |= serverMoreResultsExists
+		}
+
+		if err := sess.writeResult(res, status); err != nil {
+			return err
+		}
+
+		// the statements following an error aren't executed
+		if res.err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// writeResult writes the result of a statement.
+func (sess *session) writeResult(res *result, status uint16) error {
+	if res.err != nil {
+		return sess.writeError(res.err)
+	} else if res.infile != "" {
+		return sess.infile(res.infile, status)
+	} else if res.resultSet {
+		return sess.writeResultSet(sess.db, res.columns, res.rows, status)
+	}
+
+	return sess.writeOK(res.affectedRows, 0, status, "")
+}
+
+// infile requests the file from the client, the client sends the contents
+// of the file when local infile is enabled. The contents are saved as an
+// artifact.
+func (sess *session) infile(filename string, status uint16) error {
+	if err := sess.writePacket(append([]byte{0xfb}, filename...)); err != nil {
+		return err
+	}
+
+	buff := bytes.Buffer{}
+
+	truncated := false
+	for {
+		data, err := sess.readPacket()
+		if err != nil {
+			return err
+		}
+
+		// the contents end with an empty packet
+		if len(data) == 0 {
+			break
+		}
+
+		if int64(buff.Len()+len(data)) > sess.s.MaxInfileSize {
+			truncated = true
+			continue
+		}
+
+		buff.Write(data)
+	}
+
+	options := []event.Option{
+		event.Type("infile"),
+		event.Custom("mysql.filename", filename),
+		event.Custom("mysql.size", buff.Len()),
+		event.Custom("mysql.truncated", truncated),
+	}
+
+	if buff.Len() > 0 {
+		a, err := artifacts.Save(sess.s.c, bytes.NewReader(buff.Bytes()), filename, sess.options...)
+		if err != nil {
+			log.Errorf("Error saving infile: %s", err.Error())
+		} else {
+			options = append(options, event.Custom("mysql.sha256", a.SHA256))
+		}
+	}
+
+	sess.send(options...)
+
+	return sess.writeOK(0, 0, status, "")
+}
@@ -0,0 +1,516 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+func newService(options ...func(*mysqlService)) (*mysqlService, *pushertest.Channel) {
+	s := MySQL(func(s services.Servicer) error {
+		for _, o := range options {
+			o(s.(*mysqlService))
+		}
+		return nil
+	}).(*mysqlService)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	return s, c
+}
+
+const testCapabilities = clientLongPassword | clientLongFlag | clientLocalFiles | clientProtocol41 |
+	clientTransactions | clientSecureConnection | clientMultiResults | clientPluginAuth |
+	clientConnectAttrs | clientPluginAuthLenEncClientData
+
+// client is a minimal client of the protocol.
+type client struct {
+	*packetConn
+
+	salt []byte
+}
+
+// connect reads the handshake and sends the handshake response with the
+// auth response of the plugin, the response of the server is returned.
+func connect(t *testing.T, s *mysqlService, user string, password string, plugin string, db string) (*client, []byte) {
+	conn, server := net.Pipe()
+
+	go s.Handle(context.TODO(), server)
+
+	c := &client{packetConn: newPacketConn(conn)}
+
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reader{b: data}
+	if r.uint8() != 0x0a {
+		t.Fatalf("Unexpected protocol version")
+	}
+
+	if version := r.nullString(); version != s.Version {
+		t.Fatalf("Unexpected version %s", version)
+	}
+
+	r.uint32()
+	c.salt = append([]byte{}, r.bytes(8)...)
+	r.bytes(1 + 2 + 1 + 2 + 2 + 1 + 10)
+	c.salt = append(c.salt, r.bytes(12)...)
+	r.uint8()
+
+	if v := r.nullString(); v != s.AuthPlugin {
+		t.Fatalf("Unexpected auth plugin %s", v)
+	}
+
+	auth := nativeScramble(password, c.salt)
+	if plugin == cachingSHA2Password {
+		auth = cachingSHA2Scramble(password, c.salt)
+	}
+
+	capabilities := testCapabilities
+	if db != "" {
+		capabilities |= clientConnectWithDB
+	}
+
+	b := []byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)}
+	b = append(b, 0, 0, 0, 1, 0xff)
+	b = append(b, make([]byte, 23)...)
+	b = append(b, user...)
+	b = append(b, 0)
+	b = appendLengthEncodedString(b, string(auth))
+
+	if db != "" {
+		b = append(b, db...)
+		b = append(b, 0)
+	}
+
+	b = append(b, plugin...)
+	b = append(b, 0)
+
+	attrs := appendLengthEncodedString(nil, "_client_name")
+	attrs = appendLengthEncodedString(attrs, "libmysql")
+	attrs = appendLengthEncodedString(attrs, "program_name")
+	attrs = appendLengthEncodedString(attrs, "mysql")
+	b = appendLengthEncodedString(b, string(attrs))
+
+	if err := c.writePacket(b); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, resp
+}
+
+// mustLogin connects and expects the login to be accepted.
+func mustLogin(t *testing.T, s *mysqlService, user string, password string, db string) *client {
+	c, resp := connect(t, s, user, password, nativePassword, db)
+	if resp[0] != 0x00 {
+		t.Fatalf("Expected login to be accepted, got %q", resp)
+	}
+	return c
+}
+
+// query sends the query and returns the columns and rows of the result, or
+// the error.
+func (c *client) query(t *testing.T, q string) ([]string, [][]interface{}, *mysqlError) {
+	c.seq = 0
+	if err := c.writePacket(append([]byte{comQuery}, q...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reader{b: data}
+
+	switch data[0] {
+	case 0x00:
+		return nil, nil, nil
+	case 0xff:
+		r.uint8()
+		code := r.uint16()
+		r.bytes(6)
+		return nil, nil, &mysqlError{Code: code, Message: string(r.b)}
+	}
+
+	columns := []string{}
+	for i := r.lengthEncodedInteger(); i > 0; i-- {
+		data, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := &reader{b: data}
+		for j := 0; j < 4; j++ {
+			r.lengthEncodedString()
+		}
+
+		columns = append(columns, r.lengthEncodedString())
+	}
+
+	if data, err := c.readPacket(); err != nil || data[0] != 0xfe {
+		t.Fatalf("Expected EOF after columns, got %q", data)
+	}
+
+	rows := [][]interface{}{}
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data[0] == 0xfe && len(data) < 9 {
+			return columns, rows, nil
+		}
+
+		r := &reader{b: data}
+
+		row := []interface{}{}
+		for range columns {
+			if r.b[0] == 0xfb {
+				r.uint8()
+				row = append(row, nil)
+			} else {
+				row = append(row, r.lengthEncodedString())
+			}
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func TestNativePassword(t *testing.T) {
+	s, ch := newService(func(s *mysqlService) {
+		s.AuthPlugin = nativePassword
+		s.Users = map[string]string{"root": "secret"}
+	})
+
+	c := mustLogin(t, s, "root", "secret", "")
+	defer c.Close()
+
+	logins := ch.Find("login")
+	if len(logins) != 1 {
+		t.Fatalf("Expected login event, got %d", len(logins))
+	}
+
+	for key, want := range map[string]interface{}{
+		"mysql.username":               "root",
+		"mysql.password":               "secret",
+		"mysql.auth-plugin":            nativePassword,
+		"mysql.accepted":               true,
+		"mysql.attribute._client_name": "libmysql",
+		"mysql.attribute.program_name": "mysql",
+	} {
+		if v, _ := logins[0].Load(key); v != want {
+			t.Errorf("Expected %s to be %v, got %v", key, want, v)
+		}
+	}
+
+	if v := logins[0].Get("mysql.hash"); !strings.HasPrefix(v, "$mysqlna$") || len(v) != len("$mysqlna$")+40+1+40 {
+		t.Errorf("Unexpected hash %s", v)
+	}
+
+	for _, test := range []struct {
+		query   string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"SELECT @@version_comment LIMIT 1", []string{"@@version_comment"}, [][]interface{}{{"MySQL Community Server - GPL"}}},
+		{"select version() as v, database(), 0x726f6f74", []string{"v", "database()", "0x726f6f74"}, [][]interface{}{{"8.0.35", nil, "root"}}},
+		{"SHOW DATABASES", []string{"Database"}, [][]interface{}{{"information_schema"}, {"mysql"}, {"performance_schema"}, {"production"}, {"sys"}}},
+		{"SHOW TABLES FROM `production` LIKE 'user%'", []string{"Tables_in_production"}, [][]interface{}{{"users"}}},
+		{"SELECT username, `users`.password FROM production.users WHERE role = 'admin'", []string{"username", "password"}, [][]interface{}{{"admin", "$2y$10$Qx6ZrOq3n0H7dLXbA1nM8uVY0P3y7cNwz1mHk8bS9oXgq2aTjL5eW"}}},
+		{"/* ApplicationName=DBeaver */ SELECT COUNT(*) FROM production.customers", []string{"COUNT(*)"}, [][]interface{}{{"3"}}},
+		{"SELECT id, name FROM production.customers ORDER BY id LIMIT 1, 1", []string{"id", "name"}, [][]interface{}{{"2", "Mark Peters"}}},
+		{"SELECT User, plugin FROM mysql.user", []string{"User", "plugin"}, [][]interface{}{{"root", nativePassword}}},
+		{"SELECT table_name FROM information_schema.tables WHERE table_schema = 'production'", []string{"TABLE_NAME"}, [][]interface{}{{"users"}, {"customers"}, {"orders"}}},
+	} {
+		columns, rows, err := c.query(t, test.query)
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(columns, test.columns) {
+			t.Errorf("%s: expected columns %v, got %v", test.query, test.columns, columns)
+		}
+
+		if !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("%s: expected rows %v, got %v", test.query, test.rows, rows)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		code  uint16
+	}{
+		{"SELECT * FROM users", 1046},
+		{"USE shop", 1049},
+		{"SELECT * FROM production.accounts", 1146},
+		{"SELECT @@nope", 1193},
+		{"SELECT 'a' INTO OUTFILE '/var/www/html/shell.php'", 1290},
+		{"EXEC xp_cmdshell 'whoami'", 1064},
+		{"SELECT 1 FROM", 1064},
+		{"SELECT * FROM mysql.user LIMIT -1,1", 1064},
+		{"", 1065},
+	} {
+		if _, _, err := c.query(t, test.query); err == nil || err.Code != test.code {
+			t.Errorf("%s: expected error %d, got %v", test.query, test.code, err)
+		}
+	}
+
+	queries := ch.Find("query")
+	if len(queries) != 18 {
+		t.Fatalf("Expected 18 query events, got %d", len(queries))
+	}
+
+	if v := queries[4].Get("mysql.query"); v != "SELECT username, `users`.password FROM production.users WHERE role = 'admin'" {
+		t.Errorf("Unexpected query %s", v)
+	}
+
+	if v, _ := queries[4].Load("mysql.rows"); v != 1 {
+		t.Errorf("Expected 1 row, got %v", v)
+	}
+
+	if v, _ := queries[11].Load("mysql.error-code"); v != uint16(1146) {
+		t.Errorf("Expected error code, got %v", v)
+	}
+}
+
+func TestAccessDenied(t *testing.T) {
+	s, ch := newService(func(s *mysqlService) {
+		s.Users = map[string]string{"root": "secret"}
+	})
+
+	c, resp := connect(t, s, "root", "123456", nativePassword, "")
+	defer c.Close()
+
+	if resp[0] != 0xff || !strings.Contains(string(resp), "Access denied for user 'root'@'pipe' (using password: YES)") {
+		t.Fatalf("Expected access to be denied, got %q", resp)
+	}
+
+	logins := ch.Find("login")
+	if len(logins) != 1 {
+		t.Fatalf("Expected login event, got %d", len(logins))
+	}
+
+	if v, _ := logins[0].Load("mysql.accepted"); v != false {
+		t.Errorf("Expected login to be refused")
+	}
+
+	if _, ok := logins[0].Load("mysql.password"); ok {
+		t.Errorf("Expected password to be unknown")
+	}
+
+	if logins[0].Get("mysql.hash") == "" {
+		t.Errorf("Expected hash")
+	}
+}
+
+func TestMaxAuthPacket(t *testing.T) {
+	s, _ := newService()
+
+	conn, server := net.Pipe()
+	defer conn.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	c := newPacketConn(conn)
+
+	if _, err := c.readPacket(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a handshake response of 1MB is refused before the payload is sent
+	if _, err := conn.Write([]byte{0x00, 0x00, 0x10, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp[0] != 0xff || resp[1] != byte(1153&0xff) || resp[2] != byte(1153>>8) {
+		t.Errorf("Expected max_allowed_packet error, got %q", resp)
+	}
+}
+
+func TestCachingSHA2Password(t *testing.T) {
+	s, ch := newService(func(s *mysqlService) {
+		s.Accept = true
+	})
+
+	c, resp := connect(t, s, "admin", "hunter2", cachingSHA2Password, "production")
+	defer c.Close()
+
+	if !reflect.DeepEqual(resp, []byte{0x01, 0x04}) {
+		t.Fatalf("Expected full authentication, got %q", resp)
+	}
+
+	// request the public key
+	if err := c.writePacket([]byte{0x02}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data[1:])
+	if block == nil {
+		t.Fatalf("Expected public key, got %q", data)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, key.(*rsa.PublicKey), xor([]byte("hunter2\x00"), c.salt), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.writePacket(encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := c.readPacket(); err != nil || data[0] != 0x00 {
+		t.Fatalf("Expected login to be accepted, got %q", data)
+	}
+
+	logins := ch.Find("login")
+	if len(logins) != 1 || logins[0].Get("mysql.password") != "hunter2" || logins[0].Get("mysql.database") != "production" {
+		t.Fatalf("Expected password to be captured, got %v", logins)
+	}
+
+	if _, rows, err := c.query(t, "SELECT DATABASE()"); err != nil || rows[0][0] != "production" {
+		t.Errorf("Expected database to be selected, got %v %v", rows, err)
+	}
+}
+
+func TestInfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := artifacts.New(artifacts.WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts.SetDefault(store)
+	defer artifacts.SetDefault(nil)
+
+	s, ch := newService(func(s *mysqlService) {
+		s.Accept = true
+		s.Infile = []string{"/etc/passwd"}
+	})
+
+	c := mustLogin(t, s, "root", "", "")
+	defer c.Close()
+
+	// the first query is answered with the request for the file
+	c.seq = 0
+	if err := c.writePacket(append([]byte{comQuery}, "SELECT @@version_comment LIMIT 1"...)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "\xfb/etc/passwd" {
+		t.Fatalf("Expected file request, got %q", data)
+	}
+
+	passwd := "root:x:0:0:root:/root:/bin/bash\n"
+
+	c.writePacket([]byte(passwd))
+	c.writePacket([]byte{})
+
+	if data, err := c.readPacket(); err != nil || data[0] != 0x00 {
+		t.Fatalf("Expected OK, got %q", data)
+	}
+
+	infiles := ch.Find("infile")
+	if len(infiles) != 1 || infiles[0].Get("mysql.filename") != "/etc/passwd" {
+		t.Fatalf("Unexpected infile events %v", infiles)
+	}
+
+	if v, _ := infiles[0].Load("mysql.size"); v != len(passwd) {
+		t.Errorf("Expected size %d, got %v", len(passwd), v)
+	}
+
+	a, err := artifacts.Default().Get(infiles[0].Get("mysql.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a.Names, []string{"/etc/passwd"}) {
+		t.Errorf("Unexpected artifact names %v", a.Names)
+	}
+
+	// the following queries are answered
+	if _, rows, err := c.query(t, "SELECT @@version_comment LIMIT 1"); err != nil || len(rows) != 1 {
+		t.Errorf("Expected query to be answered, got %v %v", rows, err)
+	}
+
+	// files loaded by the client are requested too
+	c.seq = 0
+	c.writePacket(append([]byte{comQuery}, "LOAD DATA LOCAL INFILE '/root/.ssh/id_rsa' INTO TABLE t"...))
+
+	if data, err := c.readPacket(); err != nil || string(data) != "\xfb/root/.ssh/id_rsa" {
+		t.Fatalf("Expected file request, got %q", data)
+	}
+
+	c.writePacket([]byte{})
+
+	if data, err := c.readPacket(); err != nil || data[0] != 0x00 {
+		t.Fatalf("Expected OK, got %q", data)
+	}
+
+	if infiles := ch.Find("infile"); len(infiles) != 2 || infiles[1].Get("mysql.filename") != "/root/.ssh/id_rsa" {
+		t.Errorf("Unexpected infile events %v", infiles)
+	}
+}
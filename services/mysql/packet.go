@@ -0,0 +1,361 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	// maxPayloadLength is the maximum length of the payload of a single
+	// packet, longer payloads are split over multiple packets
+	maxPayloadLength = 1<<24 - 1
+
+	// maxPacketSize is the maximum size of a payload read, like
+	// max_allowed_packet
+	maxPacketSize = 16 * 1024 * 1024
+
+	// maxAuthPacketSize is the maximum size of a payload read before the
+	// client has authenticated, the handshake response is small
+	maxAuthPacketSize = 64 * 1024
+)
+
+// capability flags
+const (
+	clientLongPassword uint32 = 1 << iota
+	clientFoundRows
+	clientLongFlag
+	clientConnectWithDB
+	clientNoSchema
+	clientCompress
+	clientODBC
+	clientLocalFiles
+	clientIgnoreSpace
+	clientProtocol41
+	clientInteractive
+	clientSSL
+	clientIgnoreSIGPIPE
+	clientTransactions
+	clientReserved
+	clientSecureConnection
+	clientMultiStatements
+	clientMultiResults
+	clientPSMultiResults
+	clientPluginAuth
+	clientConnectAttrs
+	clientPluginAuthLenEncClientData
+	clientCanHandleExpiredPasswords
+	clientSessionTrack
+	clientDeprecateEOF
+)
+
+// status flags
+const (
+	serverStatusAutocommit  uint16 = 0x0002
+	serverMoreResultsExists uint16 = 0x0008
+)
+
+// column types
+const (
+	typeLongLong  = 0x08
+	typeVarString = 0xfd
+)
+
+// mysqlError is an error sent to the client.
+type mysqlError struct {
+	Code    uint16
+	State   string
+	Message string
+}
+
+func (e *mysqlError) Error() string {
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.State, e.Message)
+}
+
+func newError(code uint16, state string, format string, a ...interface{}) *mysqlError {
+	return &mysqlError{
+		Code:    code,
+		State:   state,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// packetConn reads and writes the packets of the protocol, keeping track
+// of the sequence id.
+type packetConn struct {
+	net.Conn
+
+	r *bufio.Reader
+
+	seq byte
+
+	// maxSize is the maximum size of a payload read, raised to
+	// maxPacketSize when the client has authenticated
+	maxSize int
+}
+
+func newPacketConn(conn net.Conn) *packetConn {
+	return &packetConn{
+		Conn:    conn,
+		r:       bufio.NewReader(conn),
+		maxSize: maxAuthPacketSize,
+	}
+}
+
+// readPacket reads a payload, joining the packets of payloads exceeding the
+// maximum packet length. The payload is read incrementally, so the memory
+// used grows with the data actually sent instead of the length announced.
+func (c *packetConn) readPacket() ([]byte, error) {
+	payload := bytes.Buffer{}
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return nil, err
+		}
+
+		length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		c.seq = header[3] + 1
+
+		if payload.Len()+length > c.maxSize {
+			return nil, newError(1153, "08S01", "Got a packet bigger than 'max_allowed_packet' bytes")
+		}
+
+		if _, err := io.CopyN(&payload, c.r, int64(length)); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if length < maxPayloadLength {
+			return payload.Bytes(), nil
+		}
+	}
+}
+
+// writePacket writes the payload with the next sequence id.
+func (c *packetConn) writePacket(payload []byte) error {
+	for {
+		length := len(payload)
+		if length > maxPayloadLength {
+			length = maxPayloadLength
+		}
+
+		header := []byte{byte(length), byte(length >> 8), byte(length >> 16), c.seq}
+		c.seq++
+
+		if _, err := c.Write(append(header, payload[:length]...)); err != nil {
+			return err
+		}
+
+		payload = payload[length:]
+
+		if length < maxPayloadLength {
+			return nil
+		}
+	}
+}
+
+// writeOK writes an OK packet.
+func (c *packetConn) writeOK(affectedRows uint64, insertID uint64, status uint16, info string) error {
+	b := []byte{0x00}
+	b = appendLengthEncodedInteger(b, affectedRows)
+	b = appendLengthEncodedInteger(b, insertID)
+	b = append(b, byte(status), byte(status>>8), 0, 0)
+	b = append(b, info...)
+
+	return c.writePacket(b)
+}
+
+// writeError writes an ERR packet.
+func (c *packetConn) writeError(e *mysqlError) error {
+	b := []byte{0xff, byte(e.Code), byte(e.Code >> 8), '#'}
+	b = append(b, e.State...)
+	b = append(b, e.Message...)
+
+	return c.writePacket(b)
+}
+
+// writeEOF writes an EOF packet.
+func (c *packetConn) writeEOF(status uint16) error {
+	return c.writePacket([]byte{0xfe, 0, 0, byte(status), byte(status >> 8)})
+}
+
+// column is a column of a result set.
+type column struct {
+	Name  string
+	Table string
+	Type  byte
+}
+
+// writeResultSet writes the columns and rows of a result set, nil values
+// are written as NULL.
+func (c *packetConn) writeResultSet(db string, columns []column, rows [][]interface{}, status uint16) error {
+	if err := c.writePacket(appendLengthEncodedInteger(nil, uint64(len(columns)))); err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		b := appendLengthEncodedString(nil, "def")
+		b = appendLengthEncodedString(b, db)
+		b = appendLengthEncodedString(b, col.Table)
+		b = appendLengthEncodedString(b, col.Table)
+		b = appendLengthEncodedString(b, col.Name)
+		b = appendLengthEncodedString(b, col.Name)
+		b = append(b, 0x0c)
+
+		// character set, column length, type, flags and decimals
+		if col.Type == typeLongLong {
+			b = append(b, 0x3f, 0x00, 20, 0, 0, 0, col.Type, 0x81, 0x00, 0x00)
+		} else {
+			b = append(b, 0xff, 0x00, 0x00, 0x04, 0, 0, col.Type, 0x00, 0x00, 0x1f)
+		}
+
+		b = append(b, 0, 0)
+
+		if err := c.writePacket(b); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeEOF(status); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		b := []byte{}
+		for _, v := range row {
+			if v == nil {
+				b = append(b, 0xfb)
+			} else {
+				b = appendLengthEncodedString(b, fmt.Sprint(v))
+			}
+		}
+
+		if err := c.writePacket(b); err != nil {
+			return err
+		}
+	}
+
+	return c.writeEOF(status)
+}
+
+func appendLengthEncodedInteger(b []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		b = append(b, 0xfe, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], n)
+		return b
+	}
+}
+
+func appendLengthEncodedString(b []byte, s string) []byte {
+	b = appendLengthEncodedInteger(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// reader reads the fields of a payload, reads past the end return zero
+// values.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return nil
+	}
+
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// nullString reads a string terminated by a NUL byte, or the end of the
+// payload.
+func (r *reader) nullString() string {
+	i := bytes.IndexByte(r.b, 0)
+	if i < 0 {
+		s := string(r.b)
+		r.b = nil
+		return s
+	}
+
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s
+}
+
+func (r *reader) lengthEncodedInteger() uint64 {
+	switch v := r.uint8(); v {
+	case 0xfc:
+		return uint64(r.uint16())
+	case 0xfd:
+		b := r.bytes(3)
+		if b == nil {
+			return 0
+		}
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+	case 0xfe:
+		if b := r.bytes(8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+		return 0
+	default:
+		return uint64(v)
+	}
+}
+
+func (r *reader) lengthEncodedString() string {
+	n := r.lengthEncodedInteger()
+	if n > uint64(len(r.b)) {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return ""
+	}
+
+	return string(r.bytes(int(n)))
+}
@@ -0,0 +1,974 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxQueryLength is the maximum length of queries in events
+const maxQueryLength = 4096
+
+// result is the result of a statement.
+type result struct {
+	columns []column
+	rows    [][]interface{}
+
+	// resultSet is set when columns and rows are returned, otherwise an
+	// OK packet is returned
+	resultSet bool
+
+	affectedRows uint64
+
+	// infile is the file requested from the client
+	infile string
+
+	err *mysqlError
+}
+
+func errorResult(code uint16, state string, format string, a ...interface{}) *result {
+	return &result{
+		err: newError(code, state, format, a...),
+	}
+}
+
+func syntaxError(q string) *result {
+	near := q
+	if len(near) > 80 {
+		near = near[:80]
+	}
+
+	return errorResult(1064, "42000", "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near '%s' at line 1", near)
+}
+
+// scan calls fn for every byte of q outside of quotes, with the nesting
+// depth of parentheses, until fn returns false.
+func scan(q string, fn func(i int, depth int) bool) {
+	quote := byte(0)
+	depth := 0
+
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+
+		if !fn(i, depth) {
+			return
+		}
+	}
+}
+
+// split splits q at the separator, outside of quotes and parentheses.
+func split(q string, sep byte) []string {
+	parts := []string{}
+
+	start := 0
+	scan(q, func(i int, depth int) bool {
+		if depth == 0 && q[i] == sep {
+			parts = append(parts, q[start:i])
+			start = i + 1
+		}
+		return true
+	})
+
+	return append(parts, q[start:])
+}
+
+// indexKeyword returns the index of the keyword in q, outside of quotes
+// and parentheses, -1 if q doesn't contain the keyword.
+func indexKeyword(q string, keyword string) int {
+	index := -1
+
+	scan(q, func(i int, depth int) bool {
+		if depth != 0 || i+len(keyword) > len(q) || !strings.EqualFold(q[i:i+len(keyword)], keyword) {
+			return true
+		}
+
+		if i > 0 && isIdentifier(q[i-1]) {
+			return true
+		}
+
+		if end := i + len(keyword); end < len(q) && isIdentifier(q[end]) {
+			return true
+		}
+
+		index = i
+		return false
+	})
+
+	return index
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+var (
+	comments   = regexp.MustCompile(`(?s)^(\s*(/\*.*?\*/|(--|#)[^\n]*(\n|$)))*`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// normalize removes leading comments, as sent by connectors, and the
+// statement terminator and collapses whitespace.
+func normalize(q string) string {
+	q = comments.ReplaceAllString(q, "")
+	q = whitespace.ReplaceAllString(q, " ")
+	q = strings.TrimSpace(q)
+	q = strings.TrimRight(q, "; ")
+	return q
+}
+
+// unquote removes the quotes of identifiers and strings.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return s
+	}
+
+	switch q := s[0]; {
+	case q == '`' && s[len(s)-1] == '`':
+		return strings.Replace(s[1:len(s)-1], "``", "`", -1)
+	case (q == '\'' || q == '"') && s[len(s)-1] == q:
+		v := s[1 : len(s)-1]
+		v = strings.Replace(v, string(q)+string(q), string(q), -1)
+		v = strings.Replace(v, `\`+string(q), string(q), -1)
+		return strings.Replace(v, `\\`, `\`, -1)
+	}
+
+	return s
+}
+
+// splitName splits a qualified name, like db.table, in its unquoted parts.
+func splitName(name string) []string {
+	parts := []string{}
+	for _, part := range split(strings.TrimSpace(name), '.') {
+		parts = append(parts, unquote(part))
+	}
+	return parts
+}
+
+// like returns whether the value matches the pattern of LIKE.
+func like(pattern string, value string) bool {
+	expr := "(?is)^"
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			expr += ".*"
+		case '_':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+
+	re, err := regexp.Compile(expr + "$")
+	return err == nil && re.MatchString(value)
+}
+
+// filter returns the rows matching the pattern of the LIKE clause in rest,
+// the value of the column at index is matched.
+func filter(rest string, rows [][]interface{}, index int) [][]interface{} {
+	i := indexKeyword(rest, "LIKE")
+	if i < 0 {
+		return rows
+	}
+
+	pattern := unquote(rest[i+4:])
+
+	filtered := [][]interface{}{}
+	for _, row := range rows {
+		if like(pattern, fmt.Sprint(row[index])) {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// stringColumns returns columns of strings with the names.
+func stringColumns(table string, names ...string) []column {
+	columns := make([]column, len(names))
+	for i, name := range names {
+		columns[i] = column{Name: name, Table: table, Type: typeVarString}
+	}
+	return columns
+}
+
+// variables returns the system variables of the session.
+func (sess *session) variables() map[string]string {
+	return map[string]string{
+		"auto_increment_increment":      "1",
+		"auto_increment_offset":         "1",
+		"autocommit":                    "1",
+		"basedir":                       "/usr/",
+		"character_set_client":          "utf8mb4",
+		"character_set_connection":      "utf8mb4",
+		"character_set_database":        "utf8mb4",
+		"character_set_results":         "utf8mb4",
+		"character_set_server":          "utf8mb4",
+		"character_set_system":          "utf8mb3",
+		"collation_connection":          "utf8mb4_0900_ai_ci",
+		"collation_server":              "utf8mb4_0900_ai_ci",
+		"datadir":                       "/var/lib/mysql/",
+		"default_authentication_plugin": sess.s.AuthPlugin,
+		"general_log":                   "OFF",
+		"have_ssl":                      "DISABLED",
+		"hostname":                      sess.s.Hostname,
+		"init_connect":                  "",
+		"interactive_timeout":           "28800",
+		"license":                       "GPL",
+		"local_infile":                  "ON",
+		"log_bin":                       "ON",
+		"lower_case_table_names":        "0",
+		"max_allowed_packet":            "67108864",
+		"max_connections":               "151",
+		"net_buffer_length":             "16384",
+		"net_write_timeout":             "60",
+		"performance_schema":            "ON",
+		"plugin_dir":                    "/usr/lib/mysql/plugin/",
+		"port":                          "3306",
+		"query_cache_size":              "0",
+		"query_cache_type":              "OFF",
+		"secure_file_priv":              "/var/lib/mysql-files/",
+		"server_id":                     "1",
+		"socket":                        "/var/run/mysqld/mysqld.sock",
+		"sql_mode":                      "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION",
+		"system_time_zone":              "UTC",
+		"time_zone":                     "SYSTEM",
+		"tmpdir":                        "/tmp",
+		"transaction_isolation":         "REPEATABLE-READ",
+		"tx_isolation":                  "REPEATABLE-READ",
+		"version":                       sess.s.Version,
+		"version_comment":               "MySQL Community Server - GPL",
+		"version_compile_machine":       "x86_64",
+		"version_compile_os":            "Linux",
+		"wait_timeout":                  "28800",
+	}
+}
+
+var (
+	function = regexp.MustCompile(`^([A-Za-z_]+)\s*\((.*)\)$`)
+	hexValue = regexp.MustCompile(`^0x([0-9A-Fa-f]+)$`)
+	name     = regexp.MustCompile("^`?[A-Za-z_][A-Za-z0-9_$]*`?$")
+)
+
+// user returns the user of the session as user@host.
+func (sess *session) user() string {
+	host := sess.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return sess.login.Username + "@" + host
+}
+
+// eval evaluates a value expression, which is not a column. Expressions that
+// aren't supported evaluate to NULL.
+func (sess *session) eval(expr string) (interface{}, *mysqlError) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, "@@") {
+		v := strings.ToLower(expr[2:])
+		for _, scope := range []string{"global.", "session.", "local."} {
+			v = strings.TrimPrefix(v, scope)
+		}
+
+		value, ok := sess.variables()[v]
+		if !ok {
+			return nil, newError(1193, "HY000", "Unknown system variable '%s'", v)
+		}
+
+		return value, nil
+	}
+
+	if strings.EqualFold(expr, "null") {
+		return nil, nil
+	} else if _, err := strconv.ParseFloat(expr, 64); err == nil {
+		return expr, nil
+	} else if m := hexValue.FindStringSubmatch(expr); m != nil {
+		if b, err := hex.DecodeString(m[1]); err == nil {
+			return string(b), nil
+		}
+	} else if len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0] {
+		return unquote(expr), nil
+	}
+
+	if m := function.FindStringSubmatch(expr); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "version":
+			return sess.s.Version, nil
+		case "database", "schema":
+			if sess.db == "" {
+				return nil, nil
+			}
+			return sess.db, nil
+		case "user", "session_user", "system_user":
+			return sess.user(), nil
+		case "current_user":
+			return sess.login.Username + "@%", nil
+		case "connection_id":
+			return fmt.Sprintf("%d", sess.connID), nil
+		case "now", "current_timestamp", "sysdate", "localtime":
+			return time.Now().UTC().Format("2006-01-02 15:04:05"), nil
+		case "sleep", "benchmark":
+			return "0", nil
+		case "concat":
+			v := ""
+			for _, arg := range split(m[2], ',') {
+				value, err := sess.eval(arg)
+				if err != nil {
+					return nil, err
+				} else if value == nil {
+					return nil, nil
+				}
+
+				v += fmt.Sprint(value)
+			}
+			return v, nil
+		}
+
+		return nil, nil
+	}
+
+	if name.MatchString(expr) {
+		return nil, newError(1054, "42S22", "Unknown column '%s' in 'field list'", unquote(expr))
+	}
+
+	return nil, nil
+}
+
+// alias splits the alias of a select expression from the expression.
+func alias(expr string) (string, string) {
+	expr = strings.TrimSpace(expr)
+
+	if i := indexKeyword(expr, "AS"); i > 0 {
+		return strings.TrimSpace(expr[:i]), unquote(expr[i+2:])
+	}
+
+	return expr, expr
+}
+
+// splitClauses splits the select statement in the select expressions and
+// the clauses following it.
+func splitClauses(q string) (string, map[string]string) {
+	type position struct {
+		keyword string
+		index   int
+	}
+
+	positions := []position{}
+	for _, keyword := range []string{"FROM", "WHERE", "GROUP BY", "HAVING", "ORDER BY", "LIMIT", "INTO", "FOR UPDATE"} {
+		if i := indexKeyword(q, keyword); i >= 0 {
+			positions = append(positions, position{keyword, i})
+		}
+	}
+
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].index < positions[j].index
+	})
+
+	values := map[string]string{}
+
+	end := len(q)
+	for i := len(positions) - 1; i >= 0; i-- {
+		p := positions[i]
+		values[p.keyword] = strings.TrimSpace(q[p.index+len(p.keyword) : end])
+		end = p.index
+	}
+
+	return strings.TrimSpace(q[:end]), values
+}
+
+var and = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+var selectModifiers = regexp.MustCompile(`(?i)^((ALL|DISTINCT|DISTINCTROW|HIGH_PRIORITY|STRAIGHT_JOIN|SQL_SMALL_RESULT|SQL_BIG_RESULT|SQL_BUFFER_RESULT|SQL_NO_CACHE|SQL_CALC_FOUND_ROWS)\s+)*`)
+
+// where returns the rows matching the conditions, only equality of columns
+// and values is supported. Other conditions don't filter the rows.
+func where(t *table, conditions string, rows [][]interface{}) ([][]interface{}, *mysqlError) {
+	if conditions == "" {
+		return rows, nil
+	}
+
+	type condition struct {
+		index int
+		value string
+	}
+
+	filters := []condition{}
+
+	for _, cond := range and.Split(conditions, -1) {
+		parts := split(cond, '=')
+		if len(parts) != 2 || strings.ContainsAny(parts[0], "<>!") {
+			return rows, nil
+		}
+
+		left, right := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !name.MatchString(strings.TrimSpace(left[strings.LastIndex(left, ".")+1:])) {
+			return rows, nil
+		}
+
+		names := splitName(left)
+		index := t.column(names[len(names)-1])
+		if index < 0 {
+			return nil, newError(1054, "42S22", "Unknown column '%s' in 'where clause'", left)
+		}
+
+		if _, err := strconv.ParseFloat(right, 64); err != nil && !strings.HasPrefix(right, "'") && !strings.HasPrefix(right, `"`) {
+			return rows, nil
+		}
+
+		filters = append(filters, condition{index, unquote(right)})
+	}
+
+	filtered := [][]interface{}{}
+
+	for _, row := range rows {
+		match := true
+		for _, f := range filters {
+			if !strings.EqualFold(fmt.Sprint(row[f.index]), f.value) {
+				match = false
+			}
+		}
+
+		if match {
+			filtered = append(filtered, row)
+		}
+	}
+
+	return filtered, nil
+}
+
+// limit returns the rows of the limit clause, false if the offset or row
+// count isn't a positive number.
+func limit(clause string, rows [][]interface{}) ([][]interface{}, bool) {
+	if clause == "" {
+		return rows, true
+	}
+
+	offset, count := "0", ""
+
+	clause = strings.ToUpper(clause)
+	if parts := strings.Split(clause, ","); len(parts) == 2 {
+		offset, count = parts[0], parts[1]
+	} else if parts := strings.Split(clause, " OFFSET "); len(parts) == 2 {
+		count, offset = parts[0], parts[1]
+	} else {
+		count = clause
+	}
+
+	o, err := strconv.ParseUint(strings.TrimSpace(offset), 10, 31)
+	if err != nil {
+		return nil, false
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(count), 10, 31)
+	if err != nil {
+		return nil, false
+	}
+
+	start, end := int(o), int(o)+int(n)
+
+	if start > len(rows) {
+		start = len(rows)
+	}
+
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	return rows[start:end], true
+}
+
+func (sess *session) selectStmt(q string) *result {
+	exprs, clauses := splitClauses(selectModifiers.ReplaceAllString(q[len("SELECT "):], ""))
+
+	if into, ok := clauses["INTO"]; ok && (strings.HasPrefix(strings.ToUpper(into), "OUTFILE") || strings.HasPrefix(strings.ToUpper(into), "DUMPFILE")) {
+		return errorResult(1290, "HY000", "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement")
+	}
+
+	from, ok := clauses["FROM"]
+	if !ok || strings.EqualFold(from, "DUAL") {
+		res := &result{resultSet: true}
+
+		row := []interface{}{}
+		for _, expr := range split(exprs, ',') {
+			expr, name := alias(expr)
+
+			v, err := sess.eval(expr)
+			if err != nil {
+				return &result{err: err}
+			}
+
+			res.columns = append(res.columns, column{Name: name, Type: typeVarString})
+			row = append(row, v)
+		}
+
+		res.rows = [][]interface{}{row}
+		return res
+	}
+
+	// joins and multiple tables return the rows of the first table
+	fields := strings.Fields(split(from, ',')[0])
+	if len(fields) == 0 {
+		return syntaxError(q)
+	}
+
+	ref := fields[0]
+
+	names := splitName(ref)
+
+	db := sess.db
+	if len(names) == 2 {
+		db = names[0]
+	} else if db == "" {
+		return errorResult(1046, "3D000", "No database selected")
+	}
+
+	if sess.s.schema().database(db) == "" {
+		return errorResult(1049, "42000", "Unknown database '%s'", db)
+	}
+
+	t := sess.s.schema().table(db, names[len(names)-1])
+	if t == nil {
+		return errorResult(1146, "42S02", "Table '%s.%s' doesn't exist", db, names[len(names)-1])
+	}
+
+	rows, err := where(t, clauses["WHERE"], t.Rows)
+	if err != nil {
+		return &result{err: err}
+	}
+
+	types := t.types()
+
+	// matched is the number of rows before aggregation
+	matched := len(rows)
+
+	res := &result{resultSet: true}
+
+	// values returns the values of the columns of a row
+	values := []func(row []interface{}) interface{}{}
+
+	count := false
+
+	for _, expr := range split(exprs, ',') {
+		expr, name := alias(expr)
+
+		if expr == "*" || strings.HasSuffix(expr, ".*") {
+			for i, c := range t.Columns {
+				i := i
+
+				res.columns = append(res.columns, column{Name: c, Table: t.Name, Type: types[i]})
+				values = append(values, func(row []interface{}) interface{} {
+					return row[i]
+				})
+			}
+			continue
+		}
+
+		if strings.HasPrefix(strings.ToUpper(expr), "COUNT(") {
+			count = true
+
+			res.columns = append(res.columns, column{Name: name, Type: typeLongLong})
+			values = append(values, func(row []interface{}) interface{} {
+				return fmt.Sprintf("%d", matched)
+			})
+			continue
+		}
+
+		parts := splitName(expr)
+		if i := t.column(parts[len(parts)-1]); i >= 0 && isColumnName(expr) {
+			if name == expr {
+				name = t.Columns[i]
+			}
+
+			res.columns = append(res.columns, column{Name: name, Table: t.Name, Type: types[i]})
+			values = append(values, func(row []interface{}) interface{} {
+				return row[i]
+			})
+			continue
+		}
+
+		v, err := sess.eval(expr)
+		if err != nil {
+			return &result{err: err}
+		}
+
+		res.columns = append(res.columns, column{Name: name, Type: typeVarString})
+		values = append(values, func(row []interface{}) interface{} {
+			return v
+		})
+	}
+
+	// aggregates return a single row, with the values of the first row
+	if count {
+		first := make([]interface{}, len(t.Columns))
+		if len(rows) > 0 {
+			first = rows[0]
+		}
+
+		rows = [][]interface{}{first}
+	}
+
+	rows, ok = limit(clauses["LIMIT"], rows)
+	if !ok {
+		return syntaxError(q)
+	}
+
+	for _, r := range rows {
+		row := []interface{}{}
+		for _, value := range values {
+			row = append(row, value(r))
+		}
+
+		res.rows = append(res.rows, row)
+	}
+
+	return res
+}
+
+// isColumnName returns whether the expression is a (qualified) column name.
+func isColumnName(expr string) bool {
+	for _, part := range split(expr, '.') {
+		if !name.MatchString(strings.TrimSpace(part)) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	showFrom = regexp.MustCompile("(?i)^(?:FROM|IN) (`[^`]+`|[^ ]+)")
+)
+
+func (sess *session) showStmt(q string) *result {
+	rest := strings.TrimSpace(q[len("SHOW "):])
+
+	words := strings.Fields(strings.ToUpper(rest))
+	for len(words) > 0 && (words[0] == "FULL" || words[0] == "GLOBAL" || words[0] == "SESSION") {
+		rest = strings.TrimSpace(rest[len(words[0]):])
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		return syntaxError(q)
+	}
+
+	full := strings.Contains(strings.ToUpper(q), "SHOW FULL ")
+	schema := sess.s.schema()
+
+	switch words[0] {
+	case "DATABASES", "SCHEMAS":
+		rows := [][]interface{}{}
+		for _, db := range schema.databases {
+			rows = append(rows, []interface{}{db})
+		}
+
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("SCHEMATA", "Database"),
+			rows:      filter(rest, rows, 0),
+		}
+
+	case "TABLES":
+		db := sess.db
+		if m := showFrom.FindStringSubmatch(strings.TrimSpace(rest[len("TABLES"):])); m != nil {
+			db = unquote(m[1])
+		}
+
+		if db == "" {
+			return errorResult(1046, "3D000", "No database selected")
+		} else if schema.database(db) == "" {
+			return errorResult(1049, "42000", "Unknown database '%s'", db)
+		}
+
+		rows := [][]interface{}{}
+		for _, t := range schema.databaseTables(db) {
+			row := []interface{}{t.Name}
+			if full {
+				row = append(row, "BASE TABLE")
+			}
+
+			rows = append(rows, row)
+		}
+
+		columns := stringColumns("TABLES", "Tables_in_"+schema.database(db))
+		if full {
+			columns = append(columns, stringColumns("TABLES", "Table_type")...)
+		}
+
+		return &result{
+			resultSet: true,
+			columns:   columns,
+			rows:      filter(rest, rows, 0),
+		}
+
+	case "VARIABLES", "STATUS":
+		values := sess.variables()
+		if words[0] == "STATUS" {
+			values = map[string]string{
+				"Aborted_connects":  "1203",
+				"Connections":       fmt.Sprintf("%d", sess.connID),
+				"Threads_connected": "3",
+				"Uptime":            fmt.Sprintf("%d", int(time.Since(sess.s.started).Seconds())+1843920),
+			}
+		}
+
+		names := []string{}
+		for k := range values {
+			names = append(names, k)
+		}
+
+		sort.Strings(names)
+
+		rows := [][]interface{}{}
+		for _, k := range names {
+			rows = append(rows, []interface{}{k, values[k]})
+		}
+
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", "Variable_name", "Value"),
+			rows:      filter(rest, rows, 0),
+		}
+
+	case "GRANTS":
+		user := sess.login.Username
+
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", fmt.Sprintf("Grants for %s@%%", user)),
+			rows: [][]interface{}{
+				{fmt.Sprintf("GRANT ALL PRIVILEGES ON *.* TO `%s`@`%%` WITH GRANT OPTION", user)},
+			},
+		}
+
+	case "PROCESSLIST":
+		var db interface{}
+		if sess.db != "" {
+			db = sess.db
+		}
+
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", "Id", "User", "Host", "db", "Command", "Time", "State", "Info"),
+			rows: [][]interface{}{
+				{"5", "event_scheduler", "localhost", nil, "Daemon", "1843920", "Waiting on empty queue", nil},
+				{fmt.Sprintf("%d", sess.connID), sess.login.Username, sess.RemoteAddr().String(), db, "Query", "0", "init", q},
+			},
+		}
+
+	case "WARNINGS", "ERRORS":
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", "Level", "Code", "Message"),
+		}
+
+	case "COLUMNS", "FIELDS":
+		m := regexp.MustCompile("(?i)^(?:COLUMNS|FIELDS) (?:FROM|IN) ([^ ]+)(?: (?:FROM|IN) ([^ ]+))?").FindStringSubmatch(rest)
+		if m == nil {
+			return syntaxError(q)
+		}
+
+		return sess.describe(m[1], m[2])
+
+	case "CREATE":
+		m := regexp.MustCompile("(?i)^CREATE TABLE ([^ ]+)$").FindStringSubmatch(rest)
+		if m == nil {
+			return syntaxError(q)
+		}
+
+		t, res := sess.lookup(m[1], "")
+		if t == nil {
+			return res
+		}
+
+		types := t.types()
+
+		defs := []string{}
+		for i, c := range t.Columns {
+			_, typ := columnType(types[i])
+			defs = append(defs, fmt.Sprintf("  `%s` %s DEFAULT NULL", c, typ))
+		}
+
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", "Table", "Create Table"),
+			rows: [][]interface{}{
+				{t.Name, fmt.Sprintf("CREATE TABLE `%s` (\n%s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci", t.Name, strings.Join(defs, ",\n"))},
+			},
+		}
+
+	case "ENGINES", "PLUGINS", "MASTER", "SLAVE", "REPLICA", "BINARY", "TRIGGERS", "EVENTS", "PROCEDURE", "FUNCTION", "INDEX", "INDEXES", "KEYS", "CHARSET", "CHARACTER", "COLLATION", "OPEN", "PRIVILEGES", "PROFILES", "ENGINE", "TABLE":
+		return &result{
+			resultSet: true,
+			columns:   stringColumns("", "Name", "Value"),
+		}
+	}
+
+	return syntaxError(q)
+}
+
+// lookup returns the table of a (qualified) name, or the result of the
+// error when it doesn't exist.
+func (sess *session) lookup(ref string, db string) (*table, *result) {
+	names := splitName(ref)
+
+	if len(names) == 2 {
+		db = names[0]
+	} else if db == "" {
+		db = sess.db
+	}
+
+	if db == "" {
+		return nil, errorResult(1046, "3D000", "No database selected")
+	}
+
+	t := sess.s.schema().table(db, names[len(names)-1])
+	if t == nil {
+		return nil, errorResult(1146, "42S02", "Table '%s.%s' doesn't exist", db, names[len(names)-1])
+	}
+
+	return t, nil
+}
+
+// describe returns the columns of the table.
+func (sess *session) describe(ref string, db string) *result {
+	t, res := sess.lookup(ref, unquote(db))
+	if t == nil {
+		return res
+	}
+
+	types := t.types()
+
+	rows := [][]interface{}{}
+	for i, c := range t.Columns {
+		_, typ := columnType(types[i])
+
+		key := ""
+		if i == 0 {
+			key = "PRI"
+		}
+
+		rows = append(rows, []interface{}{c, typ, "YES", key, nil, ""})
+	}
+
+	return &result{
+		resultSet: true,
+		columns:   stringColumns("COLUMNS", "Field", "Type", "Null", "Key", "Default", "Extra"),
+		rows:      rows,
+	}
+}
+
+var loadData = regexp.MustCompile(`(?i)^LOAD DATA (?:(?:LOW_PRIORITY|CONCURRENT) )?(LOCAL )?INFILE ('(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*")`)
+
+// execute executes a single statement.
+func (sess *session) execute(stmt string) *result {
+	q := normalize(stmt)
+	if q == "" {
+		return errorResult(1065, "42000", "Query was empty")
+	}
+
+	keyword := strings.ToUpper(strings.Fields(q)[0])
+
+	switch keyword {
+	case "SELECT":
+		return sess.selectStmt(q)
+
+	case "SHOW":
+		return sess.showStmt(q)
+
+	case "USE":
+		return sess.use(unquote(strings.TrimSpace(q[len("USE"):])))
+
+	case "DESCRIBE", "DESC", "EXPLAIN":
+		fields := strings.Fields(q)
+		if len(fields) < 2 || strings.EqualFold(fields[1], "SELECT") {
+			return syntaxError(q)
+		}
+
+		return sess.describe(fields[1], "")
+
+	case "LOAD":
+		m := loadData.FindStringSubmatch(q)
+		if m == nil {
+			return syntaxError(q)
+		} else if m[1] == "" {
+			return errorResult(1290, "HY000", "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement")
+		}
+
+		return &result{infile: unquote(m[2])}
+
+	case "SET", "BEGIN", "START", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE", "LOCK", "UNLOCK", "FLUSH", "KILL", "DO", "CREATE", "DROP", "ALTER", "GRANT", "REVOKE", "TRUNCATE", "RENAME", "INSTALL", "UNINSTALL", "ANALYZE", "OPTIMIZE":
+		return &result{}
+
+	case "INSERT", "UPDATE", "DELETE", "REPLACE":
+		return &result{affectedRows: 1}
+	}
+
+	return syntaxError(q)
+}
+
+// use changes the database of the session.
+func (sess *session) use(db string) *result {
+	name := sess.s.schema().database(db)
+	if name == "" {
+		return errorResult(1049, "42000", "Unknown database '%s'", db)
+	}
+
+	sess.db = name
+	return &result{}
+}
+
+// statements returns the statements of the query, multiple statements are
+// only supported when enabled by the client.
+func (sess *session) statements(query string) []string {
+	if sess.login.Capabilities&clientMultiStatements == 0 {
+		return []string{query}
+	}
+
+	statements := []string{}
+	for _, stmt := range split(query, ';') {
+		if strings.TrimSpace(stmt) != "" {
+			statements = append(statements, stmt)
+		}
+	}
+
+	if len(statements) == 0 {
+		return []string{query}
+	}
+
+	return statements
+}
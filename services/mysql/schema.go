@@ -0,0 +1,264 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tableConfig configures a table of the fake schema.
+type tableConfig struct {
+	Database string     `toml:"database"`
+	Name     string     `toml:"name"`
+	Columns  []string   `toml:"columns"`
+	Rows     [][]string `toml:"rows"`
+}
+
+// defaultTables is the schema used when no tables are configured.
+var defaultTables = []tableConfig{
+	{
+		Database: "production",
+		Name:     "users",
+		Columns:  []string{"id", "username", "email", "password", "role", "created_at"},
+		Rows: [][]string{
+			{"1", "admin", "admin@example.com", "$2y$10$Qx6ZrOq3n0H7dLXbA1nM8uVY0P3y7cNwz1mHk8bS9oXgq2aTjL5eW", "admin", "2021-03-14 09:26:53"},
+			{"2", "jdevries", "j.devries@example.com", "$2y$10$8Hn2WkZ1qO6tYc3LrVd0meJb5sGf9aPx4uKi7NqE2hRzT1oUy6wCa", "editor", "2021-06-02 14:11:08"},
+			{"3", "backup", "backup@example.com", "$2y$10$Lp4rT9vXe2QmZc8bN1sHouW6yJd3fA7kGi0EqR5tYx9nMz2VhUb3O", "service", "2022-01-19 03:00:00"},
+		},
+	},
+	{
+		Database: "production",
+		Name:     "customers",
+		Columns:  []string{"id", "name", "email", "phone", "country"},
+		Rows: [][]string{
+			{"1", "Anna Jansen", "anna.jansen@example.net", "+31 6 12345678", "NL"},
+			{"2", "Mark Peters", "mark.peters@example.org", "+44 7700 900123", "GB"},
+			{"3", "Julia Schmidt", "j.schmidt@example.de", "+49 151 23456789", "DE"},
+		},
+	},
+	{
+		Database: "production",
+		Name:     "orders",
+		Columns:  []string{"id", "customer_id", "amount", "status"},
+		Rows: [][]string{
+			{"1", "1", "129.95", "shipped"},
+			{"2", "3", "49.00", "paid"},
+			{"3", "2", "310.50", "pending"},
+		},
+	},
+}
+
+// systemDatabases are the databases of every server
+var systemDatabases = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+// table is a table of the fake schema.
+type table struct {
+	Database string
+	Name     string
+	Columns  []string
+	Rows     [][]interface{}
+}
+
+// types returns the types of the columns, integer columns are returned as
+// integers.
+func (t *table) types() []byte {
+	types := make([]byte, len(t.Columns))
+	for i := range t.Columns {
+		types[i] = typeLongLong
+
+		for _, row := range t.Rows {
+			if v, ok := row[i].(string); ok {
+				if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+					types[i] = typeVarString
+				}
+			}
+		}
+
+		if len(t.Rows) == 0 {
+			types[i] = typeVarString
+		}
+	}
+	return types
+}
+
+// column returns the index of the column, -1 if it doesn't exist.
+func (t *table) column(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// schema contains the databases and tables of the server.
+type schema struct {
+	databases []string
+	tables    []*table
+}
+
+// nativeHash returns the authentication string of mysql_native_password.
+func nativeHash(password string) string {
+	h1 := sha1.Sum([]byte(password))
+	h2 := sha1.Sum(h1[:])
+	return "*" + strings.ToUpper(hex.EncodeToString(h2[:]))
+}
+
+func newSchema(configs []tableConfig, users map[string]string) *schema {
+	if len(configs) == 0 {
+		configs = defaultTables
+	}
+
+	s := &schema{
+		databases: append([]string{}, systemDatabases...),
+	}
+
+	for _, tc := range configs {
+		t := &table{
+			Database: tc.Database,
+			Name:     tc.Name,
+			Columns:  tc.Columns,
+		}
+
+		for _, row := range tc.Rows {
+			values := make([]interface{}, len(tc.Columns))
+			for i := range values {
+				if i < len(row) {
+					values[i] = row[i]
+				}
+			}
+
+			t.Rows = append(t.Rows, values)
+		}
+
+		if s.database(tc.Database) == "" {
+			s.databases = append(s.databases, tc.Database)
+		}
+
+		s.tables = append(s.tables, t)
+	}
+
+	sort.Strings(s.databases)
+
+	// the accounts of the server, with the hashes of the configured
+	// passwords
+	accounts := &table{
+		Database: "mysql",
+		Name:     "user",
+		Columns:  []string{"Host", "User", "plugin", "authentication_string"},
+	}
+
+	names := []string{}
+	for name := range users {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if _, ok := users["root"]; !ok {
+		names = append([]string{"root"}, names...)
+	}
+
+	for _, name := range names {
+		password, ok := users[name]
+		if !ok {
+			password = randomString(16)
+		}
+
+		accounts.Rows = append(accounts.Rows, []interface{}{"%", name, nativePassword, nativeHash(password)})
+	}
+
+	s.tables = append(s.tables, accounts)
+
+	schemata := &table{
+		Database: "information_schema",
+		Name:     "SCHEMATA",
+		Columns:  []string{"CATALOG_NAME", "SCHEMA_NAME", "DEFAULT_CHARACTER_SET_NAME", "DEFAULT_COLLATION_NAME"},
+	}
+
+	for _, db := range s.databases {
+		schemata.Rows = append(schemata.Rows, []interface{}{"def", db, "utf8mb4", "utf8mb4_0900_ai_ci"})
+	}
+
+	tables := &table{
+		Database: "information_schema",
+		Name:     "TABLES",
+		Columns:  []string{"TABLE_CATALOG", "TABLE_SCHEMA", "TABLE_NAME", "TABLE_TYPE", "ENGINE", "TABLE_ROWS"},
+	}
+
+	columns := &table{
+		Database: "information_schema",
+		Name:     "COLUMNS",
+		Columns:  []string{"TABLE_CATALOG", "TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "ORDINAL_POSITION", "DATA_TYPE", "COLUMN_TYPE"},
+	}
+
+	for _, t := range s.tables {
+		tables.Rows = append(tables.Rows, []interface{}{"def", t.Database, t.Name, "BASE TABLE", "InnoDB", fmt.Sprintf("%d", len(t.Rows))})
+
+		for i, name := range t.Columns {
+			dataType, columnType := columnType(t.types()[i])
+			columns.Rows = append(columns.Rows, []interface{}{"def", t.Database, t.Name, name, fmt.Sprintf("%d", i+1), dataType, columnType})
+		}
+	}
+
+	s.tables = append(s.tables, schemata, tables, columns)
+
+	return s
+}
+
+// columnType returns the data type and column type of a column.
+func columnType(typ byte) (string, string) {
+	if typ == typeLongLong {
+		return "bigint", "bigint"
+	}
+
+	return "varchar", "varchar(255)"
+}
+
+// database returns the name of the database, the empty string if it doesn't
+// exist.
+func (s *schema) database(name string) string {
+	for _, db := range s.databases {
+		if strings.EqualFold(db, name) {
+			return db
+		}
+	}
+	return ""
+}
+
+// table returns the table of the database, nil if it doesn't exist.
+func (s *schema) table(db string, name string) *table {
+	for _, t := range s.tables {
+		if strings.EqualFold(t.Database, db) && strings.EqualFold(t.Name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// databaseTables returns the tables of the database.
+func (s *schema) databaseTables(db string) []*table {
+	tables := []*table{}
+	for _, t := range s.tables {
+		if strings.EqualFold(t.Database, db) {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
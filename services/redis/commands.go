@@ -13,37 +13,135 @@
 // limitations under the License.
 package redis
 
-type cmd func(*redisService, []interface{}) (string, bool)
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
-var mapCmds = map[string]cmd{
-	"info": (*redisService).infoCmd,
-	// ...
+type cmd func(*redisConn, []string) (string, bool)
+
+type redisCommand struct {
+	fn cmd
+
+	// arity is the number of arguments including the command name,
+	// negative values are the minimum number
+	arity int
+
+	// write commands count towards the memory limit
+	write bool
+
+	// noauth commands are allowed before authentication
+	noauth bool
+}
+
+var mapCmds = map[string]redisCommand{
+	"auth":         {fn: (*redisConn).authCmd, arity: -2, noauth: true},
+	"ping":         {fn: (*redisConn).pingCmd, arity: -1},
+	"echo":         {fn: (*redisConn).echoCmd, arity: 2},
+	"quit":         {fn: (*redisConn).quitCmd, arity: 1, noauth: true},
+	"select":       {fn: (*redisConn).selectCmd, arity: 2},
+	"info":         {fn: (*redisConn).infoCmd, arity: -1},
+	"config":       {fn: (*redisConn).configCmd, arity: -2},
+	"client":       {fn: (*redisConn).clientCmd, arity: -2},
+	"flushall":     {fn: (*redisConn).flushallCmd, arity: -1},
+	"flushdb":      {fn: (*redisConn).flushdbCmd, arity: -1},
+	"dbsize":       {fn: (*redisConn).dbsizeCmd, arity: 1},
+	"save":         {fn: (*redisConn).saveCmd, arity: 1},
+	"bgsave":       {fn: (*redisConn).bgsaveCmd, arity: -1},
+	"bgrewriteaof": {fn: (*redisConn).bgrewriteaofCmd, arity: 1},
+	"lastsave":     {fn: (*redisConn).lastsaveCmd, arity: 1},
+	"time":         {fn: (*redisConn).timeCmd, arity: 1},
+	"command":      {fn: (*redisConn).commandCmd, arity: -1},
+	"slaveof":      {fn: (*redisConn).slaveofCmd, arity: 3},
+	"replicaof":    {fn: (*redisConn).slaveofCmd, arity: 3},
+	"role":         {fn: (*redisConn).roleCmd, arity: 1},
+	"module":       {fn: (*redisConn).moduleCmd, arity: -2},
+	"eval":         {fn: (*redisConn).evalCmd, arity: -3},
+	"evalsha":      {fn: (*redisConn).evalCmd, arity: -3},
+	"script":       {fn: (*redisConn).scriptCmd, arity: -2},
+	"shutdown":     {fn: (*redisConn).quitCmd, arity: -1},
+
+	"keys":      {fn: (*redisConn).keysCmd, arity: 2},
+	"scan":      {fn: (*redisConn).scanCmd, arity: -2},
+	"exists":    {fn: (*redisConn).existsCmd, arity: -2},
+	"del":       {fn: (*redisConn).delCmd, arity: -2},
+	"unlink":    {fn: (*redisConn).delCmd, arity: -2},
+	"type":      {fn: (*redisConn).typeCmd, arity: 2},
+	"expire":    {fn: (*redisConn).expireCmd, arity: 3},
+	"pexpire":   {fn: (*redisConn).pexpireCmd, arity: 3},
+	"expireat":  {fn: (*redisConn).expireatCmd, arity: 3},
+	"ttl":       {fn: (*redisConn).ttlCmd, arity: 2},
+	"pttl":      {fn: (*redisConn).pttlCmd, arity: 2},
+	"persist":   {fn: (*redisConn).persistCmd, arity: 2},
+	"rename":    {fn: (*redisConn).renameCmd, arity: 3, write: true},
+	"randomkey": {fn: (*redisConn).randomkeyCmd, arity: 1},
+
+	"get":    {fn: (*redisConn).getCmd, arity: 2},
+	"set":    {fn: (*redisConn).setCmd, arity: -3, write: true},
+	"setnx":  {fn: (*redisConn).setnxCmd, arity: 3, write: true},
+	"setex":  {fn: (*redisConn).setexCmd, arity: 4, write: true},
+	"psetex": {fn: (*redisConn).psetexCmd, arity: 4, write: true},
+	"mget":   {fn: (*redisConn).mgetCmd, arity: -2},
+	"mset":   {fn: (*redisConn).msetCmd, arity: -3, write: true},
+	"getset": {fn: (*redisConn).getsetCmd, arity: 3, write: true},
+	"append": {fn: (*redisConn).appendCmd, arity: 3, write: true},
+	"strlen": {fn: (*redisConn).strlenCmd, arity: 2},
+	"incr":   {fn: (*redisConn).incrCmd, arity: 2, write: true},
+	"decr":   {fn: (*redisConn).decrCmd, arity: 2, write: true},
+	"incrby": {fn: (*redisConn).incrbyCmd, arity: 3, write: true},
+	"decrby": {fn: (*redisConn).decrbyCmd, arity: 3, write: true},
+
+	"lpush":  {fn: (*redisConn).lpushCmd, arity: -3, write: true},
+	"rpush":  {fn: (*redisConn).rpushCmd, arity: -3, write: true},
+	"lpop":   {fn: (*redisConn).lpopCmd, arity: 2},
+	"rpop":   {fn: (*redisConn).rpopCmd, arity: 2},
+	"llen":   {fn: (*redisConn).llenCmd, arity: 2},
+	"lrange": {fn: (*redisConn).lrangeCmd, arity: 4},
+	"lindex": {fn: (*redisConn).lindexCmd, arity: 3},
+
+	"hset":    {fn: (*redisConn).hsetCmd, arity: -4, write: true},
+	"hmset":   {fn: (*redisConn).hmsetCmd, arity: -4, write: true},
+	"hsetnx":  {fn: (*redisConn).hsetnxCmd, arity: 4, write: true},
+	"hget":    {fn: (*redisConn).hgetCmd, arity: 3},
+	"hmget":   {fn: (*redisConn).hmgetCmd, arity: -3},
+	"hgetall": {fn: (*redisConn).hgetallCmd, arity: 2},
+	"hdel":    {fn: (*redisConn).hdelCmd, arity: -3},
+	"hkeys":   {fn: (*redisConn).hkeysCmd, arity: 2},
+	"hvals":   {fn: (*redisConn).hvalsCmd, arity: 2},
+	"hlen":    {fn: (*redisConn).hlenCmd, arity: 2},
+	"hexists": {fn: (*redisConn).hexistsCmd, arity: 3},
+
+	"sadd":      {fn: (*redisConn).saddCmd, arity: -3, write: true},
+	"srem":      {fn: (*redisConn).sremCmd, arity: -3},
+	"smembers":  {fn: (*redisConn).smembersCmd, arity: 2},
+	"sismember": {fn: (*redisConn).sismemberCmd, arity: 3},
+	"scard":     {fn: (*redisConn).scardCmd, arity: 2},
 }
 
-type infoSection func(*redisService) string
+type infoSection func(*redisConn) string
 
 var mapInfoCmds = map[string]infoSection{
-	"server":      (*redisService).infoServerMsg,
-	"clients":     (*redisService).infoClientsMsg,
-	"memory":      (*redisService).infoMemoryMsg,
-	"persistence": (*redisService).infoPersistenceMsg,
-	"stats":       (*redisService).infoStatsMsg,
-	"replication": (*redisService).infoReplicationMsg,
-	"cpu":         (*redisService).infoCPUMsg,
-	"cluster":     (*redisService).infoClusterMsg,
-	"keyspace":    (*redisService).infoKeyspaceMsg,
-}
-
-func (s *redisService) infoCmd(args []interface{}) (string, bool) {
+	"server":      (*redisConn).infoServerMsg,
+	"clients":     (*redisConn).infoClientsMsg,
+	"memory":      (*redisConn).infoMemoryMsg,
+	"persistence": (*redisConn).infoPersistenceMsg,
+	"stats":       (*redisConn).infoStatsMsg,
+	"replication": (*redisConn).infoReplicationMsg,
+	"cpu":         (*redisConn).infoCPUMsg,
+	"cluster":     (*redisConn).infoClusterMsg,
+	"keyspace":    (*redisConn).infoKeyspaceMsg,
+}
+
+func (s *redisConn) infoCmd(args []string) (string, bool) {
 	switch len(args) {
 	case 0:
 		return bulkString(s.infoSectionsMsg(), true), false
 	case 1:
-		_word := args[0].(redisDatum)
-		word, success := _word.ToString()
-		if !success {
-			return "Expected string argument, got something else", false
-		}
+		word := strings.ToLower(args[0])
 		fn, ok := mapInfoCmds[word]
 		if ok {
 			return bulkString(fn(s), true), false
@@ -59,3 +157,199 @@ func (s *redisService) infoCmd(args []interface{}) (string, bool) {
 		return errorMsg("syntax"), false
 	}
 }
+
+func (s *redisConn) authCmd(args []string) (string, bool) {
+	password := args[len(args)-1]
+	if len(args) > 2 {
+		return errorMsg("syntax"), false
+	} else if len(args) == 2 {
+		s.field("redis.username", args[0])
+	}
+
+	s.field("redis.password", password)
+
+	requirepass := s.inst.config["requirepass"]
+	if requirepass == "" {
+		return "-ERR Client sent AUTH, but no password is set\r\n", false
+	}
+
+	s.authenticated = password == requirepass
+	s.field("redis.authenticated", s.authenticated)
+
+	if !s.authenticated {
+		return "-ERR invalid password\r\n", false
+	}
+
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) pingCmd(args []string) (string, bool) {
+	switch len(args) {
+	case 0:
+		return simpleString("PONG"), false
+	case 1:
+		return bulkString(args[0], false), false
+	default:
+		return fmt.Sprintf(errorMsg("arity"), "ping"), false
+	}
+}
+
+func (s *redisConn) echoCmd(args []string) (string, bool) {
+	return bulkString(args[0], false), false
+}
+
+func (s *redisConn) quitCmd(args []string) (string, bool) {
+	return simpleString("OK"), true
+}
+
+func (s *redisConn) selectCmd(args []string) (string, bool) {
+	db, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errorMsg("integer"), false
+	} else if db < 0 || db >= databases {
+		return "-ERR DB index is out of range\r\n", false
+	}
+
+	s.db = db
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) configCmd(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "get":
+		if len(args) != 2 {
+			return fmt.Sprintf(errorMsg("arity"), "config|get"), false
+		}
+
+		names := []string{}
+		for name := range s.inst.config {
+			if match(strings.ToLower(args[1]), name) {
+				names = append(names, name)
+			}
+		}
+
+		sort.Strings(names)
+
+		values := []string{}
+		for _, name := range names {
+			values = append(values, name, s.inst.config[name])
+		}
+
+		return bulkStrings(values), false
+	case "set":
+		if len(args) != 3 {
+			return fmt.Sprintf(errorMsg("arity"), "config|set"), false
+		}
+
+		name := strings.ToLower(args[1])
+		if _, ok := s.inst.config[name]; !ok {
+			return fmt.Sprintf("-ERR Unsupported CONFIG parameter: %s\r\n", sanitize.Replace(args[1])), false
+		}
+
+		if !s.alloc(args[1:]...) {
+			return errorMsg("oom"), false
+		}
+
+		s.inst.config[name] = args[2]
+		s.field("redis.config."+name, args[2])
+		return simpleString("OK"), false
+	case "resetstat", "rewrite":
+		return simpleString("OK"), false
+	default:
+		return "-ERR CONFIG subcommand must be one of GET, SET, RESETSTAT, REWRITE\r\n", false
+	}
+}
+
+func (s *redisConn) clientCmd(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "setname":
+		if len(args) != 2 {
+			break
+		}
+
+		s.name = args[1]
+		return simpleString("OK"), false
+	case "getname":
+		if s.name == "" {
+			return nilString(), false
+		}
+		return bulkString(s.name, false), false
+	case "id":
+		return integer(s.id), false
+	case "list", "info":
+		line := fmt.Sprintf("id=%d addr=%s fd=8 name=%s age=%d idle=0 flags=N db=%d sub=0 psub=0 multi=-1 qbuf=0 qbuf-free=32768 obl=0 oll=0 omem=0 events=r cmd=client\n",
+			s.id, s.conn.RemoteAddr(), s.name, int(time.Since(s.created).Seconds()), s.db)
+		return bulkString(line, false), false
+	case "kill", "reply", "setinfo", "pause", "unpause":
+		return simpleString("OK"), false
+	}
+
+	return fmt.Sprintf("-ERR Unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP\r\n", sanitize.Replace(args[0])), false
+}
+
+func (s *redisConn) flushallCmd(args []string) (string, bool) {
+	for i := range s.inst.dbs {
+		s.inst.dbs[i] = map[string]*entry{}
+	}
+
+	s.free(s.inst)
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) flushdbCmd(args []string) (string, bool) {
+	s.inst.dbs[s.db] = map[string]*entry{}
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) dbsizeCmd(args []string) (string, bool) {
+	return integer(int64(len(s.keys("*")))), false
+}
+
+func (s *redisConn) bgrewriteaofCmd(args []string) (string, bool) {
+	return simpleString("Background append only file rewriting started"), false
+}
+
+func (s *redisConn) lastsaveCmd(args []string) (string, bool) {
+	return integer(s.inst.lastSave.Unix()), false
+}
+
+func (s *redisConn) timeCmd(args []string) (string, bool) {
+	now := time.Now()
+	return bulkStrings([]string{
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.Itoa(now.Nanosecond() / 1000),
+	}), false
+}
+
+func (s *redisConn) commandCmd(args []string) (string, bool) {
+	return array(), false
+}
+
+func (s *redisConn) evalCmd(args []string) (string, bool) {
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		return errorMsg("integer"), false
+	}
+
+	return nilString(), false
+}
+
+func (s *redisConn) scriptCmd(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		if len(args) != 2 {
+			break
+		}
+
+		return bulkString(fmt.Sprintf("%x", sha1.Sum([]byte(args[1]))), false), false
+	case "exists":
+		values := make([]string, len(args)-1)
+		for i := range values {
+			values[i] = integer(0)
+		}
+		return array(values...), false
+	case "flush", "kill":
+		return simpleString("OK"), false
+	}
+
+	return fmt.Sprintf("-ERR Unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP\r\n", sanitize.Replace(args[0])), false
+}
@@ -0,0 +1,377 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package redis
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// databases is the number of databases of an instance
+const databases = 16
+
+// entry is a value in the keyspace, the value is a string, list
+// ([]string), hash (map[string]string) or set (map[string]struct{}).
+type entry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// instance contains the state of an emulated redis server, it is used by
+// a single connection or shared by the connections of an ip address.
+type instance struct {
+	sync.Mutex
+
+	dbs    [databases]map[string]*entry
+	config map[string]string
+
+	// master is the address set with SLAVEOF or REPLICAOF
+	master string
+
+	modules []string
+
+	// files contains the sha256 of the artifacts written to a path
+	files map[string]string
+
+	// used counts the bytes written, to limit the memory an attacker
+	// can use
+	used int64
+
+	// evicted is set when a persisted instance is removed while in use,
+	// its memory is freed when the last connection closes
+	evicted bool
+
+	// replicating is set while a replication runs
+	replicating bool
+
+	lastSave time.Time
+	lastUsed time.Time
+}
+
+func (s *redisService) newInstance() *instance {
+	inst := &instance{
+		config: map[string]string{
+			"dir":                         "/data",
+			"dbfilename":                  "dump.rdb",
+			"requirepass":                 s.Password,
+			"masterauth":                  "",
+			"maxmemory":                   "0",
+			"maxmemory-policy":            "noeviction",
+			"appendonly":                  "no",
+			"appendfilename":              "appendonly.aof",
+			"save":                        "3600 1 300 100 60 10000",
+			"bind":                        "",
+			"port":                        "6379",
+			"protected-mode":              "no",
+			"databases":                   "16",
+			"timeout":                     "0",
+			"loglevel":                    "notice",
+			"logfile":                     "",
+			"slave-read-only":             "yes",
+			"stop-writes-on-bgsave-error": "yes",
+			"rdbcompression":              "yes",
+		},
+		files:    map[string]string{},
+		lastSave: time.Now(),
+	}
+
+	for i := range inst.dbs {
+		inst.dbs[i] = map[string]*entry{}
+	}
+
+	return inst
+}
+
+// instance returns the instance for a connection from ip, when persisting
+// the instance of the ip address is reused.
+func (s *redisService) instance(ip string) *instance {
+	if !s.Persist {
+		return s.newInstance()
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	inst, ok := s.instances[ip]
+	if !ok {
+		if len(s.instances) >= s.MaxInstances {
+			s.evict()
+		}
+
+		inst = s.newInstance()
+		s.instances[ip] = inst
+	}
+
+	inst.Lock()
+	inst.lastUsed = time.Now()
+	inst.Unlock()
+
+	return inst
+}
+
+// evict removes the least recently used instance.
+func (s *redisService) evict() {
+	oldest := ""
+
+	var last time.Time
+	for ip, inst := range s.instances {
+		inst.Lock()
+		used := inst.lastUsed
+		inst.Unlock()
+
+		if oldest == "" || used.Before(last) {
+			oldest, last = ip, used
+		}
+	}
+
+	inst, ok := s.instances[oldest]
+	if !ok {
+		return
+	}
+
+	delete(s.instances, oldest)
+
+	inst.Lock()
+	inst.evicted = true
+	s.free(inst)
+	inst.Unlock()
+}
+
+// alloc counts the bytes of args written to the keyspace, false when the
+// memory of the keyspace or of the service would be exceeded. The instance
+// is locked.
+func (s *redisService) alloc(inst *instance, args ...string) bool {
+	n := int64(0)
+	for _, arg := range args {
+		n += int64(len(arg))
+	}
+
+	if inst.used+n > s.MaxMemory {
+		return false
+	}
+
+	if atomic.AddInt64(&s.used, n) > s.MaxTotalMemory {
+		atomic.AddInt64(&s.used, -n)
+		return false
+	}
+
+	inst.used += n
+	return true
+}
+
+// free returns the memory of the instance to the service, the instance is
+// locked.
+func (s *redisService) free(inst *instance) {
+	atomic.AddInt64(&s.used, -inst.used)
+	inst.used = 0
+}
+
+// redisConn is a client connection.
+type redisConn struct {
+	*redisService
+
+	conn net.Conn
+	inst *instance
+
+	id      int64
+	name    string
+	db      int
+	created time.Time
+
+	authenticated bool
+
+	// fields are added to the event of the current command
+	fields []event.Option
+}
+
+func (s *redisService) newConn(conn net.Conn) *redisConn {
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	return &redisConn{
+		redisService: s,
+		conn:         conn,
+		inst:         s.instance(ip),
+		id:           atomic.AddInt64(&s.clientID, 1),
+		created:      time.Now(),
+	}
+}
+
+// budget returns the number of bytes of arguments a command may contain, the
+// memory left in the keyspace and the service, so arguments over the maximum
+// memory aren't buffered. Small commands are always accepted.
+func (c *redisConn) budget() *int64 {
+	c.inst.Lock()
+	n := c.MaxMemory - c.inst.used
+	c.inst.Unlock()
+
+	if left := c.MaxTotalMemory - atomic.LoadInt64(&c.redisService.used); left < n {
+		n = left
+	}
+
+	if n < maxInlineLength {
+		n = maxInlineLength
+	}
+
+	return &n
+}
+
+// alloc counts the bytes of args written to the keyspace of the connection.
+func (c *redisConn) alloc(args ...string) bool {
+	return c.redisService.alloc(c.inst, args...)
+}
+
+// close frees the memory of the instance when it isn't kept for the next
+// connections.
+func (c *redisConn) close() {
+	c.inst.Lock()
+	defer c.inst.Unlock()
+
+	if !c.Persist || c.inst.evicted {
+		c.redisService.free(c.inst)
+	}
+}
+
+func (c *redisConn) field(key string, value interface{}) {
+	c.fields = append(c.fields, event.Custom(key, value))
+}
+
+func (c *redisConn) keyspace() map[string]*entry {
+	return c.inst.dbs[c.db]
+}
+
+// lookup returns the entry of key, expired entries are removed.
+func (c *redisConn) lookup(key string) *entry {
+	db := c.keyspace()
+
+	e, ok := db[key]
+	if !ok {
+		return nil
+	}
+
+	if e.expired(time.Now()) {
+		delete(db, key)
+		return nil
+	}
+
+	return e
+}
+
+// keys returns the sorted keys matching pattern.
+func (c *redisConn) keys(pattern string) []string {
+	now := time.Now()
+
+	keys := []string{}
+	for key, e := range c.keyspace() {
+		if e.expired(now) {
+			continue
+		}
+
+		if match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// match matches s with a glob style pattern, supporting *, ?, [...] and
+// escaping with \. Stars are matched by backtracking to the last star, so
+// the time is bounded by the length of pattern times the length of s.
+func match(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, next = p, i
+			p++
+			continue
+		}
+
+		if p < len(pattern) {
+			if n, ok := matchOne(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+
+		p = star + 1
+		next++
+		i = next
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchOne matches c with the first element of pattern, it returns the
+// length of the element.
+func matchOne(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			break
+		}
+
+		class := pattern[1 : end+1]
+
+		not := strings.HasPrefix(class, "^")
+		if not {
+			class = class[1:]
+		}
+
+		found := false
+		for i := 0; i < len(class); i++ {
+			if i+2 < len(class) && class[i+1] == '-' {
+				found = found || (class[i] <= c && c <= class[i+2])
+				i += 2
+			} else {
+				found = found || class[i] == c
+			}
+		}
+
+		return end + 2, found != not
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	}
+
+	return 1, pattern[0] == c
+}
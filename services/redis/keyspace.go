@@ -0,0 +1,818 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package redis
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *redisConn) keysCmd(args []string) (string, bool) {
+	return bulkStrings(s.keys(args[0])), false
+}
+
+func (s *redisConn) scanCmd(args []string) (string, bool) {
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return "-ERR invalid cursor\r\n", false
+	}
+
+	pattern, count, typ := "*", 10, ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errorMsg("syntax"), false
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil {
+				return errorMsg("integer"), false
+			} else if count < 1 {
+				return errorMsg("syntax"), false
+			}
+		case "type":
+			typ = strings.ToLower(args[i+1])
+		default:
+			return errorMsg("syntax"), false
+		}
+	}
+
+	// the cursor is the index in the sorted keys
+	keys := s.keys("*")
+
+	found := []string{}
+	next := cursor
+	for ; next < len(keys) && next < cursor+count; next++ {
+		if !match(pattern, keys[next]) {
+			continue
+		} else if typ != "" && typeOf(s.lookup(keys[next])) != typ {
+			continue
+		}
+
+		found = append(found, keys[next])
+	}
+
+	if next >= len(keys) {
+		next = 0
+	}
+
+	return array(bulkString(strconv.Itoa(next), false), bulkStrings(found)), false
+}
+
+func (s *redisConn) existsCmd(args []string) (string, bool) {
+	n := int64(0)
+	for _, key := range args {
+		if s.lookup(key) != nil {
+			n++
+		}
+	}
+	return integer(n), false
+}
+
+func (s *redisConn) delCmd(args []string) (string, bool) {
+	n := int64(0)
+	for _, key := range args {
+		if s.lookup(key) != nil {
+			delete(s.keyspace(), key)
+			n++
+		}
+	}
+	return integer(n), false
+}
+
+func typeOf(e *entry) string {
+	if e == nil {
+		return "none"
+	}
+
+	switch e.value.(type) {
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]string:
+		return "hash"
+	case map[string]struct{}:
+		return "set"
+	default:
+		return "none"
+	}
+}
+
+func (s *redisConn) typeCmd(args []string) (string, bool) {
+	return simpleString(typeOf(s.lookup(args[0]))), false
+}
+
+// expire sets the expire time of key, a time in the past deletes the key.
+func (s *redisConn) expire(key string, expires time.Time) (string, bool) {
+	e := s.lookup(key)
+	if e == nil {
+		return integer(0), false
+	}
+
+	if !expires.After(time.Now()) {
+		delete(s.keyspace(), key)
+	} else {
+		e.expires = expires
+	}
+
+	return integer(1), false
+}
+
+func (s *redisConn) expireCmd(args []string) (string, bool) {
+	seconds, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return errorMsg("integer"), false
+	}
+	return s.expire(args[0], time.Now().Add(time.Duration(seconds)*time.Second))
+}
+
+func (s *redisConn) pexpireCmd(args []string) (string, bool) {
+	ms, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return errorMsg("integer"), false
+	}
+	return s.expire(args[0], time.Now().Add(time.Duration(ms)*time.Millisecond))
+}
+
+func (s *redisConn) expireatCmd(args []string) (string, bool) {
+	timestamp, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errorMsg("integer"), false
+	}
+	return s.expire(args[0], time.Unix(timestamp, 0))
+}
+
+// ttl returns the time to live of key in units.
+func (s *redisConn) ttl(key string, unit time.Duration) (string, bool) {
+	e := s.lookup(key)
+	if e == nil {
+		return integer(-2), false
+	} else if e.expires.IsZero() {
+		return integer(-1), false
+	}
+
+	return integer(int64((time.Until(e.expires) + unit - 1) / unit)), false
+}
+
+func (s *redisConn) ttlCmd(args []string) (string, bool) {
+	return s.ttl(args[0], time.Second)
+}
+
+func (s *redisConn) pttlCmd(args []string) (string, bool) {
+	return s.ttl(args[0], time.Millisecond)
+}
+
+func (s *redisConn) persistCmd(args []string) (string, bool) {
+	e := s.lookup(args[0])
+	if e == nil || e.expires.IsZero() {
+		return integer(0), false
+	}
+
+	e.expires = time.Time{}
+	return integer(1), false
+}
+
+func (s *redisConn) renameCmd(args []string) (string, bool) {
+	e := s.lookup(args[0])
+	if e == nil {
+		return errorMsg("nokey"), false
+	}
+
+	delete(s.keyspace(), args[0])
+	s.keyspace()[args[1]] = e
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) randomkeyCmd(args []string) (string, bool) {
+	keys := s.keys("*")
+	if len(keys) == 0 {
+		return nilString(), false
+	}
+	return bulkString(keys[rand.Intn(len(keys))], false), false
+}
+
+// str returns the string value of key, ok is false when key holds another
+// type.
+func (s *redisConn) str(key string) (value string, exists bool, ok bool) {
+	e := s.lookup(key)
+	if e == nil {
+		return "", false, true
+	}
+
+	value, ok = e.value.(string)
+	return value, true, ok
+}
+
+func (s *redisConn) getCmd(args []string) (string, bool) {
+	value, exists, ok := s.str(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	} else if !exists {
+		return nilString(), false
+	}
+	return bulkString(value, false), false
+}
+
+func (s *redisConn) setCmd(args []string) (string, bool) {
+	var expires time.Time
+
+	nx, xx := false, false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); option {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				return errorMsg("syntax"), false
+			}
+
+			n, err := strconv.ParseInt(args[i+1], 10, 32)
+			if err != nil {
+				return errorMsg("integer"), false
+			} else if n <= 0 {
+				return "-ERR invalid expire time in set\r\n", false
+			}
+
+			unit := time.Second
+			if option == "px" {
+				unit = time.Millisecond
+			}
+
+			expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		case "keepttl":
+			if e := s.lookup(args[0]); e != nil {
+				expires = e.expires
+			}
+		default:
+			return errorMsg("syntax"), false
+		}
+	}
+
+	if nx && xx {
+		return errorMsg("syntax"), false
+	}
+
+	exists := s.lookup(args[0]) != nil
+	if (nx && exists) || (xx && !exists) {
+		return nilString(), false
+	}
+
+	s.keyspace()[args[0]] = &entry{value: args[1], expires: expires}
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) setnxCmd(args []string) (string, bool) {
+	if s.lookup(args[0]) != nil {
+		return integer(0), false
+	}
+
+	s.keyspace()[args[0]] = &entry{value: args[1]}
+	return integer(1), false
+}
+
+func (s *redisConn) setexCmd(args []string) (string, bool) {
+	return s.setCmd([]string{args[0], args[2], "ex", args[1]})
+}
+
+func (s *redisConn) psetexCmd(args []string) (string, bool) {
+	return s.setCmd([]string{args[0], args[2], "px", args[1]})
+}
+
+func (s *redisConn) mgetCmd(args []string) (string, bool) {
+	replies := make([]string, len(args))
+	for i, key := range args {
+		if value, exists, ok := s.str(key); ok && exists {
+			replies[i] = bulkString(value, false)
+		} else {
+			replies[i] = nilString()
+		}
+	}
+	return array(replies...), false
+}
+
+func (s *redisConn) msetCmd(args []string) (string, bool) {
+	if len(args)%2 != 0 {
+		return "-ERR wrong number of arguments for MSET\r\n", false
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		s.keyspace()[args[i]] = &entry{value: args[i+1]}
+	}
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) getsetCmd(args []string) (string, bool) {
+	reply, _ := s.getCmd(args[:1])
+	if strings.HasPrefix(reply, "-") {
+		return reply, false
+	}
+
+	s.keyspace()[args[0]] = &entry{value: args[1]}
+	return reply, false
+}
+
+func (s *redisConn) appendCmd(args []string) (string, bool) {
+	value, exists, ok := s.str(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	if exists {
+		e := s.lookup(args[0])
+		e.value = value + args[1]
+	} else {
+		s.keyspace()[args[0]] = &entry{value: args[1]}
+	}
+
+	return integer(int64(len(value) + len(args[1]))), false
+}
+
+func (s *redisConn) strlenCmd(args []string) (string, bool) {
+	value, _, ok := s.str(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return integer(int64(len(value))), false
+}
+
+func (s *redisConn) incrby(key string, by int64) (string, bool) {
+	value, exists, ok := s.str(key)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	n := int64(0)
+	if exists {
+		var err error
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errorMsg("integer"), false
+		}
+	}
+
+	n += by
+
+	if e := s.lookup(key); e != nil {
+		e.value = strconv.FormatInt(n, 10)
+	} else {
+		s.keyspace()[key] = &entry{value: strconv.FormatInt(n, 10)}
+	}
+
+	return integer(n), false
+}
+
+func (s *redisConn) incrCmd(args []string) (string, bool) {
+	return s.incrby(args[0], 1)
+}
+
+func (s *redisConn) decrCmd(args []string) (string, bool) {
+	return s.incrby(args[0], -1)
+}
+
+func (s *redisConn) incrbyCmd(args []string) (string, bool) {
+	by, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errorMsg("integer"), false
+	}
+	return s.incrby(args[0], by)
+}
+
+func (s *redisConn) decrbyCmd(args []string) (string, bool) {
+	by, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errorMsg("integer"), false
+	}
+	return s.incrby(args[0], -by)
+}
+
+// list returns the list of key, ok is false when key holds another type.
+func (s *redisConn) list(key string) (*entry, []string, bool) {
+	e := s.lookup(key)
+	if e == nil {
+		return nil, nil, true
+	}
+
+	list, ok := e.value.([]string)
+	return e, list, ok
+}
+
+func (s *redisConn) push(args []string, left bool) (string, bool) {
+	e, list, ok := s.list(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	if left {
+		// values are pushed one after another, so end up reversed
+		values := make([]string, 0, len(args)-1+len(list))
+		for i := len(args) - 1; i > 0; i-- {
+			values = append(values, args[i])
+		}
+		list = append(values, list...)
+	} else {
+		list = append(list, args[1:]...)
+	}
+
+	if e == nil {
+		s.keyspace()[args[0]] = &entry{value: list}
+	} else {
+		e.value = list
+	}
+
+	return integer(int64(len(list))), false
+}
+
+func (s *redisConn) lpushCmd(args []string) (string, bool) {
+	return s.push(args, true)
+}
+
+func (s *redisConn) rpushCmd(args []string) (string, bool) {
+	return s.push(args, false)
+}
+
+func (s *redisConn) pop(key string, left bool) (string, bool) {
+	e, list, ok := s.list(key)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	} else if e == nil {
+		return nilString(), false
+	}
+
+	var value string
+	if left {
+		value, list = list[0], list[1:]
+	} else {
+		value, list = list[len(list)-1], list[:len(list)-1]
+	}
+
+	if len(list) == 0 {
+		delete(s.keyspace(), key)
+	} else {
+		e.value = list
+	}
+
+	return bulkString(value, false), false
+}
+
+func (s *redisConn) lpopCmd(args []string) (string, bool) {
+	return s.pop(args[0], true)
+}
+
+func (s *redisConn) rpopCmd(args []string) (string, bool) {
+	return s.pop(args[0], false)
+}
+
+func (s *redisConn) llenCmd(args []string) (string, bool) {
+	_, list, ok := s.list(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return integer(int64(len(list))), false
+}
+
+// index converts a possibly negative index to an index in a list of n
+// values.
+func index(s string, n int) (int, bool) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+
+	if i < 0 {
+		i += n
+	}
+
+	return i, true
+}
+
+func (s *redisConn) lrangeCmd(args []string) (string, bool) {
+	_, list, ok := s.list(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	start, ok1 := index(args[1], len(list))
+	stop, ok2 := index(args[2], len(list))
+	if !ok1 || !ok2 {
+		return errorMsg("integer"), false
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(list) {
+		stop = len(list) - 1
+	}
+	if start > stop {
+		return array(), false
+	}
+
+	return bulkStrings(list[start : stop+1]), false
+}
+
+func (s *redisConn) lindexCmd(args []string) (string, bool) {
+	_, list, ok := s.list(args[0])
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	i, ok := index(args[1], len(list))
+	if !ok {
+		return errorMsg("integer"), false
+	} else if i < 0 || i >= len(list) {
+		return nilString(), false
+	}
+
+	return bulkString(list[i], false), false
+}
+
+// hash returns the hash of key, when create is set a missing hash is
+// created. ok is false when key holds another type.
+func (s *redisConn) hash(key string, create bool) (map[string]string, bool) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, true
+		}
+
+		hash := map[string]string{}
+		s.keyspace()[key] = &entry{value: hash}
+		return hash, true
+	}
+
+	hash, ok := e.value.(map[string]string)
+	return hash, ok
+}
+
+func (s *redisConn) hsetCmd(args []string) (string, bool) {
+	if len(args)%2 != 1 {
+		return fmt.Sprintf(errorMsg("arity"), "hset"), false
+	}
+
+	hash, ok := s.hash(args[0], true)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	n := int64(0)
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := hash[args[i]]; !ok {
+			n++
+		}
+		hash[args[i]] = args[i+1]
+	}
+
+	return integer(n), false
+}
+
+func (s *redisConn) hmsetCmd(args []string) (string, bool) {
+	reply, _ := s.hsetCmd(args)
+	if strings.HasPrefix(reply, "-") {
+		return strings.Replace(reply, "'hset'", "'hmset'", 1), false
+	}
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) hsetnxCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], true)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	if _, ok := hash[args[1]]; ok {
+		return integer(0), false
+	}
+
+	hash[args[1]] = args[2]
+	return integer(1), false
+}
+
+func (s *redisConn) hgetCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	value, ok := hash[args[1]]
+	if !ok {
+		return nilString(), false
+	}
+	return bulkString(value, false), false
+}
+
+func (s *redisConn) hmgetCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	replies := make([]string, len(args)-1)
+	for i, field := range args[1:] {
+		if value, ok := hash[field]; ok {
+			replies[i] = bulkString(value, false)
+		} else {
+			replies[i] = nilString()
+		}
+	}
+	return array(replies...), false
+}
+
+// fields returns the sorted fields of a hash.
+func fields(hash map[string]string) []string {
+	names := []string{}
+	for name := range hash {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (s *redisConn) hgetallCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	values := []string{}
+	for _, name := range fields(hash) {
+		values = append(values, name, hash[name])
+	}
+	return bulkStrings(values), false
+}
+
+func (s *redisConn) hdelCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	n := int64(0)
+	for _, field := range args[1:] {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			n++
+		}
+	}
+
+	if hash != nil && len(hash) == 0 {
+		delete(s.keyspace(), args[0])
+	}
+
+	return integer(n), false
+}
+
+func (s *redisConn) hkeysCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return bulkStrings(fields(hash)), false
+}
+
+func (s *redisConn) hvalsCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	values := []string{}
+	for _, name := range fields(hash) {
+		values = append(values, hash[name])
+	}
+	return bulkStrings(values), false
+}
+
+func (s *redisConn) hlenCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return integer(int64(len(hash))), false
+}
+
+func (s *redisConn) hexistsCmd(args []string) (string, bool) {
+	hash, ok := s.hash(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	if _, ok := hash[args[1]]; ok {
+		return integer(1), false
+	}
+	return integer(0), false
+}
+
+// set returns the set of key, when create is set a missing set is created.
+// ok is false when key holds another type.
+func (s *redisConn) set(key string, create bool) (map[string]struct{}, bool) {
+	e := s.lookup(key)
+	if e == nil {
+		if !create {
+			return nil, true
+		}
+
+		set := map[string]struct{}{}
+		s.keyspace()[key] = &entry{value: set}
+		return set, true
+	}
+
+	set, ok := e.value.(map[string]struct{})
+	return set, ok
+}
+
+// members returns the sorted members of a set.
+func members(set map[string]struct{}) []string {
+	values := []string{}
+	for value := range set {
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+	return values
+}
+
+func (s *redisConn) saddCmd(args []string) (string, bool) {
+	set, ok := s.set(args[0], true)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	n := int64(0)
+	for _, member := range args[1:] {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			n++
+		}
+	}
+	return integer(n), false
+}
+
+func (s *redisConn) sremCmd(args []string) (string, bool) {
+	set, ok := s.set(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	n := int64(0)
+	for _, member := range args[1:] {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			n++
+		}
+	}
+
+	if set != nil && len(set) == 0 {
+		delete(s.keyspace(), args[0])
+	}
+
+	return integer(n), false
+}
+
+func (s *redisConn) smembersCmd(args []string) (string, bool) {
+	set, ok := s.set(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return bulkStrings(members(set)), false
+}
+
+func (s *redisConn) sismemberCmd(args []string) (string, bool) {
+	set, ok := s.set(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+
+	if _, ok := set[args[1]]; ok {
+		return integer(1), false
+	}
+	return integer(0), false
+}
+
+func (s *redisConn) scardCmd(args []string) (string, bool) {
+	set, ok := s.set(args[0], false)
+	if !ok {
+		return errorMsg("wrongtype"), false
+	}
+	return integer(int64(len(set))), false
+}
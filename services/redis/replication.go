@@ -0,0 +1,376 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+const (
+	replicationTimeout = 60 * time.Second
+
+	// maxModules is the maximum number of loaded modules
+	maxModules = 64
+)
+
+// dial connects to masters, forbidden addresses are refused like for
+// downloads.
+var dial = artifacts.Dialer.DialContext
+
+// options returns the options of events sent outside of commands.
+func (s *redisConn) options() []event.Option {
+	return []event.Option{
+		services.EventOptions,
+		event.Category("redis"),
+		event.SourceAddr(s.conn.RemoteAddr()),
+		event.DestinationAddr(s.conn.LocalAddr()),
+	}
+}
+
+func (s *redisConn) slaveofCmd(args []string) (string, bool) {
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		s.inst.master = ""
+		return simpleString("OK"), false
+	}
+
+	if port, err := strconv.Atoi(args[1]); err != nil || port < 0 || port > 65535 {
+		return errorMsg("integer"), false
+	}
+
+	master := net.JoinHostPort(args[0], args[1])
+	if !s.alloc(master) {
+		return errorMsg("oom"), false
+	}
+
+	s.inst.master = master
+	s.field("redis.master", master)
+
+	// a single replication runs per keyspace, the instance is locked
+	// while the command is handled
+	if s.Replicate && !s.inst.replicating {
+		s.inst.replicating = true
+		go s.replicate(master)
+	}
+
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) roleCmd(args []string) (string, bool) {
+	host, port, err := net.SplitHostPort(s.inst.master)
+	if err != nil {
+		return array(bulkString("master", false), integer(0), array()), false
+	}
+
+	n, _ := strconv.ParseInt(port, 10, 64)
+	return array(bulkString("slave", false), bulkString(host, false), integer(n), bulkString("connected", false), integer(0)), false
+}
+
+// replicate fakes the replication handshake with master, the payload sent
+// by the master is saved as artifact with the name of the dump file, as
+// rogue masters use it to write modules to disk.
+func (s *redisConn) replicate(master string) {
+	defer func() {
+		s.inst.Lock()
+		s.inst.replicating = false
+		s.inst.Unlock()
+	}()
+
+	options := event.NewWith(append(s.options(), event.Custom("redis.master", master))...)
+
+	payload, replid, err := s.sync(master)
+	if err != nil {
+		log.Errorf("Error replicating from %s: %s", master, err.Error())
+
+		s.ch.Send(event.New(
+			options,
+			event.Type("redis-replication"),
+			event.Custom("redis.error", err.Error()),
+		))
+		return
+	}
+
+	s.inst.Lock()
+	name := path.Join(s.inst.config["dir"], s.inst.config["dbfilename"])
+	s.inst.Unlock()
+
+	a, err := artifacts.Save(s.ch, bytes.NewReader(payload), name, options)
+	if err != nil {
+		log.Errorf("Error saving replication payload from %s: %s", master, err.Error())
+		return
+	}
+
+	s.inst.Lock()
+	s.inst.files[name] = a.SHA256
+	s.inst.Unlock()
+
+	s.ch.Send(event.New(
+		options,
+		event.Type("redis-replication"),
+		event.Custom("redis.replid", replid),
+		event.Custom("redis.filename", name),
+		event.Custom("redis.payload-size", len(payload)),
+		event.Custom("redis.sha256", a.SHA256),
+	))
+}
+
+// sync connects to master as replica and returns the payload of the full
+// resynchronization. Payloads larger than the maximum artifact size are
+// truncated to one byte more, so they are flagged as too large.
+func (s *redisConn) sync(master string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicationTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", master)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(replicationTimeout))
+
+	r := bufio.NewReaderSize(conn, maxInlineLength)
+
+	replid := ""
+	for _, command := range [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", "6379"},
+		{"REPLCONF", "capa", "eof", "capa", "psync2"},
+		{"PSYNC", "?", "-1"},
+	} {
+		if _, err := conn.Write([]byte(bulkStrings(command))); err != nil {
+			return nil, "", err
+		}
+
+		reply, err := readLine(r)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if fields := strings.Fields(reply); len(fields) > 1 && fields[0] == "+FULLRESYNC" {
+			replid = fields[1]
+		}
+	}
+
+	max := artifacts.Default().MaxFileSize + 1
+
+	// the payload follows after optional newlines, sent as keepalive
+	line := ""
+	for line == "" {
+		if line, err = readLine(r); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if !strings.HasPrefix(line, "$") {
+		return nil, "", fmt.Errorf("unexpected reply: %q", line)
+	}
+
+	buf := bytes.Buffer{}
+
+	if strings.HasPrefix(line, "$EOF:") {
+		// diskless replication ends with a mark instead of a length
+		mark := []byte(line[5:])
+
+		for int64(buf.Len()) < max && !bytes.HasSuffix(buf.Bytes(), mark) {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, "", err
+			}
+
+			buf.WriteByte(b)
+		}
+
+		return bytes.TrimSuffix(buf.Bytes(), mark), replid, nil
+	}
+
+	n, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || n < 0 {
+		return nil, "", fmt.Errorf("invalid bulk length: %q", line)
+	}
+
+	if n > max {
+		n = max
+	}
+
+	if _, err := io.CopyN(&buf, r, n); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), replid, nil
+}
+
+// moduleName returns the name of a module from its path.
+func moduleName(p string) string {
+	name := path.Base(p)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func (s *redisConn) moduleCmd(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "load":
+		if len(args) < 2 {
+			break
+		}
+
+		p := args[1]
+		if !path.IsAbs(p) {
+			p = path.Join(s.inst.config["dir"], p)
+		}
+
+		s.field("redis.module", args[1])
+
+		// modules written by a rogue master or save are linked to the
+		// artifact
+		if sha256, ok := s.inst.files[p]; ok {
+			s.field("redis.module-sha256", sha256)
+		}
+
+		name := moduleName(p)
+		for _, module := range s.inst.modules {
+			if module == name {
+				return simpleString("OK"), false
+			}
+		}
+
+		if len(s.inst.modules) < maxModules {
+			if !s.alloc(name) {
+				return errorMsg("oom"), false
+			}
+
+			s.inst.modules = append(s.inst.modules, name)
+		}
+
+		return simpleString("OK"), false
+	case "list":
+		replies := []string{}
+		for _, module := range s.inst.modules {
+			replies = append(replies, array(
+				bulkString("name", false),
+				bulkString(module, false),
+				bulkString("ver", false),
+				integer(1),
+			))
+		}
+		return array(replies...), false
+	case "unload":
+		if len(args) != 2 {
+			break
+		}
+
+		for i, module := range s.inst.modules {
+			if module == args[1] {
+				s.inst.modules = append(s.inst.modules[:i], s.inst.modules[i+1:]...)
+				return simpleString("OK"), false
+			}
+		}
+
+		return "-ERR Error unloading module: no such module with that name\r\n", false
+	}
+
+	return fmt.Sprintf("-ERR Unknown subcommand or wrong number of arguments for '%s'. Try MODULE HELP\r\n", sanitize.Replace(args[0])), false
+}
+
+// dump returns the keyspace as a simplified dump file. Keys and values are
+// written on separate lines, so payloads meant for cron or authorized_keys
+// files are readable like in a real dump.
+func (s *redisConn) dump() []byte {
+	buf := bytes.Buffer{}
+
+	now := time.Now()
+	for _, db := range s.inst.dbs {
+		keys := []string{}
+		for key, e := range db {
+			if !e.expired(now) {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			values := []string{key}
+
+			switch v := db[key].value.(type) {
+			case string:
+				values = append(values, v)
+			case []string:
+				values = append(values, v...)
+			case map[string]string:
+				for _, name := range fields(v) {
+					values = append(values, name, v[name])
+				}
+			case map[string]struct{}:
+				values = append(values, members(v)...)
+			}
+
+			for _, value := range values {
+				buf.WriteString(value)
+				buf.WriteString("\n")
+			}
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	return append([]byte("REDIS0008\n"), buf.Bytes()...)
+}
+
+// save saves the dump as artifact, with the file name of the dump.
+func (s *redisConn) save() {
+	s.inst.lastSave = time.Now()
+
+	data := s.dump()
+	if data == nil {
+		return
+	}
+
+	name := path.Join(s.inst.config["dir"], s.inst.config["dbfilename"])
+	s.field("redis.filename", name)
+
+	a, err := artifacts.Save(s.ch, bytes.NewReader(data), name, s.options()...)
+	if err != nil {
+		log.Errorf("Error saving dump %s: %s", name, err.Error())
+		return
+	}
+
+	s.inst.files[name] = a.SHA256
+	s.field("redis.sha256", a.SHA256)
+}
+
+func (s *redisConn) saveCmd(args []string) (string, bool) {
+	s.save()
+	return simpleString("OK"), false
+}
+
+func (s *redisConn) bgsaveCmd(args []string) (string, bool) {
+	s.save()
+	return simpleString("Background saving started"), false
+}
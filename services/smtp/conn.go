@@ -15,14 +15,21 @@ package smtp
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/textproto"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
 )
 
 const (
@@ -38,14 +45,32 @@ type conn struct {
 	server *Server
 	rcv    chan string
 	i      int
+
+	// username is the username the client authenticated with
+	username string
+
+	// send sends an event with the options of the connection
+	send func(options ...event.Option)
 }
 
 func (c *conn) newMessage() *Message {
 
 	return &Message{
-		Body:   &bytes.Buffer{},
-		Buffer: &bytes.Buffer{},
+		Body:       &bytes.Buffer{},
+		Buffer:     &bytes.Buffer{},
+		Helo:       c.domain,
+		Username:   c.username,
+		RemoteAddr: c.rwc.RemoteAddr(),
+		LocalAddr:  c.rwc.LocalAddr(),
+	}
+}
+
+func (c *conn) event(options ...event.Option) {
+	if c.send == nil {
+		return
 	}
+
+	c.send(options...)
 }
 
 func (c *conn) RemoteAddr() net.Addr {
@@ -105,6 +130,176 @@ func isCommand(line string, cmd string) bool {
 	return strings.HasPrefix(strings.ToUpper(line), cmd)
 }
 
+// parsePath returns the address of the path argument of MAIL FROM and RCPT
+// TO, like "FROM:<user@example.com> SIZE=100".
+func parsePath(line string, cmd string) string {
+	arg := strings.TrimSpace(line[len(cmd):])
+	arg = strings.TrimSpace(strings.TrimPrefix(arg, ":"))
+
+	if strings.HasPrefix(arg, "<") {
+		if i := strings.IndexByte(arg, '>'); i >= 0 {
+			return arg[1:i]
+		}
+	}
+
+	if fields := strings.Fields(arg); len(fields) > 0 {
+		return fields[0]
+	}
+
+	return ""
+}
+
+func decodeBase64(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	return string(data), err
+}
+
+// readAuthLine writes the challenge and reads the base64 encoded response,
+// aborted is set when the client cancels the exchange.
+func (c *conn) readAuthLine(challenge string) (response string, aborted bool, err error) {
+	c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+
+	line, err := c.ReadLine()
+	if err != nil {
+		return "", false, err
+	}
+
+	if strings.TrimSpace(line) == "*" {
+		return "", true, nil
+	}
+
+	response, err = decodeBase64(line)
+	return response, false, err
+}
+
+// authState handles AUTH PLAIN, LOGIN and CRAM-MD5, rfc4954. The
+// credentials are sent as auth event and accepted.
+func authState(line string) stateFn {
+	return func(c *conn) stateFn {
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			c.PrintfLine("501 5.5.4 Syntax: AUTH mechanism")
+			return loopState
+		}
+
+		mechanism := strings.ToUpper(parts[1])
+
+		initial := ""
+		if len(parts) > 2 {
+			initial = parts[2]
+		}
+
+		options := []event.Option{
+			event.Type("auth"),
+			event.Custom("smtp.mechanism", mechanism),
+		}
+
+		var (
+			username string
+			aborted  bool
+			err      error
+		)
+
+		switch mechanism {
+		case "PLAIN":
+			var response string
+
+			if initial == "" || initial == "=" {
+				response, aborted, err = c.readAuthLine("")
+			} else {
+				response, err = decodeBase64(initial)
+			}
+
+			if err != nil || aborted {
+				break
+			}
+
+			// authorization identity, authentication identity and password
+			fields := strings.SplitN(response, "\x00", 3)
+			if len(fields) != 3 {
+				err = fmt.Errorf("invalid plain response")
+				break
+			}
+
+			username = fields[1]
+
+			options = append(options,
+				event.Custom("smtp.authzid", fields[0]),
+				event.Custom("smtp.username", fields[1]),
+				event.Custom("smtp.password", fields[2]),
+			)
+		case "LOGIN":
+			if initial != "" {
+				username, err = decodeBase64(initial)
+			} else {
+				username, aborted, err = c.readAuthLine("Username:")
+			}
+
+			if err != nil || aborted {
+				break
+			}
+
+			var password string
+			if password, aborted, err = c.readAuthLine("Password:"); err != nil || aborted {
+				break
+			}
+
+			options = append(options,
+				event.Custom("smtp.username", username),
+				event.Custom("smtp.password", password),
+			)
+		case "CRAM-MD5":
+			n, _ := rand.Int(rand.Reader, big.NewInt(1<<31))
+
+			challenge := fmt.Sprintf("<%d.%d@%s>", n, time.Now().Unix(), c.server.Host)
+
+			var response string
+			if response, aborted, err = c.readAuthLine(challenge); err != nil || aborted {
+				break
+			}
+
+			// the response is the username and the hmac-md5 of the
+			// challenge keyed with the password, which can be cracked
+			i := strings.LastIndexByte(response, ' ')
+			if i == -1 {
+				err = fmt.Errorf("invalid cram-md5 response")
+				break
+			}
+
+			username = response[:i]
+
+			if _, err = hex.DecodeString(response[i+1:]); err != nil {
+				break
+			}
+
+			options = append(options,
+				event.Custom("smtp.username", username),
+				event.Custom("smtp.cram-md5.challenge", challenge),
+				event.Custom("smtp.cram-md5.digest", response[i+1:]),
+			)
+		default:
+			c.PrintfLine("504 5.5.4 Unrecognized authentication type")
+			return loopState
+		}
+
+		if aborted {
+			c.PrintfLine("501 5.0.0 Authentication aborted")
+			return loopState
+		} else if err != nil {
+			c.PrintfLine("501 5.5.2 Cannot decode response")
+			return loopState
+		}
+
+		c.event(options...)
+
+		c.username = username
+		c.msg.Username = username
+
+		c.PrintfLine("235 2.7.0 Authentication successful")
+		return loopState
+	}
+}
+
 func mailFromState(c *conn) stateFn {
 	line, err := c.ReadLine()
 	if err != nil {
@@ -119,6 +314,25 @@ func mailFromState(c *conn) stateFn {
 		c.msg = c.newMessage()
 		return loopState
 	} else if isCommand(line, "RCPT TO") {
+		rcpt := parsePath(line, "RCPT TO")
+
+		if !c.server.isLocal(rcpt) {
+			c.event(
+				event.Type("relay-attempt"),
+				event.Custom("smtp.from", c.msg.From),
+				event.Custom("smtp.rcpt", rcpt),
+				event.Custom("smtp.open-relay", c.server.OpenRelay),
+			)
+
+			if !c.server.OpenRelay {
+				c.PrintfLine("554 5.7.1 <%s>: Relay access denied", rcpt)
+				return mailFromState
+			}
+
+			c.msg.Relay = true
+		}
+
+		c.msg.To = append(c.msg.To, rcpt)
 
 		c.PrintfLine("250 Ok")
 		return mailFromState
@@ -192,9 +406,11 @@ func loopState(c *conn) stateFn {
 	}
 
 	if isCommand(line, "MAIL FROM") {
-		//c.msg.From, _ = mail.ParseAddress(line[10:])
+		c.msg.From = parsePath(line, "MAIL FROM")
 		c.PrintfLine("250 Ok")
 		return mailFromState
+	} else if isCommand(line, "AUTH") {
+		return authState(line)
 	} else if isCommand(line, "STARTTLS") {
 		c.PrintfLine("220 Ready to start TLS")
 
@@ -257,6 +473,7 @@ func helloState(c *conn) stateFn {
 		}
 
 		c.domain = domain
+		c.msg.Helo = domain
 
 		c.PrintfLine("250 Hello %s, I am glad to meet you", domain)
 		return loopState
@@ -267,6 +484,7 @@ func helloState(c *conn) stateFn {
 		}
 
 		c.domain = domain
+		c.msg.Helo = domain
 
 		c.PrintfLine("250-Hello %s", domain)
 		c.PrintfLine("250-SIZE 35882577")
@@ -276,6 +494,7 @@ func helloState(c *conn) stateFn {
 			c.PrintfLine("250-STARTTLS")
 		}
 
+		c.PrintfLine("250-AUTH PLAIN LOGIN CRAM-MD5")
 		c.PrintfLine("250-HELP")
 		c.PrintfLine("250-ENHANCEDSTATUSCODES")
 		c.PrintfLine("250-PIPELINING")
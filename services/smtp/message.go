@@ -15,11 +15,39 @@ package smtp
 
 import (
 	"bytes"
+	"encoding/base64"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
 	"net/mail"
+	"net/textproto"
+	"strings"
 )
 
+const (
+	// maxPartSize limits the size of a decoded part
+	maxPartSize = 10 * 1024 * 1024
+
+	// maxParts limits the number of parts of a message
+	maxParts = 100
+
+	// maxDepth limits the nesting of multipart parts
+	maxDepth = 10
+)
+
+// Part is a decoded part of a message.
+type Part struct {
+	Header textproto.MIMEHeader
+
+	ContentType string
+	FileName    string
+
+	Data []byte
+}
+
 // Message smtp message
 type Message struct {
 	Header mail.Header
@@ -27,6 +55,25 @@ type Message struct {
 	Buffer *bytes.Buffer
 
 	Body *bytes.Buffer
+
+	// the envelope of the message
+	Helo string
+	From string
+	To   []string
+
+	// Username is the username the client authenticated with
+	Username string
+
+	// Relay is set when a recipient isn't in the local domains
+	Relay bool
+
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+
+	// Texts contains the text parts of the message, Attachments the
+	// files and other parts
+	Texts       []Part
+	Attachments []Part
 }
 
 func (m *Message) Read(r io.Reader) error {
@@ -49,5 +96,66 @@ func (m *Message) Read(r io.Reader) error {
 	}
 
 	m.Body = bytes.NewBuffer(buff)
+
+	m.parse(textproto.MIMEHeader(m.Header), bytes.NewReader(buff), 0)
 	return err
 }
+
+// decode returns a reader decoding the content transfer encoding.
+func decode(header textproto.MIMEHeader, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+
+	return r
+}
+
+// parse decodes the parts of the body into texts and attachments, invalid
+// parts are skipped.
+func (m *Message) parse(header textproto.MIMEHeader, r io.Reader, depth int) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxDepth {
+		mr := multipart.NewReader(r, params["boundary"])
+
+		for len(m.Texts)+len(m.Attachments) < maxParts {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+
+			m.parse(part.Header, part, depth+1)
+		}
+
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(decode(header, r), maxPartSize))
+	if err != nil && len(data) == 0 {
+		return
+	}
+
+	part := Part{
+		Header:      header,
+		ContentType: mediaType,
+		FileName:    params["name"],
+		Data:        data,
+	}
+
+	disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil && dparams["filename"] != "" {
+		part.FileName = dparams["filename"]
+	}
+
+	if part.FileName != "" || disposition == "attachment" || !strings.HasPrefix(mediaType, "text/") {
+		m.Attachments = append(m.Attachments, part)
+	} else {
+		m.Texts = append(m.Texts, part)
+	}
+}
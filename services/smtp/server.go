@@ -16,6 +16,7 @@ package smtp
 import (
 	"crypto/tls"
 	"net"
+	"strings"
 	"sync"
 )
 
@@ -58,11 +59,43 @@ func (mux *ServeMux) Serve(msg Message) error {
 type Server struct {
 	Banner string
 
+	// Host is the host name of the server
+	Host string
+
+	// Domains are the local domains, recipients in other domains are
+	// relayed. Host is the local domain when not set.
+	Domains []string
+
+	// OpenRelay accepts relaying, relayed messages are never delivered
+	OpenRelay bool
+
 	Handler Handler
 
 	tlsConfig *tls.Config
 }
 
+// isLocal returns if the address is in one of the local domains.
+func (s *Server) isLocal(address string) bool {
+	domains := s.Domains
+	if len(domains) == 0 {
+		domains = []string{s.Host}
+	}
+
+	i := strings.LastIndexByte(address, '@')
+	if i == -1 {
+		// local part only, like postmaster
+		return true
+	}
+
+	for _, domain := range domains {
+		if strings.EqualFold(address[i+1:], domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *Server) newConn(rwc net.Conn, recv chan string) *conn {
 	c := &conn{
 		server: s,
@@ -75,9 +108,9 @@ func (s *Server) newConn(rwc net.Conn, recv chan string) *conn {
 	return c
 }
 
-func (s *Server) tlsConf(cert *tls.Certificate) {
+func (s *Server) tlsConf(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
 	s.tlsConfig = &tls.Config{
-		Certificates:       []tls.Certificate{*cert},
+		GetCertificate:     getCertificate,
 		InsecureSkipVerify: true,
 	}
 }
@@ -14,12 +14,16 @@
 package smtp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/honeytrap/honeytrap/artifacts"
+	"github.com/honeytrap/honeytrap/certificates"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
@@ -44,10 +48,10 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 				Host:           "remailer.ru",
 				Name:           "SMTP",
 			},
+			OpenRelay: true,
 			srv: &Server{
 				tlsConfig: nil,
 			},
-			receiveChan: make(chan Message),
 		},
 	}
 
@@ -56,19 +60,9 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 		o(s)
 	}
 
-	if store, err := getStorage(); err != nil {
-		log.Errorf("Could not initialize storage: %s", err.Error())
-	} else {
-
-		cert, err := store.Certificate()
-		if err != nil {
-			log.Debugf("SMTP: No TLS, %s", err.Error())
-		} else {
-			s.srv.tlsConf(cert)
-
-			log.Debug("SMTP server: set tls certificate")
-		}
-	}
+	s.srv.tlsConf(certificates.Default().GetCertificate(certificates.Subject{
+		CommonName: s.Host,
+	}))
 
 	banner, err := bannerfmt.New(s.BannerTemplate, s.Config.bannerData)
 	if err != nil {
@@ -76,13 +70,14 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 	}
 
 	s.srv.Banner = banner.String()
+	s.srv.Host = s.Host
+	s.srv.Domains = s.Domains
+	s.srv.OpenRelay = s.OpenRelay
 
-	handler := HandleFunc(func(msg Message) error {
-		s.receiveChan <- msg
-		return nil
-	})
+	mux := NewServeMux()
+	mux.HandleFunc(s.message)
 
-	s.srv.Handler = handler
+	s.srv.Handler = mux
 
 	return s
 }
@@ -98,9 +93,14 @@ type bannerData struct {
 type Config struct {
 	bannerData
 
-	srv *Server
+	// Domains are the local domains, host by default
+	Domains []string `toml:"domains"`
 
-	receiveChan chan Message
+	// OpenRelay accepts messages for other domains, which are recorded
+	// but never delivered
+	OpenRelay bool `toml:"open-relay"`
+
+	srv *Server
 }
 
 type Service struct {
@@ -113,6 +113,96 @@ func (s *Service) SetChannel(c pushers.Channel) {
 	s.ch = c
 }
 
+// relayProbePattern matches the phrases of relay tests, like "relay test" or
+// "open relay check".
+var relayProbePattern = regexp.MustCompile(`\b(open[ -]?relay|relay[ -]?(test|check|probe)|(test|check|probe)(ing)?[ -]?relay)\b`)
+
+// relayProbe returns if the relayed message looks like a relay test.
+// Spammers test open relays by sending a message to themselves, mentioning
+// the address of the server or a relay test.
+func relayProbe(msg Message) bool {
+	if !msg.Relay {
+		return false
+	}
+
+	content := []string{msg.Header.Get("Subject")}
+	for _, part := range msg.Texts {
+		content = append(content, string(part.Data))
+	}
+
+	text := strings.ToLower(strings.Join(content, "\n"))
+
+	// the address of the server, but not as part of another address
+	if ta, ok := msg.LocalAddr.(*net.TCPAddr); ok {
+		ip := regexp.MustCompile(`(^|[^0-9a-f.:])` + regexp.QuoteMeta(ta.IP.String()) + `\.?($|[^0-9a-f.:])`)
+		if ip.MatchString(text) {
+			return true
+		}
+	}
+
+	return relayProbePattern.MatchString(text)
+}
+
+// message sends the email event of a received message, the attachments are
+// saved as artifacts.
+func (s *Service) message(msg Message) error {
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("smtp"),
+		event.SourceAddr(msg.RemoteAddr),
+		event.DestinationAddr(msg.LocalAddr),
+	}
+
+	header := []event.Option{}
+
+	for key, values := range msg.Header {
+		header = append(header, event.Custom("smtp."+key, strings.Join(values, ",")))
+	}
+
+	texts := map[string][]string{}
+	for _, part := range msg.Texts {
+		texts[part.ContentType] = append(texts[part.ContentType], string(part.Data))
+	}
+
+	attachments := []map[string]interface{}{}
+
+	for _, part := range msg.Attachments {
+		attachment := map[string]interface{}{
+			"filename":     part.FileName,
+			"content-type": part.ContentType,
+			"size":         len(part.Data),
+		}
+
+		a, err := artifacts.Save(s.ch, bytes.NewReader(part.Data), part.FileName, options...)
+		if err != nil {
+			log.Errorf("Error storing attachment %s: %s", part.FileName, err.Error())
+		} else {
+			attachment["sha256"] = a.SHA256
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	s.ch.Send(event.New(
+		append(options,
+			event.Type("email"),
+			event.Custom("smtp.body", msg.Body.String()),
+			event.Custom("smtp.helo", msg.Helo),
+			event.Custom("smtp.from", msg.From),
+			event.Custom("smtp.to", strings.Join(msg.To, ",")),
+			event.Custom("smtp.username", msg.Username),
+			event.Custom("smtp.text", strings.Join(texts["text/plain"], "\n")),
+			event.Custom("smtp.html", strings.Join(texts["text/html"], "\n")),
+			event.Custom("smtp.attachments", attachments),
+			event.Custom("smtp.relay", msg.Relay),
+			event.Custom("smtp.relay-probe", relayProbe(msg)),
+			event.NewWith(header...),
+		)...,
+	))
+
+	return nil
+}
+
 func (s *Service) Handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
@@ -122,35 +212,13 @@ func (s *Service) Handle(ctx context.Context, conn net.Conn) error {
 
 	rcvLine := make(chan string)
 
-	// Wait for a message and send it into the eventbus
+	done := make(chan struct{})
+	defer close(done)
+
+	// send the received lines into the eventbus
 	go func() {
 		for {
 			select {
-			case message := <-s.receiveChan:
-				header := []event.Option{}
-
-				for key, values := range message.Header {
-					var vals strings.Builder
-
-					for _, s := range values {
-						if vals.Len() > 0 {
-							_, _ = vals.WriteRune(',')
-						}
-						_, _ = vals.WriteString(s)
-					}
-
-					header = append(header, event.Custom("smtp."+key, vals.String()))
-				}
-
-				s.ch.Send(event.New(
-					services.EventOptions,
-					event.Category("smtp"),
-					event.Type("email"),
-					event.SourceAddr(conn.RemoteAddr()),
-					event.DestinationAddr(conn.LocalAddr()),
-					event.Custom("smtp.body", message.Body.String()),
-					event.NewWith(header...),
-				))
 			case line := <-rcvLine:
 				s.ch.Send(event.New(
 					services.EventOptions,
@@ -160,12 +228,25 @@ func (s *Service) Handle(ctx context.Context, conn net.Conn) error {
 					event.DestinationAddr(conn.LocalAddr()),
 					event.Custom("smtp.line", line),
 				))
+			case <-done:
+				return
 			}
 		}
 	}()
 
 	//Create new smtp server connection
 	c := s.srv.newConn(conn, rcvLine)
+	c.send = func(options ...event.Option) {
+		s.ch.Send(event.New(
+			append([]event.Option{
+				services.EventOptions,
+				event.Category("smtp"),
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+			}, options...)...,
+		))
+	}
+
 	// Start server loop
 	c.serve()
 	return nil
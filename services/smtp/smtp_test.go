@@ -14,13 +14,23 @@
 package smtp
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/honeytrap/honeytrap/pushers"
+
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	"github.com/honeytrap/honeytrap/services"
 	"github.com/honeytrap/honeytrap/storage"
 )
 
@@ -110,3 +120,294 @@ func TestSMTP(t *testing.T) {
 	// Check if data is received.
 	// with file channel?
 }
+
+// dial starts the service on a pipe and returns the client side, after
+// the greeting and EHLO.
+func dial(t *testing.T, s *Service) (*textproto.Conn, func()) {
+	client, server := net.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.Handle(nil, server)
+		close(done)
+	}()
+
+	text := textproto.NewConn(client)
+
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := text.Cmd("EHLO client.example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, msg, err := text.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(msg, "AUTH PLAIN LOGIN CRAM-MD5") {
+		t.Errorf("Expected AUTH to be advertised, got %q", msg)
+	}
+
+	return text, func() {
+		text.Cmd("QUIT")
+		text.ReadResponse(221)
+		client.Close()
+		<-done
+	}
+}
+
+func cmd(t *testing.T, text *textproto.Conn, code int, format string, args ...interface{}) string {
+	if _, err := text.Cmd(format, args...); err != nil {
+		t.Fatal(err)
+	}
+
+	_, msg, err := text.ReadResponse(code)
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+
+	return msg
+}
+
+func TestAuth(t *testing.T) {
+	s := SMTP().(*Service)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	text, close := dial(t, s)
+
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	cmd(t, text, 235, "AUTH PLAIN %s", b64("\x00alice\x00secret"))
+
+	cmd(t, text, 334, "AUTH LOGIN")
+	cmd(t, text, 334, b64("bob"))
+	cmd(t, text, 235, b64("hunter2"))
+
+	challenge, err := base64.StdEncoding.DecodeString(cmd(t, text, 334, "AUTH CRAM-MD5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(md5.New, []byte("password"))
+	mac.Write(challenge)
+	digest := hex.EncodeToString(mac.Sum(nil))
+
+	cmd(t, text, 235, b64("carol "+digest))
+
+	// cancelled by the client
+	cmd(t, text, 334, "AUTH LOGIN")
+	cmd(t, text, 501, "*")
+
+	cmd(t, text, 504, "AUTH GSSAPI")
+
+	close()
+
+	events := c.Find("auth")
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 auth events, got %d", len(events))
+	}
+
+	for i, want := range []struct {
+		mechanism, username, password string
+	}{
+		{"PLAIN", "alice", "secret"},
+		{"LOGIN", "bob", "hunter2"},
+		{"CRAM-MD5", "carol", ""},
+	} {
+		e := events[i]
+
+		if e.Get("smtp.mechanism") != want.mechanism || e.Get("smtp.username") != want.username || e.Get("smtp.password") != want.password {
+			t.Errorf("Unexpected credentials %s %s %s", e.Get("smtp.mechanism"), e.Get("smtp.username"), e.Get("smtp.password"))
+		}
+	}
+
+	if v := events[2].Get("smtp.cram-md5.digest"); v != digest {
+		t.Errorf("Expected digest %s, got %s", digest, v)
+	}
+
+	if v := events[2].Get("smtp.cram-md5.challenge"); v != string(challenge) {
+		t.Errorf("Expected challenge %s, got %s", challenge, v)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	s := SMTP(func(v services.Servicer) error {
+		v.(*Service).Domains = []string{"example.com"}
+		v.(*Service).OpenRelay = false
+		return nil
+	}).(*Service)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	text, close := dial(t, s)
+
+	cmd(t, text, 250, "MAIL FROM:<spammer@example.org>")
+	cmd(t, text, 250, "RCPT TO:<postmaster@example.com>")
+	cmd(t, text, 554, "RCPT TO:<victim@example.net>")
+	cmd(t, text, 354, "DATA")
+
+	w := text.DotWriter()
+	fmt.Fprintf(w, "Subject: hello\r\n\r\nhello\r\n")
+	w.Close()
+
+	if _, _, err := text.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	}
+
+	close()
+
+	// the denied recipient doesn't make the message relayed
+	if emails := c.Find("email"); len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	} else if v, _ := emails[0].Load("smtp.relay"); v != false {
+		t.Errorf("Expected message not to be relayed")
+	}
+
+	events := c.Find("relay-attempt")
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 relay attempt, got %d", len(events))
+	}
+
+	if v := events[0].Get("smtp.rcpt"); v != "victim@example.net" {
+		t.Errorf("Unexpected recipient %s", v)
+	}
+
+	if v := events[0].Get("smtp.from"); v != "spammer@example.org" {
+		t.Errorf("Unexpected sender %s", v)
+	}
+}
+
+func TestOpenRelay(t *testing.T) {
+	s := SMTP().(*Service)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	text, close := dial(t, s)
+
+	cmd(t, text, 250, "MAIL FROM:<spammer@example.org>")
+	cmd(t, text, 250, "RCPT TO:<spammer@example.org>")
+	cmd(t, text, 354, "DATA")
+
+	w := text.DotWriter()
+	fmt.Fprintf(w, "Subject: Relay test 127.0.0.1\r\n\r\nopen relay check\r\n")
+	w.Close()
+
+	if _, _, err := text.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	}
+
+	close()
+
+	events := c.Find("email")
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(events))
+	}
+
+	e := events[0]
+
+	for key, want := range map[string]string{
+		"smtp.from": "spammer@example.org",
+		"smtp.to":   "spammer@example.org",
+		"smtp.helo": "client.example.org",
+		"smtp.text": "open relay check\n",
+	} {
+		if v := e.Get(key); v != want {
+			t.Errorf("Expected %s to be %q, got %q", key, want, v)
+		}
+	}
+
+	if v, _ := e.Load("smtp.relay-probe"); v != true {
+		t.Errorf("Expected relay probe")
+	}
+}
+
+func TestRelayProbe(t *testing.T) {
+	local := &net.TCPAddr{IP: net.ParseIP("192.0.2.25"), Port: 25}
+
+	for subject, want := range map[string]bool{
+		"Relay test":                true,
+		"open-relay check":          true,
+		"testing relay 12":          true,
+		"hello from 192.0.2.25.":    true,
+		"The latest contest":        false,
+		"Relay race results":        false,
+		"hello from 192.0.2.250":    false,
+		"hello from 10.192.0.2.25x": false,
+	} {
+		msg := Message{
+			Header:    mail.Header{"Subject": []string{subject}},
+			Relay:     true,
+			LocalAddr: local,
+		}
+
+		if v := relayProbe(msg); v != want {
+			t.Errorf("Expected relay probe %t for %q, got %t", want, subject, v)
+		}
+	}
+
+	if relayProbe(Message{Header: mail.Header{"Subject": []string{"relay test"}}}) {
+		t.Errorf("Expected no relay probe for local messages")
+	}
+}
+
+func TestMessageMIME(t *testing.T) {
+	data := "From: sender@example.org\r\n" +
+		"Subject: invoice\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Please find the invoice attached =E2=82=AC\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>invoice</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/octet-stream; name=\"invoice.exe\"\r\n" +
+		"Content-Disposition: attachment; filename=\"invoice.exe\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"TVqQAAMAAAAEAAAA\r\n" +
+		"//8AALgAAAAAAAAA\r\n" +
+		"--outer--\r\n"
+
+	m := &Message{}
+	if err := m.Read(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Texts) != 2 {
+		t.Fatalf("Expected 2 texts, got %d", len(m.Texts))
+	}
+
+	if v := string(m.Texts[0].Data); v != "Please find the invoice attached €" {
+		t.Errorf("Unexpected text %q", v)
+	}
+
+	if m.Texts[1].ContentType != "text/html" {
+		t.Errorf("Unexpected content type %s", m.Texts[1].ContentType)
+	}
+
+	if len(m.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(m.Attachments))
+	}
+
+	a := m.Attachments[0]
+	if a.FileName != "invoice.exe" || len(a.Data) != 24 || string(a.Data[:2]) != "MZ" {
+		t.Errorf("Unexpected attachment %s of %d bytes", a.FileName, len(a.Data))
+	}
+}
@@ -67,12 +67,16 @@ func (s *sshAuthService) SetChannel(c pushers.Channel) {
 func (s *sshAuthService) Handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
+	// record the handshake to fingerprint the client
+	hs := recordHandshake(conn)
+
 	config := ssh.ServerConfig{
 		ServerVersion: s.Banner,
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("publickey-authentication"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -86,6 +90,7 @@ func (s *sshAuthService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("password-authentication"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -99,7 +104,7 @@ func (s *sshAuthService) Handle(ctx context.Context, conn net.Conn) error {
 
 	config.AddHostKey(s.Key)
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	sconn, chans, reqs, err := ssh.NewServerConn(hs, &config)
 	if err == io.EOF {
 		// server closed connection
 		return nil
@@ -0,0 +1,227 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ssh
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// msgKexInit is the message number of SSH_MSG_KEXINIT, rfc4253 section 7.1.
+const msgKexInit = 20
+
+// maxHandshakeSize limits the bytes buffered per direction while looking for
+// the key exchange init, the identification and kexinit are small.
+const maxHandshakeSize = 64 * 1024
+
+// kexInit contains the algorithm lists of a SSH_MSG_KEXINIT message.
+type kexInit struct {
+	KexAlgos                []string
+	ServerHostKeyAlgos      []string
+	CiphersClientServer     []string
+	CiphersServerClient     []string
+	MACsClientServer        []string
+	MACsServerClient        []string
+	CompressionClientServer []string
+	CompressionServerClient []string
+}
+
+// parseKexInit parses the payload of a SSH_MSG_KEXINIT message.
+func parseKexInit(payload []byte) (*kexInit, bool) {
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return nil, false
+	}
+
+	// skip the message number and the cookie
+	payload = payload[17:]
+
+	lists := make([][]string, 8)
+
+	for i := range lists {
+		if len(payload) < 4 {
+			return nil, false
+		}
+
+		length := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+
+		if uint32(len(payload)) < length {
+			return nil, false
+		}
+
+		if length > 0 {
+			lists[i] = strings.Split(string(payload[:length]), ",")
+		}
+
+		payload = payload[length:]
+	}
+
+	return &kexInit{
+		KexAlgos:                lists[0],
+		ServerHostKeyAlgos:      lists[1],
+		CiphersClientServer:     lists[2],
+		CiphersServerClient:     lists[3],
+		MACsClientServer:        lists[4],
+		MACsServerClient:        lists[5],
+		CompressionClientServer: lists[6],
+		CompressionServerClient: lists[7],
+	}, true
+}
+
+// handshake parses the identification and kexinit of one side of the
+// connection from the bytes it sent.
+type handshake struct {
+	buf []byte
+
+	version string
+	kex     *kexInit
+
+	done bool
+}
+
+func (h *handshake) write(p []byte) {
+	if h.done {
+		return
+	}
+
+	h.buf = append(h.buf, p...)
+
+	for h.version == "" {
+		// lines before the version are allowed, rfc4253 section 4.2
+		i := bytes.IndexByte(h.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		line := strings.TrimRight(string(h.buf[:i]), "\r")
+		h.buf = h.buf[i+1:]
+
+		if strings.HasPrefix(line, "SSH-") {
+			h.version = line
+		}
+	}
+
+	if h.version != "" && len(h.buf) >= 5 {
+		// the first binary packet is the unencrypted kexinit
+		length := binary.BigEndian.Uint32(h.buf)
+		if length > maxHandshakeSize {
+			h.done = true
+		} else if uint32(len(h.buf)-4) >= length {
+			padding := uint32(h.buf[4])
+
+			if padding+1 <= length {
+				h.kex, _ = parseKexInit(h.buf[5 : 4+length-padding])
+			}
+
+			h.done = true
+		}
+	}
+
+	if h.done || len(h.buf) > maxHandshakeSize {
+		h.done = true
+		h.buf = nil
+	}
+}
+
+// handshakeConn records the handshake of a ssh connection, to fingerprint
+// the client (hassh) and our own configuration (hasshServer).
+type handshakeConn struct {
+	net.Conn
+
+	m      sync.Mutex
+	client handshake
+	server handshake
+}
+
+func recordHandshake(conn net.Conn) *handshakeConn {
+	return &handshakeConn{
+		Conn: conn,
+	}
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.m.Lock()
+	c.client.write(p[:n])
+	c.m.Unlock()
+
+	return n, err
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	c.m.Lock()
+	c.server.write(p)
+	c.m.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+// hassh returns the hassh algorithms string and its md5 hash, see
+// https://github.com/salesforce/hassh.
+func hassh(kex, ciphers, macs, compression []string) (string, string) {
+	algorithms := strings.Join([]string{
+		strings.Join(kex, ","),
+		strings.Join(ciphers, ","),
+		strings.Join(macs, ","),
+		strings.Join(compression, ","),
+	}, ";")
+
+	digest := md5.Sum([]byte(algorithms))
+	return algorithms, hex.EncodeToString(digest[:])
+}
+
+// Options returns an option adding the client metadata and fingerprints of
+// the handshake to events, the option can be created before the handshake.
+func (c *handshakeConn) Options() event.Option {
+	return func(e event.Event) {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		if c.client.version != "" {
+			e.Store("ssh.client-version", c.client.version)
+		}
+
+		if c.server.version != "" {
+			e.Store("ssh.server-version", c.server.version)
+		}
+
+		if kex := c.client.kex; kex != nil {
+			algorithms, digest := hassh(kex.KexAlgos, kex.CiphersClientServer, kex.MACsClientServer, kex.CompressionClientServer)
+
+			e.Store("ssh.hassh", digest)
+			e.Store("ssh.hassh-algorithms", algorithms)
+
+			e.Store("ssh.kex-algorithms", strings.Join(kex.KexAlgos, ","))
+			e.Store("ssh.host-key-algorithms", strings.Join(kex.ServerHostKeyAlgos, ","))
+			e.Store("ssh.encryption-algorithms", strings.Join(kex.CiphersClientServer, ","))
+			e.Store("ssh.mac-algorithms", strings.Join(kex.MACsClientServer, ","))
+			e.Store("ssh.compression-algorithms", strings.Join(kex.CompressionClientServer, ","))
+		}
+
+		if kex := c.server.kex; kex != nil {
+			algorithms, digest := hassh(kex.KexAlgos, kex.CiphersServerClient, kex.MACsServerClient, kex.CompressionServerClient)
+
+			e.Store("ssh.hassh-server", digest)
+			e.Store("ssh.hassh-server-algorithms", algorithms)
+		}
+	}
+}
@@ -29,6 +29,8 @@ import (
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/asciicast"
+	"github.com/honeytrap/honeytrap/storage"
 
 	"bytes"
 
@@ -88,12 +90,16 @@ func (s *sshJailService) SetChannel(c pushers.Channel) {
 func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
+	// record the handshake to fingerprint the client
+	hs := recordHandshake(conn)
+
 	config := ssh.ServerConfig{
 		ServerVersion: s.Banner,
 		PublicKeyCallback: func(cm ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("publickey-authentication"),
 				event.SourceAddr(cm.RemoteAddr()),
 				event.DestinationAddr(cm.LocalAddr()),
@@ -109,6 +115,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("password-authentication"),
 				event.SourceAddr(cm.RemoteAddr()),
 				event.DestinationAddr(cm.LocalAddr()),
@@ -141,7 +148,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 
 	defer conn.Close()
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	sconn, chans, reqs, err := ssh.NewServerConn(hs, &config)
 	if err == io.EOF {
 		// server closed connection
 		return nil
@@ -166,6 +173,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -195,6 +203,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -214,6 +223,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -242,6 +252,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -259,6 +270,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -284,6 +296,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 			options := []event.Option{
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-request"),
 				event.SourceAddr(conn.RemoteAddr()),
 				event.DestinationAddr(conn.LocalAddr()),
@@ -294,6 +307,9 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 				options...,
 			))
 
+			rec := asciicast.New(storage.DataDir(), id.String(), asciicast.WithTitle("ssh"))
+			defer rec.Close()
+
 			for req := range requests {
 				log.Debugf("Request: %s %s %s %s\n", channel, req.Type, req.WantReply, req.Payload)
 
@@ -307,6 +323,11 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 					b = true
 				case "pty-req":
 					b = true
+
+					decoder := PayloadDecoder(req.Payload)
+
+					rec.SetEnv("TERM", decoder.String())
+					rec.Resize(int(decoder.Uint32()), int(decoder.Uint32()))
 				case "env":
 					b = true
 
@@ -388,7 +409,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 						defer cmd.Process.Kill()
 
 						// should only be started in req.Type == shell
-						twrc := NewTypeWriterReadCloser(channel)
+						twrc := NewTypeWriterReadCloser(rec.Wrap(channel))
 						var wrappedChannel io.ReadWriteCloser = twrc
 
 						prompt := "root@host:~$ "
@@ -427,6 +448,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 							s.c.Send(event.New(
 								services.EventOptions,
 								event.Category("ssh"),
+								hs.Options(),
 								event.Type("shell"),
 								event.SourceAddr(conn.RemoteAddr()),
 								event.DestinationAddr(conn.LocalAddr()),
@@ -499,6 +521,7 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 							options2 := []event.Option{
 								services.EventOptions,
 								event.Category("ssh"),
+								hs.Options(),
 								event.Type("exec"),
 								event.SourceAddr(conn.RemoteAddr()),
 								event.DestinationAddr(conn.LocalAddr()),
@@ -24,6 +24,8 @@ import (
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/asciicast"
+	"github.com/honeytrap/honeytrap/storage"
 
 	"encoding/base64"
 
@@ -76,6 +78,9 @@ func (s *sshProxyService) SetDirector(d director.Director) {
 func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
+	// record the handshake to fingerprint the client
+	hs := recordHandshake(conn)
+
 	var client *ssh.Client
 
 	config := ssh.ServerConfig{
@@ -85,6 +90,7 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("publickey-authentication"),
 				event.SourceAddr(cm.RemoteAddr()),
 				event.DestinationAddr(cm.LocalAddr()),
@@ -100,6 +106,7 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("password-authentication"),
 				event.SourceAddr(cm.RemoteAddr()),
 				event.DestinationAddr(cm.LocalAddr()),
@@ -140,7 +147,7 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 
 	defer conn.Close()
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	sconn, chans, reqs, err := ssh.NewServerConn(hs, &config)
 	if err == io.EOF {
 		// server closed connection
 		return nil
@@ -178,6 +185,7 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 		s.c.Send(event.New(
 			services.EventOptions,
 			event.Category("ssh"),
+			hs.Options(),
 			event.Type("ssh-channel"),
 			event.SourceAddr(conn.RemoteAddr()),
 			event.DestinationAddr(conn.LocalAddr()),
@@ -185,6 +193,8 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 			event.Custom("ssh.channel-type", newChannel.ChannelType()),
 		))
 
+		rec := asciicast.New(storage.DataDir(), id.String(), asciicast.WithTitle("ssh"))
+
 		requestFn := func(in <-chan *ssh.Request, dst ssh.Channel) {
 			defer dst.Close()
 
@@ -202,6 +212,7 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 				options := []event.Option{
 					services.EventOptions,
 					event.Category("ssh"),
+					hs.Options(),
 					event.Type("ssh-request"),
 					event.SourceAddr(conn.RemoteAddr()),
 					event.DestinationAddr(conn.LocalAddr()),
@@ -210,6 +221,18 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 					event.Custom("ssh.payload", req.Payload),
 				}
 
+				if dst != channel2 {
+					// requests of the server
+				} else if req.Type == "pty-req" {
+					decoder := PayloadDecoder(req.Payload)
+
+					rec.SetEnv("TERM", decoder.String())
+					rec.Resize(int(decoder.Uint32()), int(decoder.Uint32()))
+				} else if req.Type == "window-change" {
+					decoder := PayloadDecoder(req.Payload)
+					rec.Resize(int(decoder.Uint32()), int(decoder.Uint32()))
+				}
+
 				switch req.Type {
 				case "exit-status":
 					fallthrough
@@ -254,17 +277,22 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 			dst.Close()
 		}
 
-		var wrappedChannel io.ReadCloser = channel
+		recordedChannel := rec.Wrap(channel)
+
+		var wrappedChannel io.ReadCloser = recordedChannel
 
 		twrc := NewTypeWriterReadCloser(channel2)
 		var wrappedChannel2 io.ReadCloser = twrc
 
 		go copyFn(channel2, wrappedChannel)
-		copyFn(channel, wrappedChannel2)
+		copyFn(recordedChannel, wrappedChannel2)
+
+		rec.Close()
 
 		s.c.Send(event.New(
 			services.EventOptions,
 			event.Category("ssh"),
+			hs.Options(),
 			event.Type("ssh-session"),
 			event.SourceAddr(conn.RemoteAddr()),
 			event.DestinationAddr(conn.LocalAddr()),
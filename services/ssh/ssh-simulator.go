@@ -20,12 +20,19 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/honeytrap/honeytrap/artifacts"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/asciicast"
 	"github.com/honeytrap/honeytrap/services/decoder"
+	"github.com/honeytrap/honeytrap/services/filesystem"
+	"github.com/honeytrap/honeytrap/services/shell"
+	"github.com/honeytrap/honeytrap/storage"
 
 	"bytes"
 
@@ -70,12 +77,29 @@ func Simulator(options ...services.ServicerFunc) services.Servicer {
 		Credentials: []string{
 			"*",
 		},
+		Hostname: "host",
+
+		FsWriteLimit: shell.DefaultWriteLimit,
+		FsQuota:      shell.DefaultQuota,
 	}
 
 	for _, o := range options {
 		o(service)
 	}
 
+	if service.FsBase == "" {
+		service.FsBase = filepath.Join(storage.DataDir(), "filesystem")
+	}
+
+	fs, err := filesystem.New(service.FsBase, "ssh-simulator", service.FsRoot)
+	if err != nil {
+		log.Errorf("SSH simulator filesystem error: %s", err.Error())
+	} else {
+		log.Debugf("FileSystem rooted at %s", fs.RealPath("/"))
+		service.fs = fs
+		service.quota = shell.NewQuota(service.FsQuota)
+	}
+
 	return service
 }
 
@@ -89,6 +113,28 @@ type sshSimulatorService struct {
 
 	Credentials []string    `toml:"credentials"`
 	key         *privateKey `toml:"private-key"`
+
+	Hostname string `toml:"hostname"`
+
+	// FsBase is the directory containing the filesystems of the services,
+	// the filesystem directory of the data directory by default
+	FsBase string `toml:"fs_base"`
+	FsRoot string `toml:"fs_root"`
+
+	// FsWriteLimit is the number of bytes a session may write to the
+	// filesystem
+	FsWriteLimit int64 `toml:"fs_write_limit"`
+
+	// FsQuota is the number of bytes all sessions may write to the
+	// filesystem, the sessions share the filesystem
+	FsQuota int64 `toml:"fs_quota"`
+
+	// Download enables the download of urls requested by attackers (wget,
+	// curl), the downloads are saved as artifacts
+	Download bool `toml:"download"`
+
+	fs    *filesystem.Htfs
+	quota *shell.Quota
 }
 
 func (s *sshSimulatorService) CanHandle(payload []byte) bool {
@@ -118,6 +164,9 @@ func PayloadDecoder(payload []byte) *payloadDecoder {
 func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
+	// record the handshake to fingerprint the client
+	hs := recordHandshake(conn)
+
 	var connOptions event.Option = nil
 
 	if ec, ok := conn.(*event.Conn); ok {
@@ -131,6 +180,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("publickey-authentication"),
 				connOptions,
 				event.SourceAddr(cm.RemoteAddr()),
@@ -147,6 +197,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("password-authentication"),
 				connOptions,
 				event.SourceAddr(cm.RemoteAddr()),
@@ -180,7 +231,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 
 	defer conn.Close()
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, &config)
+	sconn, chans, reqs, err := ssh.NewServerConn(hs, &config)
 	if err == io.EOF {
 		// server closed connection
 		return nil
@@ -205,6 +256,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				connOptions,
 				event.SourceAddr(conn.RemoteAddr()),
@@ -226,6 +278,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				connOptions,
 				event.SourceAddr(conn.RemoteAddr()),
@@ -245,6 +298,7 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			s.c.Send(event.New(
 				services.EventOptions,
 				event.Category("ssh"),
+				hs.Options(),
 				event.Type("ssh-channel"),
 				connOptions,
 				event.SourceAddr(conn.RemoteAddr()),
@@ -267,143 +321,286 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 			continue
 		}
 
-		func() {
-			for req := range requests {
-				log.Debugf("Request: %s %s %s %s\n", channel, req.Type, req.WantReply, req.Payload)
-
-				options := []event.Option{
-					services.EventOptions,
-					event.Category("ssh"),
-					event.Type("ssh-request"),
-					connOptions,
-					event.SourceAddr(conn.RemoteAddr()),
-					event.DestinationAddr(conn.LocalAddr()),
-					event.Custom("ssh.sessionid", id.String()),
-					event.Custom("ssh.request-type", req.Type),
-					event.Custom("ssh.payload", req.Payload),
-				}
+		sess := &simulatorSession{
+			id:   id,
+			user: sconn.User(),
+			options: []event.Option{
+				services.EventOptions,
+				event.Category("ssh"),
+				hs.Options(),
+				connOptions,
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+				event.Custom("ssh.sessionid", id.String()),
+			},
+			channel: channel,
+			rec:     asciicast.New(storage.DataDir(), id.String(), asciicast.WithTitle("ssh")),
+		}
 
-				b := false
+		go s.handleSession(sess, requests)
+	}
 
-				switch req.Type {
-				case "shell":
-					b = true
-				case "pty-req":
-					b = true
-				case "env":
-					b = true
+	return nil
+}
 
-					decoder := PayloadDecoder(req.Payload)
+// simulatorSession contains the state of a single session channel.
+type simulatorSession struct {
+	id   xid.ID
+	user string
 
-					payloads := []string{}
+	options []event.Option
 
-					for {
-						if decoder.Available() == 0 {
-							break
-						}
+	channel ssh.Channel
 
-						payload := decoder.String()
-						payloads = append(payloads, payload)
-					}
+	// rec records the interactive shell
+	rec *asciicast.Recorder
 
-					options = append(options, event.Custom("ssh.env", payloads))
-				case "tcpip-forward":
-					decoder := PayloadDecoder(req.Payload)
+	m      sync.Mutex
+	term   *terminal.Terminal
+	width  int
+	height int
+}
 
-					options = append(options, event.Custom("ssh.tcpip-forward.address-to-bind", decoder.String()))
-					options = append(options, event.Custom("ssh.tcpip-forward.port-to-bind", fmt.Sprintf("%d", decoder.Uint32())))
-				case "exec":
-					b = true
+func (sess *simulatorSession) event(options ...event.Option) event.Event {
+	return event.New(append(append([]event.Option{}, sess.options...), options...)...)
+}
 
-					decoder := PayloadDecoder(req.Payload)
+// setSize sets the window size, the terminal is resized when the shell has
+// been started already.
+func (sess *simulatorSession) setSize(width, height int) {
+	sess.m.Lock()
+	defer sess.m.Unlock()
 
-					payloads := []string{}
+	sess.width, sess.height = width, height
 
-					for {
-						if decoder.Available() == 0 {
-							break
-						}
+	sess.rec.Resize(width, height)
 
-						payload := decoder.String()
-						payloads = append(payloads, payload)
-					}
+	if sess.term == nil {
+		return
+	}
 
-					options = append(options, event.Custom("ssh.exec", payloads))
-				case "subsystem":
-					b = true
+	if err := sess.term.SetSize(width, height); err != nil {
+		log.Errorf("Error resizing terminal: %s", err.Error())
+	}
+}
 
-					decoder := PayloadDecoder(req.Payload)
-					options = append(options, event.Custom("ssh.subsystem", decoder.String()))
-				default:
-					log.Errorf("Unsupported request type=%s payload=%s", req.Type, string(req.Payload))
-				}
+func (s *sshSimulatorService) newShell(sess *simulatorSession, w io.Writer) *shell.Shell {
+	options := []shell.Option{
+		shell.WithHostname(s.Hostname),
+		shell.WithUser(sess.user),
+	}
 
-				if !b {
-					// no reply
-				} else if err := req.Reply(b, nil); err != nil {
-					log.Errorf("wantreply: ", err)
-				}
+	if s.Download {
+		options = append(options, shell.WithDownloader(func(rawurl string) (io.ReadCloser, error) {
+			return artifacts.Download(s.c, rawurl, sess.options...)
+		}))
+	}
 
-				s.c.Send(event.New(
-					options...,
-				))
-
-				func() {
-					if req.Type == "shell" {
-						defer channel.Close()
-
-						// should only be started in req.Type == shell
-						twrc := NewTypeWriterReadCloser(channel)
-						var wrappedChannel io.ReadWriteCloser = twrc
-
-						prompt := "root@host:~$ "
-
-						term := terminal.NewTerminal(wrappedChannel, prompt)
-
-						term.Write([]byte(s.MOTD))
-
-						for {
-							line, err := term.ReadLine()
-							if err == io.EOF {
-								return
-							} else if err != nil {
-								log.Errorf("Error reading from connection: %s", err.Error())
-								return
-							}
-
-							if line == "exit" {
-								channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-								return
-							}
-
-							if line == "" {
-								continue
-							}
-
-							s.c.Send(event.New(
-								services.EventOptions,
-								event.Category("ssh"),
-								event.Type("ssh-channel"),
-								event.SourceAddr(conn.RemoteAddr()),
-								event.DestinationAddr(conn.LocalAddr()),
-								event.Custom("ssh.sessionid", id.String()),
-								event.Custom("ssh.command", line),
-							))
-
-							term.Write([]byte(fmt.Sprintf("%s: command not found\n", line)))
-						}
-					} else if req.Type == "exec" {
-						defer channel.Close()
-
-						channel.Write([]byte(fmt.Sprintf("%s: command not found\n", "ls")))
-						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-						return
-					} else {
-					}
-				}()
-			}
-		}()
+	if s.fs != nil {
+		// every session has its own working directory
+		fs := *s.fs
+		options = append(options, shell.WithFileSystem(&fs), shell.WithWriteLimit(s.FsWriteLimit), shell.WithQuota(s.quota))
 	}
 
-	return nil
+	return shell.New(w, options...)
+}
+
+// handleSession handles the requests of a session channel, as described in
+// https://tools.ietf.org/html/rfc4254#section-6.
+func (s *sshSimulatorService) handleSession(sess *simulatorSession, requests <-chan *ssh.Request) {
+	defer sess.rec.Close()
+	defer sess.channel.Close()
+
+	started := false
+
+	for req := range requests {
+		log.Debugf("Request: %s %t %s", req.Type, req.WantReply, req.Payload)
+
+		options := []event.Option{
+			event.Type("ssh-request"),
+			event.Custom("ssh.request-type", req.Type),
+			event.Custom("ssh.payload", req.Payload),
+		}
+
+		ok := false
+
+		switch req.Type {
+		case "pty-req":
+			ok = true
+
+			decoder := PayloadDecoder(req.Payload)
+
+			term := decoder.String()
+			width, height := int(decoder.Uint32()), int(decoder.Uint32())
+
+			options = append(options,
+				event.Custom("ssh.pty.term", term),
+				event.Custom("ssh.pty.width", width),
+				event.Custom("ssh.pty.height", height),
+			)
+
+			sess.rec.SetEnv("TERM", term)
+
+			sess.setSize(width, height)
+		case "window-change":
+			ok = true
+
+			decoder := PayloadDecoder(req.Payload)
+
+			width, height := int(decoder.Uint32()), int(decoder.Uint32())
+
+			options = append(options,
+				event.Custom("ssh.window.width", width),
+				event.Custom("ssh.window.height", height),
+			)
+
+			sess.setSize(width, height)
+		case "env":
+			ok = true
+
+			decoder := PayloadDecoder(req.Payload)
+
+			name := decoder.String()
+			value := decoder.String()
+
+			options = append(options,
+				event.Custom("ssh.env.name", name),
+				event.Custom("ssh.env.value", value),
+			)
+
+			sess.rec.SetEnv(name, value)
+		case "shell":
+			ok = !started
+		case "exec":
+			ok = !started
+
+			decoder := PayloadDecoder(req.Payload)
+			options = append(options, event.Custom("ssh.command", decoder.String()))
+		case "subsystem":
+			// subsystems like sftp are not emulated
+			decoder := PayloadDecoder(req.Payload)
+			options = append(options, event.Custom("ssh.subsystem", decoder.String()))
+		default:
+			log.Debugf("Unsupported request type=%s payload=%s", req.Type, string(req.Payload))
+		}
+
+		if !req.WantReply {
+		} else if err := req.Reply(ok, nil); err != nil {
+			log.Errorf("Error replying to request: %s", err.Error())
+		}
+
+		s.c.Send(sess.event(options...))
+
+		if !ok {
+			continue
+		}
+
+		switch req.Type {
+		case "shell":
+			started = true
+			go s.shell(sess)
+		case "exec":
+			started = true
+
+			decoder := PayloadDecoder(req.Payload)
+			go s.exec(sess, decoder.String())
+		}
+	}
+}
+
+// exitStatus sends the exit status of the command and closes the channel.
+func (sess *simulatorSession) exitStatus(status int) {
+	msg := struct {
+		Status uint32
+	}{
+		Status: uint32(status),
+	}
+
+	if _, err := sess.channel.SendRequest("exit-status", false, ssh.Marshal(&msg)); err != nil {
+		log.Debugf("Error sending exit status: %s", err.Error())
+	}
+
+	sess.channel.Close()
+}
+
+func (s *sshSimulatorService) exec(sess *simulatorSession, command string) {
+	sh := s.newShell(sess, sess.channel)
+
+	emulated := sh.Run(command)
+
+	s.c.Send(sess.event(
+		event.Type("exec"),
+		event.Custom("ssh.command", command),
+		event.Custom("ssh.emulated", emulated),
+	))
+
+	sess.exitStatus(sh.ExitStatus())
+}
+
+func (s *sshSimulatorService) shell(sess *simulatorSession) {
+	term := terminal.NewTerminal(NewTypeWriterReadCloser(sess.rec.Wrap(sess.channel)), "")
+
+	sess.m.Lock()
+	sess.term = term
+	if sess.width > 0 && sess.height > 0 {
+		term.SetSize(sess.width, sess.height)
+	}
+	sess.m.Unlock()
+
+	sh := s.newShell(sess, term)
+
+	term.Write([]byte(s.MOTD))
+
+	for {
+		term.SetPrompt(prompt(sh))
+
+		line, err := term.ReadLine()
+		if err == io.EOF {
+			sess.channel.Close()
+			return
+		} else if err != nil {
+			log.Errorf("Error reading from connection: %s", err.Error())
+			sess.channel.Close()
+			return
+		}
+
+		if line == "" {
+			continue
+		}
+
+		emulated := sh.Run(line)
+
+		s.c.Send(sess.event(
+			event.Type("shell"),
+			event.Custom("ssh.command", line),
+			event.Custom("ssh.emulated", emulated),
+		))
+
+		if sh.Exited() {
+			sess.exitStatus(0)
+			return
+		}
+	}
+}
+
+// prompt returns the prompt of the shell, like bash would show it.
+func prompt(sh *shell.Shell) string {
+	cwd := sh.Cwd()
+
+	home := "/home/" + sh.User
+	if sh.User == "root" {
+		home = "/root"
+	}
+
+	if cwd == home {
+		cwd = "~"
+	} else if strings.HasPrefix(cwd, home+"/") {
+		cwd = "~" + strings.TrimPrefix(cwd, home)
+	}
+
+	if sh.User == "root" {
+		return fmt.Sprintf("%s@%s:%s# ", sh.User, sh.Hostname, cwd)
+	}
+
+	return fmt.Sprintf("%s@%s:%s$ ", sh.User, sh.Hostname, cwd)
 }
@@ -0,0 +1,220 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	"github.com/honeytrap/honeytrap/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		panic(err)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSimulatorExec(t *testing.T) {
+	s := Simulator().(*sshSimulatorService)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	// net.Pipe can't be used, both sides write their version first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		srv, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Handle(context.Background(), srv)
+	}()
+
+	clt, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer clt.Close()
+
+	conn, chans, reqs, err := ssh.NewClientConn(clt, "pipe", &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("root")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Setenv("LANG", "C"); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := session.Output("echo hello && foo")
+
+	if want := "hello\nsh: foo: command not found\n"; string(output) != want {
+		t.Errorf("Expected output %q, got %q", want, string(output))
+	}
+
+	if ee, ok := err.(*ssh.ExitError); !ok || ee.ExitStatus() != 127 {
+		t.Errorf("Expected exit status 127, got %v", err)
+	}
+
+	if execs := c.Find("exec"); len(execs) == 0 {
+		t.Errorf("Expected exec event")
+	} else if e := execs[0]; e.Get("ssh.command") != "echo hello && foo" {
+		t.Errorf("Unexpected command %q", e.Get("ssh.command"))
+	}
+
+	if requests := c.Find("ssh-request"); len(requests) == 0 {
+		t.Errorf("Expected request event")
+	} else if e := requests[0]; e.Get("ssh.env.name") != "LANG" || e.Get("ssh.sessionid") == "" {
+		t.Errorf("Unexpected env request event")
+	}
+}
+
+func TestSimulatorHassh(t *testing.T) {
+	s := Simulator().(*sshSimulatorService)
+
+	c := &pushertest.Channel{}
+	s.SetChannel(c)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		srv, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.Handle(context.Background(), srv)
+	}()
+
+	clt, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _, _, err := ssh.NewClientConn(clt, "pipe", &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: []string{"curve25519-sha256@libssh.org"},
+			Ciphers:      []string{"aes128-ctr", "aes256-ctr"},
+			MACs:         []string{"hmac-sha2-256"},
+		},
+		ClientVersion:   "SSH-2.0-OpenSSH_7.4",
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("root")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+	<-done
+
+	events := c.Find("password-authentication")
+	if len(events) == 0 {
+		t.Fatal("Expected password-authentication event")
+	}
+
+	e := events[0]
+
+	if v := e.Get("ssh.client-version"); v != "SSH-2.0-OpenSSH_7.4" {
+		t.Errorf("Unexpected client version %q", v)
+	}
+
+	if v := e.Get("ssh.server-version"); v != s.Banner {
+		t.Errorf("Unexpected server version %q", v)
+	}
+
+	if v := e.Get("ssh.encryption-algorithms"); v != "aes128-ctr,aes256-ctr" {
+		t.Errorf("Unexpected encryption algorithms %q", v)
+	}
+
+	algorithms, digest := hassh(
+		strings.Split(e.Get("ssh.kex-algorithms"), ","),
+		[]string{"aes128-ctr", "aes256-ctr"},
+		[]string{"hmac-sha2-256"},
+		[]string{"none"},
+	)
+
+	if !strings.HasPrefix(algorithms, "curve25519-sha256@libssh.org") {
+		t.Errorf("Unexpected hassh algorithms %q", algorithms)
+	}
+
+	if v := e.Get("ssh.hassh-algorithms"); v != algorithms {
+		t.Errorf("Expected hassh algorithms %q, got %q", algorithms, v)
+	}
+
+	if v := e.Get("ssh.hassh"); v != digest {
+		t.Errorf("Expected hassh %s, got %s", digest, v)
+	}
+
+	if e.Get("ssh.hassh-server") == "" {
+		t.Errorf("Expected hassh server")
+	}
+}
+
+func TestSimulatorFileSystem(t *testing.T) {
+	s := Simulator().(*sshSimulatorService)
+
+	if s.fs == nil {
+		t.Fatal("Expected filesystem")
+	}
+
+	if root := s.fs.RealPath("/"); !strings.HasPrefix(root, filepath.Join(storage.DataDir(), "filesystem")+string(filepath.Separator)) {
+		t.Errorf("Expected filesystem in the data directory, got %s", root)
+	}
+}
@@ -15,13 +15,18 @@ package telnet
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"net"
+	"path/filepath"
 
+	"github.com/honeytrap/honeytrap/artifacts"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/asciicast"
+	"github.com/honeytrap/honeytrap/services/filesystem"
+	"github.com/honeytrap/honeytrap/services/shell"
+	"github.com/honeytrap/honeytrap/storage"
 	logging "github.com/op/go-logging"
 	"github.com/rs/xid"
 )
@@ -55,22 +60,60 @@ Login authentication
 // Telnet is a placeholder
 func Telnet(options ...services.ServicerFunc) services.Servicer {
 	s := &telnetService{
-		MOTD:   motd,
-		Prompt: prompt,
+		MOTD:     motd,
+		Prompt:   prompt,
+		Hostname: "localhost",
+
+		FsWriteLimit: shell.DefaultWriteLimit,
+		FsQuota:      shell.DefaultQuota,
 	}
 
 	for _, o := range options {
 		o(s)
 	}
 
+	if s.FsBase == "" {
+		s.FsBase = filepath.Join(storage.DataDir(), "filesystem")
+	}
+
+	fs, err := filesystem.New(s.FsBase, "telnet", s.FsRoot)
+	if err != nil {
+		log.Errorf("Telnet filesystem error: %s", err.Error())
+	} else {
+		log.Debugf("FileSystem rooted at %s", fs.RealPath("/"))
+		s.fs = fs
+		s.quota = shell.NewQuota(s.FsQuota)
+	}
+
 	return s
 }
 
 type telnetService struct {
 	c pushers.Channel
 
-	Prompt string `toml:"prompt"`
-	MOTD   string `toml:"motd"`
+	Prompt   string `toml:"prompt"`
+	MOTD     string `toml:"motd"`
+	Hostname string `toml:"hostname"`
+
+	// FsBase is the directory containing the filesystems of the services,
+	// the filesystem directory of the data directory by default
+	FsBase string `toml:"fs_base"`
+	FsRoot string `toml:"fs_root"`
+
+	// FsWriteLimit is the number of bytes a session may write to the
+	// filesystem
+	FsWriteLimit int64 `toml:"fs_write_limit"`
+
+	// FsQuota is the number of bytes all sessions may write to the
+	// filesystem, the sessions share the filesystem
+	FsQuota int64 `toml:"fs_quota"`
+
+	// Download enables the download of urls requested by attackers (wget,
+	// curl), the downloads are saved as artifacts
+	Download bool `toml:"download"`
+
+	fs    *filesystem.Htfs
+	quota *shell.Quota
 }
 
 func (s *telnetService) SetChannel(c pushers.Channel) {
@@ -98,7 +141,10 @@ func (s *telnetService) Handle(ctx context.Context, conn net.Conn) error {
 		event.Custom("telnet.sessionid", id.String()),
 	))
 
-	term := NewTerminal(conn, s.Prompt)
+	rec := asciicast.New(storage.DataDir(), id.String(), asciicast.WithTitle("telnet"))
+	defer rec.Close()
+
+	term := NewTerminal(rec.WrapConn(conn), s.Prompt)
 
 	term.Write([]byte(s.MOTD + "\n"))
 
@@ -131,6 +177,32 @@ func (s *telnetService) Handle(ctx context.Context, conn net.Conn) error {
 
 	term.SetPrompt(s.Prompt)
 
+	options := []shell.Option{
+		shell.WithHostname(s.Hostname),
+		shell.WithUser(username),
+	}
+
+	if s.Download {
+		options = append(options, shell.WithDownloader(func(rawurl string) (io.ReadCloser, error) {
+			return artifacts.Download(s.c, rawurl,
+				services.EventOptions,
+				event.Category("telnet"),
+				connOptions,
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+				event.Custom("telnet.sessionid", id.String()),
+			)
+		}))
+	}
+
+	if s.fs != nil {
+		// every session has its own working directory
+		fs := *s.fs
+		options = append(options, shell.WithFileSystem(&fs), shell.WithWriteLimit(s.FsWriteLimit), shell.WithQuota(s.quota))
+	}
+
+	sh := shell.New(term, options...)
+
 	for {
 		line, err := term.ReadLine()
 		if err == io.EOF {
@@ -139,6 +211,8 @@ func (s *telnetService) Handle(ctx context.Context, conn net.Conn) error {
 			return err
 		}
 
+		emulated := sh.Run(line)
+
 		s.c.Send(event.New(
 			services.EventOptions,
 			event.Category("telnet"),
@@ -148,12 +222,11 @@ func (s *telnetService) Handle(ctx context.Context, conn net.Conn) error {
 			event.DestinationAddr(conn.LocalAddr()),
 			event.Custom("telnet.sessionid", id.String()),
 			event.Custom("telnet.command", line),
+			event.Custom("telnet.emulated", emulated),
 		))
 
-		if line == "" {
-			continue
+		if sh.Exited() {
+			return nil
 		}
-
-		term.Write([]byte(fmt.Sprintf("sh: %s: command not found\n", line)))
 	}
 }
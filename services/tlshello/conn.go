@@ -0,0 +1,237 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tlshello
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/honeytrap/honeytrap/certificates"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+// recordTypeHandshake is the content type of handshake records.
+const recordTypeHandshake = 22
+
+// maxHelloSize limits the bytes buffered per direction while looking for
+// the hello.
+const maxHelloSize = 64 * 1024
+
+// recorder reassembles the first handshake message from the tls records
+// sent by one side of the connection.
+type recorder struct {
+	buf []byte
+
+	// message contains the reassembled handshake fragments
+	message []byte
+
+	done bool
+}
+
+func (r *recorder) write(p []byte) []byte {
+	if r.done {
+		return nil
+	}
+
+	r.buf = append(r.buf, p...)
+
+	for len(r.buf) >= 5 {
+		if r.buf[0] != recordTypeHandshake {
+			// not tls, or no handshake first
+			r.done = true
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(r.buf[3:]))
+		if len(r.buf) < 5+length {
+			break
+		}
+
+		r.message = append(r.message, r.buf[5:5+length]...)
+		r.buf = r.buf[5+length:]
+
+		if len(r.message) < 4 {
+			continue
+		}
+
+		size := 4 + (int(r.message[1])<<16 | int(r.message[2])<<8 | int(r.message[3]))
+		if len(r.message) >= size {
+			r.done = true
+			r.buf = nil
+
+			return r.message[:size]
+		}
+	}
+
+	if r.done || len(r.buf)+len(r.message) > maxHelloSize {
+		r.done = true
+		r.buf = nil
+		r.message = nil
+	}
+
+	return nil
+}
+
+// Conn records the hellos of a tls connection.
+type Conn struct {
+	net.Conn
+
+	m sync.Mutex
+
+	client, server recorder
+
+	clientHello *ClientHello
+	serverHello *ServerHello
+}
+
+// Record returns a connection which records the hellos sent over conn, the
+// ClientHello is read and the ServerHello written.
+func Record(conn net.Conn) *Conn {
+	return &Conn{
+		Conn: conn,
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.m.Lock()
+	if message := c.client.write(p[:n]); message != nil {
+		c.clientHello, _ = ParseClientHello(message)
+	}
+	c.m.Unlock()
+
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.m.Lock()
+	if message := c.server.write(p); message != nil {
+		c.serverHello, _ = ParseServerHello(message)
+	}
+	c.m.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+// ClientHello returns the received ClientHello, nil when not received.
+func (c *Conn) ClientHello() *ClientHello {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.clientHello
+}
+
+// ServerHello returns the sent ServerHello, nil when not sent.
+func (c *Conn) ServerHello() *ServerHello {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.serverHello
+}
+
+func joinStrings(values []string) string {
+	return strings.Join(values, ",")
+}
+
+// Options returns an option adding the fields of the hellos to events, the
+// field names start with prefix.
+func (c *Conn) Options(prefix string) event.Option {
+	return func(e event.Event) {
+		if h := c.ClientHello(); h != nil {
+			points := make([]uint16, len(h.SupportedPoints))
+			for i, v := range h.SupportedPoints {
+				points[i] = uint16(v)
+			}
+
+			e.Store(prefix+".ja3", h.JA3())
+			e.Store(prefix+".ja3-digest", h.JA3Digest())
+			e.Store(prefix+".ja4", h.JA4())
+			e.Store(prefix+".server-name", h.ServerName)
+			e.Store(prefix+".alpn-protocols", joinStrings(h.ALPNProtocols))
+			e.Store(prefix+".version", int(h.Version))
+			e.Store(prefix+".supported-versions", join(h.SupportedVersions, "%d", ","))
+			e.Store(prefix+".cipher-suites", join(h.CipherSuites, "%d", ","))
+			e.Store(prefix+".extensions", join(h.Extensions, "%d", ","))
+			e.Store(prefix+".supported-curves", join(h.SupportedCurves, "%d", ","))
+			e.Store(prefix+".supported-points", join(points, "%d", ","))
+			e.Store(prefix+".signature-algorithms", join(h.SignatureAlgorithms, "%d", ","))
+			e.Store(prefix+".client-hello", hex.EncodeToString(h.Raw))
+		}
+
+		if h := c.ServerHello(); h != nil {
+			e.Store(prefix+".ja3s", h.JA3S())
+			e.Store(prefix+".ja3s-digest", h.JA3SDigest())
+		}
+	}
+}
+
+// Server terminates tls on conn. A single event is sent to c: a tls-hello
+// event with the fields of the hellos when the handshake succeeded, or a
+// handshake-failed event with the error and the fields of the ClientHello, if
+// received. The returned option adds the fields of the hellos to events of
+// the connection.
+func Server(c pushers.Channel, conn net.Conn, config *tls.Config, prefix string, options ...event.Option) (*tls.Conn, event.Option, error) {
+	rc := Record(conn)
+
+	tlsConn := tls.Server(rc, config)
+
+	err := tlsConn.Handshake()
+
+	hello := rc.Options(prefix)
+
+	options = append(options,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		hello,
+	)
+
+	if err != nil {
+		options = append(options,
+			event.Type("handshake-failed"),
+			event.Custom(prefix+".handshake-error", err.Error()),
+		)
+	} else {
+		options = append(options,
+			event.Type("tls-hello"),
+			event.Custom(prefix+".alpn", tlsConn.ConnectionState().NegotiatedProtocol),
+		)
+	}
+
+	c.Send(event.New(options...))
+
+	return tlsConn, hello, err
+}
+
+// GetCertificate returns a function for tls.Config.GetCertificate, which
+// returns the certificates of the manager issued with the subject.
+func GetCertificate(m *certificates.Manager, subject certificates.Subject) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := m.Certificate(subject, hello.ServerName)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tls.Certificate{
+			Certificate: cert.Certificate,
+			PrivateKey:  cert.PrivateKey,
+			Leaf:        cert.Leaf,
+		}, nil
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlshello parses and fingerprints the ClientHello and ServerHello
+// of tls connections, JA3, JA3S and JA4.
+package tlshello
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Handshake message types.
+const (
+	typeClientHello = 1
+	typeServerHello = 2
+)
+
+// Extension types.
+const (
+	extensionServerName          = 0
+	extensionSupportedCurves     = 10
+	extensionSupportedPoints     = 11
+	extensionSignatureAlgorithms = 13
+	extensionALPN                = 16
+	extensionSupportedVersions   = 43
+)
+
+var errMalformed = errors.New("malformed hello")
+
+// isGREASE returns if v is a GREASE value, rfc8701.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ClientHello contains the fields of a ClientHello message.
+type ClientHello struct {
+	// Raw is the handshake message
+	Raw []byte
+
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedCurves     []uint16
+	SupportedPoints     []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPNProtocols       []string
+	ServerName          string
+}
+
+// reader reads the fields of hello messages, the err is set on reads
+// past the end.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errMalformed
+		return nil
+	}
+
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+func (r *reader) uint16s(n int) []uint16 {
+	b := r.bytes(n)
+
+	values := []uint16{}
+	for i := 0; i+1 < len(b); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(b[i:]))
+	}
+
+	return values
+}
+
+// ParseClientHello parses a ClientHello handshake message, including the
+// message header.
+func ParseClientHello(b []byte) (*ClientHello, error) {
+	r := &reader{b: b}
+
+	if r.uint8() != typeClientHello {
+		return nil, errMalformed
+	}
+
+	// length
+	r.bytes(3)
+
+	h := &ClientHello{
+		Raw:     b,
+		Version: r.uint16(),
+	}
+
+	// random
+	r.bytes(32)
+
+	// session id
+	r.bytes(int(r.uint8()))
+
+	h.CipherSuites = r.uint16s(int(r.uint16()))
+
+	// compression methods
+	r.bytes(int(r.uint8()))
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(r.b) == 0 {
+		// no extensions
+		return h, nil
+	}
+
+	extensions := &reader{b: r.bytes(int(r.uint16()))}
+
+	for r.err == nil && extensions.err == nil && len(extensions.b) > 0 {
+		typ := extensions.uint16()
+		data := &reader{b: extensions.bytes(int(extensions.uint16()))}
+
+		if extensions.err != nil {
+			break
+		}
+
+		h.Extensions = append(h.Extensions, typ)
+
+		switch typ {
+		case extensionServerName:
+			names := &reader{b: data.bytes(int(data.uint16()))}
+			for names.err == nil && len(names.b) > 0 {
+				nameType := names.uint8()
+				name := names.bytes(int(names.uint16()))
+
+				if nameType == 0 && names.err == nil {
+					h.ServerName = string(name)
+				}
+			}
+		case extensionSupportedCurves:
+			h.SupportedCurves = data.uint16s(int(data.uint16()))
+		case extensionSupportedPoints:
+			h.SupportedPoints = data.bytes(int(data.uint8()))
+		case extensionSignatureAlgorithms:
+			h.SignatureAlgorithms = data.uint16s(int(data.uint16()))
+		case extensionALPN:
+			protocols := &reader{b: data.bytes(int(data.uint16()))}
+			for protocols.err == nil && len(protocols.b) > 0 {
+				proto := protocols.bytes(int(protocols.uint8()))
+
+				if protocols.err == nil {
+					h.ALPNProtocols = append(h.ALPNProtocols, string(proto))
+				}
+			}
+		case extensionSupportedVersions:
+			h.SupportedVersions = data.uint16s(int(data.uint8()))
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	} else if extensions.err != nil {
+		return nil, extensions.err
+	}
+
+	return h, nil
+}
+
+// join joins the values without GREASE values, formatted with format.
+func join(values []uint16, format string, sep string) string {
+	parts := []string{}
+
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf(format, v))
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// JA3 returns the JA3 string of the hello, see
+// https://github.com/salesforce/ja3.
+func (h *ClientHello) JA3() string {
+	points := make([]uint16, len(h.SupportedPoints))
+	for i, v := range h.SupportedPoints {
+		points[i] = uint16(v)
+	}
+
+	return strings.Join([]string{
+		fmt.Sprintf("%d", h.Version),
+		join(h.CipherSuites, "%d", "-"),
+		join(h.Extensions, "%d", "-"),
+		join(h.SupportedCurves, "%d", "-"),
+		join(points, "%d", "-"),
+	}, ",")
+}
+
+// JA3Digest returns the md5 hash of the JA3 string.
+func (h *ClientHello) JA3Digest() string {
+	digest := md5.Sum([]byte(h.JA3()))
+	return hex.EncodeToString(digest[:])
+}
+
+// MaxVersion returns the highest version supported by the client.
+func (h *ClientHello) MaxVersion() uint16 {
+	version := uint16(0)
+
+	for _, v := range h.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	if version == 0 {
+		version = h.Version
+	}
+
+	return version
+}
+
+func versionString(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+
+	return "00"
+}
+
+func isAlphanumeric(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// truncatedHash returns the first 12 characters of the sha256 hash of s, or
+// zeroes when s is empty.
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+
+	digest := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(digest[:])[:12]
+}
+
+// JA4 returns the JA4 fingerprint of a hello received over tcp, see
+// https://github.com/FoxIO-LLC/ja4.
+func (h *ClientHello) JA4() string {
+	sni := "i"
+	if h.ServerName != "" {
+		sni = "d"
+	}
+
+	count := func(values []uint16) int {
+		n := 0
+		for _, v := range values {
+			if !isGREASE(v) {
+				n++
+			}
+		}
+
+		if n > 99 {
+			n = 99
+		}
+
+		return n
+	}
+
+	alpn := "00"
+	if len(h.ALPNProtocols) > 0 && h.ALPNProtocols[0] != "" {
+		proto := h.ALPNProtocols[0]
+
+		first, last := proto[0], proto[len(proto)-1]
+		if !isAlphanumeric(first) || !isAlphanumeric(last) {
+			s := hex.EncodeToString([]byte(proto))
+			first, last = s[0], s[len(s)-1]
+		}
+
+		alpn = string([]byte{first, last})
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		versionString(h.MaxVersion()),
+		sni,
+		count(h.CipherSuites),
+		count(h.Extensions),
+		alpn,
+	)
+
+	ciphers := append([]uint16{}, h.CipherSuites...)
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+
+	// the server name and alpn extensions are excluded from the hash
+	extensions := []uint16{}
+	for _, v := range h.Extensions {
+		if v == extensionServerName || v == extensionALPN {
+			continue
+		}
+
+		extensions = append(extensions, v)
+	}
+
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i] < extensions[j] })
+
+	c := join(extensions, "%04x", ",")
+	if c != "" && len(h.SignatureAlgorithms) > 0 {
+		c += "_" + join(h.SignatureAlgorithms, "%04x", ",")
+	}
+
+	return strings.Join([]string{
+		a,
+		truncatedHash(join(ciphers, "%04x", ",")),
+		truncatedHash(c),
+	}, "_")
+}
+
+// ServerHello contains the fields of a ServerHello message.
+type ServerHello struct {
+	Version     uint16
+	CipherSuite uint16
+	Extensions  []uint16
+}
+
+// ParseServerHello parses a ServerHello handshake message, including the
+// message header.
+func ParseServerHello(b []byte) (*ServerHello, error) {
+	r := &reader{b: b}
+
+	if r.uint8() != typeServerHello {
+		return nil, errMalformed
+	}
+
+	// length
+	r.bytes(3)
+
+	h := &ServerHello{
+		Version: r.uint16(),
+	}
+
+	// random
+	r.bytes(32)
+
+	// session id
+	r.bytes(int(r.uint8()))
+
+	h.CipherSuite = r.uint16()
+
+	// compression method
+	r.uint8()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(r.b) == 0 {
+		return h, nil
+	}
+
+	extensions := &reader{b: r.bytes(int(r.uint16()))}
+
+	for extensions.err == nil && len(extensions.b) > 0 {
+		typ := extensions.uint16()
+		extensions.bytes(int(extensions.uint16()))
+
+		if extensions.err == nil {
+			h.Extensions = append(h.Extensions, typ)
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	} else if extensions.err != nil {
+		return nil, extensions.err
+	}
+
+	return h, nil
+}
+
+// JA3S returns the JA3S string of the hello.
+func (h *ServerHello) JA3S() string {
+	return strings.Join([]string{
+		fmt.Sprintf("%d", h.Version),
+		fmt.Sprintf("%d", h.CipherSuite),
+		join(h.Extensions, "%d", "-"),
+	}, ",")
+}
+
+// JA3SDigest returns the md5 hash of the JA3S string.
+func (h *ServerHello) JA3SDigest() string {
+	digest := md5.Sum([]byte(h.JA3S()))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,247 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tlshello
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers/pushertest"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+func certificate(t *testing.T) tls.Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}
+}
+
+func TestJA4(t *testing.T) {
+	// the example of https://github.com/FoxIO-LLC/ja4, with grease values
+	h := &ClientHello{
+		Version:           0x0303,
+		SupportedVersions: []uint16{0x3a3a, 0x0304, 0x0303},
+		ServerName:        "example.com",
+		ALPNProtocols:     []string{"h2", "http/1.1"},
+		CipherSuites: []uint16{
+			0x2a2a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+			0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []uint16{
+			0x0a0a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005,
+			0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015,
+		},
+		SignatureAlgorithms: []uint16{
+			0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601,
+		},
+	}
+
+	if v, want := h.JA4(), "t13d1516h2_8daaf6152771_e5627efa2ab1"; v != want {
+		t.Errorf("Expected %s, got %s", want, v)
+	}
+
+	if v := h.JA3(); strings.Contains(v, "10794") || strings.Contains(v, "2570") {
+		t.Errorf("Expected grease values to be excluded, got %s", v)
+	}
+}
+
+func TestServer(t *testing.T) {
+	c := &pushertest.Channel{}
+
+	clt, srv := net.Pipe()
+
+	go func() {
+		conn := stdtls.Client(clt, &stdtls.Config{
+			ServerName:         "example.com",
+			NextProtos:         []string{"h2", "http/1.1"},
+			MaxVersion:         stdtls.VersionTLS12,
+			CipherSuites:       []uint16{stdtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			InsecureSkipVerify: true,
+		})
+
+		if err := conn.Handshake(); err != nil {
+			return
+		}
+
+		// read until the close notify of the server
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	tlsConn, hello, err := Server(c, srv, &tls.Config{
+		Certificates: []tls.Certificate{certificate(t)},
+		NextProtos:   []string{"h2"},
+	}, "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tlsConn.Close()
+
+	if len(c.Events()) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(c.Events()))
+	}
+
+	e := c.Events()[0]
+
+	if v := e.Get("type"); v != "tls-hello" {
+		t.Errorf("Unexpected type %s", v)
+	}
+
+	for key, want := range map[string]string{
+		"tls.server-name":    "example.com",
+		"tls.alpn-protocols": "h2,http/1.1",
+		"tls.alpn":           "h2",
+		"tls.cipher-suites":  "49195",
+	} {
+		if v := e.Get(key); v != want {
+			t.Errorf("Expected %s to be %q, got %q", key, want, v)
+		}
+	}
+
+	if v := e.Get("tls.ja4"); !strings.HasPrefix(v, "t12d0") {
+		t.Errorf("Unexpected ja4 %s", v)
+	}
+
+	if v := e.Get("tls.ja3s"); !strings.HasPrefix(v, "771,49195,") {
+		t.Errorf("Unexpected ja3s %s", v)
+	}
+
+	if e.Get("tls.ja3-digest") == "" || e.Get("tls.ja3s-digest") == "" || e.Get("tls.client-hello") == "" {
+		t.Errorf("Expected digests and client hello")
+	}
+
+	// the option adds the fields to the events of the connection
+	if v := event.New(hello).Get("tls.ja4"); v != e.Get("tls.ja4") {
+		t.Errorf("Expected option to add ja4, got %q", v)
+	}
+}
+
+// helloConn closes the connection after the ClientHello has been written.
+type helloConn struct {
+	net.Conn
+}
+
+func (c *helloConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.Conn.Close()
+	return n, err
+}
+
+func TestServerAbort(t *testing.T) {
+	c := &pushertest.Channel{}
+
+	clt, srv := net.Pipe()
+
+	go func() {
+		stdtls.Client(&helloConn{clt}, &stdtls.Config{
+			ServerName:         "scanner.example.com",
+			InsecureSkipVerify: true,
+		}).Handshake()
+	}()
+
+	_, _, err := Server(c, srv, &tls.Config{
+		Certificates: []tls.Certificate{certificate(t)},
+	}, "tls")
+	if err == nil {
+		t.Fatal("Expected handshake error")
+	}
+
+	if len(c.Events()) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(c.Events()))
+	}
+
+	e := c.Events()[0]
+
+	if v := e.Get("type"); v != "handshake-failed" {
+		t.Errorf("Unexpected type %s", v)
+	}
+
+	if v := e.Get("tls.server-name"); v != "scanner.example.com" {
+		t.Errorf("Unexpected server name %q", v)
+	}
+
+	if e.Get("tls.handshake-error") == "" {
+		t.Errorf("Expected handshake error")
+	}
+
+	if v := e.Get("tls.ja4"); !strings.HasPrefix(v, "t13d") {
+		t.Errorf("Unexpected ja4 %s", v)
+	}
+}
+
+func TestServerNotTLS(t *testing.T) {
+	c := &pushertest.Channel{}
+
+	clt, srv := net.Pipe()
+
+	go func() {
+		clt.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		clt.Close()
+	}()
+
+	_, _, err := Server(c, srv, &tls.Config{
+		Certificates: []tls.Certificate{certificate(t)},
+	}, "tls")
+	if err == nil {
+		t.Fatal("Expected handshake error")
+	}
+
+	if len(c.Events()) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(c.Events()))
+	}
+
+	if e := c.Events()[0]; e.Get("type") != "handshake-failed" || e.Get("tls.ja3") != "" {
+		t.Errorf("Unexpected event %v", e)
+	}
+}
+
+func TestRecordNotTLS(t *testing.T) {
+	r := &recorder{}
+
+	if r.write([]byte("GET / HTTP/1.1\r\n\r\n")) != nil || !r.done {
+		t.Errorf("Expected recorder to stop on non tls data")
+	}
+}
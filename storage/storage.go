@@ -28,6 +28,9 @@ type storage interface {
 var db *badger.DB
 var dataDir string
 
+// ErrNotFound is returned by Get when the key doesn't exist.
+var ErrNotFound = badger.ErrKeyNotFound
+
 // SetDataDir
 func SetDataDir(s string) {
 	if db != nil {
@@ -38,6 +41,12 @@ func SetDataDir(s string) {
 	db = MustDB()
 }
 
+// DataDir returns the data directory, files which are not stored in the
+// database are stored here.
+func DataDir() string {
+	return dataDir
+}
+
 // MustDB
 func MustDB() *badger.DB {
 	opts := badger.DefaultOptions
@@ -116,3 +125,38 @@ func (s *badgeStorage) Set(key string, data []byte) error {
 		return err
 	})
 }
+
+// Delete removes the key
+func (s *badgeStorage) Delete(key string) error {
+	k := append(s.ns, key...)
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(k)
+	})
+}
+
+// Range calls fn for the keys starting with prefix in order, until fn
+// returns false
+func (s *badgeStorage) Range(prefix string, fn func(key string, data []byte) bool) error {
+	p := append(s.ns, prefix...)
+
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !fn(string(item.Key()[len(s.ns):]), v) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
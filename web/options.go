@@ -14,6 +14,7 @@
 package web
 
 import (
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -42,6 +43,15 @@ func WithDataDir(dataDir string) func(*web) error {
 	}
 }
 
+// WithHandler serves handler on pattern, for endpoints which are implemented
+// outside of the web package.
+func WithHandler(pattern string, handler http.Handler) func(*web) error {
+	return func(w *web) error {
+		w.handlers[pattern] = handler
+		return nil
+	}
+}
+
 type TomlDecoder interface {
 	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
 }
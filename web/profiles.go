@@ -0,0 +1,134 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/profiles"
+)
+
+// ServeProfiles serves the list of attacker profiles, /api/v1/attackers. The
+// list is sorted by the sort parameter (last-seen, first-seen, events or ip)
+// in the order parameter (asc or desc) and paged by offset and limit, the
+// limit is at most profiles.MaxLimit.
+func (web *web) ServeProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	store := profiles.Default()
+	if store == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	values := r.URL.Query()
+
+	q := profiles.Query{
+		Sort:  values.Get("sort"),
+		Limit: 100,
+	}
+
+	switch values.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		http.Error(w, "invalid order", http.StatusBadRequest)
+		return
+	}
+
+	for name, v := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		s := values.Get(name)
+		if s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid "+name, http.StatusBadRequest)
+			return
+		}
+
+		*v = n
+	}
+
+	if q.Limit < 1 || q.Limit > profiles.MaxLimit {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	list, total, err := store.List(q)
+	if err == profiles.ErrInvalidSort {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Errorf("Error listing profiles: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(struct {
+		Total    int                `json:"total"`
+		Offset   int                `json:"offset"`
+		Limit    int                `json:"limit"`
+		Profiles []profiles.Profile `json:"profiles"`
+	}{
+		Total:    total,
+		Offset:   q.Offset,
+		Limit:    q.Limit,
+		Profiles: list,
+	}); err != nil {
+		log.Errorf("Error encoding profiles: %s", err.Error())
+	}
+}
+
+// ServeProfile serves the profile of an attacker, /api/v1/attackers/<ip>.
+func (web *web) ServeProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	store := profiles.Default()
+	if store == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	ip := strings.TrimPrefix(r.URL.Path, "/api/v1/attackers/")
+
+	p, err := store.Get(ip)
+	if err == profiles.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Errorf("Error loading profile %s: %s", ip, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Errorf("Error encoding profile: %s", err.Error())
+	}
+}
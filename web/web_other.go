@@ -14,13 +14,8 @@
 package web
 
 import (
-	"compress/gzip"
 	"encoding/json"
-	"io"
-	"net"
 	"net/http"
-	"os"
-	"path"
 	"time"
 
 	"github.com/honeytrap/honeytrap/cmd"
@@ -32,47 +27,12 @@ import (
 	"github.com/gorilla/websocket"
 	assets "github.com/honeytrap/honeytrap-web"
 	logging "github.com/op/go-logging"
-	maxminddb "github.com/oschwald/maxminddb-golang"
 )
 
 var log = logging.MustGetLogger("web")
 
 func AcceptAllOrigins(r *http.Request) bool { return true }
 
-func download(url string, dest string) error {
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", geoLiteURL, nil)
-	if err != nil {
-		return err
-	}
-
-	var resp *http.Response
-	if resp, err = client.Do(req); err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	gzf, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return err
-	}
-	defer gzf.Close()
-
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-
-	defer f.Close()
-
-	_, err = io.Copy(f, gzf)
-	return err
-}
-
-const geoLiteURL = "http://geolite.maxmind.com/download/geoip/database/GeoLite2-City.mmdb.gz"
-
 type web struct {
 	config *config.Config
 
@@ -99,6 +59,9 @@ type web struct {
 
 	hotCountries *SafeArray
 	events       *SafeArray
+
+	// handlers are served next to the api, set by WithHandler
+	handlers map[string]http.Handler
 }
 
 func New(options ...func(*web) error) (*web, error) {
@@ -119,6 +82,8 @@ func New(options ...func(*web) error) (*web, error) {
 
 		hotCountries: NewSafeArray(),
 		events:       NewLimitedSafeArray(1000),
+
+		handlers: map[string]http.Handler{},
 	}
 
 	for _, optionFn := range options {
@@ -162,17 +127,36 @@ func (web *web) Start() {
 	})
 
 	handler.HandleFunc("/ws", web.ServeWS)
+	handler.HandleFunc("/api/v1/recordings/", web.ServeRecording)
+	handler.HandleFunc("/api/v1/artifacts", web.ServeArtifacts)
+	handler.HandleFunc("/api/v1/artifacts/", web.ServeArtifact)
+	handler.HandleFunc("/api/v1/attackers", web.ServeProfiles)
+	handler.HandleFunc("/api/v1/attackers/", web.ServeProfile)
+
+	for pattern, h := range web.handlers {
+		handler.Handle(pattern, h)
+	}
+
 	handler.Handle("/", sh)
 
 	eventCh := make(chan event.Event)
 
 	go func(ch chan event.Event) {
 		for evt := range ch {
+			// the country is added by the geoip processor, the
+			// dashboard expects it as source.country.isocode
+			isoCode := evt.Get("source.country")
+			if isoCode != "" {
+				evt = event.New(
+					event.CopyFrom(event.ToMap(evt)),
+					event.Custom("source.country.isocode", isoCode),
+				)
+			}
+
 			web.events.Append(evt)
 
 			web.messageCh <- Data("event", evt)
 
-			isoCode := evt.Get("source.country.isocode")
 			if isoCode == "" {
 				continue
 			}
@@ -205,7 +189,6 @@ func (web *web) Start() {
 		}
 	}(eventCh)
 
-	eventCh = resolver(web.dataDir, eventCh)
 	eventCh = filter(eventCh)
 
 	web.eventCh = eventCh
@@ -274,59 +257,6 @@ func filter(outCh chan event.Event) chan event.Event {
 	return ch
 }
 
-func resolver(dataDir string, outCh chan event.Event) chan event.Event {
-	dbPath := path.Join(dataDir, "GeoLite2-Country.mmdb")
-
-	_, err := os.Stat(dbPath)
-	if os.IsNotExist(err) {
-		err = download(geoLiteURL, dbPath)
-		if err != nil {
-			log.Fatal(err)
-			return outCh
-		}
-	}
-
-	ch := make(chan event.Event)
-	go func() {
-		db, err := maxminddb.Open(dbPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		defer db.Close()
-
-		for {
-			evt := <-ch
-
-			v := evt.Get("source-ip")
-			if v == "" {
-				outCh <- evt
-				continue
-			}
-
-			ip := net.ParseIP(v)
-
-			var record struct {
-				Country struct {
-					ISOCode string `maxminddb:"iso_code"`
-				} `maxminddb:"country"`
-			}
-
-			if err = db.Lookup(ip, &record); err != nil {
-				log.Error("Error looking up country for: %s", err.Error())
-
-				outCh <- evt
-				continue
-			}
-
-			evt.Store("source.country.isocode", record.Country.ISOCode)
-			outCh <- evt
-		}
-	}()
-
-	return ch
-}
-
 func (web *web) Send(evt event.Event) {
 	web.eventCh <- evt
 }